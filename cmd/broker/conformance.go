@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"broker/pkg/conformance"
+)
+
+// runConformance runs the conformance scenarios against a running broker and writes the report.
+// It returns the exit code, which is non-zero if a scenario failed.
+func runConformance(args []string) int {
+	cfg, err := conformance.LoadConfig(args, os.LookupEnv)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		fmt.Fprintf(os.Stderr, "unable to read config: %s\n", err)
+		return exitCodeErr
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signalChan)
+	go func() {
+		select {
+		case <-signalChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	report, err := conformance.NewRunner(*cfg, nil).Run(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to run conformance checks: %s\n", err)
+		return exitCodeErr
+	}
+
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to encode report: %s\n", err)
+		return exitCodeErr
+	}
+	b = append(b, '\n')
+	if cfg.Output == "" {
+		os.Stdout.Write(b)
+	} else if err := ioutil.WriteFile(cfg.Output, b, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to write report: %s\n", err)
+		return exitCodeErr
+	}
+
+	fmt.Fprintf(os.Stderr, "%d passed, %d failed, %d skipped\n", report.Passed, report.Failed, report.Skipped)
+	if !report.Succeeded() {
+		return exitCodeErr
+	}
+	return 0
+}
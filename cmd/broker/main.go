@@ -3,24 +3,26 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
-	"strings"
 	"syscall"
-	"time"
 
+	"broker/pkg/auth"
+	"broker/pkg/config"
 	"broker/pkg/crossplane"
 	"broker/pkg/crossplanebroker"
 	"broker/pkg/custom"
+	"broker/pkg/dashboard"
+	"broker/pkg/ratelimit"
+	"broker/pkg/tlsconfig"
 
 	"code.cloudfoundry.org/lager"
 	"github.com/gorilla/mux"
 	api "github.com/pivotal-cf/brokerapi/v7"
-	"github.com/pivotal-cf/brokerapi/v7/auth"
 	"github.com/pivotal-cf/brokerapi/v7/middlewares"
 )
 
@@ -29,11 +31,23 @@ const (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "conformance" {
+		os.Exit(runConformance(os.Args[2:]))
+	}
+
+	cfg, err := config.Load(os.Args[1:], os.LookupEnv)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		fmt.Fprintf(os.Stderr, "unable to read config: %s\n", err)
+		os.Exit(exitCodeErr)
+	}
+
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
 
-	logger := lager.NewLogger("broker")
-	logger.RegisterSink(lager.NewPrettySink(os.Stdout, lager.DEBUG))
+	logger := newLogger(cfg.Log)
 
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
@@ -43,36 +57,50 @@ func main() {
 		cancel()
 	}()
 
-	if err := run(ctx, signalChan, logger); err != nil {
+	if err := run(ctx, cfg, signalChan, logger); err != nil {
 		logger.Error("application-run-failed", err)
 		os.Exit(exitCodeErr)
 	}
 }
 
-func run(ctx context.Context, signalChan chan os.Signal, logger lager.Logger) error {
-	cfg, err := readAppConfig()
-	if err != nil {
-		return fmt.Errorf("unable to read app env: %w", err)
-	}
-
-	logger.WithData(lager.Data{"service": cfg.serviceIDs}).Info("starting-broker", lager.Data{"listen-addr": cfg.listenAddr})
+func run(ctx context.Context, cfg *config.Config, signalChan chan os.Signal, logger lager.Logger) error {
+	logger.WithData(lager.Data{"service": cfg.ServiceIDs}).Info("starting-broker", lager.Data{"listen-addr": cfg.HTTP.ListenAddr, "tls": cfg.HTTP.TLS.Enabled()})
 
-	cp, err := crossplane.New(cfg.serviceIDs, logger)
+	cp, err := crossplane.New(cfg.ServiceIDs, cfg.Crossplane, logger)
 	if err != nil {
 		return fmt.Errorf("unable to create crossplane client: %w", err)
 	}
+	go cp.Downstream.Run(ctx, cfg.Crossplane.DownstreamRefreshInterval.Duration)
+	go cp.RunUpgrader(ctx, cfg.Crossplane.UpgradeInterval.Duration)
 
 	b, err := crossplanebroker.New(cp, logger.WithData(lager.Data{"module": "broker"}))
 	if err != nil {
 		return fmt.Errorf("unable to create broker: %w", err)
 	}
+	dash, err := dashboard.New(cfg.Dashboard)
+	if err != nil {
+		return fmt.Errorf("unable to create dashboards: %w", err)
+	}
+	b.Dashboard = dash
 
-	logger.Debug("basic-auth-credentials", lager.Data{"Username": cfg.username})
+	logger.Debug("basic-auth-credentials", lager.Data{"Username": cfg.Auth.Username})
 
-	credentials := api.BrokerCredentials{
-		Username: cfg.username,
-		Password: cfg.password,
+	authenticator, err := auth.New(cfg.Auth, cp.Client)
+	if err != nil {
+		return fmt.Errorf("unable to create authenticators: %w", err)
 	}
+	authMiddleware := auth.NewMiddleware(authenticator, logger.Session("auth"))
+
+	go config.NewWatcher(os.Args[1:], os.LookupEnv, logger).Run(ctx, cfg, func(newCfg *config.Config) {
+		logger.Info("reload-config", lager.Data{"service": newCfg.ServiceIDs, "Username": newCfg.Auth.Username})
+		authenticator, err := auth.New(newCfg.Auth, cp.Client)
+		if err != nil {
+			logger.Error("reload-authenticators", err)
+		} else {
+			authMiddleware.Set(authenticator)
+		}
+		cp.SetServiceIDs(newCfg.ServiceIDs)
+	})
 
 	baseRouter := mux.NewRouter()
 	baseRouter.HandleFunc("/healthz", func(res http.ResponseWriter, req *http.Request) {
@@ -82,32 +110,56 @@ func run(ctx context.Context, signalChan chan os.Signal, logger lager.Logger) er
 	}).Methods(http.MethodGet)
 	baseRouter.Use(middlewares.AddCorrelationIDToContext)
 
-	authMiddleware := auth.NewWrapper(credentials.Username, credentials.Password).Wrap
+	customAPIHandler := custom.NewAPIHandler(cp, logger.WithData(lager.Data{"module": "custom"}))
+	customAPIHandler.Dashboard = dash
+	customAPIHandler.Locks = b
+	// Dashboards are protected by the tokens of signed links instead of the broker's authentication.
+	// The dashboard URLs returned to the platform are served by the custom API and require authentication.
+	custom.NewDashboardPage(baseRouter, customAPIHandler, logger.Session("dashboard"))
+
 	osbRouter := baseRouter.NewRoute().Subrouter()
 	osbRouter.Use(loggerMiddleware(logger))
-	osbRouter.Use(authMiddleware)
+	osbRouter.Use(authMiddleware.Wrap)
+	if cfg.HTTP.RateLimit.Enabled() {
+		osbRouter.Use(ratelimit.New(cfg.HTTP.RateLimit, logger.Session("ratelimit")).Middleware)
+	}
 	osbRouter.Use(middlewares.AddOriginatingIdentityToContext)
 	osbRouter.Use(middlewares.AddInfoLocationToContext)
 
 	apiVersionMiddleware := middlewares.APIVersionMiddleware{LoggerFactory: logger}
 	apiRouter := osbRouter.NewRoute().Subrouter()
+	apiRouter.Use(auth.RequireRole(auth.RolePlatform))
 	apiRouter.Use(apiVersionMiddleware.ValidateAPIVersionHdr)
 
+	crossplanebroker.AttachGetInstanceRoute(apiRouter, b, logger.Session("get-instance"))
 	api.AttachRoutes(apiRouter, b, logger)
 
-	customAPIHandler := custom.NewAPIHandler(cp, logger.WithData(lager.Data{"module": "custom"}))
 	custom.NewAPI(osbRouter, customAPIHandler, logger)
 
 	srv := http.Server{
-		Addr:           cfg.listenAddr,
+		Addr:           cfg.HTTP.ListenAddr,
 		Handler:        baseRouter,
-		ReadTimeout:    cfg.readTimeout,
-		WriteTimeout:   cfg.writeTimeout,
-		MaxHeaderBytes: cfg.maxHeaderBytes,
+		ReadTimeout:    cfg.HTTP.ReadTimeout.Duration,
+		WriteTimeout:   cfg.HTTP.WriteTimeout.Duration,
+		MaxHeaderBytes: cfg.HTTP.MaxHeaderBytes,
+	}
+
+	listenAndServe := srv.ListenAndServe
+	if cfg.HTTP.TLS.Enabled() {
+		certs, err := tlsconfig.NewReloader(cfg.HTTP.TLS, logger)
+		if err != nil {
+			return err
+		}
+		go certs.Run(ctx, cfg.ReloadInterval.Duration)
+		srv.TLSConfig = certs.TLSConfig()
+		listenAndServe = func() error {
+			// The certificate is provided by the TLS config.
+			return srv.ListenAndServeTLS("", "")
+		}
 	}
 
 	go func() {
-		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		if err := listenAndServe(); !errors.Is(err, http.ErrServerClosed) {
 			logger.Error("server-error", err)
 			signalChan <- syscall.SIGABRT
 		}
@@ -121,64 +173,22 @@ func run(ctx context.Context, signalChan chan os.Signal, logger lager.Logger) er
 
 	logger.Info("shutting down server", lager.Data{"signal": sig.String()})
 
-	graceCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	graceCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownGracePeriod.Duration)
 	defer cancel()
 	return srv.Shutdown(graceCtx)
 }
 
-type appConfig struct {
-	serviceIDs     []string
-	username       string
-	password       string
-	listenAddr     string
-	readTimeout    time.Duration
-	writeTimeout   time.Duration
-	maxHeaderBytes int
-}
+func newLogger(cfg config.LogConfig) lager.Logger {
+	// The log level has been validated when loading the config.
+	level, _ := cfg.LogLevel()
 
-func readAppConfig() (*appConfig, error) {
-	cfg := appConfig{
-		serviceIDs: strings.Split(os.Getenv("OSB_SERVICE_IDS"), ","),
-		username:   os.Getenv("OSB_USERNAME"),
-		password:   os.Getenv("OSB_PASSWORD"),
-		listenAddr: os.Getenv("OSB_HTTP_LISTEN_ADDR"),
-	}
-	for i := range cfg.serviceIDs {
-		cfg.serviceIDs[i] = strings.TrimSpace(cfg.serviceIDs[i])
-		if len(cfg.serviceIDs[i]) == 0 {
-			return nil, errors.New("OSB_SERVICE_IDS is required")
-		}
-	}
-	if cfg.username == "" {
-		return nil, errors.New("OSB_USERNAME is required")
-	}
-	if cfg.password == "" {
-		return nil, errors.New("OSB_PASSWORD is required")
-	}
-
-	if cfg.listenAddr == "" {
-		cfg.listenAddr = ":8080"
-	}
-
-	rt, err := time.ParseDuration(os.Getenv("OSB_HTTP_READ_TIMEOUT"))
-	if err != nil {
-		rt = 180 * time.Second
-	}
-	cfg.readTimeout = rt
-
-	wt, err := time.ParseDuration(os.Getenv("OSB_HTTP_WRITE_TIMEOUT"))
-	if err != nil {
-		wt = 180 * time.Second
-	}
-	cfg.writeTimeout = wt
-
-	mhb, err := strconv.Atoi(os.Getenv("OSB_HTTP_MAX_HEADER_BYTES"))
-	if err != nil {
-		mhb = 1 << 20 // 1 MB
+	logger := lager.NewLogger("broker")
+	if cfg.Format == config.LogFormatJSON {
+		logger.RegisterSink(lager.NewWriterSink(os.Stdout, level))
+	} else {
+		logger.RegisterSink(lager.NewPrettySink(os.Stdout, level))
 	}
-	cfg.maxHeaderBytes = mhb
-
-	return &cfg, nil
+	return logger
 }
 
 func loggerMiddleware(logger lager.Logger) mux.MiddlewareFunc {
@@ -11,12 +11,18 @@ require (
 	github.com/gorilla/mux v1.8.0
 	github.com/mattn/go-isatty v0.0.12 // indirect
 	github.com/pivotal-cf/brokerapi/v7 v7.4.0
+	github.com/prometheus/client_model v0.2.0
+	github.com/prometheus/common v0.6.0
 	github.com/stretchr/testify v1.6.1
-	golang.org/x/crypto v0.0.0-20201002170205-7f63de1d35b0 // indirect
+	golang.org/x/crypto v0.0.0-20201002170205-7f63de1d35b0
+	golang.org/x/time v0.0.0-20200416051211-89c76fbcd5d1
+	gopkg.in/square/go-jose.v2 v2.2.2
 	gopkg.in/yaml.v3 v3.0.0-20200605160147-a5ece683394c // indirect
 	k8s.io/api v0.19.3
+	k8s.io/apiextensions-apiserver v0.18.6
 	k8s.io/apimachinery v0.19.3
 	k8s.io/client-go v0.19.3
 	k8s.io/utils v0.0.0-20201110183641-67b214c5f920
 	sigs.k8s.io/controller-runtime v0.6.3
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -0,0 +1,60 @@
+#!/usr/bin/env bash
+# Creates a local development environment with kind:
+#   spks-control    control plane cluster running Crossplane and provider-helm
+#   spks-service-1  service cluster for the ProviderConfig "service-1"
+#   spks-service-2  service cluster for the ProviderConfig "service-2"
+# Run the broker with `go run ./cmd/broker --config deploy/dev/config.yaml` afterwards.
+set -euo pipefail
+
+KIND=${KIND:-kind}
+KUBECTL=${KUBECTL:-kubectl}
+HELM=${HELM:-helm}
+CROSSPLANE_VERSION=${CROSSPLANE_VERSION:-0.14.0}
+PROVIDER_HELM_VERSION=${PROVIDER_HELM_VERSION:-v0.4.0}
+
+control=spks-control
+services=(spks-service-1 spks-service-2)
+
+create_cluster() {
+	if ! "${KIND}" get clusters | grep -qx "$1"; then
+		"${KIND}" create cluster --name "$1"
+	fi
+}
+
+for cluster in "${control}" "${services[@]}"; do
+	create_cluster "${cluster}"
+done
+
+ctl() {
+	"${KUBECTL}" --context "kind-${control}" "$@"
+}
+
+"${HELM}" repo add crossplane-stable https://charts.crossplane.io/stable
+"${HELM}" upgrade --install crossplane crossplane-stable/crossplane \
+	--kube-context "kind-${control}" \
+	--namespace crossplane-system --create-namespace \
+	--version "${CROSSPLANE_VERSION}" --wait
+
+ctl apply -f - <<YAML
+apiVersion: pkg.crossplane.io/v1alpha1
+kind: Provider
+metadata:
+  name: provider-helm
+spec:
+  package: crossplane/provider-helm:${PROVIDER_HELM_VERSION}
+YAML
+ctl wait --for condition=established --timeout=120s crd/providerconfigs.helm.crossplane.io
+
+# provider-helm runs in the control cluster and reaches the service clusters on the kind network.
+for i in "${!services[@]}"; do
+	"${KIND}" get kubeconfig --internal --name "${services[$i]}" > "/tmp/${services[$i]}.kubeconfig"
+	ctl -n crossplane-system create secret generic "service-$((i + 1))-kubeconfig" \
+		--from-file=kubeconfig="/tmp/${services[$i]}.kubeconfig" \
+		--dry-run=client -o yaml | ctl apply -f -
+	rm "/tmp/${services[$i]}.kubeconfig"
+done
+
+ctl create namespace spks-crossplane --dry-run=client -o yaml | ctl apply -f -
+ctl apply -k deploy/dev
+
+"${KUBECTL}" config use-context "kind-${control}"
@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	"broker/pkg/config"
+
+	"code.cloudfoundry.org/lager"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const notAuthorized = "Not Authorized"
+
+type contextKey string
+
+const principalKey contextKey = "principal"
+
+// ErrNoCredentials is returned by an Authenticator if the request doesn't contain credentials it can verify.
+var ErrNoCredentials = errors.New("no credentials")
+
+// Principal is an authenticated caller.
+type Principal struct {
+	// Name identifies the caller, e.g. the basic auth username or the subject of a token.
+	Name string
+	// Method is the name of the authenticator which authenticated the caller.
+	Method string
+	// Groups the caller is a member of.
+	Groups []string
+	// Roles granted to the caller.
+	Roles []Role
+	// Tenant the caller belongs to. Callers own the instances of their tenant.
+	Tenant string
+}
+
+// Authenticator verifies the credentials of a request.
+type Authenticator interface {
+	// Authenticate returns the principal of a request, ErrNoCredentials if the request doesn't contain
+	// credentials for this authenticator or any other error if the credentials are invalid.
+	Authenticate(req *http.Request) (*Principal, error)
+}
+
+// Chain tries all authenticators in order and accepts the first successfully authenticated principal.
+type Chain []Authenticator
+
+// Authenticate implements Authenticator.
+func (c Chain) Authenticate(req *http.Request) (*Principal, error) {
+	var lastErr error = ErrNoCredentials
+	for _, a := range c {
+		p, err := a.Authenticate(req)
+		if err == nil {
+			return p, nil
+		}
+		if !errors.Is(err, ErrNoCredentials) {
+			lastErr = err
+		}
+	}
+	return nil, lastErr
+}
+
+// New creates the authenticators enabled in the config and assigns roles to the authenticated principals.
+// The kubernetes client is used to review tokens with the TokenReview API.
+func New(cfg config.AuthConfig, k k8sclient.Client) (Authenticator, error) {
+	chain, err := NewChain(cfg, k)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := NewRoles(cfg.Authorization)
+	if err != nil {
+		return nil, err
+	}
+	return withRoles{Authenticator: chain, roles: roles}, nil
+}
+
+// NewChain creates the authenticators enabled in the config.
+// The kubernetes client is used to review tokens with the TokenReview API.
+func NewChain(cfg config.AuthConfig, k k8sclient.Client) (Chain, error) {
+	chain := Chain{}
+	if cfg.ClientCert.Enabled {
+		chain = append(chain, NewClientCert(cfg.ClientCert.AllowedNames))
+	}
+	if cfg.Username != "" {
+		chain = append(chain, NewBasic(map[string]string{cfg.Username: cfg.Password}))
+	}
+	if cfg.HtpasswdFile != "" {
+		a, err := NewHtpasswdFile(cfg.HtpasswdFile)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, a)
+	}
+	if cfg.JWT.Issuer != "" {
+		a, err := NewJWT(cfg.JWT)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, a)
+	}
+	if cfg.TokenReview.Enabled {
+		chain = append(chain, NewTokenReview(k, cfg.TokenReview))
+	}
+	return chain, nil
+}
+
+// Middleware authenticates requests and adds the principal to the request context.
+// The authenticator can be replaced atomically without affecting in-flight requests.
+type Middleware struct {
+	authenticator atomic.Value
+	logger        lager.Logger
+}
+
+// NewMiddleware creates a middleware using the given authenticator.
+func NewMiddleware(a Authenticator, logger lager.Logger) *Middleware {
+	m := &Middleware{logger: logger}
+	m.Set(a)
+	return m
+}
+
+// Set replaces the authenticator.
+func (m *Middleware) Set(a Authenticator) {
+	m.authenticator.Store(&a)
+}
+
+// Wrap rejects unauthenticated requests with 401.
+func (m *Middleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		a := *m.authenticator.Load().(*Authenticator)
+		p, err := a.Authenticate(req)
+		if err != nil {
+			if !errors.Is(err, ErrNoCredentials) {
+				m.logger.Info("authentication-failed", lager.Data{"error": err.Error(), "URI": req.RequestURI})
+			}
+			http.Error(w, notAuthorized, http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, req.WithContext(WithPrincipal(req.Context(), p)))
+	})
+}
+
+// WithPrincipal returns a context containing the principal.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// PrincipalFromContext returns the authenticated principal of a request, if any.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey).(*Principal)
+	return p, ok
+}
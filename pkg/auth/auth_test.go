@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"broker/pkg/config"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const issuer = "https://issuer.example.com"
+
+func tempFile(t *testing.T, name string, content []byte) string {
+	dir, err := ioutil.TempDir("", "auth")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, content, 0600))
+	return path
+}
+
+func basicRequest(username, password string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/v2/catalog", nil)
+	req.SetBasicAuth(username, password)
+	return req
+}
+
+func bearerRequest(token string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/v2/catalog", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestBasic(t *testing.T) {
+	b := NewBasic(map[string]string{"user": "secret"})
+
+	p, err := b.Authenticate(basicRequest("user", "secret"))
+	require.NoError(t, err)
+	assert.Equal(t, "user", p.Name)
+
+	_, err = b.Authenticate(basicRequest("user", "wrong"))
+	assert.Error(t, err)
+
+	_, err = b.Authenticate(httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, ErrNoCredentials, err)
+}
+
+func TestHtpasswd(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	h, err := NewHtpasswdFile(tempFile(t, "htpasswd", []byte("# comment\nalice:"+string(hash)+"\n")))
+	require.NoError(t, err)
+
+	p, err := h.Authenticate(basicRequest("alice", "secret"))
+	require.NoError(t, err)
+	assert.Equal(t, "alice", p.Name)
+
+	_, err = h.Authenticate(basicRequest("alice", "wrong"))
+	assert.Error(t, err)
+
+	_, err = h.Authenticate(basicRequest("bob", "secret"))
+	assert.Equal(t, ErrNoCredentials, err)
+
+	_, err = NewHtpasswdFile(tempFile(t, "htpasswd", []byte("alice:{SHA}plain")))
+	assert.Error(t, err)
+}
+
+func newSigner(t *testing.T) (jose.Signer, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, (&jose.SignerOptions{}).WithHeader("kid", "test"))
+	require.NoError(t, err)
+
+	jwks, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"}}})
+	require.NoError(t, err)
+	return signer, tempFile(t, "jwks.json", jwks)
+}
+
+func TestJWT(t *testing.T) {
+	signer, jwksFile := newSigner(t)
+	otherSigner, _ := newSigner(t)
+	j, err := NewJWT(config.JWTConfig{
+		Issuer:        issuer,
+		JWKSFile:      jwksFile,
+		Audiences:     []string{"broker"},
+		UsernameClaim: "sub",
+		GroupsClaim:   "groups",
+	})
+	require.NoError(t, err)
+
+	token := func(s jose.Signer, c jwt.Claims) string {
+		raw, err := jwt.Signed(s).Claims(c).Claims(map[string]interface{}{"groups": []string{"admins"}}).CompactSerialize()
+		require.NoError(t, err)
+		return raw
+	}
+	valid := jwt.Claims{
+		Issuer:   issuer,
+		Subject:  "service-catalog",
+		Audience: jwt.Audience{"broker"},
+		Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+
+	p, err := j.Authenticate(bearerRequest(token(signer, valid)))
+	require.NoError(t, err)
+	assert.Equal(t, "service-catalog", p.Name)
+	assert.Equal(t, []string{"admins"}, p.Groups)
+
+	expired := valid
+	expired.Expiry = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+	_, err = j.Authenticate(bearerRequest(token(signer, expired)))
+	assert.Error(t, err)
+
+	withoutExpiry := valid
+	withoutExpiry.Expiry = 0
+	_, err = j.Authenticate(bearerRequest(token(signer, withoutExpiry)))
+	assert.EqualError(t, err, "invalid token: no expiry")
+
+	wrongAudience := valid
+	wrongAudience.Audience = jwt.Audience{"other"}
+	_, err = j.Authenticate(bearerRequest(token(signer, wrongAudience)))
+	assert.Error(t, err)
+
+	_, err = j.Authenticate(bearerRequest(token(otherSigner, valid)))
+	assert.EqualError(t, err, "invalid token signature")
+
+	otherIssuer := valid
+	otherIssuer.Issuer = "https://kubernetes.default.svc"
+	_, err = j.Authenticate(bearerRequest(token(signer, otherIssuer)))
+	assert.Equal(t, ErrNoCredentials, err)
+
+	_, err = j.Authenticate(bearerRequest("opaque"))
+	assert.Equal(t, ErrNoCredentials, err)
+}
+
+func TestMiddleware(t *testing.T) {
+	var principal *Principal
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		principal, _ = PrincipalFromContext(req.Context())
+	})
+	m := NewMiddleware(Chain{
+		NewBasic(map[string]string{"user": "secret"}),
+		NewBasic(map[string]string{"other": "secret"}),
+	}, lager.NewLogger("test"))
+
+	rec := httptest.NewRecorder()
+	m.Wrap(handler).ServeHTTP(rec, basicRequest("other", "secret"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	require.NotNil(t, principal)
+	assert.Equal(t, "other", principal.Name)
+
+	rec = httptest.NewRecorder()
+	m.Wrap(handler).ServeHTTP(rec, basicRequest("other", "wrong"))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	m.Set(Chain{NewBasic(map[string]string{"rotated": "secret"})})
+	rec = httptest.NewRecorder()
+	m.Wrap(handler).ServeHTTP(rec, basicRequest("user", "secret"))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestRoles(t *testing.T) {
+	r, err := NewRoles(config.AuthorizationConfig{
+		DefaultRoles: []string{"platform"},
+		Bindings: []config.RoleBinding{
+			{Roles: []string{"admin"}, Users: []string{"root"}},
+			{Roles: []string{"instance-owner"}, Groups: []string{"team-a"}, Tenant: "a"},
+		},
+	})
+	require.NoError(t, err)
+
+	p := &Principal{Name: "alice", Groups: []string{"team-a"}}
+	r.Assign(p)
+	assert.Equal(t, []Role{RoleInstanceOwner}, p.Roles)
+	assert.Equal(t, "a", p.Tenant)
+	assert.False(t, p.HasRole(RolePlatform))
+
+	p = &Principal{Name: "root"}
+	r.Assign(p)
+	assert.True(t, p.HasRole(RolePlatform, RoleInstanceOwner))
+
+	p = &Principal{Name: "catalog"}
+	r.Assign(p)
+	assert.Equal(t, []Role{RolePlatform}, p.Roles)
+
+	_, err = NewRoles(config.AuthorizationConfig{DefaultRoles: []string{"superuser"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	methodBasic    = "basic"
+	methodHtpasswd = "htpasswd"
+)
+
+// Basic authenticates static basic auth credentials.
+type Basic struct {
+	credentials [][2][sha256.Size]byte
+}
+
+// NewBasic creates a Basic authenticator for the given username/password pairs.
+func NewBasic(users map[string]string) *Basic {
+	b := &Basic{}
+	for u, p := range users {
+		b.credentials = append(b.credentials, [2][sha256.Size]byte{sha256.Sum256([]byte(u)), sha256.Sum256([]byte(p))})
+	}
+	return b
+}
+
+// Authenticate implements Authenticator.
+func (b *Basic) Authenticate(req *http.Request) (*Principal, error) {
+	username, password, err := basicAuth(req)
+	if err != nil {
+		return nil, err
+	}
+	u := sha256.Sum256([]byte(username))
+	p := sha256.Sum256([]byte(password))
+	for _, c := range b.credentials {
+		// Compare hashes in constant time to not leak the length or content of the credentials.
+		if subtle.ConstantTimeCompare(c[0][:], u[:]) == 1 && subtle.ConstantTimeCompare(c[1][:], p[:]) == 1 {
+			return &Principal{Name: username, Method: methodBasic}, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid credentials for user %q", username)
+}
+
+// Htpasswd authenticates basic auth credentials against bcrypt hashes.
+type Htpasswd struct {
+	hashes map[string][]byte
+}
+
+// NewHtpasswdFile reads bcrypt hashed credentials from a file in htpasswd format.
+func NewHtpasswdFile(path string) (*Htpasswd, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read htpasswd file: %w", err)
+	}
+	hashes, err := parseHtpasswd(b)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse htpasswd file %q: %w", path, err)
+	}
+	return &Htpasswd{hashes: hashes}, nil
+}
+
+// Authenticate implements Authenticator.
+func (h *Htpasswd) Authenticate(req *http.Request) (*Principal, error) {
+	username, password, err := basicAuth(req)
+	if err != nil {
+		return nil, err
+	}
+	hash, ok := h.hashes[username]
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+		return nil, fmt.Errorf("invalid credentials for user %q: %w", username, err)
+	}
+	return &Principal{Name: username, Method: methodHtpasswd}, nil
+}
+
+func parseHtpasswd(content []byte) (map[string][]byte, error) {
+	hashes := map[string][]byte{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	line := 0
+	for scanner.Scan() {
+		line++
+		l := strings.TrimSpace(scanner.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		parts := strings.SplitN(l, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("line %d: expected <user>:<hash>", line)
+		}
+		if _, err := bcrypt.Cost([]byte(parts[1])); err != nil {
+			return nil, fmt.Errorf("line %d: only bcrypt hashes are supported: %w", line, err)
+		}
+		hashes[parts[0]] = []byte(parts[1])
+	}
+	return hashes, scanner.Err()
+}
+
+func basicAuth(req *http.Request) (string, string, error) {
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		return "", "", ErrNoCredentials
+	}
+	return username, password, nil
+}
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const methodClientCert = "client-certificate"
+
+// ClientCert authenticates requests with a TLS client certificate verified by the server.
+type ClientCert struct {
+	allowedNames map[string]bool
+}
+
+// NewClientCert creates a ClientCert authenticator. If allowedNames is empty, any verified certificate is accepted.
+func NewClientCert(allowedNames []string) *ClientCert {
+	c := &ClientCert{allowedNames: map[string]bool{}}
+	for _, n := range allowedNames {
+		c.allowedNames[n] = true
+	}
+	return c
+}
+
+// Authenticate implements Authenticator.
+func (c *ClientCert) Authenticate(req *http.Request) (*Principal, error) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return nil, ErrNoCredentials
+	}
+	leaf := req.TLS.VerifiedChains[0][0]
+	if len(c.allowedNames) == 0 {
+		return &Principal{Name: leaf.Subject.CommonName, Method: methodClientCert}, nil
+	}
+	for _, name := range append([]string{leaf.Subject.CommonName}, leaf.DNSNames...) {
+		if c.allowedNames[name] {
+			return &Principal{Name: name, Method: methodClientCert}, nil
+		}
+	}
+	return nil, fmt.Errorf("client certificate %q is not allowed", leaf.Subject.CommonName)
+}
@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"broker/pkg/config"
+
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	methodJWT = "jwt"
+
+	// jwtLeeway is the accepted clock skew when validating time based claims.
+	jwtLeeway = time.Minute
+	// jwksMinRefreshInterval limits how often remote keys are fetched when a token references an unknown key.
+	jwksMinRefreshInterval = time.Minute
+)
+
+// JWT authenticates bearer tokens signed by an OIDC issuer.
+type JWT struct {
+	cfg  config.JWTConfig
+	keys keySource
+}
+
+// keySource returns the keys matching a key ID.
+type keySource interface {
+	keys(ctx context.Context, kid string) ([]jose.JSONWebKey, error)
+}
+
+// NewJWT creates a JWT authenticator. Keys are read from the configured JWKS file or discovered
+// from the issuer's OpenID configuration.
+func NewJWT(cfg config.JWTConfig) (*JWT, error) {
+	j := &JWT{cfg: cfg}
+	if cfg.JWKSFile != "" {
+		b, err := ioutil.ReadFile(cfg.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read JWKS file: %w", err)
+		}
+		set := staticKeys{}
+		if err := json.Unmarshal(b, &set.set); err != nil {
+			return nil, fmt.Errorf("unable to parse JWKS file %q: %w", cfg.JWKSFile, err)
+		}
+		j.keys = set
+	} else {
+		j.keys = &discoveredKeys{
+			issuer: cfg.Issuer,
+			client: &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+	return j, nil
+}
+
+// Authenticate implements Authenticator.
+func (j *JWT) Authenticate(req *http.Request) (*Principal, error) {
+	raw, ok := bearerToken(req)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	tok, err := jwt.ParseSigned(raw)
+	if err != nil || len(tok.Headers) == 0 {
+		// Not a JWT, maybe another authenticator understands it.
+		return nil, ErrNoCredentials
+	}
+	unverified := jwt.Claims{}
+	if err := tok.UnsafeClaimsWithoutVerification(&unverified); err != nil || unverified.Issuer != j.cfg.Issuer {
+		return nil, ErrNoCredentials
+	}
+
+	keys, err := j.keys.keys(req.Context(), tok.Headers[0].KeyID)
+	if err != nil {
+		return nil, err
+	}
+	claims := jwt.Claims{}
+	custom := map[string]interface{}{}
+	verified := false
+	for _, key := range keys {
+		if err := tok.Claims(key, &claims, &custom); err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("invalid token signature")
+	}
+
+	// Tokens without expiry would grant access forever.
+	if claims.Expiry == 0 {
+		return nil, errors.New("invalid token: no expiry")
+	}
+	if err := claims.ValidateWithLeeway(jwt.Expected{Issuer: j.cfg.Issuer, Time: time.Now()}, jwtLeeway); err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	if !audienceAllowed(claims.Audience, j.cfg.Audiences) {
+		return nil, fmt.Errorf("invalid token audience %q", claims.Audience)
+	}
+
+	name, ok := custom[j.cfg.UsernameClaim].(string)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("token has no %q claim", j.cfg.UsernameClaim)
+	}
+	p := &Principal{Name: name, Method: methodJWT}
+	if groups, ok := custom[j.cfg.GroupsClaim].([]interface{}); ok {
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				p.Groups = append(p.Groups, s)
+			}
+		}
+	}
+	return p, nil
+}
+
+func audienceAllowed(audience jwt.Audience, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if audience.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+func bearerToken(req *http.Request) (string, bool) {
+	h := req.Header.Get("Authorization")
+	const prefix = "bearer "
+	if len(h) <= len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(h[len(prefix):]), true
+}
+
+type staticKeys struct {
+	set jose.JSONWebKeySet
+}
+
+func (s staticKeys) keys(_ context.Context, kid string) ([]jose.JSONWebKey, error) {
+	if kid == "" {
+		return s.set.Keys, nil
+	}
+	return s.set.Key(kid), nil
+}
+
+// discoveredKeys fetches the keys from the jwks_uri of the issuer's OpenID configuration.
+// Keys are cached and refetched if a token references an unknown key.
+type discoveredKeys struct {
+	issuer string
+	client *http.Client
+
+	mu          sync.Mutex
+	set         jose.JSONWebKeySet
+	lastRefresh time.Time
+}
+
+func (d *discoveredKeys) keys(ctx context.Context, kid string) ([]jose.JSONWebKey, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	keys := staticKeys{d.set}
+	if k, _ := keys.keys(ctx, kid); len(k) > 0 {
+		return k, nil
+	}
+	if time.Since(d.lastRefresh) < jwksMinRefreshInterval {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	d.lastRefresh = time.Now()
+	if err := d.refresh(ctx); err != nil {
+		return nil, fmt.Errorf("unable to fetch keys of issuer %q: %w", d.issuer, err)
+	}
+	return staticKeys{d.set}.keys(ctx, kid)
+}
+
+func (d *discoveredKeys) refresh(ctx context.Context) error {
+	discovery := struct {
+		JWKSURI string `json:"jwks_uri"`
+	}{}
+	if err := d.getJSON(ctx, strings.TrimSuffix(d.issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return err
+	}
+	if discovery.JWKSURI == "" {
+		return errors.New("OpenID configuration contains no jwks_uri")
+	}
+	set := jose.JSONWebKeySet{}
+	if err := d.getJSON(ctx, discovery.JWKSURI, &set); err != nil {
+		return err
+	}
+	d.set = set
+	return nil
+}
+
+func (d *discoveredKeys) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %d", url, res.StatusCode)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
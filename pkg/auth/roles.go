@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+
+	"broker/pkg/config"
+
+	"github.com/gorilla/mux"
+)
+
+// Role grants access to a set of APIs.
+type Role string
+
+const (
+	// RoleAdmin grants access to all APIs and all instances.
+	RoleAdmin Role = "admin"
+	// RolePlatform grants access to the OSB API and the custom APIs of the instances of the principal's tenant.
+	RolePlatform Role = "platform"
+	// RoleInstanceOwner grants access to the custom APIs of the instances of the principal's tenant.
+	RoleInstanceOwner Role = "instance-owner"
+)
+
+// HasRole checks if the principal has one of the roles. Admins have all roles.
+func (p *Principal) HasRole(roles ...Role) bool {
+	for _, have := range p.Roles {
+		if have == RoleAdmin {
+			return true
+		}
+		for _, want := range roles {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Roles assigns roles and tenants to principals.
+type Roles struct {
+	defaults []Role
+	bindings []roleBinding
+}
+
+type roleBinding struct {
+	roles  []Role
+	users  map[string]bool
+	groups map[string]bool
+	tenant string
+}
+
+// NewRoles creates the role assignments of the config.
+func NewRoles(cfg config.AuthorizationConfig) (*Roles, error) {
+	r := &Roles{}
+	var err error
+	if r.defaults, err = parseRoles(cfg.DefaultRoles); err != nil {
+		return nil, err
+	}
+	for _, b := range cfg.Bindings {
+		rb := roleBinding{
+			users:  map[string]bool{},
+			groups: map[string]bool{},
+			tenant: b.Tenant,
+		}
+		if rb.roles, err = parseRoles(b.Roles); err != nil {
+			return nil, err
+		}
+		for _, u := range b.Users {
+			rb.users[u] = true
+		}
+		for _, g := range b.Groups {
+			rb.groups[g] = true
+		}
+		r.bindings = append(r.bindings, rb)
+	}
+	return r, nil
+}
+
+// Assign sets the roles and the tenant of a principal. Principals get the roles of all matching
+// bindings and the tenant of the first matching binding specifying one.
+// Principals not matching any binding get the default roles.
+func (r *Roles) Assign(p *Principal) {
+	matched := false
+	for _, b := range r.bindings {
+		if !b.matches(p) {
+			continue
+		}
+		matched = true
+		p.Roles = append(p.Roles, b.roles...)
+		if p.Tenant == "" {
+			p.Tenant = b.tenant
+		}
+	}
+	if !matched {
+		p.Roles = append(p.Roles, r.defaults...)
+	}
+}
+
+func (b roleBinding) matches(p *Principal) bool {
+	if b.users[p.Name] {
+		return true
+	}
+	for _, g := range p.Groups {
+		if b.groups[g] {
+			return true
+		}
+	}
+	return false
+}
+
+func parseRoles(names []string) ([]Role, error) {
+	roles := make([]Role, 0, len(names))
+	for _, n := range names {
+		switch r := Role(n); r {
+		case RoleAdmin, RolePlatform, RoleInstanceOwner:
+			roles = append(roles, r)
+		default:
+			return nil, fmt.Errorf("unknown role %q", n)
+		}
+	}
+	return roles, nil
+}
+
+// withRoles assigns roles to the principals authenticated by an authenticator.
+type withRoles struct {
+	Authenticator
+	roles *Roles
+}
+
+func (w withRoles) Authenticate(req *http.Request) (*Principal, error) {
+	p, err := w.Authenticator.Authenticate(req)
+	if err != nil {
+		return nil, err
+	}
+	// Authenticators may cache principals, assign the roles to a copy.
+	assigned := *p
+	assigned.Roles = append([]Role(nil), p.Roles...)
+	w.roles.Assign(&assigned)
+	return &assigned, nil
+}
+
+// RequireRole rejects requests of principals not having one of the roles with 403.
+func RequireRole(roles ...Role) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			p, ok := PrincipalFromContext(req.Context())
+			if !ok || !p.HasRole(roles...) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
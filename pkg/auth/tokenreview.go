@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"broker/pkg/config"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const methodTokenReview = "token-review"
+
+// TokenReview authenticates bearer tokens, e.g. ServiceAccount tokens, with the Kubernetes TokenReview API.
+type TokenReview struct {
+	client    k8sclient.Client
+	audiences []string
+	ttl       time.Duration
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]tokenReviewResult
+}
+
+type tokenReviewResult struct {
+	principal *Principal
+	err       error
+	expires   time.Time
+}
+
+// NewTokenReview creates a TokenReview authenticator using the given client.
+func NewTokenReview(client k8sclient.Client, cfg config.TokenReviewConfig) *TokenReview {
+	return &TokenReview{
+		client:    client,
+		audiences: cfg.Audiences,
+		ttl:       cfg.CacheTTL.Duration,
+		cache:     map[[sha256.Size]byte]tokenReviewResult{},
+	}
+}
+
+// Authenticate implements Authenticator. Results are cached to not review a token on every request.
+func (t *TokenReview) Authenticate(req *http.Request) (*Principal, error) {
+	token, ok := bearerToken(req)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	key := sha256.Sum256([]byte(token))
+
+	now := time.Now()
+	t.mu.Lock()
+	r, ok := t.cache[key]
+	t.mu.Unlock()
+	if ok && now.Before(r.expires) {
+		return r.principal, r.err
+	}
+
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token:     token,
+			Audiences: t.audiences,
+		},
+	}
+	if err := t.client.Create(req.Context(), review); err != nil {
+		// Don't cache failing API calls.
+		return nil, fmt.Errorf("unable to review token: %w", err)
+	}
+
+	r = tokenReviewResult{expires: now.Add(t.ttl)}
+	if review.Status.Authenticated {
+		r.principal = &Principal{
+			Name:   review.Status.User.Username,
+			Method: methodTokenReview,
+			Groups: review.Status.User.Groups,
+		}
+	} else {
+		r.err = errors.New("token not authenticated")
+		if review.Status.Error != "" {
+			r.err = fmt.Errorf("token not authenticated: %s", review.Status.Error)
+		}
+	}
+
+	t.mu.Lock()
+	for k, v := range t.cache {
+		if now.After(v.expires) {
+			delete(t.cache, k)
+		}
+	}
+	t.cache[key] = r
+	t.mu.Unlock()
+
+	return r.principal, r.err
+}
@@ -0,0 +1,839 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"broker/pkg/maintenance"
+
+	"code.cloudfoundry.org/lager"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// LogFormatPretty logs human readable lines
+	LogFormatPretty = "pretty"
+	// LogFormatJSON logs one JSON object per line
+	LogFormatJSON = "json"
+)
+
+// Config contains all settings of the broker.
+type Config struct {
+	ServiceIDs []string        `json:"service_ids"`
+	Auth       AuthConfig      `json:"auth"`
+	HTTP       HTTPConfig      `json:"http"`
+	Log        LogConfig       `json:"log"`
+	Crossplane Crossplane      `json:"crossplane"`
+	Dashboard  DashboardConfig `json:"dashboard"`
+	// ReloadInterval is the interval in which the config file and the password file are checked for changes.
+	// Zero disables reloading.
+	ReloadInterval metav1.Duration `json:"reload_interval"`
+
+	// file is the path of the config file the configuration has been read from.
+	file string
+}
+
+// AuthConfig contains the credentials used to authenticate API calls.
+type AuthConfig struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// PasswordFile is read to get the password, e.g. from a mounted secret. It takes precedence over Password.
+	PasswordFile string `json:"password_file"`
+	// HtpasswdFile contains additional basic auth users with bcrypt hashed passwords in htpasswd format.
+	HtpasswdFile string `json:"htpasswd_file"`
+	// BasicAuthRequiresTLS refuses to start with basic auth credentials but without TLS.
+	// Only disable it if TLS is terminated in front of the broker.
+	BasicAuthRequiresTLS bool                `json:"basic_auth_requires_tls"`
+	ClientCert           ClientCertConfig    `json:"client_cert"`
+	JWT                  JWTConfig           `json:"jwt"`
+	TokenReview          TokenReviewConfig   `json:"token_review"`
+	Authorization        AuthorizationConfig `json:"authorization"`
+}
+
+// AuthorizationConfig assigns roles and tenants to authenticated principals.
+type AuthorizationConfig struct {
+	// DefaultRoles are the roles of principals not matching any binding.
+	DefaultRoles []string `json:"default_roles"`
+	// Bindings assign roles to principals. A principal gets the roles of all matching bindings.
+	Bindings []RoleBinding `json:"bindings"`
+}
+
+// roleInstanceOwner is the role granting access to the instances of a tenant, see auth.RoleInstanceOwner.
+const roleInstanceOwner = "instance-owner"
+
+// RoleBinding assigns roles to users and groups.
+type RoleBinding struct {
+	Roles  []string `json:"roles"`
+	Users  []string `json:"users"`
+	Groups []string `json:"groups"`
+	// Tenant the principals belong to. Principals only own instances of their tenant.
+	// Bindings granting the instance-owner role require a tenant.
+	Tenant string `json:"tenant"`
+}
+
+// JWTConfig configures authentication with bearer tokens issued by an OIDC provider.
+type JWTConfig struct {
+	// Issuer of accepted tokens. Enables JWT authentication.
+	Issuer string `json:"issuer"`
+	// JWKSFile contains the keys to verify token signatures.
+	// If empty, the keys are discovered from the issuer's OpenID configuration.
+	JWKSFile string `json:"jwks_file"`
+	// Audiences accepts tokens containing at least one of these audiences.
+	Audiences []string `json:"audiences"`
+	// UsernameClaim is the claim used as the name of the principal.
+	UsernameClaim string `json:"username_claim"`
+	// GroupsClaim is the claim containing the groups of the principal.
+	GroupsClaim string `json:"groups_claim"`
+}
+
+// TokenReviewConfig configures authentication of bearer tokens using the Kubernetes TokenReview API.
+type TokenReviewConfig struct {
+	Enabled bool `json:"enabled"`
+	// Audiences the token must be valid for. Defaults to the API server's audiences.
+	Audiences []string `json:"audiences"`
+	// CacheTTL is how long a review result is cached.
+	CacheTTL metav1.Duration `json:"cache_ttl"`
+}
+
+// ClientCertConfig configures authentication with TLS client certificates.
+type ClientCertConfig struct {
+	// Enabled allows a verified client certificate to authenticate a request instead of basic auth.
+	Enabled bool `json:"enabled"`
+	// AllowedNames restricts the certificates to the ones with a matching common name or DNS name.
+	// If empty, any certificate signed by the client CA is allowed.
+	AllowedNames []string `json:"allowed_names"`
+}
+
+// HTTPConfig contains the settings of the HTTP server.
+type HTTPConfig struct {
+	ListenAddr          string          `json:"listen_addr"`
+	ReadTimeout         metav1.Duration `json:"read_timeout"`
+	WriteTimeout        metav1.Duration `json:"write_timeout"`
+	MaxHeaderBytes      int             `json:"max_header_bytes"`
+	ShutdownGracePeriod metav1.Duration `json:"shutdown_grace_period"`
+	TLS                 TLSConfig       `json:"tls"`
+	RateLimit           RateLimitConfig `json:"rate_limit"`
+}
+
+// RateLimitConfig limits the request rate of each principal with a token bucket.
+type RateLimitConfig struct {
+	// RequestsPerSecond is the rate at which the bucket is refilled. Zero disables rate limiting.
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	// Burst is the size of the bucket, i.e. the number of requests allowed at once.
+	Burst int `json:"burst"`
+}
+
+// Enabled returns true if requests should be rate limited.
+func (rc RateLimitConfig) Enabled() bool {
+	return rc.RequestsPerSecond > 0
+}
+
+const (
+	// ClientAuthOptional verifies client certificates if they are sent.
+	ClientAuthOptional = "optional"
+	// ClientAuthRequired rejects connections without a valid client certificate.
+	ClientAuthRequired = "required"
+)
+
+// TLSConfig contains the TLS settings of the HTTP server. TLS is enabled if a certificate is configured.
+// All files are reloaded when they change.
+type TLSConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// ClientCAFile enables verification of client certificates against the CAs in this file.
+	ClientCAFile string `json:"client_ca_file"`
+	// ClientAuth is either `optional` or `required`.
+	ClientAuth string `json:"client_auth"`
+}
+
+// Enabled returns true if the server should serve TLS.
+func (tc TLSConfig) Enabled() bool {
+	return tc.CertFile != ""
+}
+
+// DashboardConfig configures the dashboards of instances hosted by the broker.
+type DashboardConfig struct {
+	// URL is the external URL of the broker the dashboard URLs of instances are built from. Enables dashboards.
+	URL string `json:"url"`
+	// TokenKeyFile contains the key signing the tokens which grant access to a dashboard.
+	TokenKeyFile string `json:"token_key_file"`
+	// TokenTTL is how long a signed dashboard link is valid.
+	TokenTTL metav1.Duration `json:"token_ttl"`
+}
+
+// Enabled returns true if instances have dashboards.
+func (dc DashboardConfig) Enabled() bool {
+	return dc.URL != ""
+}
+
+// LogConfig contains the logger settings.
+type LogConfig struct {
+	Level  string `json:"level"`
+	Format string `json:"format"`
+}
+
+const (
+	// DeprovisionBindingsRefuse rejects deleting instances with bindings.
+	DeprovisionBindingsRefuse = "refuse"
+	// DeprovisionBindingsCascade deletes the bindings of an instance before deleting it.
+	DeprovisionBindingsCascade = "cascade"
+)
+
+// Crossplane contains the settings of the control plane client.
+type Crossplane struct {
+	// Namespace in which the broker stores secrets.
+	Namespace string `json:"namespace"`
+	// HaProxyRelease is the name of the helm release exposing an instance.
+	HaProxyRelease string `json:"haproxy_release"`
+	// DownstreamRefreshInterval is the interval in which the clients of the downstream clusters are checked
+	// and rebuilt if their credentials changed. Zero disables refreshing.
+	DownstreamRefreshInterval metav1.Duration `json:"downstream_refresh_interval"`
+	// DevContexts maps ProviderConfig names to contexts of the local kubeconfig.
+	// Used in local development to connect to local clusters instead of the ones configured in the ProviderConfigs.
+	DevContexts map[string]string `json:"dev_contexts"`
+	// MariadbMaxDatabases is the number of databases a MariaDB cluster can hold if its plan doesn't specify it.
+	// Zero means unlimited.
+	MariadbMaxDatabases int `json:"mariadb_max_databases"`
+	// DeprovisionBindings is either `refuse` or `cascade` and decides what happens when deleting an instance which still has bindings.
+	DeprovisionBindings string `json:"deprovision_bindings"`
+	// Quota limits the instances organizations and spaces can provision.
+	Quota QuotaConfig `json:"quota"`
+	// Placement is the pool of clusters instances of plans without a cluster are placed on.
+	Placement PlacementConfig `json:"placement"`
+	// UpgradeInterval is the interval in which instances in their maintenance window are upgraded. Zero disables upgrades.
+	UpgradeInterval metav1.Duration `json:"upgrade_interval"`
+	// MaintenanceWindow of instances without a window of their own. Empty means such instances aren't upgraded.
+	MaintenanceWindow string `json:"maintenance_window"`
+	// ActionCooldown is the minimum time between two restart or failover actions on an instance. Zero disables the limit.
+	ActionCooldown metav1.Duration `json:"action_cooldown"`
+}
+
+// PlacementConfig is the pool of service clusters new instances are placed on.
+type PlacementConfig struct {
+	// Clusters in the pool.
+	Clusters []ClusterConfig `json:"clusters"`
+}
+
+// ClusterConfig describes a service cluster of the placement pool.
+type ClusterConfig struct {
+	// Name of the ProviderConfig of the cluster, also used as the cluster label of the instances placed on it.
+	Name string `json:"name"`
+	// Region of the cluster, instances requesting a region are only placed on clusters in it.
+	Region string `json:"region"`
+	// Zone of the cluster, clusters in the zone requested by an instance are preferred.
+	Zone string `json:"zone"`
+	// Capacity is the maximum number of instances on the cluster. Zero means unlimited.
+	Capacity int `json:"capacity"`
+}
+
+// QuotaConfig limits the instances of organizations and spaces. Instances without organization aren't limited.
+type QuotaConfig struct {
+	// Organization limits the instances of each organization.
+	Organization QuotaLimits `json:"organization"`
+	// Organizations overrides the limits of single organizations, keyed by organization GUID.
+	Organizations map[string]QuotaLimits `json:"organizations"`
+	// Space limits the instances of each space.
+	Space QuotaLimits `json:"space"`
+	// Plans limits the number of instances of a plan in each organization, keyed by plan ID.
+	Plans map[string]int `json:"plans"`
+}
+
+// QuotaLimits are the limits of a quota. Zero values are unlimited.
+type QuotaLimits struct {
+	// Instances is the maximum number of instances.
+	Instances int `json:"instances"`
+	// Memory is the maximum memory of all instances as set by the annotations of their plans.
+	Memory resource.Quantity `json:"memory"`
+	// Storage is the maximum storage of all instances as set by the annotations of their plans.
+	Storage resource.Quantity `json:"storage"`
+}
+
+// IsZero returns true if the quota doesn't limit anything.
+func (ql QuotaLimits) IsZero() bool {
+	return ql.Instances == 0 && ql.Memory.IsZero() && ql.Storage.IsZero()
+}
+
+// Enabled returns true if any quota is configured.
+func (qc QuotaConfig) Enabled() bool {
+	return !qc.Organization.IsZero() || len(qc.Organizations) > 0 || !qc.Space.IsZero() || len(qc.Plans) > 0
+}
+
+// Default returns the configuration used when nothing else is specified.
+func Default() *Config {
+	return &Config{
+		HTTP: HTTPConfig{
+			ListenAddr:          ":8080",
+			ReadTimeout:         metav1.Duration{Duration: 180 * time.Second},
+			WriteTimeout:        metav1.Duration{Duration: 180 * time.Second},
+			MaxHeaderBytes:      1 << 20, // 1 MB
+			ShutdownGracePeriod: metav1.Duration{Duration: 10 * time.Second},
+			TLS: TLSConfig{
+				ClientAuth: ClientAuthOptional,
+			},
+			RateLimit: RateLimitConfig{
+				Burst: 20,
+			},
+		},
+		Auth: AuthConfig{
+			BasicAuthRequiresTLS: true,
+			JWT: JWTConfig{
+				UsernameClaim: "sub",
+				GroupsClaim:   "groups",
+			},
+			TokenReview: TokenReviewConfig{
+				CacheTTL: metav1.Duration{Duration: time.Minute},
+			},
+			Authorization: AuthorizationConfig{
+				DefaultRoles: []string{"platform"},
+			},
+		},
+		Log: LogConfig{
+			Level:  "debug",
+			Format: LogFormatPretty,
+		},
+		Crossplane: Crossplane{
+			Namespace:                 "spks-crossplane",
+			HaProxyRelease:            "haproxy",
+			DownstreamRefreshInterval: metav1.Duration{Duration: time.Minute},
+			DeprovisionBindings:       DeprovisionBindingsRefuse,
+			ActionCooldown:            metav1.Duration{Duration: 5 * time.Minute},
+		},
+		Dashboard: DashboardConfig{
+			TokenTTL: metav1.Duration{Duration: 15 * time.Minute},
+		},
+		ReloadInterval: metav1.Duration{Duration: 10 * time.Second},
+	}
+}
+
+// setting maps a single value to its flag and environment variable.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(cfg *Config, value string) error
+}
+
+var settings = []setting{
+	{"service-ids", "OSB_SERVICE_IDS", "comma separated list of service IDs", func(cfg *Config, v string) error {
+		cfg.ServiceIDs = strings.Split(v, ",")
+		return nil
+	}},
+	{"username", "OSB_USERNAME", "basic auth username", func(cfg *Config, v string) error {
+		cfg.Auth.Username = v
+		return nil
+	}},
+	{"password", "OSB_PASSWORD", "basic auth password", func(cfg *Config, v string) error {
+		cfg.Auth.Password = v
+		return nil
+	}},
+	{"password-file", "OSB_PASSWORD_FILE", "file containing the basic auth password", func(cfg *Config, v string) error {
+		cfg.Auth.PasswordFile = v
+		return nil
+	}},
+	{"htpasswd-file", "OSB_HTPASSWD_FILE", "htpasswd file with bcrypt hashed basic auth credentials", func(cfg *Config, v string) error {
+		cfg.Auth.HtpasswdFile = v
+		return nil
+	}},
+	{"basic-auth-requires-tls", "OSB_BASIC_AUTH_REQUIRES_TLS", "refuse to start with basic auth credentials but without TLS", func(cfg *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		cfg.Auth.BasicAuthRequiresTLS = b
+		return nil
+	}},
+	{"jwt-issuer", "OSB_JWT_ISSUER", "issuer of accepted JWT bearer tokens", func(cfg *Config, v string) error {
+		cfg.Auth.JWT.Issuer = v
+		return nil
+	}},
+	{"jwt-jwks-file", "OSB_JWT_JWKS_FILE", "JWKS file to verify JWT bearer tokens", func(cfg *Config, v string) error {
+		cfg.Auth.JWT.JWKSFile = v
+		return nil
+	}},
+	{"token-review", "OSB_TOKEN_REVIEW", "authenticate bearer tokens using the Kubernetes TokenReview API", func(cfg *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		cfg.Auth.TokenReview.Enabled = b
+		return nil
+	}},
+	{"listen-addr", "OSB_HTTP_LISTEN_ADDR", "address the HTTP server listens on", func(cfg *Config, v string) error {
+		cfg.HTTP.ListenAddr = v
+		return nil
+	}},
+	{"read-timeout", "OSB_HTTP_READ_TIMEOUT", "HTTP read timeout", func(cfg *Config, v string) error {
+		return parseDuration(v, &cfg.HTTP.ReadTimeout)
+	}},
+	{"write-timeout", "OSB_HTTP_WRITE_TIMEOUT", "HTTP write timeout", func(cfg *Config, v string) error {
+		return parseDuration(v, &cfg.HTTP.WriteTimeout)
+	}},
+	{"max-header-bytes", "OSB_HTTP_MAX_HEADER_BYTES", "maximum size of request headers", func(cfg *Config, v string) error {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		cfg.HTTP.MaxHeaderBytes = i
+		return nil
+	}},
+	{"shutdown-grace-period", "OSB_SHUTDOWN_GRACE_PERIOD", "time to wait for in-flight requests on shutdown", func(cfg *Config, v string) error {
+		return parseDuration(v, &cfg.HTTP.ShutdownGracePeriod)
+	}},
+	{"tls-cert-file", "OSB_TLS_CERT_FILE", "TLS certificate, enables TLS", func(cfg *Config, v string) error {
+		cfg.HTTP.TLS.CertFile = v
+		return nil
+	}},
+	{"tls-key-file", "OSB_TLS_KEY_FILE", "TLS private key", func(cfg *Config, v string) error {
+		cfg.HTTP.TLS.KeyFile = v
+		return nil
+	}},
+	{"tls-client-ca-file", "OSB_TLS_CLIENT_CA_FILE", "CA certificates to verify client certificates", func(cfg *Config, v string) error {
+		cfg.HTTP.TLS.ClientCAFile = v
+		return nil
+	}},
+	{"tls-client-auth", "OSB_TLS_CLIENT_AUTH", "client certificate policy (optional, required)", func(cfg *Config, v string) error {
+		cfg.HTTP.TLS.ClientAuth = v
+		return nil
+	}},
+	{"rate-limit", "OSB_RATE_LIMIT", "requests per second allowed per principal, 0 disables rate limiting", func(cfg *Config, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		cfg.HTTP.RateLimit.RequestsPerSecond = f
+		return nil
+	}},
+	{"rate-limit-burst", "OSB_RATE_LIMIT_BURST", "number of requests a principal may send at once", func(cfg *Config, v string) error {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		cfg.HTTP.RateLimit.Burst = i
+		return nil
+	}},
+	{"reload-interval", "OSB_RELOAD_INTERVAL", "interval to check configuration files for changes, 0 disables reloading", func(cfg *Config, v string) error {
+		return parseDuration(v, &cfg.ReloadInterval)
+	}},
+	{"log-level", "OSB_LOG_LEVEL", "log level (debug, info, error, fatal)", func(cfg *Config, v string) error {
+		cfg.Log.Level = v
+		return nil
+	}},
+	{"log-format", "OSB_LOG_FORMAT", "log format (pretty, json)", func(cfg *Config, v string) error {
+		cfg.Log.Format = v
+		return nil
+	}},
+	{"namespace", "OSB_NAMESPACE", "namespace in which the broker stores secrets", func(cfg *Config, v string) error {
+		cfg.Crossplane.Namespace = v
+		return nil
+	}},
+	{"haproxy-release", "OSB_HAPROXY_RELEASE", "name of the HAProxy helm release of an instance", func(cfg *Config, v string) error {
+		cfg.Crossplane.HaProxyRelease = v
+		return nil
+	}},
+	{"dev-contexts", "OSB_DEV_CONTEXTS", "comma separated list of provider-config=kubeconfig-context mappings for local development", func(cfg *Config, v string) error {
+		contexts := map[string]string{}
+		for _, m := range strings.Split(v, ",") {
+			kv := strings.SplitN(m, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("mapping %q is not of the form provider-config=context", m)
+			}
+			contexts[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+		cfg.Crossplane.DevContexts = contexts
+		return nil
+	}},
+	{"downstream-refresh-interval", "OSB_DOWNSTREAM_REFRESH_INTERVAL", "interval to check downstream clusters and refresh their clients, 0 disables refreshing", func(cfg *Config, v string) error {
+		return parseDuration(v, &cfg.Crossplane.DownstreamRefreshInterval)
+	}},
+	{"mariadb-max-databases", "OSB_MARIADB_MAX_DATABASES", "number of databases of a MariaDB cluster if not set by its plan, 0 is unlimited", func(cfg *Config, v string) error {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		cfg.Crossplane.MariadbMaxDatabases = i
+		return nil
+	}},
+	{"upgrade-interval", "OSB_UPGRADE_INTERVAL", "interval to upgrade instances in their maintenance window, 0 disables upgrades", func(cfg *Config, v string) error {
+		return parseDuration(v, &cfg.Crossplane.UpgradeInterval)
+	}},
+	{"maintenance-window", "OSB_MAINTENANCE_WINDOW", "maintenance window of instances without their own, e.g. 'sun 02:00-04:00' (UTC)", func(cfg *Config, v string) error {
+		cfg.Crossplane.MaintenanceWindow = v
+		return nil
+	}},
+	{"action-cooldown", "OSB_ACTION_COOLDOWN", "minimum time between restart or failover actions on an instance, 0 disables the limit", func(cfg *Config, v string) error {
+		return parseDuration(v, &cfg.Crossplane.ActionCooldown)
+	}},
+	{"dashboard-url", "OSB_DASHBOARD_URL", "external URL of the broker, enables instance dashboards", func(cfg *Config, v string) error {
+		cfg.Dashboard.URL = v
+		return nil
+	}},
+	{"dashboard-token-key-file", "OSB_DASHBOARD_TOKEN_KEY_FILE", "file containing the key signing dashboard tokens", func(cfg *Config, v string) error {
+		cfg.Dashboard.TokenKeyFile = v
+		return nil
+	}},
+	{"dashboard-token-ttl", "OSB_DASHBOARD_TOKEN_TTL", "validity of signed dashboard links", func(cfg *Config, v string) error {
+		return parseDuration(v, &cfg.Dashboard.TokenTTL)
+	}},
+	{"deprovision-bindings", "OSB_DEPROVISION_BINDINGS", "deleting an instance with bindings (refuse, cascade)", func(cfg *Config, v string) error {
+		cfg.Crossplane.DeprovisionBindings = v
+		return nil
+	}},
+}
+
+// Load reads the configuration. Values are read from (in increasing precedence)
+// the defaults, the configuration file, the environment and the command line flags.
+// The resulting configuration is validated.
+func Load(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	fs := flag.NewFlagSet("broker", flag.ContinueOnError)
+	configFile := fs.String("config", "", "path to the YAML configuration file (env: OSB_CONFIG_FILE)")
+	flagValues := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		flagValues[s.flag] = fs.String(s.flag, "", fmt.Sprintf("%s (env: %s)", s.usage, s.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %q", fs.Args())
+	}
+
+	cfg := Default()
+
+	if *configFile == "" {
+		*configFile, _ = lookupEnv("OSB_CONFIG_FILE")
+	}
+	if *configFile != "" {
+		if err := cfg.readFile(*configFile); err != nil {
+			return nil, err
+		}
+		cfg.file = *configFile
+	}
+
+	for _, s := range settings {
+		v, ok := lookupEnv(s.env)
+		if !ok {
+			continue
+		}
+		if err := s.set(cfg, v); err != nil {
+			return nil, fmt.Errorf("invalid value %q for %s: %w", v, s.env, err)
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag != f.Name || err != nil {
+				continue
+			}
+			if setErr := s.set(cfg, *flagValues[s.flag]); setErr != nil {
+				err = fmt.Errorf("invalid value %q for flag --%s: %w", *flagValues[s.flag], s.flag, setErr)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Auth.PasswordFile != "" {
+		b, err := ioutil.ReadFile(cfg.Auth.PasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read password file: %w", err)
+		}
+		cfg.Auth.Password = strings.TrimSpace(string(b))
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) readFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read config file: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(b, cfg); err != nil {
+		return fmt.Errorf("unable to parse config file %q: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks that all required settings are present and valid.
+func (cfg *Config) Validate() error {
+	for i := range cfg.ServiceIDs {
+		cfg.ServiceIDs[i] = strings.TrimSpace(cfg.ServiceIDs[i])
+		if len(cfg.ServiceIDs[i]) == 0 {
+			return errors.New("service IDs must not contain empty values")
+		}
+	}
+	if len(cfg.ServiceIDs) == 0 {
+		return errors.New("OSB_SERVICE_IDS is required")
+	}
+	if err := cfg.Auth.validate(); err != nil {
+		return err
+	}
+
+	if _, _, err := net.SplitHostPort(cfg.HTTP.ListenAddr); err != nil {
+		return fmt.Errorf("invalid listen address %q: %w", cfg.HTTP.ListenAddr, err)
+	}
+	for name, d := range map[string]metav1.Duration{
+		"read timeout":          cfg.HTTP.ReadTimeout,
+		"write timeout":         cfg.HTTP.WriteTimeout,
+		"shutdown grace period": cfg.HTTP.ShutdownGracePeriod,
+	} {
+		if d.Duration <= 0 {
+			return fmt.Errorf("%s must be positive, got %s", name, d.Duration)
+		}
+	}
+	if cfg.HTTP.MaxHeaderBytes <= 0 {
+		return fmt.Errorf("max header bytes must be positive, got %d", cfg.HTTP.MaxHeaderBytes)
+	}
+	if err := cfg.HTTP.TLS.validate(); err != nil {
+		return err
+	}
+	if cfg.HTTP.RateLimit.RequestsPerSecond < 0 {
+		return fmt.Errorf("rate limit must not be negative, got %g", cfg.HTTP.RateLimit.RequestsPerSecond)
+	}
+	if cfg.HTTP.RateLimit.Enabled() && cfg.HTTP.RateLimit.Burst <= 0 {
+		return fmt.Errorf("rate limit burst must be positive, got %d", cfg.HTTP.RateLimit.Burst)
+	}
+	if cfg.Auth.ClientCert.Enabled && cfg.HTTP.TLS.ClientCAFile == "" {
+		return errors.New("client certificate authentication requires a TLS client CA file")
+	}
+	if cfg.Auth.BasicAuthRequiresTLS && cfg.Auth.basicAuth() && !cfg.HTTP.TLS.Enabled() {
+		return errors.New("basic auth requires TLS but no TLS certificate is configured")
+	}
+
+	if cfg.ReloadInterval.Duration < 0 {
+		return fmt.Errorf("reload interval must not be negative, got %s", cfg.ReloadInterval.Duration)
+	}
+
+	if _, err := cfg.Log.LogLevel(); err != nil {
+		return err
+	}
+	if cfg.Log.Format != LogFormatPretty && cfg.Log.Format != LogFormatJSON {
+		return fmt.Errorf("invalid log format %q, must be one of %q or %q", cfg.Log.Format, LogFormatPretty, LogFormatJSON)
+	}
+
+	if cfg.Crossplane.Namespace == "" {
+		return errors.New("crossplane namespace is required")
+	}
+	if cfg.Crossplane.HaProxyRelease == "" {
+		return errors.New("crossplane HAProxy release name is required")
+	}
+	for pc, context := range cfg.Crossplane.DevContexts {
+		if pc == "" || context == "" {
+			return fmt.Errorf("invalid dev context mapping %q=%q", pc, context)
+		}
+	}
+	if cfg.Crossplane.DownstreamRefreshInterval.Duration < 0 {
+		return fmt.Errorf("downstream refresh interval must not be negative, got %s", cfg.Crossplane.DownstreamRefreshInterval.Duration)
+	}
+	if cfg.Crossplane.UpgradeInterval.Duration < 0 {
+		return fmt.Errorf("upgrade interval must not be negative, got %s", cfg.Crossplane.UpgradeInterval.Duration)
+	}
+	if cfg.Crossplane.ActionCooldown.Duration < 0 {
+		return fmt.Errorf("action cooldown must not be negative, got %s", cfg.Crossplane.ActionCooldown.Duration)
+	}
+	if cfg.Crossplane.MaintenanceWindow != "" {
+		if _, err := maintenance.Parse(cfg.Crossplane.MaintenanceWindow); err != nil {
+			return err
+		}
+	}
+	if cfg.Crossplane.MariadbMaxDatabases < 0 {
+		return fmt.Errorf("MariaDB max databases must not be negative, got %d", cfg.Crossplane.MariadbMaxDatabases)
+	}
+	if cfg.Crossplane.DeprovisionBindings != DeprovisionBindingsRefuse && cfg.Crossplane.DeprovisionBindings != DeprovisionBindingsCascade {
+		return fmt.Errorf("invalid deprovision bindings policy %q, must be one of %q or %q", cfg.Crossplane.DeprovisionBindings, DeprovisionBindingsRefuse, DeprovisionBindingsCascade)
+	}
+	if err := cfg.Crossplane.Quota.validate(); err != nil {
+		return err
+	}
+	if err := cfg.Crossplane.Placement.validate(); err != nil {
+		return err
+	}
+	if err := cfg.Dashboard.validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (dc DashboardConfig) validate() error {
+	if !dc.Enabled() {
+		return nil
+	}
+	u, err := url.Parse(dc.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid dashboard URL %q, must be an absolute http or https URL", dc.URL)
+	}
+	if dc.TokenKeyFile == "" {
+		return errors.New("dashboards require a token key file")
+	}
+	if dc.TokenTTL.Duration <= 0 {
+		return fmt.Errorf("dashboard token TTL must be positive, got %s", dc.TokenTTL.Duration)
+	}
+	return nil
+}
+
+func (pc PlacementConfig) validate() error {
+	names := map[string]bool{}
+	for _, c := range pc.Clusters {
+		if c.Name == "" {
+			return errors.New("clusters of the placement pool require a name")
+		}
+		if names[c.Name] {
+			return fmt.Errorf("duplicate cluster %q in placement pool", c.Name)
+		}
+		names[c.Name] = true
+		// The name is stored as label on the instances placed on the cluster.
+		if errs := validation.IsValidLabelValue(c.Name); len(errs) > 0 {
+			return fmt.Errorf("invalid name of cluster %q: %s", c.Name, strings.Join(errs, ", "))
+		}
+		if c.Capacity < 0 {
+			return fmt.Errorf("capacity of cluster %q must not be negative, got %d", c.Name, c.Capacity)
+		}
+	}
+	return nil
+}
+
+func (qc QuotaConfig) validate() error {
+	if err := qc.Organization.validate("organization"); err != nil {
+		return err
+	}
+	for org, limits := range qc.Organizations {
+		if err := limits.validate(fmt.Sprintf("organization %q", org)); err != nil {
+			return err
+		}
+	}
+	if err := qc.Space.validate("space"); err != nil {
+		return err
+	}
+	for plan, max := range qc.Plans {
+		if max < 0 {
+			return fmt.Errorf("quota of plan %q must not be negative, got %d", plan, max)
+		}
+	}
+	return nil
+}
+
+func (ql QuotaLimits) validate(name string) error {
+	if ql.Instances < 0 {
+		return fmt.Errorf("instance quota of %s must not be negative, got %d", name, ql.Instances)
+	}
+	if ql.Memory.Sign() < 0 {
+		return fmt.Errorf("memory quota of %s must not be negative, got %s", name, ql.Memory.String())
+	}
+	if ql.Storage.Sign() < 0 {
+		return fmt.Errorf("storage quota of %s must not be negative, got %s", name, ql.Storage.String())
+	}
+	return nil
+}
+
+// basicAuth returns true if basic auth credentials are configured.
+func (ac AuthConfig) basicAuth() bool {
+	return ac.Username != "" || ac.HtpasswdFile != ""
+}
+
+func (ac AuthConfig) validate() error {
+	otherMethods := ac.HtpasswdFile != "" || ac.ClientCert.Enabled || ac.JWT.Issuer != "" || ac.TokenReview.Enabled
+	// Static credentials are optional if any other authentication method is configured.
+	if ac.Username != "" || ac.Password != "" || !otherMethods {
+		if ac.Username == "" {
+			return errors.New("OSB_USERNAME is required")
+		}
+		if ac.Password == "" {
+			return errors.New("OSB_PASSWORD is required")
+		}
+	}
+	if ac.JWT.JWKSFile != "" && ac.JWT.Issuer == "" {
+		return errors.New("JWT authentication requires an issuer")
+	}
+	if ac.JWT.Issuer != "" && ac.JWT.UsernameClaim == "" {
+		return errors.New("JWT username claim is required")
+	}
+	if ac.TokenReview.CacheTTL.Duration < 0 {
+		return fmt.Errorf("token review cache TTL must not be negative, got %s", ac.TokenReview.CacheTTL.Duration)
+	}
+	for i, b := range ac.Authorization.Bindings {
+		if len(b.Roles) == 0 {
+			return fmt.Errorf("role binding %d has no roles", i)
+		}
+		if len(b.Users) == 0 && len(b.Groups) == 0 {
+			return fmt.Errorf("role binding %d has neither users nor groups", i)
+		}
+		if errs := validation.IsValidLabelValue(b.Tenant); len(errs) > 0 {
+			return fmt.Errorf("invalid tenant %q of role binding %d: %s", b.Tenant, i, strings.Join(errs, ", "))
+		}
+		if b.Tenant == "" && hasRole(b.Roles, roleInstanceOwner) {
+			return fmt.Errorf("role binding %d grants the %s role and requires a tenant", i, roleInstanceOwner)
+		}
+	}
+	if hasRole(ac.Authorization.DefaultRoles, roleInstanceOwner) {
+		return fmt.Errorf("the %s role requires a tenant and can't be a default role", roleInstanceOwner)
+	}
+	return nil
+}
+
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (tc TLSConfig) validate() error {
+	if (tc.CertFile == "") != (tc.KeyFile == "") {
+		return errors.New("TLS certificate and key file must be configured together")
+	}
+	if tc.ClientCAFile != "" && !tc.Enabled() {
+		return errors.New("TLS client CA file requires a TLS certificate")
+	}
+	if tc.ClientAuth != ClientAuthOptional && tc.ClientAuth != ClientAuthRequired {
+		return fmt.Errorf("invalid TLS client auth %q, must be one of %q or %q", tc.ClientAuth, ClientAuthOptional, ClientAuthRequired)
+	}
+	if tc.ClientAuth == ClientAuthRequired && tc.ClientCAFile == "" {
+		return errors.New("required TLS client auth needs a TLS client CA file")
+	}
+	return nil
+}
+
+// Files returns the paths of all files the configuration has been read from.
+func (cfg *Config) Files() []string {
+	files := []string{}
+	if cfg.file != "" {
+		files = append(files, cfg.file)
+	}
+	for _, f := range []string{cfg.Auth.PasswordFile, cfg.Auth.HtpasswdFile, cfg.Auth.JWT.JWKSFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// LogLevel returns the parsed log level.
+func (lc LogConfig) LogLevel() (lager.LogLevel, error) {
+	return lager.LogLevelFromString(lc.Level)
+}
+
+func parseDuration(v string, d *metav1.Duration) error {
+	pd, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	d.Duration = pd
+	return nil
+}
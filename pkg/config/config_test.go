@@ -0,0 +1,258 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	}
+}
+
+var requiredEnv = map[string]string{
+	"OSB_SERVICE_IDS": "a, b",
+	"OSB_USERNAME":    "user",
+	"OSB_PASSWORD":    "secret",
+	// The tests don't configure TLS.
+	"OSB_BASIC_AUTH_REQUIRES_TLS": "false",
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := Load(nil, env(requiredEnv))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"a", "b"}, cfg.ServiceIDs)
+	assert.Equal(t, ":8080", cfg.HTTP.ListenAddr)
+	assert.Equal(t, 180*time.Second, cfg.HTTP.ReadTimeout.Duration)
+	assert.Equal(t, 10*time.Second, cfg.HTTP.ShutdownGracePeriod.Duration)
+	assert.Equal(t, "spks-crossplane", cfg.Crossplane.Namespace)
+	assert.Equal(t, "haproxy", cfg.Crossplane.HaProxyRelease)
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeConfigFile(t, `
+service_ids: [from-file]
+auth:
+  username: file-user
+  password: file-password
+  basic_auth_requires_tls: false
+http:
+  listen_addr: ":9000"
+  read_timeout: 5s
+log:
+  format: json
+crossplane:
+  namespace: file-namespace
+`)
+
+	cfg, err := Load(
+		[]string{"--config", path, "--listen-addr", ":9100"},
+		env(map[string]string{
+			"OSB_USERNAME":          "env-user",
+			"OSB_HTTP_LISTEN_ADDR":  ":9050",
+			"OSB_HTTP_READ_TIMEOUT": "7s",
+		}),
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"from-file"}, cfg.ServiceIDs)
+	assert.Equal(t, "env-user", cfg.Auth.Username)
+	assert.Equal(t, "file-password", cfg.Auth.Password)
+	assert.Equal(t, ":9100", cfg.HTTP.ListenAddr)
+	assert.Equal(t, 7*time.Second, cfg.HTTP.ReadTimeout.Duration)
+	assert.Equal(t, LogFormatJSON, cfg.Log.Format)
+	assert.Equal(t, "file-namespace", cfg.Crossplane.Namespace)
+}
+
+func TestLoad_Quota(t *testing.T) {
+	path := writeConfigFile(t, `
+crossplane:
+  quota:
+    organization:
+      instances: 10
+      memory: 8Gi
+    organizations:
+      org-a:
+        storage: 100Gi
+    space:
+      instances: 3
+    plans:
+      redis-large: 1
+`)
+	cfg, err := Load([]string{"--config", path}, env(requiredEnv))
+	require.NoError(t, err)
+
+	q := cfg.Crossplane.Quota
+	assert.True(t, q.Enabled())
+	assert.Equal(t, 10, q.Organization.Instances)
+	assert.Equal(t, resource.MustParse("8Gi"), q.Organization.Memory)
+	assert.True(t, q.Organization.Storage.IsZero())
+	assert.Equal(t, resource.MustParse("100Gi"), q.Organizations["org-a"].Storage)
+	assert.Equal(t, 3, q.Space.Instances)
+	assert.Equal(t, map[string]int{"redis-large": 1}, q.Plans)
+
+	assert.False(t, Default().Crossplane.Quota.Enabled())
+}
+
+func TestLoad_Placement(t *testing.T) {
+	path := writeConfigFile(t, `
+crossplane:
+  placement:
+    clusters:
+      - name: service-1
+        region: eu-central
+        zone: zone-a
+        capacity: 50
+      - name: service-2
+        region: eu-central
+`)
+	cfg, err := Load([]string{"--config", path}, env(requiredEnv))
+	require.NoError(t, err)
+
+	assert.Equal(t, []ClusterConfig{
+		{Name: "service-1", Region: "eu-central", Zone: "zone-a", Capacity: 50},
+		{Name: "service-2", Region: "eu-central"},
+	}, cfg.Crossplane.Placement.Clusters)
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	tests := map[string]struct {
+		args []string
+		env  map[string]string
+		file string
+		err  string
+	}{
+		"invalid duration": {
+			env: map[string]string{"OSB_HTTP_READ_TIMEOUT": "forever"},
+			err: `invalid value "forever" for OSB_HTTP_READ_TIMEOUT`,
+		},
+		"invalid flag value": {
+			args: []string{"--max-header-bytes", "lots"},
+			err:  `invalid value "lots" for flag --max-header-bytes`,
+		},
+		"negative timeout": {
+			env: map[string]string{"OSB_HTTP_WRITE_TIMEOUT": "-1s"},
+			err: "write timeout must be positive",
+		},
+		"invalid log level": {
+			env: map[string]string{"OSB_LOG_LEVEL": "verbose"},
+			err: "invalid log level",
+		},
+		"invalid log format": {
+			env: map[string]string{"OSB_LOG_FORMAT": "xml"},
+			err: `invalid log format "xml"`,
+		},
+		"empty service id": {
+			env: map[string]string{"OSB_SERVICE_IDS": "a,,b"},
+			err: "service IDs must not contain empty values",
+		},
+		"rate limit without burst": {
+			env: map[string]string{"OSB_RATE_LIMIT": "5", "OSB_RATE_LIMIT_BURST": "0"},
+			err: "rate limit burst must be positive",
+		},
+		"invalid dev context": {
+			env: map[string]string{"OSB_DEV_CONTEXTS": "cluster-1"},
+			err: `mapping "cluster-1" is not of the form provider-config=context`,
+		},
+		"negative MariaDB max databases": {
+			env: map[string]string{"OSB_MARIADB_MAX_DATABASES": "-1"},
+			err: "MariaDB max databases must not be negative, got -1",
+		},
+		"invalid deprovision bindings policy": {
+			env: map[string]string{"OSB_DEPROVISION_BINDINGS": "ignore"},
+			err: `invalid deprovision bindings policy "ignore"`,
+		},
+		"negative quota": {
+			file: "crossplane: {quota: {organizations: {org-a: {memory: -1Gi}}}}",
+			err:  `memory quota of organization "org-a" must not be negative, got -1Gi`,
+		},
+		"duplicate placement cluster": {
+			file: "crossplane: {placement: {clusters: [{name: service-1}, {name: service-1}]}}",
+			err:  `duplicate cluster "service-1" in placement pool`,
+		},
+		"negative cluster capacity": {
+			file: "crossplane: {placement: {clusters: [{name: service-1, capacity: -1}]}}",
+			err:  `capacity of cluster "service-1" must not be negative, got -1`,
+		},
+		"invalid maintenance window": {
+			env: map[string]string{"OSB_MAINTENANCE_WINDOW": "sunday"},
+			err: `invalid maintenance window "sunday"`,
+		},
+		"negative upgrade interval": {
+			args: []string{"--upgrade-interval", "-1m"},
+			err:  "upgrade interval must not be negative, got -1m0s",
+		},
+		"negative action cooldown": {
+			env: map[string]string{"OSB_ACTION_COOLDOWN": "-5m"},
+			err: "action cooldown must not be negative, got -5m0s",
+		},
+		"relative dashboard URL": {
+			env: map[string]string{"OSB_DASHBOARD_URL": "/dashboards", "OSB_DASHBOARD_TOKEN_KEY_FILE": "key"},
+			err: `invalid dashboard URL "/dashboards"`,
+		},
+		"dashboard without token key": {
+			env: map[string]string{"OSB_DASHBOARD_URL": "https://broker.example.com"},
+			err: "dashboards require a token key file",
+		},
+		"basic auth without TLS": {
+			env: map[string]string{"OSB_BASIC_AUTH_REQUIRES_TLS": "true"},
+			err: "basic auth requires TLS but no TLS certificate is configured",
+		},
+		"instance owner without tenant": {
+			file: "auth: {authorization: {bindings: [{roles: [instance-owner], groups: [team-a]}]}}",
+			err:  "role binding 0 grants the instance-owner role and requires a tenant",
+		},
+		"instance owner default role": {
+			file: "auth: {authorization: {default_roles: [instance-owner]}}",
+			err:  "the instance-owner role requires a tenant and can't be a default role",
+		},
+		"unknown config field": {
+			file: "unknown: true",
+			err:  `unknown field "unknown"`,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			vars := map[string]string{}
+			for k, v := range requiredEnv {
+				vars[k] = v
+			}
+			for k, v := range tc.env {
+				vars[k] = v
+			}
+			args := tc.args
+			if tc.file != "" {
+				args = append(args, "--config", writeConfigFile(t, tc.file))
+			}
+
+			_, err := Load(args, env(vars))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
+
+func TestLoad_MissingCredentials(t *testing.T) {
+	_, err := Load(nil, env(map[string]string{"OSB_SERVICE_IDS": "a"}))
+	assert.EqualError(t, err, "OSB_USERNAME is required")
+}
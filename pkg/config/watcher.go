@@ -0,0 +1,83 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// Watcher reloads the configuration whenever one of the files it has been read from changes.
+// Files are polled rather than watched with inotify, since mounted Kubernetes secrets and config maps
+// are updated by swapping symlinks.
+type Watcher struct {
+	args      []string
+	lookupEnv func(string) (string, bool)
+	logger    lager.Logger
+	checksum  string
+}
+
+// NewWatcher creates a watcher loading the configuration the same way as `Load`.
+func NewWatcher(args []string, lookupEnv func(string) (string, bool), logger lager.Logger) *Watcher {
+	return &Watcher{
+		args:      args,
+		lookupEnv: lookupEnv,
+		logger:    logger,
+	}
+}
+
+// Run checks the files of the current configuration for changes every `current.ReloadInterval`
+// and calls `onChange` with every successfully loaded new configuration.
+// Invalid configurations are logged and ignored. Run blocks until the context is cancelled.
+func (w *Watcher) Run(ctx context.Context, current *Config, onChange func(*Config)) {
+	if current.ReloadInterval.Duration == 0 {
+		return
+	}
+	w.checksum = checksum(current.Files())
+
+	ticker := time.NewTicker(current.ReloadInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		sum := checksum(current.Files())
+		if sum == w.checksum {
+			continue
+		}
+		w.checksum = sum
+
+		cfg, err := Load(w.args, w.lookupEnv)
+		if err != nil {
+			w.logger.Error("reload-config", err)
+			continue
+		}
+		w.checksum = checksum(cfg.Files())
+		current = cfg
+
+		w.logger.Info("config-changed", lager.Data{"files": cfg.Files()})
+		onChange(cfg)
+	}
+}
+
+// checksum returns a combined checksum of the contents of all files.
+// Files which can't be read contribute their error, so that they are reloaded once they are readable.
+func checksum(files []string) string {
+	h := sha256.New()
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			b = []byte(err.Error())
+		}
+		h.Write([]byte(f))
+		h.Write(b)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
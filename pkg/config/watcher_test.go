@@ -0,0 +1,46 @@
+package config
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatcher_ReloadsPasswordFile(t *testing.T) {
+	path := writeConfigFile(t, "first")
+	vars := map[string]string{
+		"OSB_SERVICE_IDS":             "a",
+		"OSB_USERNAME":                "user",
+		"OSB_PASSWORD_FILE":           path,
+		"OSB_RELOAD_INTERVAL":         "10ms",
+		"OSB_BASIC_AUTH_REQUIRES_TLS": "false",
+	}
+	cfg, err := Load(nil, env(vars))
+	require.NoError(t, err)
+	assert.Equal(t, "first", cfg.Auth.Password)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan *Config, 1)
+	go NewWatcher(nil, env(vars), lager.NewLogger("test")).Run(ctx, cfg, func(c *Config) {
+		changes <- c
+	})
+
+	// An empty password is invalid and must not be propagated.
+	require.NoError(t, ioutil.WriteFile(path, []byte(""), 0600))
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, ioutil.WriteFile(path, []byte("second\n"), 0600))
+
+	select {
+	case c := <-changes:
+		assert.Equal(t, "second", c.Auth.Password)
+	case <-time.After(5 * time.Second):
+		t.Fatal("config was not reloaded")
+	}
+}
@@ -0,0 +1,93 @@
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const apiVersionHeader = "X-Broker-API-Version"
+
+// client sends OSB requests to the broker.
+type client struct {
+	baseURL    string
+	username   string
+	password   string
+	apiVersion string
+	http       *http.Client
+}
+
+type response struct {
+	status int
+	body   []byte
+}
+
+// request configures a single request.
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	body   interface{}
+	// withoutVersion omits the API version header.
+	withoutVersion bool
+}
+
+func (c *client) do(ctx context.Context, r request) (*response, error) {
+	u := strings.TrimSuffix(c.baseURL, "/") + r.path
+	if len(r.query) > 0 {
+		u += "?" + r.query.Encode()
+	}
+
+	var body bytes.Buffer
+	if r.body != nil {
+		if err := json.NewEncoder(&body).Encode(r.body); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, r.method, u, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if !r.withoutVersion {
+		req.Header.Set(apiVersionHeader, c.apiVersion)
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &response{status: res.StatusCode, body: b}, nil
+}
+
+// expect returns an error if the status code of the response isn't one of the given codes.
+func (r *response) expect(codes ...int) error {
+	for _, c := range codes {
+		if r.status == c {
+			return nil
+		}
+	}
+	return fmt.Errorf("expected status %v, got %d: %s", codes, r.status, strings.TrimSpace(string(r.body)))
+}
+
+func (r *response) decode(v interface{}) error {
+	if err := json.Unmarshal(r.body, v); err != nil {
+		return fmt.Errorf("unable to decode response %q: %w", string(r.body), err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package conformance
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Config configures a conformance run against a broker.
+type Config struct {
+	// URL of the broker, e.g. http://localhost:8080.
+	URL      string
+	Username string
+	Password string
+	// APIVersion is sent in the X-Broker-API-Version header.
+	APIVersion string
+	// ServiceID and PlanID of the instance provisioned during the run.
+	ServiceID string
+	PlanID    string
+	// UpdatePlanID is the plan the instance is updated to. The update is skipped if empty.
+	UpdatePlanID string
+	// PollInterval is the interval in which asynchronous operations are polled.
+	PollInterval time.Duration
+	// Timeout of asynchronous operations.
+	Timeout time.Duration
+	// Output is the file the report is written to. The report is written to stdout if empty.
+	Output string
+}
+
+// LoadConfig reads the config from command line arguments.
+// Flags which aren't set fall back to the environment variables of the broker where applicable.
+func LoadConfig(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	env := func(key, fallback string) string {
+		if v, ok := lookupEnv(key); ok {
+			return v
+		}
+		return fallback
+	}
+
+	cfg := &Config{}
+	fs := flag.NewFlagSet("conformance", flag.ContinueOnError)
+	fs.StringVar(&cfg.URL, "url", env("OSB_CONFORMANCE_URL", "http://localhost:8080"), "URL of the broker (env: OSB_CONFORMANCE_URL)")
+	fs.StringVar(&cfg.Username, "username", env("OSB_USERNAME", ""), "basic auth username (env: OSB_USERNAME)")
+	fs.StringVar(&cfg.Password, "password", env("OSB_PASSWORD", ""), "basic auth password (env: OSB_PASSWORD)")
+	fs.StringVar(&cfg.APIVersion, "api-version", "2.14", "OSB API version sent to the broker")
+	fs.StringVar(&cfg.ServiceID, "service-id", "", "service of the provisioned instance")
+	fs.StringVar(&cfg.PlanID, "plan-id", "", "plan of the provisioned instance")
+	fs.StringVar(&cfg.UpdatePlanID, "update-plan-id", "", "plan the instance is updated to, skips the update if empty")
+	fs.DurationVar(&cfg.PollInterval, "poll-interval", 2*time.Second, "interval in which asynchronous operations are polled")
+	fs.DurationVar(&cfg.Timeout, "timeout", 10*time.Minute, "timeout of asynchronous operations")
+	fs.StringVar(&cfg.Output, "output", "", "file the report is written to, defaults to stdout")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %q", fs.Args())
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate checks that all required settings are present.
+func (c Config) Validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("URL %q must be http or https", c.URL)
+	}
+	if c.ServiceID == "" {
+		return errors.New("service ID is required")
+	}
+	if c.PlanID == "" {
+		return errors.New("plan ID is required")
+	}
+	if c.PollInterval <= 0 {
+		return errors.New("poll interval must be positive")
+	}
+	if c.Timeout <= 0 {
+		return errors.New("timeout must be positive")
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+// Package conformance checks that a running broker follows the Open Service Broker API.
+//
+// A run provisions, binds, updates and deprovisions an instance and checks status codes,
+// asynchronous operations and idempotency along the way. The result is a machine-readable report.
+package conformance
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Status of a scenario.
+type Status string
+
+const (
+	// StatusPassed means the broker behaved as specified.
+	StatusPassed Status = "passed"
+	// StatusFailed means the broker violated the specification.
+	StatusFailed Status = "failed"
+	// StatusSkipped means the scenario doesn't apply to the broker or a scenario it depends on failed.
+	StatusSkipped Status = "skipped"
+)
+
+// Report is the result of a conformance run.
+type Report struct {
+	URL        string    `json:"url"`
+	APIVersion string    `json:"api_version"`
+	ServiceID  string    `json:"service_id"`
+	PlanID     string    `json:"plan_id"`
+	StartedAt  time.Time `json:"started_at"`
+	Duration   string    `json:"duration"`
+	Passed     int       `json:"passed"`
+	Failed     int       `json:"failed"`
+	Skipped    int       `json:"skipped"`
+	Results    []Result  `json:"results"`
+	// Cleanup contains the error of removing leftovers of a failed run.
+	Cleanup string `json:"cleanup,omitempty"`
+}
+
+// Result of a single scenario.
+type Result struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Status      Status `json:"status"`
+	Error       string `json:"error,omitempty"`
+	Duration    string `json:"duration"`
+}
+
+// Succeeded returns true if no scenario failed.
+func (r Report) Succeeded() bool {
+	return r.Failed == 0
+}
+
+// Result returns the result of the scenario with the given name.
+func (r Report) Result(name string) (Result, bool) {
+	for _, res := range r.Results {
+		if res.Name == name {
+			return res, true
+		}
+	}
+	return Result{}, false
+}
+
+// Runner runs the conformance scenarios against a broker.
+type Runner struct {
+	cfg    Config
+	client *client
+}
+
+// NewRunner creates a runner for the broker configured in cfg.
+func NewRunner(cfg Config, httpClient *http.Client) *Runner {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Runner{
+		cfg: cfg,
+		client: &client{
+			baseURL:    cfg.URL,
+			username:   cfg.Username,
+			password:   cfg.Password,
+			apiVersion: cfg.APIVersion,
+			http:       httpClient,
+		},
+	}
+}
+
+// skipError marks a scenario as skipped.
+type skipError struct {
+	reason string
+}
+
+func (e skipError) Error() string {
+	return e.reason
+}
+
+func skip(format string, a ...interface{}) error {
+	return skipError{reason: fmt.Sprintf(format, a...)}
+}
+
+// state is shared between the scenarios of a run.
+type state struct {
+	instanceID string
+	bindingID  string
+	planID     string
+
+	bindable             bool
+	instancesRetrievable bool
+	bindingsRetrievable  bool
+	planUpdateable       bool
+
+	provisioned bool
+	bound       bool
+}
+
+// Run runs all scenarios in order and returns the report.
+func (r *Runner) Run(ctx context.Context) (*Report, error) {
+	instanceID, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	bindingID, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	s := &state{
+		instanceID: instanceID,
+		bindingID:  bindingID,
+		planID:     r.cfg.PlanID,
+	}
+
+	report := &Report{
+		URL:        r.cfg.URL,
+		APIVersion: r.cfg.APIVersion,
+		ServiceID:  r.cfg.ServiceID,
+		PlanID:     r.cfg.PlanID,
+		StartedAt:  time.Now().UTC(),
+		Results:    make([]Result, 0, len(scenarios)),
+	}
+	for _, sc := range scenarios {
+		start := time.Now()
+		err := sc.run(ctx, r, s)
+		res := Result{
+			Name:        sc.name,
+			Description: sc.description,
+			Status:      StatusPassed,
+			Duration:    time.Since(start).Round(time.Millisecond).String(),
+		}
+		var skipErr skipError
+		switch {
+		case err == nil:
+			report.Passed++
+		case errors.As(err, &skipErr):
+			res.Status = StatusSkipped
+			res.Error = err.Error()
+			report.Skipped++
+		default:
+			res.Status = StatusFailed
+			res.Error = err.Error()
+			report.Failed++
+		}
+		report.Results = append(report.Results, res)
+	}
+
+	if err := r.cleanup(ctx, s); err != nil {
+		report.Cleanup = err.Error()
+	}
+	report.Duration = time.Since(report.StartedAt).Round(time.Millisecond).String()
+	return report, nil
+}
+
+// cleanup removes the binding and instance if a scenario failed before deleting them.
+func (r *Runner) cleanup(ctx context.Context, s *state) error {
+	if s.bound {
+		if _, err := r.unbind(ctx, s, true); err != nil {
+			return fmt.Errorf("unable to delete binding %q: %w", s.bindingID, err)
+		}
+	}
+	if s.provisioned {
+		if _, err := r.deprovision(ctx, s, s.instanceID, true); err != nil {
+			return fmt.Errorf("unable to delete instance %q: %w", s.instanceID, err)
+		}
+	}
+	return nil
+}
+
+// newID returns a random ID which is a valid Kubernetes name.
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "conformance-" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,233 @@
+package conformance
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi/v7"
+	"github.com/pivotal-cf/brokerapi/v7/domain"
+	"github.com/pivotal-cf/brokerapi/v7/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBroker keeps instances and bindings in memory.
+// Provisioning is asynchronous and finishes on the second poll.
+type fakeBroker struct {
+	mu        sync.Mutex
+	instances map[string]*fakeInstance
+	bindings  map[string]bool
+
+	// unbindAlwaysSucceeds violates the specification by returning 200 for unknown bindings.
+	unbindAlwaysSucceeds bool
+}
+
+type fakeInstance struct {
+	details domain.ProvisionDetails
+	polls   int
+}
+
+func newFakeBroker() *fakeBroker {
+	return &fakeBroker{
+		instances: map[string]*fakeInstance{},
+		bindings:  map[string]bool{},
+	}
+}
+
+func (b *fakeBroker) Services(ctx context.Context) ([]domain.Service, error) {
+	return []domain.Service{{
+		ID:                   "redis",
+		Name:                 "redis",
+		Bindable:             true,
+		InstancesRetrievable: true,
+		BindingsRetrievable:  true,
+		PlanUpdatable:        true,
+		Plans: []domain.ServicePlan{
+			{ID: "small", Name: "small"},
+			{ID: "small-premium", Name: "small-premium"},
+		},
+	}}, nil
+}
+
+func (b *fakeBroker) Provision(ctx context.Context, instanceID string, details domain.ProvisionDetails, asyncAllowed bool) (domain.ProvisionedServiceSpec, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !asyncAllowed {
+		return domain.ProvisionedServiceSpec{}, apiresponses.ErrAsyncRequired
+	}
+	if instance, ok := b.instances[instanceID]; ok {
+		if reflect.DeepEqual(instance.details, details) {
+			return domain.ProvisionedServiceSpec{AlreadyExists: true}, nil
+		}
+		return domain.ProvisionedServiceSpec{}, apiresponses.ErrInstanceAlreadyExists
+	}
+	b.instances[instanceID] = &fakeInstance{details: details}
+	return domain.ProvisionedServiceSpec{IsAsync: true}, nil
+}
+
+func (b *fakeBroker) Deprovision(ctx context.Context, instanceID string, details domain.DeprovisionDetails, asyncAllowed bool) (domain.DeprovisionServiceSpec, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.instances[instanceID]; !ok {
+		return domain.DeprovisionServiceSpec{}, apiresponses.ErrInstanceDoesNotExist
+	}
+	delete(b.instances, instanceID)
+	return domain.DeprovisionServiceSpec{}, nil
+}
+
+func (b *fakeBroker) GetInstance(ctx context.Context, instanceID string) (domain.GetInstanceDetailsSpec, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	instance, ok := b.instances[instanceID]
+	if !ok {
+		return domain.GetInstanceDetailsSpec{}, apiresponses.ErrInstanceDoesNotExist
+	}
+	return domain.GetInstanceDetailsSpec{ServiceID: instance.details.ServiceID, PlanID: instance.details.PlanID}, nil
+}
+
+func (b *fakeBroker) Update(ctx context.Context, instanceID string, details domain.UpdateDetails, asyncAllowed bool) (domain.UpdateServiceSpec, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	instance, ok := b.instances[instanceID]
+	if !ok {
+		return domain.UpdateServiceSpec{}, apiresponses.ErrInstanceDoesNotExist
+	}
+	instance.details.PlanID = details.PlanID
+	return domain.UpdateServiceSpec{}, nil
+}
+
+func (b *fakeBroker) LastOperation(ctx context.Context, instanceID string, details domain.PollDetails) (domain.LastOperation, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	instance, ok := b.instances[instanceID]
+	if !ok {
+		return domain.LastOperation{}, apiresponses.ErrInstanceDoesNotExist
+	}
+	instance.polls++
+	if instance.polls < 2 {
+		return domain.LastOperation{State: domain.InProgress}, nil
+	}
+	return domain.LastOperation{State: domain.Succeeded}, nil
+}
+
+func (b *fakeBroker) Bind(ctx context.Context, instanceID, bindingID string, details domain.BindDetails, asyncAllowed bool) (domain.Binding, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.instances[instanceID]; !ok {
+		return domain.Binding{}, apiresponses.ErrInstanceDoesNotExist
+	}
+	binding := domain.Binding{Credentials: map[string]string{"password": "secret"}}
+	if b.bindings[bindingID] {
+		binding.AlreadyExists = true
+	}
+	b.bindings[bindingID] = true
+	return binding, nil
+}
+
+func (b *fakeBroker) Unbind(ctx context.Context, instanceID, bindingID string, details domain.UnbindDetails, asyncAllowed bool) (domain.UnbindSpec, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.bindings[bindingID] && !b.unbindAlwaysSucceeds {
+		return domain.UnbindSpec{}, apiresponses.ErrBindingDoesNotExist
+	}
+	delete(b.bindings, bindingID)
+	return domain.UnbindSpec{}, nil
+}
+
+func (b *fakeBroker) GetBinding(ctx context.Context, instanceID, bindingID string) (domain.GetBindingSpec, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.bindings[bindingID] {
+		return domain.GetBindingSpec{}, apiresponses.ErrBindingNotFound
+	}
+	return domain.GetBindingSpec{Credentials: map[string]string{"password": "secret"}}, nil
+}
+
+func (b *fakeBroker) LastBindingOperation(ctx context.Context, instanceID, bindingID string, details domain.PollDetails) (domain.LastOperation, error) {
+	return domain.LastOperation{}, apiresponses.ErrBindingNotFound
+}
+
+func runConformance(t *testing.T, b *fakeBroker, updatePlanID string) *Report {
+	srv := httptest.NewServer(brokerapi.New(b, lager.NewLogger("test"), brokerapi.BrokerCredentials{Username: "user", Password: "pass"}))
+	defer srv.Close()
+
+	cfg := Config{
+		URL:          srv.URL,
+		Username:     "user",
+		Password:     "pass",
+		APIVersion:   "2.14",
+		ServiceID:    "redis",
+		PlanID:       "small",
+		UpdatePlanID: updatePlanID,
+		PollInterval: time.Millisecond,
+		Timeout:      time.Second,
+	}
+	require.NoError(t, cfg.Validate())
+
+	report, err := NewRunner(cfg, srv.Client()).Run(context.Background())
+	require.NoError(t, err)
+	require.Len(t, report.Results, len(scenarios))
+	return report
+}
+
+func TestRunner_Conformant(t *testing.T) {
+	b := newFakeBroker()
+	report := runConformance(t, b, "small-premium")
+
+	for _, res := range report.Results {
+		assert.Equal(t, StatusPassed, res.Status, "%s: %s", res.Name, res.Error)
+	}
+	assert.True(t, report.Succeeded())
+	assert.Empty(t, report.Cleanup)
+	assert.Empty(t, b.instances, "instance must be deleted")
+	assert.Empty(t, b.bindings, "binding must be deleted")
+}
+
+func TestRunner_Violations(t *testing.T) {
+	b := newFakeBroker()
+	b.unbindAlwaysSucceeds = true
+	report := runConformance(t, b, "")
+
+	assert.False(t, report.Succeeded())
+	assert.Equal(t, 1, report.Failed)
+	assert.Equal(t, 1, report.Skipped)
+
+	res, ok := report.Result("unbind-gone")
+	require.True(t, ok)
+	assert.Equal(t, StatusFailed, res.Status)
+	assert.Contains(t, res.Error, "expected status [410], got 200")
+
+	res, ok = report.Result("update")
+	require.True(t, ok)
+	assert.Equal(t, StatusSkipped, res.Status)
+	assert.Empty(t, b.instances, "instance must be deleted")
+}
+
+func TestLoadConfig(t *testing.T) {
+	env := map[string]string{
+		"OSB_USERNAME": "user",
+		"OSB_PASSWORD": "pass",
+	}
+	lookupEnv := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+
+	cfg, err := LoadConfig([]string{"--service-id", "redis", "--plan-id", "small", "--timeout", "1m"}, lookupEnv)
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080", cfg.URL)
+	assert.Equal(t, "user", cfg.Username)
+	assert.Equal(t, "pass", cfg.Password)
+	assert.Equal(t, time.Minute, cfg.Timeout)
+
+	_, err = LoadConfig([]string{"--plan-id", "small"}, lookupEnv)
+	assert.EqualError(t, err, "service ID is required")
+
+	_, err = LoadConfig([]string{"--url", "localhost:8080", "--service-id", "redis", "--plan-id", "small"}, lookupEnv)
+	assert.EqualError(t, err, `URL "localhost:8080" must be http or https`)
+}
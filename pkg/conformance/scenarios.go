@@ -0,0 +1,577 @@
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pivotal-cf/brokerapi/v7/domain"
+	"github.com/pivotal-cf/brokerapi/v7/domain/apiresponses"
+)
+
+// scenario checks one aspect of the specification.
+// Scenarios run in order and may depend on the state left by previous scenarios.
+type scenario struct {
+	name        string
+	description string
+	run         func(ctx context.Context, r *Runner, s *state) error
+}
+
+var scenarios = []scenario{
+	{
+		name:        "api-version-header",
+		description: "Requests without the X-Broker-API-Version header are rejected with 412.",
+		run:         checkAPIVersionHeader,
+	},
+	{
+		name:        "catalog",
+		description: "The catalog contains the service and plans under test.",
+		run:         checkCatalog,
+	},
+	{
+		name:        "provision-async-required",
+		description: "Provisioning without accepts_incomplete either succeeds synchronously or is rejected with 422 AsyncRequired.",
+		run:         checkProvisionAsyncRequired,
+	},
+	{
+		name:        "provision",
+		description: "The instance is provisioned. Asynchronous provisioning is polled until it succeeded.",
+		run:         checkProvision,
+	},
+	{
+		name:        "provision-idempotent",
+		description: "Provisioning an identical instance again returns 200.",
+		run:         checkProvisionIdempotent,
+	},
+	{
+		name:        "provision-conflict",
+		description: "Provisioning an existing instance with different attributes returns 409.",
+		run:         checkProvisionConflict,
+	},
+	{
+		name:        "fetch-instance",
+		description: "A retrievable instance is returned with its service and plan.",
+		run:         checkFetchInstance,
+	},
+	{
+		name:        "bind",
+		description: "A binding is created. Asynchronous binding is polled until it succeeded.",
+		run:         checkBind,
+	},
+	{
+		name:        "bind-idempotent",
+		description: "Creating an identical binding again returns 200.",
+		run:         checkBindIdempotent,
+	},
+	{
+		name:        "fetch-binding",
+		description: "A retrievable binding is returned.",
+		run:         checkFetchBinding,
+	},
+	{
+		name:        "unbind",
+		description: "The binding is deleted.",
+		run:         checkUnbind,
+	},
+	{
+		name:        "unbind-gone",
+		description: "Deleting a binding which doesn't exist returns 410.",
+		run:         checkUnbindGone,
+	},
+	{
+		name:        "update",
+		description: "The instance is updated to another plan. Asynchronous updates are polled until they succeeded.",
+		run:         checkUpdate,
+	},
+	{
+		name:        "orphan-mitigation",
+		description: "Deleting an instance which has never been created returns 410. Platforms do this to clean up after failed provisioning.",
+		run:         checkOrphanMitigation,
+	},
+	{
+		name:        "deprovision",
+		description: "The instance is deleted. Asynchronous deprovisioning is polled until the instance is gone.",
+		run:         checkDeprovision,
+	},
+	{
+		name:        "deprovision-gone",
+		description: "Deleting an instance which doesn't exist anymore returns 410.",
+		run:         checkDeprovisionGone,
+	},
+}
+
+var (
+	errNotProvisioned = skip("instance has not been provisioned")
+	errNotBound       = skip("binding has not been created")
+)
+
+func checkAPIVersionHeader(ctx context.Context, r *Runner, _ *state) error {
+	res, err := r.client.do(ctx, request{method: http.MethodGet, path: "/v2/catalog", withoutVersion: true})
+	if err != nil {
+		return err
+	}
+	return res.expect(http.StatusPreconditionFailed)
+}
+
+func checkCatalog(ctx context.Context, r *Runner, s *state) error {
+	res, err := r.client.do(ctx, request{method: http.MethodGet, path: "/v2/catalog"})
+	if err != nil {
+		return err
+	}
+	if err := res.expect(http.StatusOK); err != nil {
+		return err
+	}
+	catalog := apiresponses.CatalogResponse{}
+	if err := res.decode(&catalog); err != nil {
+		return err
+	}
+
+	for _, svc := range catalog.Services {
+		if svc.ID != r.cfg.ServiceID {
+			continue
+		}
+		s.instancesRetrievable = svc.InstancesRetrievable
+		s.bindingsRetrievable = svc.BindingsRetrievable
+		s.planUpdateable = svc.PlanUpdatable
+
+		plan, ok := findPlan(svc.Plans, r.cfg.PlanID)
+		if !ok {
+			return fmt.Errorf("plan %q not found in service %q", r.cfg.PlanID, svc.ID)
+		}
+		s.bindable = svc.Bindable
+		if plan.Bindable != nil {
+			s.bindable = *plan.Bindable
+		}
+		if r.cfg.UpdatePlanID != "" {
+			if _, ok := findPlan(svc.Plans, r.cfg.UpdatePlanID); !ok {
+				return fmt.Errorf("update plan %q not found in service %q", r.cfg.UpdatePlanID, svc.ID)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("service %q not found in catalog", r.cfg.ServiceID)
+}
+
+func findPlan(plans []domain.ServicePlan, id string) (domain.ServicePlan, bool) {
+	for _, p := range plans {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return domain.ServicePlan{}, false
+}
+
+func checkProvisionAsyncRequired(ctx context.Context, r *Runner, s *state) error {
+	res, err := r.provision(ctx, s, false, nil)
+	if err != nil {
+		return err
+	}
+	switch res.status {
+	case http.StatusCreated:
+		// The broker provisions synchronously.
+		s.provisioned = true
+		return nil
+	case http.StatusUnprocessableEntity:
+		return expectErrorKey(res, "AsyncRequired")
+	}
+	return res.expect(http.StatusCreated, http.StatusUnprocessableEntity)
+}
+
+func checkProvision(ctx context.Context, r *Runner, s *state) error {
+	if s.provisioned {
+		return skip("instance has been provisioned synchronously")
+	}
+	res, err := r.provision(ctx, s, true, nil)
+	if err != nil {
+		return err
+	}
+	if err := res.expect(http.StatusCreated, http.StatusAccepted); err != nil {
+		return err
+	}
+	// The instance might exist even if provisioning fails, clean it up in any case.
+	s.provisioned = true
+	if res.status == http.StatusCreated {
+		return nil
+	}
+
+	op := apiresponses.ProvisioningResponse{}
+	if err := res.decode(&op); err != nil {
+		return err
+	}
+	return r.poll(ctx, instancePath(s.instanceID)+"/last_operation", s.planID, op.OperationData, false)
+}
+
+func checkProvisionIdempotent(ctx context.Context, r *Runner, s *state) error {
+	if !s.provisioned {
+		return errNotProvisioned
+	}
+	res, err := r.provision(ctx, s, true, nil)
+	if err != nil {
+		return err
+	}
+	return res.expect(http.StatusOK)
+}
+
+func checkProvisionConflict(ctx context.Context, r *Runner, s *state) error {
+	if !s.provisioned {
+		return errNotProvisioned
+	}
+	res, err := r.provision(ctx, s, true, map[string]interface{}{"conformance": "conflict"})
+	if err != nil {
+		return err
+	}
+	return res.expect(http.StatusConflict)
+}
+
+func checkFetchInstance(ctx context.Context, r *Runner, s *state) error {
+	if !s.provisioned {
+		return errNotProvisioned
+	}
+	if !s.instancesRetrievable {
+		return skip("instances are not retrievable")
+	}
+	res, err := r.client.do(ctx, request{method: http.MethodGet, path: instancePath(s.instanceID)})
+	if err != nil {
+		return err
+	}
+	if err := res.expect(http.StatusOK); err != nil {
+		return err
+	}
+	instance := apiresponses.GetInstanceResponse{}
+	if err := res.decode(&instance); err != nil {
+		return err
+	}
+	if instance.ServiceID != r.cfg.ServiceID || instance.PlanID != s.planID {
+		return fmt.Errorf("expected service %q and plan %q, got service %q and plan %q", r.cfg.ServiceID, s.planID, instance.ServiceID, instance.PlanID)
+	}
+	return nil
+}
+
+func checkBind(ctx context.Context, r *Runner, s *state) error {
+	if !s.provisioned {
+		return errNotProvisioned
+	}
+	if !s.bindable {
+		return skip("plan is not bindable")
+	}
+	res, err := r.bind(ctx, s)
+	if err != nil {
+		return err
+	}
+	if err := res.expect(http.StatusCreated, http.StatusAccepted); err != nil {
+		return err
+	}
+	s.bound = true
+	if res.status == http.StatusCreated {
+		return nil
+	}
+
+	op := apiresponses.AsyncBindResponse{}
+	if err := res.decode(&op); err != nil {
+		return err
+	}
+	return r.poll(ctx, bindingPath(s.instanceID, s.bindingID)+"/last_operation", s.planID, op.OperationData, false)
+}
+
+func checkBindIdempotent(ctx context.Context, r *Runner, s *state) error {
+	if !s.bound {
+		return errNotBound
+	}
+	res, err := r.bind(ctx, s)
+	if err != nil {
+		return err
+	}
+	return res.expect(http.StatusOK)
+}
+
+func checkFetchBinding(ctx context.Context, r *Runner, s *state) error {
+	if !s.bound {
+		return errNotBound
+	}
+	if !s.bindingsRetrievable {
+		return skip("bindings are not retrievable")
+	}
+	res, err := r.client.do(ctx, request{method: http.MethodGet, path: bindingPath(s.instanceID, s.bindingID)})
+	if err != nil {
+		return err
+	}
+	return res.expect(http.StatusOK)
+}
+
+func checkUnbind(ctx context.Context, r *Runner, s *state) error {
+	if !s.bound {
+		return errNotBound
+	}
+	_, err := r.unbind(ctx, s, false)
+	return err
+}
+
+func checkUnbindGone(ctx context.Context, r *Runner, s *state) error {
+	if !s.provisioned {
+		return errNotProvisioned
+	}
+	if !s.bindable {
+		return skip("plan is not bindable")
+	}
+	if s.bound {
+		return skip("binding has not been deleted")
+	}
+	res, err := r.client.do(ctx, request{
+		method: http.MethodDelete,
+		path:   bindingPath(s.instanceID, s.bindingID),
+		query:  r.query(s.planID, true),
+	})
+	if err != nil {
+		return err
+	}
+	return res.expect(http.StatusGone)
+}
+
+func checkUpdate(ctx context.Context, r *Runner, s *state) error {
+	if !s.provisioned {
+		return errNotProvisioned
+	}
+	if r.cfg.UpdatePlanID == "" {
+		return skip("no update plan configured")
+	}
+	if !s.planUpdateable {
+		return skip("plans of the service are not updateable")
+	}
+	res, err := r.client.do(ctx, request{
+		method: http.MethodPatch,
+		path:   instancePath(s.instanceID),
+		query:  url.Values{"accepts_incomplete": []string{"true"}},
+		body: map[string]interface{}{
+			"service_id": r.cfg.ServiceID,
+			"plan_id":    r.cfg.UpdatePlanID,
+			"previous_values": map[string]string{
+				"service_id": r.cfg.ServiceID,
+				"plan_id":    s.planID,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if err := res.expect(http.StatusOK, http.StatusAccepted); err != nil {
+		return err
+	}
+	if res.status == http.StatusAccepted {
+		op := apiresponses.UpdateResponse{}
+		if err := res.decode(&op); err != nil {
+			return err
+		}
+		if err := r.poll(ctx, instancePath(s.instanceID)+"/last_operation", r.cfg.UpdatePlanID, op.OperationData, false); err != nil {
+			return err
+		}
+	}
+	s.planID = r.cfg.UpdatePlanID
+	return nil
+}
+
+func checkOrphanMitigation(ctx context.Context, r *Runner, _ *state) error {
+	orphanID, err := newID()
+	if err != nil {
+		return err
+	}
+	res, err := r.client.do(ctx, request{
+		method: http.MethodDelete,
+		path:   instancePath(orphanID),
+		query:  r.query(r.cfg.PlanID, true),
+	})
+	if err != nil {
+		return err
+	}
+	return res.expect(http.StatusGone)
+}
+
+func checkDeprovision(ctx context.Context, r *Runner, s *state) error {
+	if !s.provisioned {
+		return errNotProvisioned
+	}
+	if s.bound {
+		return skip("binding has not been deleted")
+	}
+	_, err := r.deprovision(ctx, s, s.instanceID, false)
+	return err
+}
+
+func checkDeprovisionGone(ctx context.Context, r *Runner, s *state) error {
+	if s.provisioned {
+		return skip("instance has not been deleted")
+	}
+	res, err := r.client.do(ctx, request{
+		method: http.MethodDelete,
+		path:   instancePath(s.instanceID),
+		query:  r.query(s.planID, true),
+	})
+	if err != nil {
+		return err
+	}
+	return res.expect(http.StatusGone)
+}
+
+func (r *Runner) provision(ctx context.Context, s *state, acceptsIncomplete bool, params map[string]interface{}) (*response, error) {
+	query := url.Values{}
+	if acceptsIncomplete {
+		query.Set("accepts_incomplete", "true")
+	}
+	body := map[string]interface{}{
+		"service_id":        r.cfg.ServiceID,
+		"plan_id":           r.cfg.PlanID,
+		"organization_guid": "conformance",
+		"space_guid":        "conformance",
+	}
+	if params != nil {
+		body["parameters"] = params
+	}
+	return r.client.do(ctx, request{method: http.MethodPut, path: instancePath(s.instanceID), query: query, body: body})
+}
+
+func (r *Runner) bind(ctx context.Context, s *state) (*response, error) {
+	return r.client.do(ctx, request{
+		method: http.MethodPut,
+		path:   bindingPath(s.instanceID, s.bindingID),
+		query:  url.Values{"accepts_incomplete": []string{"true"}},
+		body: map[string]interface{}{
+			"service_id": r.cfg.ServiceID,
+			"plan_id":    s.planID,
+		},
+	})
+}
+
+// unbind deletes the binding and waits until the deletion has finished.
+func (r *Runner) unbind(ctx context.Context, s *state, cleanup bool) (*response, error) {
+	path := bindingPath(s.instanceID, s.bindingID)
+	res, err := r.client.do(ctx, request{method: http.MethodDelete, path: path, query: r.query(s.planID, true)})
+	if err != nil {
+		return nil, err
+	}
+	codes := []int{http.StatusOK, http.StatusAccepted}
+	if cleanup {
+		codes = append(codes, http.StatusGone)
+	}
+	if err := res.expect(codes...); err != nil {
+		return res, err
+	}
+	if res.status == http.StatusAccepted {
+		op := apiresponses.UnbindResponse{}
+		if err := res.decode(&op); err != nil {
+			return res, err
+		}
+		if err := r.poll(ctx, path+"/last_operation", s.planID, op.OperationData, true); err != nil {
+			return res, err
+		}
+	}
+	s.bound = false
+	return res, nil
+}
+
+// deprovision deletes the instance and waits until the deletion has finished.
+func (r *Runner) deprovision(ctx context.Context, s *state, instanceID string, cleanup bool) (*response, error) {
+	path := instancePath(instanceID)
+	res, err := r.client.do(ctx, request{method: http.MethodDelete, path: path, query: r.query(s.planID, true)})
+	if err != nil {
+		return nil, err
+	}
+	codes := []int{http.StatusOK, http.StatusAccepted}
+	if cleanup {
+		codes = append(codes, http.StatusGone)
+	}
+	if err := res.expect(codes...); err != nil {
+		return res, err
+	}
+	if res.status == http.StatusAccepted {
+		op := apiresponses.DeprovisionResponse{}
+		if err := res.decode(&op); err != nil {
+			return res, err
+		}
+		if err := r.poll(ctx, path+"/last_operation", s.planID, op.OperationData, true); err != nil {
+			return res, err
+		}
+	}
+	s.provisioned = false
+	return res, nil
+}
+
+// poll polls the last operation endpoint at path until the operation succeeded.
+// For deletions, 410 Gone is considered a success.
+func (r *Runner) poll(ctx context.Context, path, planID, operation string, deletion bool) error {
+	query := r.query(planID, false)
+	if operation != "" {
+		query.Set("operation", operation)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		res, err := r.client.do(ctx, request{method: http.MethodGet, path: path, query: query})
+		if err != nil {
+			return err
+		}
+		if deletion && res.status == http.StatusGone {
+			return nil
+		}
+		if err := res.expect(http.StatusOK); err != nil {
+			return err
+		}
+		op := apiresponses.LastOperationResponse{}
+		if err := res.decode(&op); err != nil {
+			return err
+		}
+		switch op.State {
+		case domain.Succeeded:
+			return nil
+		case domain.Failed:
+			return fmt.Errorf("operation failed: %s", op.Description)
+		case domain.InProgress:
+		default:
+			return fmt.Errorf("invalid operation state %q", op.State)
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("operation didn't finish within %s, last state %q", r.cfg.Timeout, op.Description)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Runner) query(planID string, acceptsIncomplete bool) url.Values {
+	query := url.Values{
+		"service_id": []string{r.cfg.ServiceID},
+		"plan_id":    []string{planID},
+	}
+	if acceptsIncomplete {
+		query.Set("accepts_incomplete", "true")
+	}
+	return query
+}
+
+func expectErrorKey(res *response, key string) error {
+	body := apiresponses.ErrorResponse{}
+	if err := res.decode(&body); err != nil {
+		return err
+	}
+	if body.Error != key {
+		return fmt.Errorf("expected error %q, got %q: %s", key, body.Error, body.Description)
+	}
+	return nil
+}
+
+func instancePath(instanceID string) string {
+	return "/v2/service_instances/" + instanceID
+}
+
+func bindingPath(instanceID, bindingID string) string {
+	return instancePath(instanceID) + "/service_bindings/" + bindingID
+}
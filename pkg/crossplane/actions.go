@@ -0,0 +1,543 @@
+package crossplane
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	helmv1alpha1 "github.com/crossplane-contrib/provider-helm/apis/release/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ActionType is an operation on the pods of an instance.
+type ActionType string
+
+const (
+	// ActionRestart restarts a single node of an instance or, without a node, all nodes one after another.
+	ActionRestart ActionType = "restart"
+	// ActionFailover deletes the Redis master node, Sentinel promotes one of the replicas to be the new master.
+	ActionFailover ActionType = "failover"
+)
+
+// ActionState is the progress of an action.
+type ActionState string
+
+const (
+	// ActionInProgress waits for the restarted nodes to be ready again.
+	ActionInProgress ActionState = "in_progress"
+	// ActionSucceeded is the final state of an action whose nodes are ready again.
+	ActionSucceeded ActionState = "succeeded"
+	// ActionFailed is the final state of an action which couldn't be completed.
+	ActionFailed ActionState = "failed"
+)
+
+const (
+	// actionTimeout is the time after which actions whose nodes aren't ready again have failed.
+	actionTimeout = 15 * time.Minute
+	// restartedAtAnnotation is set on the pod template of StatefulSets to restart their pods, like `kubectl rollout restart` does.
+	restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+	// sentinelContainer is the name of the Redis Sentinel container running next to Redis.
+	sentinelContainer = "sentinel"
+	// maxActionRecords is the number of actions kept per instance, the records of older actions are deleted.
+	maxActionRecords = 20
+)
+
+// The timestamps of action records have nanoseconds, they order the actions of an instance.
+const (
+	actionRecordTypeKey        = "action"
+	actionRecordNodeKey        = "node"
+	actionRecordNodeUIDKey     = "node_uid"
+	actionRecordStateKey       = "state"
+	actionRecordMessageKey     = "message"
+	actionRecordRequestedByKey = "requested_by"
+	actionRecordStartedAtKey   = "started_at"
+	actionRecordUpdatedAtKey   = "updated_at"
+)
+
+var (
+	// ErrActionNotPermitted is returned if an action isn't supported by an instance or its node doesn't exist.
+	ErrActionNotPermitted = errors.New("action not permitted")
+	// ErrActionInProgress is returned if another action on the instance hasn't finished yet.
+	ErrActionInProgress = errors.New("action in progress")
+	// ErrActionRateLimited is returned if the last action on the instance started less than the action cooldown ago.
+	ErrActionRateLimited = errors.New("action rate limited")
+	// ErrActionNotFound is returned if an instance has no action with the requested ID.
+	ErrActionNotFound = errors.New("action not found")
+)
+
+// Action is an operation on the pods of an instance, e.g. restarting a stuck node.
+// Actions are recorded with the caller requesting them.
+type Action struct {
+	ID         string
+	InstanceID string
+	Type       ActionType
+	// Node is the pod acted on. Empty for restarts of all nodes.
+	Node  string
+	State ActionState
+	// Message describes the progress or why the action failed.
+	Message     string
+	RequestedBy string
+	StartedAt   time.Time
+	UpdatedAt   time.Time
+
+	// nodeUID is the UID of the node before it has been deleted, a new UID shows that it has been recreated.
+	nodeUID types.UID
+}
+
+// Done returns true if the action succeeded or failed.
+func (a *Action) Done() bool {
+	return a.State == ActionSucceeded || a.State == ActionFailed
+}
+
+// StartAction restarts a node of an instance or fails over its Redis master and records the action.
+//
+// Restarts delete the pod of the node, its StatefulSet recreates it. Without a node, all StatefulSets of
+// the instance are restarted one pod at a time. Failovers require Redis Sentinel and the node of the current
+// master, its role is verified through its exporter before it is deleted. Only one action per instance runs at
+// a time and a new action can only be started once the cooldown since the last one has passed. Callers must
+// serialize starting actions per instance. The action is driven by AdvanceAction.
+func (cp *Crossplane) StartAction(ctx context.Context, instanceID string, actionType ActionType, node, requestedBy string) (*Action, error) {
+	instance, err := cp.GetInstance(ctx, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	actions, err := cp.ListActions(ctx, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	for _, a := range actions {
+		if !a.Done() {
+			return nil, fmt.Errorf("%w: %s %s started at %s", ErrActionInProgress, a.Type, a.ID, a.StartedAt.Format(time.RFC3339))
+		}
+	}
+	if len(actions) > 0 && cp.ActionCooldown > 0 {
+		if next := actions[0].StartedAt.Add(cp.ActionCooldown); now.Before(next) {
+			return nil, fmt.Errorf("%w: the next action can be started at %s", ErrActionRateLimited, next.Format(time.RFC3339))
+		}
+	}
+
+	releases, err := cp.namespaceReleases(ctx, instance.GetResourceReferences())
+	if err != nil {
+		return nil, err
+	}
+	if len(releases) == 0 {
+		return nil, fmt.Errorf("%w: instance has no nodes of its own", ErrActionNotPermitted)
+	}
+
+	id, err := newActionID()
+	if err != nil {
+		return nil, err
+	}
+	a := &Action{
+		ID:          id,
+		InstanceID:  instanceID,
+		Type:        actionType,
+		Node:        node,
+		State:       ActionInProgress,
+		RequestedBy: requestedBy,
+		StartedAt:   now,
+		UpdatedAt:   now,
+	}
+	var act func() error
+	switch {
+	case actionType == ActionRestart && node == "":
+		statefulSets, err := cp.actionStatefulSets(ctx, releases)
+		if err != nil {
+			return nil, err
+		}
+		if len(statefulSets) == 0 {
+			return nil, fmt.Errorf("%w: instance has no StatefulSets", ErrActionNotPermitted)
+		}
+		a.Message = fmt.Sprintf("restarting the nodes of %d StatefulSets one after another", len(statefulSets))
+		act = func() error {
+			return cp.restartStatefulSets(ctx, statefulSets, now)
+		}
+	case actionType == ActionRestart || actionType == ActionFailover:
+		if node == "" {
+			return nil, fmt.Errorf("%w: failover requires the node of the current master", ErrActionNotPermitted)
+		}
+		pod, err := cp.actionPod(ctx, releases, node)
+		if err != nil {
+			return nil, err
+		}
+		if actionType == ActionFailover {
+			if !hasContainer(pod.pod, sentinelContainer) {
+				return nil, fmt.Errorf("%w: failover requires Redis Sentinel, node %q doesn't run it", ErrActionNotPermitted, node)
+			}
+			if err := cp.verifyMaster(ctx, pod); err != nil {
+				return nil, err
+			}
+		}
+		a.nodeUID = pod.pod.UID
+		a.Message = fmt.Sprintf("waiting for node %q to be recreated", node)
+		if actionType == ActionFailover {
+			a.Message = fmt.Sprintf("Sentinel promotes a replica, waiting for the former master %q to be recreated", node)
+		}
+		act = func() error {
+			if err := pod.client.Delete(ctx, pod.pod, client.Preconditions{UID: &pod.pod.UID}); err != nil && !k8serrors.IsNotFound(err) {
+				cp.Downstream.Failed(providerConfigName(pod.release), err)
+				return fmt.Errorf("delete pod(%q): %w", node, err)
+			}
+			return nil
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown action %q", ErrActionNotPermitted, actionType)
+	}
+
+	if err := cp.saveAction(ctx, a); err != nil {
+		return nil, err
+	}
+	cp.logger.Info("start-action", lager.Data{"instance": instanceID, "action": a.Type, "id": a.ID, "node": a.Node, "requested-by": a.RequestedBy})
+	// The previous actions are done, the new one counts towards the kept records.
+	cp.pruneActions(ctx, actions, maxActionRecords-1)
+	if err := act(); err != nil {
+		if saveErr := cp.failAction(ctx, a, err); saveErr != nil {
+			return nil, saveErr
+		}
+	}
+	return a, nil
+}
+
+// AdvanceAction checks whether the nodes of an action are ready again and returns its state.
+func (cp *Crossplane) AdvanceAction(ctx context.Context, instanceID, actionID string) (*Action, error) {
+	a, err := cp.GetAction(ctx, instanceID, actionID)
+	if err != nil {
+		return nil, err
+	}
+	if a.Done() {
+		return a, nil
+	}
+	if time.Since(a.StartedAt) > actionTimeout {
+		return a, cp.failAction(ctx, a, fmt.Errorf("nodes not ready after %s", actionTimeout))
+	}
+	instance, err := cp.GetInstance(ctx, instanceID)
+	if errors.Is(err, ErrInstanceNotFound) {
+		return a, cp.failAction(ctx, a, errors.New("instance has been deleted"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	releases, err := cp.namespaceReleases(ctx, instance.GetResourceReferences())
+	if err != nil {
+		return nil, err
+	}
+
+	if a.Node == "" {
+		statefulSets, err := cp.actionStatefulSets(ctx, releases)
+		if err != nil {
+			return nil, err
+		}
+		restarted := 0
+		for _, s := range statefulSets {
+			if statefulSetRolledOut(s.statefulSet) {
+				restarted++
+			}
+		}
+		if restarted < len(statefulSets) {
+			a.Message = fmt.Sprintf("restarted %d of %d StatefulSets", restarted, len(statefulSets))
+			return a, nil
+		}
+		a.State, a.Message = ActionSucceeded, "all nodes have been restarted"
+	} else {
+		pod, err := cp.actionPod(ctx, releases, a.Node)
+		if errors.Is(err, ErrActionNotPermitted) || (err == nil && (pod.pod.UID == a.nodeUID || !podReady(pod.pod))) {
+			// The node is being deleted or hasn't been recreated and started yet.
+			return a, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		a.State, a.Message = ActionSucceeded, fmt.Sprintf("node %q has been recreated", a.Node)
+	}
+	a.UpdatedAt = time.Now().UTC()
+	cp.logger.Info("advance-action", lager.Data{"instance": instanceID, "action": a.Type, "id": a.ID, "state": a.State})
+	if err := cp.saveAction(ctx, a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// downstreamPod is a pod of an instance together with the client of its cluster.
+type downstreamPod struct {
+	release *helmv1alpha1.Release
+	client  client.Client
+	pod     *corev1.Pod
+}
+
+// downstreamStatefulSet is a StatefulSet of an instance together with the client of its cluster.
+type downstreamStatefulSet struct {
+	release     *helmv1alpha1.Release
+	client      client.Client
+	statefulSet *appsv1.StatefulSet
+}
+
+// actionPod returns the pod of a node in the namespaces of the releases.
+func (cp *Crossplane) actionPod(ctx context.Context, releases []*helmv1alpha1.Release, node string) (*downstreamPod, error) {
+	for _, release := range releases {
+		klient, err := cp.GetDownstreamClientForHelmRelease(ctx, release)
+		if err != nil {
+			return nil, err
+		}
+		pod := &corev1.Pod{}
+		err = klient.Get(ctx, types.NamespacedName{Namespace: release.Spec.ForProvider.Namespace, Name: node}, pod)
+		if k8serrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			cp.Downstream.Failed(providerConfigName(release), err)
+			return nil, fmt.Errorf("get pod(%q): %w", node, err)
+		}
+		return &downstreamPod{release: release, client: klient, pod: pod}, nil
+	}
+	return nil, fmt.Errorf("%w: node %q not found", ErrActionNotPermitted, node)
+}
+
+// actionStatefulSets returns the StatefulSets in the namespaces of the releases.
+func (cp *Crossplane) actionStatefulSets(ctx context.Context, releases []*helmv1alpha1.Release) ([]downstreamStatefulSet, error) {
+	statefulSets := []downstreamStatefulSet{}
+	for _, release := range releases {
+		klient, err := cp.GetDownstreamClientForHelmRelease(ctx, release)
+		if err != nil {
+			return nil, err
+		}
+		namespace := release.Spec.ForProvider.Namespace
+		list := &appsv1.StatefulSetList{}
+		if err := klient.List(ctx, list, client.InNamespace(namespace)); err != nil {
+			cp.Downstream.Failed(providerConfigName(release), err)
+			return nil, fmt.Errorf("list statefulsets(%q): %w", namespace, err)
+		}
+		for i := range list.Items {
+			statefulSets = append(statefulSets, downstreamStatefulSet{release: release, client: klient, statefulSet: &list.Items[i]})
+		}
+	}
+	return statefulSets, nil
+}
+
+// restartStatefulSets annotates the pod templates of the StatefulSets, which replaces their pods one after another.
+func (cp *Crossplane) restartStatefulSets(ctx context.Context, statefulSets []downstreamStatefulSet, now time.Time) error {
+	for _, s := range statefulSets {
+		patch := client.MergeFrom(s.statefulSet.DeepCopy())
+		annotations := s.statefulSet.Spec.Template.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[restartedAtAnnotation] = now.Format(time.RFC3339)
+		s.statefulSet.Spec.Template.SetAnnotations(annotations)
+		if err := s.client.Patch(ctx, s.statefulSet, patch, client.FieldOwner(FieldManager)); err != nil {
+			cp.Downstream.Failed(providerConfigName(s.release), err)
+			return fmt.Errorf("patch statefulset(%q): %w", s.statefulSet.Name, err)
+		}
+	}
+	return nil
+}
+
+// statefulSetRolledOut returns true if all pods of the StatefulSet run its latest revision and are ready.
+func statefulSetRolledOut(s *appsv1.StatefulSet) bool {
+	replicas := int32(1)
+	if s.Spec.Replicas != nil {
+		replicas = *s.Spec.Replicas
+	}
+	return s.Status.ObservedGeneration >= s.Generation &&
+		s.Status.UpdatedReplicas == replicas &&
+		s.Status.ReadyReplicas == replicas &&
+		s.Status.CurrentRevision == s.Status.UpdateRevision
+}
+
+// verifyMaster returns an error unless the exporter of the node reports it as the Redis master.
+// Deleting a replica wouldn't fail over the instance.
+func (cp *Crossplane) verifyMaster(ctx context.Context, pod *downstreamPod) error {
+	port := metricsPort(pod.pod)
+	if port == 0 {
+		return fmt.Errorf("%w: failover requires the metrics exporter to verify that node %q is the master", ErrActionNotPermitted, pod.pod.Name)
+	}
+	n := cp.scrapeMetrics(ctx, metricsSource{
+		providerConfig: providerConfigName(pod.release),
+		namespace:      pod.release.Spec.ForProvider.Namespace,
+		pod:            pod.pod.Name,
+		port:           strconv.Itoa(int(port)),
+	})
+	switch {
+	case n.Error != "":
+		return fmt.Errorf("%w: role of node %q unknown: %s", ErrActionNotPermitted, pod.pod.Name, n.Error)
+	case n.Role != "master":
+		return fmt.Errorf("%w: node %q is not the master", ErrActionNotPermitted, pod.pod.Name)
+	}
+	return nil
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func hasContainer(pod *corev1.Pod, name string) bool {
+	for _, c := range pod.Spec.Containers {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// failAction records that the action failed because of the error.
+// Only errors recording the failure are returned.
+func (cp *Crossplane) failAction(ctx context.Context, a *Action, err error) error {
+	a.State, a.Message, a.UpdatedAt = ActionFailed, err.Error(), time.Now().UTC()
+	cp.logger.Error("action-failed", err, lager.Data{"instance": a.InstanceID, "action": a.Type, "id": a.ID})
+	return cp.saveAction(ctx, a)
+}
+
+// pruneActions deletes the records of the actions, the latest first, beyond the number kept.
+// Failures are only logged, the records are pruned again when the next action starts.
+func (cp *Crossplane) pruneActions(ctx context.Context, actions []Action, keep int) {
+	for i := keep; i < len(actions); i++ {
+		if err := cp.deleteActionRecord(ctx, &actions[i]); err != nil {
+			cp.logger.Error("prune-action", err, lager.Data{"instance": actions[i].InstanceID, "id": actions[i].ID})
+		}
+	}
+}
+
+// DeleteActionRecords deletes the records of all actions of an instance.
+func (cp *Crossplane) DeleteActionRecords(ctx context.Context, instanceID string) error {
+	actions, err := cp.ListActions(ctx, instanceID)
+	if err != nil {
+		return err
+	}
+	for i := range actions {
+		if err := cp.deleteActionRecord(ctx, &actions[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cp *Crossplane) deleteActionRecord(ctx context.Context, a *Action) error {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      actionRecordName(a.InstanceID, a.ID),
+			Namespace: cp.Namespace,
+		},
+	}
+	return client.IgnoreNotFound(cp.Client.Delete(ctx, cm))
+}
+
+// ListActions returns the recorded actions of an instance, the latest first.
+func (cp *Crossplane) ListActions(ctx context.Context, instanceID string) ([]Action, error) {
+	req, err := labels.NewRequirement(ActionIDLabel, selection.Exists, nil)
+	if err != nil {
+		return nil, err
+	}
+	list := &corev1.ConfigMapList{}
+	if err := cp.Client.List(ctx, list, client.InNamespace(cp.Namespace), client.MatchingLabelsSelector{
+		Selector: labels.SelectorFromSet(labels.Set{InstanceIDLabel: instanceID}).Add(*req),
+	}); err != nil {
+		return nil, err
+	}
+
+	actions := make([]Action, 0, len(list.Items))
+	for i := range list.Items {
+		actions = append(actions, *actionFromRecord(&list.Items[i]))
+	}
+	sort.SliceStable(actions, func(i, j int) bool {
+		return actions[i].StartedAt.After(actions[j].StartedAt)
+	})
+	return actions, nil
+}
+
+// GetAction returns an action of an instance.
+func (cp *Crossplane) GetAction(ctx context.Context, instanceID, actionID string) (*Action, error) {
+	cm := &corev1.ConfigMap{}
+	err := cp.Client.Get(ctx, types.NamespacedName{Name: actionRecordName(instanceID, actionID), Namespace: cp.Namespace}, cm)
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, ErrActionNotFound
+		}
+		return nil, err
+	}
+	if cm.Labels[InstanceIDLabel] != instanceID || cm.Labels[ActionIDLabel] != actionID {
+		return nil, ErrActionNotFound
+	}
+	return actionFromRecord(cm), nil
+}
+
+func actionFromRecord(cm *corev1.ConfigMap) *Action {
+	a := &Action{
+		ID:          cm.Labels[ActionIDLabel],
+		InstanceID:  cm.Labels[InstanceIDLabel],
+		Type:        ActionType(cm.Data[actionRecordTypeKey]),
+		Node:        cm.Data[actionRecordNodeKey],
+		State:       ActionState(cm.Data[actionRecordStateKey]),
+		Message:     cm.Data[actionRecordMessageKey],
+		RequestedBy: cm.Data[actionRecordRequestedByKey],
+		nodeUID:     types.UID(cm.Data[actionRecordNodeUIDKey]),
+	}
+	a.StartedAt, _ = time.Parse(time.RFC3339Nano, cm.Data[actionRecordStartedAtKey])
+	a.UpdatedAt, _ = time.Parse(time.RFC3339Nano, cm.Data[actionRecordUpdatedAtKey])
+	return a
+}
+
+// saveAction creates or replaces the record of the action.
+func (cp *Crossplane) saveAction(ctx context.Context, a *Action) error {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      actionRecordName(a.InstanceID, a.ID),
+			Namespace: cp.Namespace,
+			Labels: map[string]string{
+				InstanceIDLabel: a.InstanceID,
+				ActionIDLabel:   a.ID,
+			},
+		},
+		Data: map[string]string{
+			actionRecordTypeKey:        string(a.Type),
+			actionRecordNodeKey:        a.Node,
+			actionRecordNodeUIDKey:     string(a.nodeUID),
+			actionRecordStateKey:       string(a.State),
+			actionRecordMessageKey:     a.Message,
+			actionRecordRequestedByKey: a.RequestedBy,
+			actionRecordStartedAtKey:   a.StartedAt.Format(time.RFC3339Nano),
+			actionRecordUpdatedAtKey:   a.UpdatedAt.Format(time.RFC3339Nano),
+		},
+	}
+	err := cp.Client.Create(ctx, cm)
+	if !k8serrors.IsAlreadyExists(err) {
+		return err
+	}
+	existing := &corev1.ConfigMap{}
+	if err := cp.Client.Get(ctx, types.NamespacedName{Name: cm.Name, Namespace: cm.Namespace}, existing); err != nil {
+		return err
+	}
+	patch := client.MergeFrom(existing.DeepCopy())
+	existing.Data = cm.Data
+	return cp.Client.Patch(ctx, existing, patch, client.FieldOwner(FieldManager))
+}
+
+// actionRecordName is the name of the ConfigMap recording an action on an instance.
+func actionRecordName(instanceID, actionID string) string {
+	return "action-" + instanceID + "-" + actionID
+}
+
+func newActionID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
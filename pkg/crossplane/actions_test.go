@@ -0,0 +1,302 @@
+package crossplane
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	helmv1alpha1 "github.com/crossplane-contrib/provider-helm/apis/release/v1alpha1"
+	"github.com/crossplane-contrib/provider-helm/apis/v1alpha1"
+	runtimev1alpha1 "github.com/crossplane/crossplane-runtime/apis/core/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newClusterProviderConfig returns the ProviderConfig of a cluster and the secret with its kubeconfig, which is the name of the cluster.
+func newClusterProviderConfig(name string) (*v1alpha1.ProviderConfig, *corev1.Secret) {
+	pc := &v1alpha1.ProviderConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1alpha1.ProviderConfigSpec{
+			ProviderConfigSpec: runtimev1alpha1.ProviderConfigSpec{
+				Credentials: runtimev1alpha1.ProviderCredentials{
+					Source: runtimev1alpha1.CredentialsSourceSecret,
+					SecretRef: &runtimev1alpha1.SecretKeySelector{
+						SecretReference: runtimev1alpha1.SecretReference{Namespace: "crossplane", Name: name},
+						Key:             runtimev1alpha1.ResourceCredentialsSecretKubeconfigKey,
+					},
+				},
+			},
+		},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "crossplane", Name: name},
+		Data:       map[string][]byte{runtimev1alpha1.ResourceCredentialsSecretKubeconfigKey: []byte(name)},
+	}
+	return pc, secret
+}
+
+// newDownstreamTestCrossplane returns a client with a Redis instance deployed to the downstream cluster with the given objects.
+func newDownstreamTestCrossplane(t *testing.T, downstreamObjs ...runtime.Object) (*Crossplane, k8sclient.Client) {
+	instance := newInstance("instance", nil)
+	instance.SetResourceReferences([]corev1.ObjectReference{
+		{Kind: "Release", Name: "instance-redis"},
+		{Kind: "Release", Name: "instance-haproxy"},
+	})
+	newRelease := func(name string) *helmv1alpha1.Release {
+		return &helmv1alpha1.Release{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: helmv1alpha1.ReleaseSpec{
+				ResourceSpec: runtimev1alpha1.ResourceSpec{
+					ProviderConfigReference: &runtimev1alpha1.Reference{Name: "eu-1"},
+				},
+				ForProvider: helmv1alpha1.ReleaseParameters{Namespace: "instance"},
+			},
+		}
+	}
+	pc, kubeconfig := newClusterProviderConfig("eu-1")
+	database := newInstance("database", map[string]string{ParentIDLabel: "instance"})
+
+	cp := newParentTestCrossplane(t, instance, database, newRelease("instance-redis"), newRelease("instance-haproxy"), pc, kubeconfig)
+	cp.Namespace = "crossplane"
+	cp.ActionCooldown = 5 * time.Minute
+
+	s := runtime.NewScheme()
+	require.NoError(t, scheme.AddToScheme(s))
+	downstream := fake.NewFakeClientWithScheme(s, downstreamObjs...)
+	cp.Downstream = NewDownstreamClients(cp.Client, nil, lager.NewLogger("test"))
+	cp.Downstream.newClient = func(kubeconfig []byte) (k8sclient.Client, *rest.Config, error) {
+		return downstream, &rest.Config{Host: string(kubeconfig)}, nil
+	}
+	return cp, downstream
+}
+
+func newActionTestPod(name, uid string, ready bool, containers ...string) *corev1.Pod {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "instance", Name: name, UID: types.UID(uid)}}
+	for _, c := range containers {
+		container := corev1.Container{Name: c}
+		if c == metricsPortName {
+			container.Ports = []corev1.ContainerPort{{Name: metricsPortName, ContainerPort: 9121}}
+		}
+		pod.Spec.Containers = append(pod.Spec.Containers, container)
+	}
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: status}}
+	return pod
+}
+
+func TestStartAction_Node(t *testing.T) {
+	ctx := context.Background()
+	cp, downstream := newDownstreamTestCrossplane(t,
+		newActionTestPod("redis-node-0", "uid-0", true, "redis", "sentinel", "metrics"),
+		newActionTestPod("redis-node-1", "uid-1", true, "redis", "sentinel", "metrics"),
+	)
+	cp.Downstream.proxyPod = func(ctx context.Context, config *rest.Config, namespace, pod, port, path string) (io.ReadCloser, error) {
+		role := "slave"
+		if pod == "redis-node-0" {
+			role = "master"
+		}
+		return ioutil.NopCloser(strings.NewReader(`redis_instance_info{role="` + role + `"} 1` + "\n")), nil
+	}
+
+	_, err := cp.StartAction(ctx, "instance", ActionFailover, "redis-node-1", "alice")
+	assert.EqualError(t, err, `action not permitted: node "redis-node-1" is not the master`)
+	require.NoError(t, downstream.Get(ctx, types.NamespacedName{Namespace: "instance", Name: "redis-node-1"}, &corev1.Pod{}), "replicas must not be deleted")
+
+	a, err := cp.StartAction(ctx, "instance", ActionFailover, "redis-node-0", "alice")
+	require.NoError(t, err)
+	assert.Equal(t, ActionInProgress, a.State)
+	assert.Equal(t, "alice", a.RequestedBy)
+	err = downstream.Get(ctx, types.NamespacedName{Namespace: "instance", Name: "redis-node-0"}, &corev1.Pod{})
+	require.Error(t, err, "the master must have been deleted")
+
+	_, err = cp.StartAction(ctx, "instance", ActionRestart, "redis-node-1", "alice")
+	assert.True(t, errors.Is(err, ErrActionInProgress), err)
+
+	a, err = cp.AdvanceAction(ctx, "instance", a.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ActionInProgress, a.State)
+
+	require.NoError(t, downstream.Create(ctx, newActionTestPod("redis-node-0", "uid-2", false, "redis", "sentinel", "metrics")))
+	a, err = cp.AdvanceAction(ctx, "instance", a.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ActionInProgress, a.State, "the recreated node isn't ready yet")
+
+	pod := &corev1.Pod{}
+	require.NoError(t, downstream.Get(ctx, types.NamespacedName{Namespace: "instance", Name: "redis-node-0"}, pod))
+	pod.Status.Conditions[0].Status = corev1.ConditionTrue
+	require.NoError(t, downstream.Update(ctx, pod))
+	a, err = cp.AdvanceAction(ctx, "instance", a.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ActionSucceeded, a.State)
+	assert.Equal(t, `node "redis-node-0" has been recreated`, a.Message)
+
+	got, err := cp.GetAction(ctx, "instance", a.ID)
+	require.NoError(t, err)
+	assert.Equal(t, a, got)
+	_, err = cp.GetAction(ctx, "database", a.ID)
+	assert.Equal(t, ErrActionNotFound, err)
+
+	_, err = cp.StartAction(ctx, "instance", ActionRestart, "redis-node-1", "alice")
+	assert.True(t, errors.Is(err, ErrActionRateLimited), err)
+
+	cp.ActionCooldown = 0
+	a, err = cp.StartAction(ctx, "instance", ActionRestart, "redis-node-1", "bob")
+	require.NoError(t, err)
+	actions, err := cp.ListActions(ctx, "instance")
+	require.NoError(t, err)
+	require.Len(t, actions, 2)
+	assert.Equal(t, "bob", actions[0].RequestedBy)
+	assert.Equal(t, "alice", actions[1].RequestedBy)
+}
+
+func TestStartAction_NotPermitted(t *testing.T) {
+	cp, _ := newDownstreamTestCrossplane(t,
+		newActionTestPod("mariadb-0", "uid-0", true, "mariadb"),
+		newActionTestPod("redis-node-0", "uid-1", true, "redis", "sentinel"),
+		newActionTestPod("redis-node-1", "uid-2", true, "redis", "sentinel", "metrics"),
+	)
+	cp.Downstream.proxyPod = func(ctx context.Context, config *rest.Config, namespace, pod, port, path string) (io.ReadCloser, error) {
+		return nil, errors.New("dial tcp 10.0.0.1:6443: connection refused")
+	}
+
+	tests := map[string]struct {
+		instance string
+		action   ActionType
+		node     string
+		err      string
+	}{
+		"failover without sentinel": {instance: "instance", action: ActionFailover, node: "mariadb-0", err: `action not permitted: failover requires Redis Sentinel, node "mariadb-0" doesn't run it`},
+		"failover without node":     {instance: "instance", action: ActionFailover, err: "action not permitted: failover requires the node of the current master"},
+		"failover without exporter": {instance: "instance", action: ActionFailover, node: "redis-node-0", err: `action not permitted: failover requires the metrics exporter to verify that node "redis-node-0" is the master`},
+		"failover of unknown role":  {instance: "instance", action: ActionFailover, node: "redis-node-1", err: `action not permitted: role of node "redis-node-1" unknown: exporter unreachable`},
+		"unknown node":              {instance: "instance", action: ActionRestart, node: "mariadb-1", err: `action not permitted: node "mariadb-1" not found`},
+		"without statefulsets":      {instance: "instance", action: ActionRestart, err: "action not permitted: instance has no StatefulSets"},
+		"unknown action":            {instance: "instance", action: "scale", node: "mariadb-0", err: `action not permitted: unknown action "scale"`},
+		"without nodes":             {instance: "database", action: ActionRestart, err: "action not permitted: instance has no nodes of its own"},
+		"unknown instance":          {instance: "unknown", action: ActionRestart, err: ErrInstanceNotFound.Error()},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := cp.StartAction(context.Background(), tt.instance, tt.action, tt.node, "alice")
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+	actions, err := cp.ListActions(context.Background(), "instance")
+	require.NoError(t, err)
+	assert.Empty(t, actions, "rejected actions must neither be recorded nor count towards the cooldown")
+}
+
+func TestStartAction_RestartAll(t *testing.T) {
+	ctx := context.Background()
+	replicas := int32(2)
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "instance", Name: "redis-node", Generation: 1},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+		Status: appsv1.StatefulSetStatus{
+			ObservedGeneration: 1,
+			ReadyReplicas:      2,
+			UpdatedReplicas:    2,
+			CurrentRevision:    "redis-node-1",
+			UpdateRevision:     "redis-node-1",
+		},
+	}
+	cp, downstream := newDownstreamTestCrossplane(t, sts)
+
+	a, err := cp.StartAction(ctx, "instance", ActionRestart, "", "alice")
+	require.NoError(t, err)
+	got := &appsv1.StatefulSet{}
+	require.NoError(t, downstream.Get(ctx, types.NamespacedName{Namespace: "instance", Name: "redis-node"}, got))
+	assert.Equal(t, a.StartedAt.Format(time.RFC3339), got.Spec.Template.Annotations[restartedAtAnnotation])
+
+	// The StatefulSet controller replaces the first pod.
+	got.Generation = 2
+	got.Status = appsv1.StatefulSetStatus{
+		ObservedGeneration: 2,
+		ReadyReplicas:      2,
+		UpdatedReplicas:    1,
+		CurrentRevision:    "redis-node-1",
+		UpdateRevision:     "redis-node-2",
+	}
+	require.NoError(t, downstream.Update(ctx, got))
+	a, err = cp.AdvanceAction(ctx, "instance", a.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ActionInProgress, a.State)
+	assert.Equal(t, "restarted 0 of 1 StatefulSets", a.Message)
+
+	got.Status.UpdatedReplicas, got.Status.CurrentRevision = 2, "redis-node-2"
+	require.NoError(t, downstream.Update(ctx, got))
+	a, err = cp.AdvanceAction(ctx, "instance", a.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ActionSucceeded, a.State)
+}
+
+func TestStartAction_PruneRecords(t *testing.T) {
+	ctx := context.Background()
+	cp, _ := newDownstreamTestCrossplane(t, newActionTestPod("redis-node-0", "uid-0", true, "redis"))
+	cp.ActionCooldown = 0
+	startedAt := time.Now().Add(-time.Hour).UTC()
+	for i := 0; i < maxActionRecords; i++ {
+		require.NoError(t, cp.saveAction(ctx, &Action{
+			ID:         strconv.Itoa(i),
+			InstanceID: "instance",
+			Type:       ActionRestart,
+			State:      ActionSucceeded,
+			StartedAt:  startedAt.Add(time.Duration(i) * time.Minute),
+			UpdatedAt:  startedAt.Add(time.Duration(i) * time.Minute),
+		}))
+	}
+
+	a, err := cp.StartAction(ctx, "instance", ActionRestart, "redis-node-0", "alice")
+	require.NoError(t, err)
+	actions, err := cp.ListActions(ctx, "instance")
+	require.NoError(t, err)
+	require.Len(t, actions, maxActionRecords)
+	assert.Equal(t, a.ID, actions[0].ID)
+	assert.Equal(t, "1", actions[len(actions)-1].ID, "the oldest action must have been deleted")
+
+	require.NoError(t, cp.DeleteActionRecords(ctx, "instance"))
+	actions, err = cp.ListActions(ctx, "instance")
+	require.NoError(t, err)
+	assert.Empty(t, actions)
+}
+
+func TestAdvanceAction_Timeout(t *testing.T) {
+	ctx := context.Background()
+	cp, _ := newDownstreamTestCrossplane(t)
+	startedAt := time.Now().Add(-actionTimeout - time.Minute).UTC()
+	require.NoError(t, cp.saveAction(ctx, &Action{
+		ID:         "1",
+		InstanceID: "instance",
+		Type:       ActionRestart,
+		Node:       "redis-node-0",
+		State:      ActionInProgress,
+		StartedAt:  startedAt,
+		UpdatedAt:  startedAt,
+	}))
+
+	a, err := cp.AdvanceAction(ctx, "instance", "1")
+	require.NoError(t, err)
+	assert.Equal(t, ActionFailed, a.State)
+	assert.Equal(t, "nodes not ready after 15m0s", a.Message)
+
+	_, err = cp.AdvanceAction(ctx, "instance", "2")
+	assert.Equal(t, ErrActionNotFound, err)
+}
@@ -0,0 +1,195 @@
+package crossplane
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	bindingRecordAppGUIDKey           = "app_guid"
+	bindingRecordCreatedAtKey         = "created_at"
+	bindingRecordCredentialVersionKey = "credential_version"
+)
+
+// Binding is the record of a binding created by the broker.
+type Binding struct {
+	ID         string
+	InstanceID string
+	// AppGUID is the application bound to the instance, empty if the binding isn't for an application.
+	AppGUID   string
+	CreatedAt time.Time
+	// CredentialVersion identifies the credentials handed out. It changes if the credentials of the binding change.
+	CredentialVersion string
+}
+
+// RecordBinding records a binding of an instance and the version of its credentials.
+// Recording an existing binding again keeps its creation time and updates the credential version.
+func (cp *Crossplane) RecordBinding(ctx context.Context, instanceID, bindingID, appGUID string, creds Credentials) error {
+	version, err := credentialVersion(creds)
+	if err != nil {
+		return err
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      bindingRecordName(bindingID),
+			Namespace: cp.Namespace,
+			Labels: map[string]string{
+				InstanceIDLabel: instanceID,
+				BindingIDLabel:  bindingID,
+			},
+		},
+		Data: map[string]string{
+			bindingRecordAppGUIDKey:           appGUID,
+			bindingRecordCreatedAtKey:         time.Now().UTC().Format(time.RFC3339),
+			bindingRecordCredentialVersionKey: version,
+		},
+	}
+	cp.logger.Debug("record-binding", lager.Data{"instance": instanceID, "binding": bindingID, "credential-version": version})
+	err = cp.Client.Create(ctx, cm)
+	if !k8serrors.IsAlreadyExists(err) {
+		return err
+	}
+
+	existing := &corev1.ConfigMap{}
+	if err := cp.Client.Get(ctx, types.NamespacedName{Name: cm.Name, Namespace: cm.Namespace}, existing); err != nil {
+		return err
+	}
+	if existing.Data[bindingRecordCredentialVersionKey] == version {
+		return nil
+	}
+	patch := client.MergeFrom(existing.DeepCopy())
+	if existing.Data == nil {
+		existing.Data = map[string]string{}
+	}
+	existing.Data[bindingRecordCredentialVersionKey] = version
+	return cp.Client.Patch(ctx, existing, patch, client.FieldOwner(FieldManager))
+}
+
+// DeleteBindingRecord deletes the record of a binding. Bindings without record are ignored.
+func (cp *Crossplane) DeleteBindingRecord(ctx context.Context, bindingID string) error {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      bindingRecordName(bindingID),
+			Namespace: cp.Namespace,
+		},
+	}
+	if err := cp.Client.Delete(ctx, cm); err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// BindingRecorded returns whether a binding has been recorded.
+func (cp *Crossplane) BindingRecorded(ctx context.Context, bindingID string) (bool, error) {
+	cm := &corev1.ConfigMap{}
+	err := cp.Client.Get(ctx, types.NamespacedName{Name: bindingRecordName(bindingID), Namespace: cp.Namespace}, cm)
+	if k8serrors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ListBindings returns the bindings of an instance sorted by creation time.
+// Besides the recorded bindings, MariaDB user bindings created before bindings were recorded are derived from their password secrets.
+func (cp *Crossplane) ListBindings(ctx context.Context, instanceID string) ([]Binding, error) {
+	req, err := labels.NewRequirement(BindingIDLabel, selection.Exists, nil)
+	if err != nil {
+		return nil, err
+	}
+	list := &corev1.ConfigMapList{}
+	if err := cp.Client.List(ctx, list, client.InNamespace(cp.Namespace), client.MatchingLabelsSelector{
+		Selector: labels.SelectorFromSet(labels.Set{InstanceIDLabel: instanceID}).Add(*req),
+	}); err != nil {
+		return nil, err
+	}
+
+	bindings := make([]Binding, 0, len(list.Items))
+	for _, cm := range list.Items {
+		b := Binding{
+			ID:                cm.Labels[BindingIDLabel],
+			InstanceID:        instanceID,
+			AppGUID:           cm.Data[bindingRecordAppGUIDKey],
+			CreatedAt:         cm.CreationTimestamp.UTC(),
+			CredentialVersion: cm.Data[bindingRecordCredentialVersionKey],
+		}
+		if t, err := time.Parse(time.RFC3339, cm.Data[bindingRecordCreatedAtKey]); err == nil {
+			b.CreatedAt = t
+		}
+		bindings = append(bindings, b)
+	}
+	unrecorded, err := cp.unrecordedUserBindings(ctx, instanceID, bindings)
+	if err != nil {
+		return nil, err
+	}
+	bindings = append(bindings, unrecorded...)
+	sort.Slice(bindings, func(i, j int) bool {
+		if !bindings[i].CreatedAt.Equal(bindings[j].CreatedAt) {
+			return bindings[i].CreatedAt.Before(bindings[j].CreatedAt)
+		}
+		return bindings[i].ID < bindings[j].ID
+	})
+	return bindings, nil
+}
+
+// unrecordedUserBindings returns the MariaDB user bindings of an instance which aren't in the given recorded bindings.
+func (cp *Crossplane) unrecordedUserBindings(ctx context.Context, instanceID string, recorded []Binding) ([]Binding, error) {
+	req, err := labels.NewRequirement(ParentIDLabel, selection.Exists, nil)
+	if err != nil {
+		return nil, err
+	}
+	secrets := &corev1.SecretList{}
+	if err := cp.Client.List(ctx, secrets, client.InNamespace(cp.Namespace), client.MatchingLabelsSelector{
+		Selector: labels.SelectorFromSet(labels.Set{InstanceIDLabel: instanceID}).Add(*req),
+	}); err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]bool, len(recorded))
+	for _, b := range recorded {
+		known[b.ID] = true
+	}
+	suffix := strings.TrimPrefix(secretName, "%s")
+	bindings := []Binding{}
+	for _, secret := range secrets.Items {
+		id := strings.TrimSuffix(secret.Name, suffix)
+		if id == secret.Name || known[id] {
+			continue
+		}
+		bindings = append(bindings, Binding{
+			ID:         id,
+			InstanceID: instanceID,
+			CreatedAt:  secret.CreationTimestamp.UTC(),
+		})
+	}
+	return bindings, nil
+}
+
+// bindingRecordName is the name of the ConfigMap recording a binding.
+func bindingRecordName(bindingID string) string {
+	return "binding-" + bindingID
+}
+
+// credentialVersion returns a hash of the credentials, so changed credentials can be detected without storing them.
+func credentialVersion(creds Credentials) (string, error) {
+	b, err := json.Marshal(creds)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8]), nil
+}
@@ -0,0 +1,86 @@
+package crossplane
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBindingRecords(t *testing.T) {
+	ctx := context.Background()
+	cp := newParentTestCrossplane(t)
+	cp.Namespace = "spks-crossplane"
+
+	require.NoError(t, cp.RecordBinding(ctx, "instance", "binding-2", "app-2", Credentials{"password": "secret"}))
+	require.NoError(t, cp.RecordBinding(ctx, "instance", "binding-1", "", Credentials{"password": "secret"}))
+	require.NoError(t, cp.RecordBinding(ctx, "other", "binding-3", "app-3", Credentials{"password": "secret"}))
+
+	bindings, err := cp.ListBindings(ctx, "instance")
+	require.NoError(t, err)
+	require.Len(t, bindings, 2)
+	assert.Equal(t, "binding-1", bindings[0].ID)
+	assert.Equal(t, "instance", bindings[0].InstanceID)
+	assert.Empty(t, bindings[0].AppGUID)
+	assert.Equal(t, "binding-2", bindings[1].ID)
+	assert.Equal(t, "app-2", bindings[1].AppGUID)
+	assert.False(t, bindings[1].CreatedAt.IsZero())
+	version := bindings[1].CredentialVersion
+	assert.Len(t, version, 16)
+	assert.Equal(t, version, bindings[0].CredentialVersion, "same credentials have the same version")
+
+	t.Run("recording again keeps the binding", func(t *testing.T) {
+		require.NoError(t, cp.RecordBinding(ctx, "instance", "binding-2", "app-2", Credentials{"password": "secret"}))
+		bindings, err := cp.ListBindings(ctx, "instance")
+		require.NoError(t, err)
+		require.Len(t, bindings, 2)
+		assert.Equal(t, version, bindings[1].CredentialVersion)
+	})
+
+	t.Run("changed credentials", func(t *testing.T) {
+		require.NoError(t, cp.RecordBinding(ctx, "instance", "binding-2", "app-2", Credentials{"password": "rotated"}))
+		bindings, err := cp.ListBindings(ctx, "instance")
+		require.NoError(t, err)
+		require.Len(t, bindings, 2)
+		assert.NotEqual(t, version, bindings[1].CredentialVersion)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, cp.DeleteBindingRecord(ctx, "binding-1"))
+		require.NoError(t, cp.DeleteBindingRecord(ctx, "binding-1"), "deleting twice is ignored")
+		bindings, err := cp.ListBindings(ctx, "instance")
+		require.NoError(t, err)
+		require.Len(t, bindings, 1)
+		assert.Equal(t, "binding-2", bindings[0].ID)
+	})
+}
+
+func TestListBindings_UnrecordedUsers(t *testing.T) {
+	ctx := context.Background()
+	newPasswordSecret := func(name, instance string) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "spks-crossplane",
+			Labels:    map[string]string{InstanceIDLabel: instance, ParentIDLabel: "cluster"},
+		}}
+	}
+	cp := newParentTestCrossplane(t,
+		newPasswordSecret("user-1-password", "database"),
+		newPasswordSecret("user-2-password", "database"),
+		newPasswordSecret("user-3-password", "other"),
+		newPasswordSecret("credentials", "database"),
+	)
+	cp.Namespace = "spks-crossplane"
+	require.NoError(t, cp.RecordBinding(ctx, "database", "user-2", "app", Credentials{"password": "secret"}))
+
+	bindings, err := cp.ListBindings(ctx, "database")
+	require.NoError(t, err)
+	ids := []string{}
+	for _, b := range bindings {
+		ids = append(ids, b.ID)
+	}
+	assert.ElementsMatch(t, []string{"user-1", "user-2"}, ids)
+}
@@ -2,34 +2,49 @@ package crossplane
 
 import (
 	"context"
-	"errors"
-	"fmt"
-	"os"
+	"sync/atomic"
+	"time"
+
+	"broker/pkg/config"
 
 	"code.cloudfoundry.org/lager"
 	helm "github.com/crossplane-contrib/provider-helm/apis"
 	helmv1alpha1 "github.com/crossplane-contrib/provider-helm/apis/release/v1alpha1"
-	"github.com/crossplane-contrib/provider-helm/apis/v1alpha1"
-	helmclient "github.com/crossplane-contrib/provider-helm/pkg/clients"
-	runtimev1alpha1 "github.com/crossplane/crossplane-runtime/apis/core/v1alpha1"
 	crossplane "github.com/crossplane/crossplane/apis"
-	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
-	"k8s.io/client-go/tools/clientcmd"
 	ctrl "sigs.k8s.io/controller-runtime"
 	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// FieldManager is the name of the broker recorded as the manager of the fields it writes.
+const FieldManager = "crossplane-service-broker"
+
 // Crossplane client to access crossplane resources.
 type Crossplane struct {
-	Client            k8sclient.Client
-	logger            lager.Logger
-	DownstreamClients map[string]k8sclient.Client
-	ServiceIDs        []string
+	Client k8sclient.Client
+	logger lager.Logger
+	// Downstream manages the clients of the clusters the instances are deployed to.
+	Downstream *DownstreamClients
+	serviceIDs atomic.Value
+	// Namespace in which secrets of instances and bindings are stored.
+	Namespace string
+	// HaProxyRelease is the name of the HAProxy release exposing an instance.
+	HaProxyRelease string
+	// MariadbMaxDatabases is the default number of databases of a MariaDB cluster, zero is unlimited.
+	MariadbMaxDatabases int
+	// DeprovisionBindings decides whether instances with bindings are deleted with their bindings or not at all.
+	DeprovisionBindings string
+	// Quota limits the instances of organizations and spaces.
+	Quota config.QuotaConfig
+	// Placement is the pool of clusters instances of plans without a cluster are placed on.
+	Placement config.PlacementConfig
+	// MaintenanceWindow of instances without a window of their own.
+	MaintenanceWindow string
+	// ActionCooldown is the minimum time between two actions on an instance.
+	ActionCooldown time.Duration
 }
 
 // SetupScheme configures the given runtime.Scheme with all requried resources
@@ -52,7 +67,7 @@ func SetupScheme(scheme *runtime.Scheme) error {
 }
 
 // New instantiates a crossplane client.
-func New(serviceIDs []string, logger lager.Logger) (*Crossplane, error) {
+func New(serviceIDs []string, cfg config.Crossplane, logger lager.Logger) (*Crossplane, error) {
 	if err := SetupScheme(scheme.Scheme); err != nil {
 		return nil, err
 	}
@@ -67,61 +82,43 @@ func New(serviceIDs []string, logger lager.Logger) (*Crossplane, error) {
 		return nil, err
 	}
 
-	cp := Crossplane{
-		Client:            k,
-		logger:            logger,
-		DownstreamClients: make(map[string]k8sclient.Client, 0),
-		ServiceIDs:        serviceIDs,
-	}
-
-	return &cp, nil
+	return NewWithClient(k, serviceIDs, cfg, logger), nil
 }
 
-// GetDownstreamClientForHelmRelease retrieves the provider config of a helm release, fetches the secret containing a kubeconfig from
-// the specified secretRef and instantiates necessary k8s clients.
-func (cp *Crossplane) GetDownstreamClientForHelmRelease(ctx context.Context, release *helmv1alpha1.Release) (k8sclient.Client, error) {
-	if kubeconfig := os.Getenv(clientcmd.RecommendedConfigPathEnvVar); len(kubeconfig) > 0 {
-		// Reuse local cluster for dev/debugging instead of remote downstream cluster,
-		// which won't be accessible.
-		config := ctrl.GetConfigOrDie()
-		return k8sclient.New(config, k8sclient.Options{})
-	}
-
-	name := release.Spec.ResourceSpec.ProviderConfigReference.Name
-
-	if _, ok := cp.DownstreamClients[name]; ok {
-		return cp.DownstreamClients[name], nil
-	}
-
-	providerconfig := &v1alpha1.ProviderConfig{}
-	ns := types.NamespacedName{Name: name}
-	if err := cp.Client.Get(ctx, ns, providerconfig); err != nil {
-		return nil, err
-	}
-
-	secretRef := types.NamespacedName{
-		Namespace: providerconfig.Spec.Credentials.SecretRef.Namespace,
-		Name:      providerconfig.Spec.Credentials.SecretRef.Name,
-	}
-	s := &corev1.Secret{}
-	if err := cp.Client.Get(ctx, secretRef, s); err != nil {
-		return nil, fmt.Errorf("unable to get secret: %w", err)
-	}
-	if s.Data == nil {
-		return nil, errors.New("nil secret data")
+// NewWithClient instantiates a crossplane client using the given kubernetes client.
+func NewWithClient(k k8sclient.Client, serviceIDs []string, cfg config.Crossplane, logger lager.Logger) *Crossplane {
+	cp := Crossplane{
+		Client:              k,
+		logger:              logger,
+		Downstream:          NewDownstreamClients(k, cfg.DevContexts, logger.Session("downstream")),
+		Namespace:           cfg.Namespace,
+		HaProxyRelease:      cfg.HaProxyRelease,
+		MariadbMaxDatabases: cfg.MariadbMaxDatabases,
+		DeprovisionBindings: cfg.DeprovisionBindings,
+		Quota:               cfg.Quota,
+		Placement:           cfg.Placement,
+		MaintenanceWindow:   cfg.MaintenanceWindow,
+		ActionCooldown:      cfg.ActionCooldown.Duration,
 	}
+	cp.SetServiceIDs(serviceIDs)
 
-	config, err := helmclient.NewRestConfig(s.Data[runtimev1alpha1.ResourceCredentialsSecretKubeconfigKey])
-	if err != nil {
-		return nil, err
-	}
+	return &cp
+}
 
-	k, err := helmclient.NewKubeClient(config)
-	if err != nil {
-		return nil, err
-	}
+// ServiceIDs returns the IDs of the services offered by the broker.
+func (cp *Crossplane) ServiceIDs() []string {
+	ids, _ := cp.serviceIDs.Load().([]string)
+	return ids
+}
 
-	cp.DownstreamClients[name] = k
+// SetServiceIDs atomically replaces the IDs of the services offered by the broker.
+func (cp *Crossplane) SetServiceIDs(serviceIDs []string) {
+	ids := make([]string, len(serviceIDs))
+	copy(ids, serviceIDs)
+	cp.serviceIDs.Store(ids)
+}
 
-	return k, nil
+// GetDownstreamClientForHelmRelease returns the client of the downstream cluster configured in the provider config of a helm release.
+func (cp *Crossplane) GetDownstreamClientForHelmRelease(ctx context.Context, release *helmv1alpha1.Release) (k8sclient.Client, error) {
+	return cp.Downstream.Get(ctx, providerConfigName(release))
 }
@@ -0,0 +1,184 @@
+package crossplane
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/crossplane/crossplane-runtime/pkg/resource/unstructured/composite"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/util/retry"
+)
+
+// ErrInvalidContext is returned if the OSB context of a request can't be stored on an instance.
+var ErrInvalidContext = errors.New("invalid context")
+
+// InstanceContext is the platform context of an instance as sent in the OSB context object.
+// Empty fields are unknown, e.g. Kubernetes platforms don't send organizations and spaces.
+type InstanceContext struct {
+	Platform         string `json:"platform,omitempty"`
+	OrganizationGUID string `json:"organization_guid,omitempty"`
+	OrganizationName string `json:"organization_name,omitempty"`
+	SpaceGUID        string `json:"space_guid,omitempty"`
+	SpaceName        string `json:"space_name,omitempty"`
+	Namespace        string `json:"namespace,omitempty"`
+	InstanceName     string `json:"instance_name,omitempty"`
+}
+
+// ParseInstanceContext reads the instance context from the OSB context object.
+func ParseInstanceContext(raw json.RawMessage) (InstanceContext, error) {
+	ic := InstanceContext{}
+	if len(raw) == 0 {
+		return ic, nil
+	}
+	if err := json.Unmarshal(raw, &ic); err != nil {
+		return ic, fmt.Errorf("%w: %s", ErrInvalidContext, err)
+	}
+	return ic, nil
+}
+
+// Labels returns the fields of the context stored as labels. The values are validated to be valid label values.
+func (ic InstanceContext) Labels() (map[string]string, error) {
+	labels := map[string]string{}
+	for k, v := range map[string]string{
+		PlatformLabel:          ic.Platform,
+		OrganizationLabel:      ic.OrganizationGUID,
+		SpaceLabel:             ic.SpaceGUID,
+		PlatformNamespaceLabel: ic.Namespace,
+	} {
+		if v == "" {
+			continue
+		}
+		if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+			return nil, fmt.Errorf("%w: %q is not a valid value for %s: %s", ErrInvalidContext, v, k, strings.Join(errs, ", "))
+		}
+		labels[k] = v
+	}
+	return labels, nil
+}
+
+// Annotations returns the fields of the context stored as annotations, i.e. the names which aren't valid label values.
+func (ic InstanceContext) Annotations() map[string]string {
+	annotations := map[string]string{}
+	for k, v := range map[string]string{
+		InstanceNameAnnotation:     ic.InstanceName,
+		OrganizationNameAnnotation: ic.OrganizationName,
+		SpaceNameAnnotation:        ic.SpaceName,
+	} {
+		if v != "" {
+			annotations[k] = v
+		}
+	}
+	return annotations
+}
+
+// InstanceContextOf returns the context stored on an instance.
+func InstanceContextOf(instance *composite.Unstructured) InstanceContext {
+	labels := instance.GetLabels()
+	annotations := instance.GetAnnotations()
+	return InstanceContext{
+		Platform:         labels[PlatformLabel],
+		OrganizationGUID: labels[OrganizationLabel],
+		OrganizationName: annotations[OrganizationNameAnnotation],
+		SpaceGUID:        labels[SpaceLabel],
+		SpaceName:        annotations[SpaceNameAnnotation],
+		Namespace:        labels[PlatformNamespaceLabel],
+		InstanceName:     annotations[InstanceNameAnnotation],
+	}
+}
+
+// UpdateInstanceContext stores the context on an instance. Fields missing in the context are left unchanged.
+// The organization authorizes parent references and quotas, it is only set on provisioning and can't be changed.
+func (cp *Crossplane) UpdateInstanceContext(ctx context.Context, instanceID string, ic InstanceContext) error {
+	labels, err := ic.Labels()
+	if err != nil {
+		return err
+	}
+	org := labels[OrganizationLabel]
+	delete(labels, OrganizationLabel)
+	annotations := ic.Annotations()
+	if org == "" && len(labels) == 0 && len(annotations) == 0 {
+		return nil
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		instance, err := cp.GetInstance(ctx, instanceID)
+		if err != nil {
+			return err
+		}
+		if current := instance.GetLabels()[OrganizationLabel]; org != "" && current != "" && org != current {
+			return fmt.Errorf("%w: the organization of an instance can't be changed from %q to %q", ErrInvalidContext, current, org)
+		}
+		if hasMetadata(instance, labels, annotations) {
+			return nil
+		}
+		return cp.patchInstance(ctx, instance, func() {
+			mergeMetadata(instance, labels, annotations)
+		})
+	})
+}
+
+// SyncNamespaceContext copies the context of an instance to its namespace on the downstream cluster.
+// Instances without a namespace of their own, like MariaDB databases, are skipped.
+func (cp *Crossplane) SyncNamespaceContext(ctx context.Context, instance *composite.Unstructured) error {
+	refs := instance.GetResourceReferences()
+	if len(findResourceRefs(refs, "Release")) == 0 {
+		return nil
+	}
+	ic := InstanceContextOf(instance)
+	labels, err := ic.Labels()
+	if err != nil {
+		return err
+	}
+	annotations := ic.Annotations()
+	if len(labels) == 0 && len(annotations) == 0 {
+		return nil
+	}
+	return patchNamespace(ctx, cp, instance.GetName(), refs, func(ns *corev1.Namespace) {
+		for k, v := range labels {
+			ns.Labels[k] = v
+		}
+		for k, v := range annotations {
+			ns.Annotations[k] = v
+		}
+	})
+}
+
+// hasMetadata checks whether the instance already has all the labels and annotations.
+func hasMetadata(instance *composite.Unstructured, labels, annotations map[string]string) bool {
+	l, a := instance.GetLabels(), instance.GetAnnotations()
+	for k, v := range labels {
+		if l[k] != v {
+			return false
+		}
+	}
+	for k, v := range annotations {
+		if a[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeMetadata sets the labels and annotations on the instance.
+func mergeMetadata(instance *composite.Unstructured, labels, annotations map[string]string) {
+	l := instance.GetLabels()
+	if l == nil {
+		l = map[string]string{}
+	}
+	for k, v := range labels {
+		l[k] = v
+	}
+	a := instance.GetAnnotations()
+	if a == nil {
+		a = map[string]string{}
+	}
+	for k, v := range annotations {
+		a[k] = v
+	}
+	instance.SetLabels(l)
+	instance.SetAnnotations(a)
+}
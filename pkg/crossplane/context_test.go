@@ -0,0 +1,134 @@
+package crossplane
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/crossplane/crossplane-runtime/pkg/resource/unstructured/composite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestParseInstanceContext(t *testing.T) {
+	tests := map[string]struct {
+		raw         string
+		labels      map[string]string
+		annotations map[string]string
+		err         string
+	}{
+		"no context": {
+			labels:      map[string]string{},
+			annotations: map[string]string{},
+		},
+		"cloudfoundry": {
+			raw: `{
+				"platform": "cloudfoundry",
+				"organization_guid": "org-guid",
+				"organization_name": "My Org",
+				"space_guid": "space-guid",
+				"space_name": "dev",
+				"instance_name": "my cache"
+			}`,
+			labels: map[string]string{
+				PlatformLabel:     "cloudfoundry",
+				OrganizationLabel: "org-guid",
+				SpaceLabel:        "space-guid",
+			},
+			annotations: map[string]string{
+				OrganizationNameAnnotation: "My Org",
+				SpaceNameAnnotation:        "dev",
+				InstanceNameAnnotation:     "my cache",
+			},
+		},
+		"kubernetes": {
+			raw: `{"platform": "kubernetes", "namespace": "app", "clusterid": "cluster", "instance_name": "cache"}`,
+			labels: map[string]string{
+				PlatformLabel:          "kubernetes",
+				PlatformNamespaceLabel: "app",
+			},
+			annotations: map[string]string{
+				InstanceNameAnnotation: "cache",
+			},
+		},
+		"not an object": {
+			raw: `"cloudfoundry"`,
+			err: "invalid context: json: cannot unmarshal string into Go value of type crossplane.InstanceContext",
+		},
+		"invalid label value": {
+			raw: `{"platform": "cloud foundry"}`,
+			err: `invalid context: "cloud foundry" is not a valid value for service.syn.tools/platform`,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var raw json.RawMessage
+			if tt.raw != "" {
+				raw = json.RawMessage(tt.raw)
+			}
+			ic, err := ParseInstanceContext(raw)
+			var labels map[string]string
+			if err == nil {
+				labels, err = ic.Labels()
+			}
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				assert.True(t, errors.Is(err, ErrInvalidContext))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.labels, labels)
+			assert.Equal(t, tt.annotations, ic.Annotations())
+		})
+	}
+}
+
+func TestUpdateInstanceContext(t *testing.T) {
+	instance := newInstance("instance", map[string]string{OrganizationLabel: "org-guid", SpaceLabel: "space-guid"})
+	instance.SetAnnotations(map[string]string{InstanceNameAnnotation: "old", "foreign": "annotation"})
+	// The fake client doesn't set a resource version on the objects it's created with.
+	instance.SetResourceVersion("1")
+	cp := newParentTestCrossplane(t, instance)
+
+	require.NoError(t, cp.UpdateInstanceContext(context.Background(), "instance", InstanceContext{
+		Platform:     "cloudfoundry",
+		SpaceGUID:    "space-guid",
+		InstanceName: "new",
+	}))
+
+	updated := composite.New(composite.WithGroupVersionKind(redisGVK))
+	require.NoError(t, cp.Client.Get(context.Background(), types.NamespacedName{Name: "instance"}, updated))
+	assert.Equal(t, InstanceContext{
+		Platform:         "cloudfoundry",
+		OrganizationGUID: "org-guid",
+		SpaceGUID:        "space-guid",
+		InstanceName:     "new",
+	}, InstanceContextOf(updated))
+	assert.Equal(t, "annotation", updated.GetAnnotations()["foreign"])
+	assert.Equal(t, "redis-k8s", updated.GetLabels()[ServiceIDLabel])
+
+	err := cp.UpdateInstanceContext(context.Background(), "unknown", InstanceContext{InstanceName: "new"})
+	assert.Equal(t, ErrInstanceNotFound, err)
+
+	err = cp.UpdateInstanceContext(context.Background(), "instance", InstanceContext{OrganizationGUID: "other-org", InstanceName: "moved"})
+	assert.EqualError(t, err, `invalid context: the organization of an instance can't be changed from "org-guid" to "other-org"`)
+	require.NoError(t, cp.Client.Get(context.Background(), types.NamespacedName{Name: "instance"}, updated))
+	assert.Equal(t, "org-guid", updated.GetLabels()[OrganizationLabel])
+	assert.Equal(t, "new", updated.GetAnnotations()[InstanceNameAnnotation])
+	require.NoError(t, cp.UpdateInstanceContext(context.Background(), "instance", InstanceContext{OrganizationGUID: "org-guid"}))
+}
+
+func TestUpdateInstanceContext_WithoutOrganization(t *testing.T) {
+	instance := newInstance("instance", nil)
+	instance.SetResourceVersion("1")
+	cp := newParentTestCrossplane(t, instance)
+
+	require.NoError(t, cp.UpdateInstanceContext(context.Background(), "instance", InstanceContext{OrganizationGUID: "org-guid", SpaceGUID: "space-guid"}))
+	updated := composite.New(composite.WithGroupVersionKind(redisGVK))
+	require.NoError(t, cp.Client.Get(context.Background(), types.NamespacedName{Name: "instance"}, updated))
+	assert.Empty(t, updated.GetLabels()[OrganizationLabel], "updates must not move instances into an organization")
+	assert.Equal(t, "space-guid", updated.GetLabels()[SpaceLabel])
+}
@@ -0,0 +1,74 @@
+package crossplane
+
+import (
+	"context"
+
+	runtimev1alpha1 "github.com/crossplane/crossplane-runtime/apis/core/v1alpha1"
+	"github.com/crossplane/crossplane-runtime/pkg/resource/unstructured/composite"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// InstanceMetadata are the OSB metadata of an instance.
+// Labels identify the instance, attributes describe its current state.
+type InstanceMetadata struct {
+	Labels     map[string]string      `json:"labels"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+// GetInstanceMetadata returns the SLA and cluster of an instance as labels
+// and its chart versions, Ready condition and whether an upgrade is available as attributes.
+// Releases which don't exist yet, e.g. while provisioning, are left out of the chart versions.
+func (cp *Crossplane) GetInstanceMetadata(ctx context.Context, instance *composite.Unstructured) (InstanceMetadata, error) {
+	md := InstanceMetadata{
+		Labels:     map[string]string{},
+		Attributes: map[string]interface{}{},
+	}
+	for name, label := range map[string]string{
+		"sla":     SLALabel,
+		"cluster": ClusterLabel,
+	} {
+		if v := instance.GetLabels()[label]; v != "" {
+			md.Labels[name] = v
+		}
+	}
+
+	ready := instance.GetCondition(runtimev1alpha1.TypeReady)
+	md.Attributes["ready"] = string(ready.Status)
+	if ready.Reason != "" {
+		md.Attributes["ready_reason"] = string(ready.Reason)
+	}
+
+	planVersions := map[string]string{}
+	if ref := instance.GetCompositionReference(); ref != nil {
+		plan, err := cp.GetPlan(ctx, ref.Name)
+		// Instances of deleted plans have no upgrades.
+		switch {
+		case err == nil:
+			if planVersions, err = planChartVersions(plan); err != nil {
+				return md, err
+			}
+		case !k8serrors.IsNotFound(err):
+			return md, err
+		}
+	}
+
+	charts := map[string]string{}
+	upgradeAvailable := false
+	for _, ref := range findResourceRefs(instance.GetResourceReferences(), "Release") {
+		release, err := cp.getRelease(ctx, ref.Name)
+		if err != nil {
+			if k8serrors.IsNotFound(err) {
+				continue
+			}
+			return md, err
+		}
+		chart := release.Spec.ForProvider.Chart
+		charts[chart.Name] = chart.Version
+		if v, ok := planVersions[chart.Name]; ok && v != chart.Version {
+			upgradeAvailable = true
+		}
+	}
+	md.Attributes["charts"] = charts
+	md.Attributes["upgrade_available"] = upgradeAvailable
+	return md, nil
+}
@@ -0,0 +1,57 @@
+package crossplane
+
+import (
+	"context"
+	"testing"
+
+	helmv1alpha1 "github.com/crossplane-contrib/provider-helm/apis/release/v1alpha1"
+	runtimev1alpha1 "github.com/crossplane/crossplane-runtime/apis/core/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetInstanceMetadata(t *testing.T) {
+	plan := newPlan("upgradable", SLAStandard)
+	plan.SetAnnotations(map[string]string{ChartVersionsAnnotation: `{"redis": "12.2.0"}`})
+	release := &helmv1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Name: "instance-redis"},
+		Spec: helmv1alpha1.ReleaseSpec{
+			ForProvider: helmv1alpha1.ReleaseParameters{
+				Chart: helmv1alpha1.ChartSpec{Name: "redis", Version: "12.1.1"},
+			},
+		},
+	}
+
+	instance := newInstance("instance", map[string]string{SLALabel: SLAStandard, ClusterLabel: "eu-1"})
+	instance.SetCompositionReference(&corev1.ObjectReference{Name: "upgradable"})
+	instance.SetResourceReferences([]corev1.ObjectReference{
+		{Kind: "Release", Name: "instance-redis"},
+		// Not created yet
+		{Kind: "Release", Name: "instance-haproxy"},
+	})
+	instance.SetConditions(runtimev1alpha1.Available())
+
+	cp := newParentTestCrossplane(t, plan, release)
+	md, err := cp.GetInstanceMetadata(context.Background(), instance)
+	require.NoError(t, err)
+	assert.Equal(t, InstanceMetadata{
+		Labels: map[string]string{"sla": SLAStandard, "cluster": "eu-1"},
+		Attributes: map[string]interface{}{
+			"ready":             "True",
+			"ready_reason":      "Available",
+			"charts":            map[string]string{"redis": "12.1.1"},
+			"upgrade_available": true,
+		},
+	}, md)
+
+	creating := newInstance("creating", nil)
+	creating.SetCompositionReference(&corev1.ObjectReference{Name: "deleted-plan"})
+	creating.SetConditions(runtimev1alpha1.Creating())
+	md, err = cp.GetInstanceMetadata(context.Background(), creating)
+	require.NoError(t, err)
+	assert.Equal(t, "False", md.Attributes["ready"])
+	assert.Equal(t, "Creating", md.Attributes["ready_reason"])
+	assert.Equal(t, false, md.Attributes["upgrade_available"])
+}
@@ -0,0 +1,363 @@
+package crossplane
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	helmv1alpha1 "github.com/crossplane-contrib/provider-helm/apis/release/v1alpha1"
+	"github.com/crossplane-contrib/provider-helm/apis/v1alpha1"
+	helmclient "github.com/crossplane-contrib/provider-helm/pkg/clients"
+	runtimev1alpha1 "github.com/crossplane/crossplane-runtime/apis/core/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	clientcmdlatest "k8s.io/client-go/tools/clientcmd/api/latest"
+	clientcmdv1 "k8s.io/client-go/tools/clientcmd/api/v1"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// healthCheckTimeout limits the duration of a single health check of a downstream cluster.
+const healthCheckTimeout = 10 * time.Second
+
+// ClusterHealth is the health of a downstream cluster.
+type ClusterHealth struct {
+	// Name of the ProviderConfig of the cluster.
+	Name      string    `json:"name"`
+	Healthy   bool      `json:"healthy"`
+	Error     string    `json:"error,omitempty"`
+	LastCheck time.Time `json:"last_check"`
+}
+
+// DownstreamClients manages the clients of the downstream clusters instances are deployed to.
+// Clients are created from the kubeconfig in the credentials secret of a helm ProviderConfig.
+// They are rebuilt if the secret changes and evicted if they fail. It is safe for concurrent use.
+//
+// For local development, ProviderConfigs can be mapped to contexts of the local kubeconfig.
+// Without a mapping, all clusters are mapped to the current context if the `KUBECONFIG` env var is set.
+type DownstreamClients struct {
+	c           k8sclient.Client
+	logger      lager.Logger
+	newClient   func(kubeconfig []byte) (k8sclient.Client, *rest.Config, error)
+	streamLogs  func(ctx context.Context, config *rest.Config, namespace, pod string, opts *corev1.PodLogOptions) (io.ReadCloser, error)
+	proxyPod    func(ctx context.Context, config *rest.Config, namespace, pod, port, path string) (io.ReadCloser, error)
+	devContexts map[string]string
+
+	mu      sync.RWMutex
+	clients map[string]*downstreamClient
+	health  map[string]ClusterHealth
+}
+
+type downstreamClient struct {
+	client k8sclient.Client
+	config *rest.Config
+	// checksum of the kubeconfig the client has been created from.
+	checksum [sha256.Size]byte
+}
+
+// NewDownstreamClients creates a manager reading ProviderConfigs and their secrets with the given control plane client.
+// The dev contexts map ProviderConfig names to contexts of the local kubeconfig.
+func NewDownstreamClients(c k8sclient.Client, devContexts map[string]string, logger lager.Logger) *DownstreamClients {
+	return &DownstreamClients{
+		c:           c,
+		logger:      logger,
+		newClient:   newKubeClient,
+		streamLogs:  streamPodLogs,
+		proxyPod:    proxyPodGet,
+		devContexts: devContexts,
+		clients:     map[string]*downstreamClient{},
+		health:      map[string]ClusterHealth{},
+	}
+}
+
+func newKubeClient(kubeconfig []byte) (k8sclient.Client, *rest.Config, error) {
+	config, err := helmclient.NewRestConfig(kubeconfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	k, err := helmclient.NewKubeClient(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return k, config, nil
+}
+
+// Get returns the client of the cluster configured in the ProviderConfig with the given name.
+func (d *DownstreamClients) Get(ctx context.Context, providerConfig string) (k8sclient.Client, error) {
+	dc, err := d.get(ctx, providerConfig)
+	if err != nil {
+		return nil, err
+	}
+	return dc.client, nil
+}
+
+// RestConfig returns the REST config of the cluster configured in the ProviderConfig with the given name.
+func (d *DownstreamClients) RestConfig(ctx context.Context, providerConfig string) (*rest.Config, error) {
+	dc, err := d.get(ctx, providerConfig)
+	if err != nil {
+		return nil, err
+	}
+	return rest.CopyConfig(dc.config), nil
+}
+
+// PodLogs opens the log stream of a pod on the cluster configured in the ProviderConfig with the given name.
+func (d *DownstreamClients) PodLogs(ctx context.Context, providerConfig, namespace, pod string, opts *corev1.PodLogOptions) (io.ReadCloser, error) {
+	dc, err := d.get(ctx, providerConfig)
+	if err != nil {
+		return nil, err
+	}
+	return d.streamLogs(ctx, dc.config, namespace, pod, opts)
+}
+
+func streamPodLogs(ctx context.Context, config *rest.Config, namespace, pod string, opts *corev1.PodLogOptions) (io.ReadCloser, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return clientset.CoreV1().Pods(namespace).GetLogs(pod, opts).Stream(ctx)
+}
+
+// PodProxy sends a GET request for the path to a port of a pod through the API server of the cluster configured
+// in the ProviderConfig with the given name. It's used to read endpoints which aren't exposed outside of the cluster.
+func (d *DownstreamClients) PodProxy(ctx context.Context, providerConfig, namespace, pod, port, path string) (io.ReadCloser, error) {
+	dc, err := d.get(ctx, providerConfig)
+	if err != nil {
+		return nil, err
+	}
+	return d.proxyPod(ctx, dc.config, namespace, pod, port, path)
+}
+
+func proxyPodGet(ctx context.Context, config *rest.Config, namespace, pod, port, path string) (io.ReadCloser, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return clientset.CoreV1().Pods(namespace).ProxyGet("http", pod, port, path, nil).Stream(ctx)
+}
+
+func (d *DownstreamClients) get(ctx context.Context, providerConfig string) (*downstreamClient, error) {
+	d.mu.RLock()
+	dc, ok := d.clients[providerConfig]
+	d.mu.RUnlock()
+	if ok {
+		return dc, nil
+	}
+
+	kubeconfig, err := d.kubeconfig(ctx, providerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// Another request might have created the client in the meantime.
+	if dc, ok := d.clients[providerConfig]; ok && dc.checksum == sha256.Sum256(kubeconfig) {
+		return dc, nil
+	}
+	dc, err = d.build(providerConfig, kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	return dc, nil
+}
+
+// build creates a client and stores it. The caller must hold the write lock.
+func (d *DownstreamClients) build(providerConfig string, kubeconfig []byte) (*downstreamClient, error) {
+	k, config, err := d.newClient(kubeconfig)
+	if err != nil {
+		d.setHealth(providerConfig, err)
+		return nil, fmt.Errorf("unable to create client for provider config %q: %w", providerConfig, err)
+	}
+	dc := &downstreamClient{
+		client:   k,
+		config:   config,
+		checksum: sha256.Sum256(kubeconfig),
+	}
+	d.clients[providerConfig] = dc
+	d.logger.Info("downstream-client-created", lager.Data{"provider-config": providerConfig})
+	return dc, nil
+}
+
+// kubeconfig reads the kubeconfig from the credentials secret of a ProviderConfig.
+func (d *DownstreamClients) kubeconfig(ctx context.Context, providerConfig string) ([]byte, error) {
+	if context, ok := d.devContexts[providerConfig]; ok {
+		return localKubeconfig(context)
+	}
+	if len(d.devContexts) == 0 && os.Getenv(clientcmd.RecommendedConfigPathEnvVar) != "" {
+		// Reuse local cluster for dev/debugging instead of remote downstream cluster,
+		// which won't be accessible.
+		return localKubeconfig("")
+	}
+
+	pc := &v1alpha1.ProviderConfig{}
+	if err := d.c.Get(ctx, types.NamespacedName{Name: providerConfig}, pc); err != nil {
+		return nil, fmt.Errorf("unable to get provider config %q: %w", providerConfig, err)
+	}
+	if pc.Spec.Credentials.SecretRef == nil {
+		return nil, fmt.Errorf("provider config %q has no credentials secret", providerConfig)
+	}
+
+	secretRef := types.NamespacedName{
+		Namespace: pc.Spec.Credentials.SecretRef.Namespace,
+		Name:      pc.Spec.Credentials.SecretRef.Name,
+	}
+	s := &corev1.Secret{}
+	if err := d.c.Get(ctx, secretRef, s); err != nil {
+		return nil, fmt.Errorf("unable to get secret: %w", err)
+	}
+	if s.Data == nil {
+		return nil, errors.New("nil secret data")
+	}
+	return s.Data[runtimev1alpha1.ResourceCredentialsSecretKubeconfigKey], nil
+}
+
+// localKubeconfig returns the local kubeconfig reduced to the given context.
+// The current context is used if the context is empty.
+func localKubeconfig(context string) ([]byte, error) {
+	config, err := clientcmd.NewDefaultClientConfigLoadingRules().Load()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load local kubeconfig: %w", err)
+	}
+	if context != "" {
+		config.CurrentContext = context
+	}
+	if err := clientcmdapi.MinifyConfig(config); err != nil {
+		return nil, fmt.Errorf("unable to use local kubeconfig context %q: %w", context, err)
+	}
+	// Inline referenced certificate files, the kubeconfig is parsed without its location.
+	if err := clientcmdapi.FlattenConfig(config); err != nil {
+		return nil, fmt.Errorf("unable to use local kubeconfig context %q: %w", context, err)
+	}
+	v1Config := clientcmdv1.Config{}
+	if err := clientcmdlatest.Scheme.Convert(config, &v1Config, nil); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(v1Config)
+}
+
+// Evict removes the client of a ProviderConfig. The next Get creates a new client.
+func (d *DownstreamClients) Evict(providerConfig string) {
+	d.mu.Lock()
+	delete(d.clients, providerConfig)
+	d.mu.Unlock()
+}
+
+// Failed records an error returned by the cluster of a ProviderConfig. Errors indicating
+// that the cluster is unreachable or the credentials are invalid evict the client.
+func (d *DownstreamClients) Failed(providerConfig string, err error) {
+	var status k8serrors.APIStatus
+	if errors.As(err, &status) && !k8serrors.IsUnauthorized(err) {
+		// The cluster answered, the client works.
+		return
+	}
+	d.logger.Info("downstream-client-evicted", lager.Data{"provider-config": providerConfig, "error": err.Error()})
+	d.mu.Lock()
+	delete(d.clients, providerConfig)
+	d.setHealth(providerConfig, err)
+	d.mu.Unlock()
+}
+
+// Health returns the health of all known clusters, ordered by name.
+func (d *DownstreamClients) Health() []ClusterHealth {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	h := make([]ClusterHealth, 0, len(d.health))
+	for _, ch := range d.health {
+		h = append(h, ch)
+	}
+	sort.Slice(h, func(i, j int) bool {
+		return h[i].Name < h[j].Name
+	})
+	return h
+}
+
+// setHealth records the result of using a cluster. The caller must hold the write lock.
+func (d *DownstreamClients) setHealth(providerConfig string, err error) {
+	ch := ClusterHealth{
+		Name:      providerConfig,
+		Healthy:   err == nil,
+		LastCheck: time.Now(),
+	}
+	if err != nil {
+		ch.Error = err.Error()
+	}
+	d.health[providerConfig] = ch
+}
+
+// Run refreshes the clients in the given interval until the context is done.
+func (d *DownstreamClients) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			d.Refresh(ctx)
+		}
+	}
+}
+
+// Refresh rebuilds the clients whose credentials changed and checks the health of all clusters.
+// Clients of unhealthy clusters are evicted.
+func (d *DownstreamClients) Refresh(ctx context.Context) {
+	d.mu.RLock()
+	names := make([]string, 0, len(d.clients))
+	for name := range d.clients {
+		names = append(names, name)
+	}
+	d.mu.RUnlock()
+
+	for _, name := range names {
+		err := d.refresh(ctx, name)
+		d.mu.Lock()
+		if err != nil {
+			d.logger.Info("downstream-client-evicted", lager.Data{"provider-config": name, "error": err.Error()})
+			delete(d.clients, name)
+		}
+		d.setHealth(name, err)
+		d.mu.Unlock()
+	}
+}
+
+func (d *DownstreamClients) refresh(ctx context.Context, providerConfig string) error {
+	kubeconfig, err := d.kubeconfig(ctx, providerConfig)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	dc, ok := d.clients[providerConfig]
+	if !ok || dc.checksum != sha256.Sum256(kubeconfig) {
+		d.logger.Info("downstream-credentials-changed", lager.Data{"provider-config": providerConfig})
+		dc, err = d.build(providerConfig, kubeconfig)
+	}
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	return dc.client.List(ctx, &corev1.NamespaceList{}, k8sclient.Limit(1))
+}
+
+// providerConfigName returns the name of the ProviderConfig of a helm release.
+func providerConfigName(release *helmv1alpha1.Release) string {
+	return release.Spec.ResourceSpec.ProviderConfigReference.Name
+}
@@ -0,0 +1,142 @@
+package crossplane
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/crossplane-contrib/provider-helm/apis/v1alpha1"
+	runtimev1alpha1 "github.com/crossplane/crossplane-runtime/apis/core/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestDownstreamClients(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, scheme.AddToScheme(s))
+	require.NoError(t, SetupScheme(s))
+
+	pc := &v1alpha1.ProviderConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster-1"},
+		Spec: v1alpha1.ProviderConfigSpec{
+			ProviderConfigSpec: runtimev1alpha1.ProviderConfigSpec{
+				Credentials: runtimev1alpha1.ProviderCredentials{
+					Source: runtimev1alpha1.CredentialsSourceSecret,
+					SecretRef: &runtimev1alpha1.SecretKeySelector{
+						SecretReference: runtimev1alpha1.SecretReference{Namespace: "crossplane", Name: "cluster-1"},
+						Key:             runtimev1alpha1.ResourceCredentialsSecretKubeconfigKey,
+					},
+				},
+			},
+		},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "crossplane", Name: "cluster-1"},
+		Data:       map[string][]byte{runtimev1alpha1.ResourceCredentialsSecretKubeconfigKey: []byte("v1")},
+	}
+	c := fake.NewFakeClientWithScheme(s, pc, secret)
+
+	built := []string{}
+	d := NewDownstreamClients(c, nil, lager.NewLogger("test"))
+	d.newClient = func(kubeconfig []byte) (k8sclient.Client, *rest.Config, error) {
+		built = append(built, string(kubeconfig))
+		return fake.NewFakeClientWithScheme(s), &rest.Config{Host: string(kubeconfig)}, nil
+	}
+	ctx := context.Background()
+
+	k1, err := d.Get(ctx, "cluster-1")
+	require.NoError(t, err)
+	k2, err := d.Get(ctx, "cluster-1")
+	require.NoError(t, err)
+	assert.Same(t, k1, k2, "clients must be cached")
+
+	secret.Data[runtimev1alpha1.ResourceCredentialsSecretKubeconfigKey] = []byte("v2")
+	require.NoError(t, c.Update(ctx, secret))
+	d.Refresh(ctx)
+	config, err := d.RestConfig(ctx, "cluster-1")
+	require.NoError(t, err)
+	assert.Equal(t, "v2", config.Host, "rotated credentials must be picked up")
+	assert.Equal(t, []string{"v1", "v2"}, built)
+
+	health := d.Health()
+	require.Len(t, health, 1)
+	assert.True(t, health[0].Healthy)
+
+	d.Failed("cluster-1", errors.New("connection refused"))
+	health = d.Health()
+	assert.False(t, health[0].Healthy)
+	assert.Equal(t, "connection refused", health[0].Error)
+
+	_, err = d.Get(ctx, "cluster-1")
+	require.NoError(t, err)
+	assert.Len(t, built, 3, "failed clients must be evicted")
+
+	_, err = d.Get(ctx, "unknown")
+	assert.Error(t, err)
+}
+
+func TestDownstreamClients_DevContexts(t *testing.T) {
+	kubeconfig := []byte(`apiVersion: v1
+kind: Config
+current-context: kind-control
+clusters:
+- name: kind-control
+  cluster:
+    server: https://127.0.0.1:6443
+- name: kind-service-1
+  cluster:
+    server: https://127.0.0.1:6444
+contexts:
+- name: kind-control
+  context:
+    cluster: kind-control
+    user: kind-control
+- name: kind-service-1
+  context:
+    cluster: kind-service-1
+    user: kind-service-1
+users:
+- name: kind-control
+  user:
+    token: control
+- name: kind-service-1
+  user:
+    token: service-1
+`)
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config")
+	require.NoError(t, ioutil.WriteFile(path, kubeconfig, 0600))
+	defer os.Setenv(clientcmd.RecommendedConfigPathEnvVar, os.Getenv(clientcmd.RecommendedConfigPathEnvVar))
+	require.NoError(t, os.Setenv(clientcmd.RecommendedConfigPathEnvVar, path))
+
+	s := runtime.NewScheme()
+	require.NoError(t, scheme.AddToScheme(s))
+	require.NoError(t, SetupScheme(s))
+	d := NewDownstreamClients(fake.NewFakeClientWithScheme(s), map[string]string{"service-1": "kind-service-1"}, lager.NewLogger("test"))
+	d.newClient = func(kubeconfig []byte) (k8sclient.Client, *rest.Config, error) {
+		config, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+		return nil, config, err
+	}
+
+	config, err := d.RestConfig(context.Background(), "service-1")
+	require.NoError(t, err)
+	assert.Equal(t, "https://127.0.0.1:6444", config.Host)
+	assert.Equal(t, "service-1", config.BearerToken)
+
+	_, err = d.Get(context.Background(), "service-2")
+	assert.Error(t, err, "unmapped provider configs must not use the local cluster")
+}
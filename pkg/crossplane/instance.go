@@ -13,6 +13,8 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 const (
@@ -36,11 +38,16 @@ var (
 	ErrSLAChangeNotPermitted = errors.New("SLA change not permitted")
 )
 
-// CreateInstance creates a service instance
-func (cp *Crossplane) CreateInstance(ctx context.Context, instanceID string, parameters json.RawMessage, plan *v1beta1.Composition) error {
+// CreateInstance creates a service instance. The additional labels and the annotations are set on the instance.
+// Instances of plans without a cluster are deployed to the cluster given in the additional labels, see PlaceInstance.
+// Children of such plans are deployed to the cluster of their parent.
+func (cp *Crossplane) CreateInstance(ctx context.Context, instanceID string, parameters json.RawMessage, plan *v1beta1.Composition, additionalLabels, annotations map[string]string) error {
 	labels := map[string]string{
 		InstanceIDLabel: instanceID,
 	}
+	for k, v := range additionalLabels {
+		labels[k] = v
+	}
 	// Copy relevant labels from plan
 	for _, l := range []string{
 		ServiceIDLabel,
@@ -74,12 +81,49 @@ func (cp *Crossplane) CreateInstance(ctx context.Context, instanceID string, par
 			labels[ParentIDLabel] = parentReference
 		}
 	}
+	placedCluster := ""
+	if plan.Labels[ClusterLabel] == "" {
+		placedCluster = additionalLabels[ClusterLabel]
+		if parentID := labels[ParentIDLabel]; parentID != "" {
+			placedCluster, err = cp.parentCluster(ctx, parentID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if placedCluster != "" {
+		labels[ClusterLabel] = placedCluster
+	}
 	if err := fieldpath.Pave(cmp.Object).SetValue(InstanceSpecParamsPath, parametersMap); err != nil {
 		return err
 	}
+	if placedCluster != "" {
+		// The cluster is named after its ProviderConfig.
+		if err := fieldpath.Pave(cmp.Object).SetValue(InstanceSpecProviderConfigPath, placedCluster); err != nil {
+			return err
+		}
+	}
 	cmp.SetLabels(labels)
+	if len(annotations) > 0 {
+		cmp.SetAnnotations(annotations)
+	}
 	cp.logger.Debug("create-instance", lager.Data{"instance": cmp})
-	return cp.Client.Create(ctx, cmp)
+	return cp.Client.Create(ctx, cmp, client.FieldOwner(FieldManager))
+}
+
+// parentCluster returns the cluster a parent has been deployed to, named after its ProviderConfig.
+func (cp *Crossplane) parentCluster(ctx context.Context, parentID string) (string, error) {
+	parent, err := cp.GetInstance(ctx, parentID)
+	if err != nil {
+		if errors.Is(err, ErrInstanceNotFound) {
+			return "", fmt.Errorf("%w: parent %q not found", ErrInvalidParent, parentID)
+		}
+		return "", err
+	}
+	if providerConfig, err := fieldpath.Pave(parent.Object).GetString(InstanceSpecProviderConfigPath); err == nil && providerConfig != "" {
+		return providerConfig, nil
+	}
+	return parent.GetLabels()[ClusterLabel], nil
 }
 
 // DeleteInstance deletes a service instance
@@ -137,7 +181,7 @@ func (cp *Crossplane) GetInstanceWithPlan(ctx context.Context, instanceID string
 // GetInstance returns the instance with a given ID.
 // It will search all available plans for the instance, therefore use `GetInstanceWithPlan` whenever possible.
 func (cp *Crossplane) GetInstance(ctx context.Context, instanceID string) (*composite.Unstructured, error) {
-	plans, err := cp.getPlansForService(ctx, cp.ServiceIDs)
+	plans, err := cp.getPlansForService(ctx, cp.ServiceIDs())
 	if err != nil {
 		return nil, fmt.Errorf("could not get plans %w", err)
 	}
@@ -157,67 +201,87 @@ func (cp *Crossplane) GetInstance(ctx context.Context, instanceID string) (*comp
 
 // UpdateInstanceSLA updates the SLA of an instance specified by the supplied planID.
 // Only SLA changes are allowed, any other change is not permitted and yields an error.
+// The instance stays on its cluster, plans deployed to another cluster are rejected with ErrClusterChangeNotPermitted.
+// The instance is patched with optimistic locking and the update is retried on conflicts,
+// e.g. if Crossplane updated the instance in the meantime.
 func (cp *Crossplane) UpdateInstanceSLA(ctx context.Context, instanceID, serviceID, planID string) error {
-	instance, err := cp.GetInstance(ctx, instanceID)
-	if err != nil {
-		return err
-	}
-
-	instanceLabels := instance.GetLabels()
-	if serviceID != instanceLabels[ServiceIDLabel] {
-		return ErrServiceUpdateNotPermitted
-	}
-
 	newPlan, err := cp.GetPlan(ctx, planID)
 	if err != nil {
 		return err
 	}
 
-	slaChangePermitted := func() bool {
-		instanceSLA := instanceLabels[SLALabel]
-		newPlanSLA := newPlan.Labels[SLALabel]
-		instancePlanLevel := getPlanLevel(instanceLabels[PlanNameLabel])
-		newPlanLevel := getPlanLevel(newPlan.Labels[PlanNameLabel])
-		instanceService := instanceLabels[ServiceIDLabel]
-		newPlanService := newPlan.Labels[ServiceIDLabel]
-
-		// switch from redis to mariadb not permitted
-		if instanceService != newPlanService {
-			return false
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		instance, err := cp.GetInstance(ctx, instanceID)
+		if err != nil {
+			return err
 		}
-		// xsmall -> large not permitted, only xsmall <-> xsmall-premium
-		if instancePlanLevel != newPlanLevel {
-			return false
+
+		instanceLabels := instance.GetLabels()
+		if serviceID != instanceLabels[ServiceIDLabel] {
+			return ErrServiceUpdateNotPermitted
 		}
-		if instanceSLA == SLAPremium && newPlanSLA == SLAStandard {
-			return true
+		if !slaChangePermitted(instanceLabels, newPlan.Labels) {
+			return ErrSLAChangeNotPermitted
 		}
-		if instanceSLA == SLAStandard && newPlanSLA == SLAPremium {
-			return true
+		cluster := instanceLabels[ClusterLabel]
+		if newCluster := newPlan.Labels[ClusterLabel]; newCluster != "" && newCluster != cluster {
+			return fmt.Errorf("%w: plan %q is deployed to cluster %q, the instance to %q", ErrClusterChangeNotPermitted, newPlan.Name, newCluster, cluster)
 		}
+		// Compositions of plans without a cluster deploy to the ProviderConfig in the spec of the instance,
+		// instances of plans with a cluster don't have one and must stay on their cluster.
+		providerConfig, err := fieldpath.Pave(instance.Object).GetString(InstanceSpecProviderConfigPath)
+		setProviderConfig := newPlan.Labels[ClusterLabel] == "" && cluster != "" && (err != nil || providerConfig == "")
+
+		return cp.patchInstance(ctx, instance, func() {
+			if setProviderConfig {
+				_ = fieldpath.Pave(instance.Object).SetValue(InstanceSpecProviderConfigPath, cluster)
+			}
+			instance.SetCompositionReference(&corev1.ObjectReference{
+				Name: newPlan.Name,
+			})
+			for _, l := range []string{
+				PlanNameLabel,
+				SLALabel,
+			} {
+				instanceLabels[l] = newPlan.Labels[l]
+			}
+			instance.SetLabels(instanceLabels)
+		})
+	})
+}
+
+// slaChangePermitted checks if an instance with the given labels can be changed to the plan with the given labels.
+func slaChangePermitted(instanceLabels, planLabels map[string]string) bool {
+	instanceSLA := instanceLabels[SLALabel]
+	newPlanSLA := planLabels[SLALabel]
+	instancePlanLevel := getPlanLevel(instanceLabels[PlanNameLabel])
+	newPlanLevel := getPlanLevel(planLabels[PlanNameLabel])
+	instanceService := instanceLabels[ServiceIDLabel]
+	newPlanService := planLabels[ServiceIDLabel]
+
+	// switch from redis to mariadb not permitted
+	if instanceService != newPlanService {
 		return false
 	}
-
-	if !slaChangePermitted() {
-		return ErrSLAChangeNotPermitted
+	// xsmall -> large not permitted, only xsmall <-> xsmall-premium
+	if instancePlanLevel != newPlanLevel {
+		return false
 	}
-
-	instance.SetCompositionReference(&corev1.ObjectReference{
-		Name: newPlan.Name,
-	})
-	for _, l := range []string{
-		PlanNameLabel,
-		SLALabel,
-	} {
-		instanceLabels[l] = newPlan.Labels[l]
+	if instanceSLA == SLAPremium && newPlanSLA == SLAStandard {
+		return true
 	}
-	instance.SetLabels(instanceLabels)
-
-	gvk, err := gvkFromPlan(newPlan)
-	if err != nil {
-		return err
+	if instanceSLA == SLAStandard && newPlanSLA == SLAPremium {
+		return true
 	}
-	instance.SetGroupVersionKind(gvk)
+	return false
+}
 
-	return cp.Client.Update(ctx, instance.GetUnstructured())
+// patchInstance applies the changes done by mutate to the instance with a merge patch.
+// Only the changed fields are sent, all other fields are left to their owners.
+// The patch contains the resource version of the instance and fails with a conflict if the instance has been changed since.
+func (cp *Crossplane) patchInstance(ctx context.Context, instance *composite.Unstructured, mutate func()) error {
+	patch := client.MergeFromWithOptions(instance.GetUnstructured().DeepCopy(), client.MergeFromWithOptimisticLock{})
+	mutate()
+	cp.logger.Debug("patch-instance", lager.Data{"instance": instance.GetName()})
+	return cp.Client.Patch(ctx, instance.GetUnstructured(), patch, client.FieldOwner(FieldManager))
 }
@@ -0,0 +1,155 @@
+package crossplane
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/crossplane/crossplane-runtime/pkg/fieldpath"
+	"github.com/crossplane/crossplane-runtime/pkg/resource/unstructured/composite"
+	"github.com/crossplane/crossplane/apis/apiextensions/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var redisGVK = schema.GroupVersionKind{Group: "syn.tools", Version: "v1alpha1", Kind: "CompositeRedisInstance"}
+
+func newPlan(name, sla string) *v1beta1.Composition {
+	return &v1beta1.Composition{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				ServiceIDLabel: "redis-k8s",
+				PlanNameLabel:  name,
+				SLALabel:       sla,
+			},
+		},
+		Spec: v1beta1.CompositionSpec{
+			CompositeTypeRef: v1beta1.TypeReference{
+				APIVersion: redisGVK.GroupVersion().String(),
+				Kind:       redisGVK.Kind,
+			},
+		},
+	}
+}
+
+// conflictingClient fails the first patches with a conflict after changing the instance like a controller would.
+type conflictingClient struct {
+	client.Client
+	conflicts int
+}
+
+func (c *conflictingClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if c.conflicts > 0 {
+		c.conflicts--
+		current := composite.New(composite.WithGroupVersionKind(redisGVK))
+		if err := c.Get(ctx, types.NamespacedName{Name: "instance"}, current); err != nil {
+			return err
+		}
+		current.SetAnnotations(map[string]string{"reconciled": "true"})
+		if err := c.Update(ctx, current); err != nil {
+			return err
+		}
+		return k8serrors.NewConflict(schema.GroupResource{}, "instance", nil)
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func TestUpdateInstanceSLA(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, scheme.AddToScheme(s))
+	require.NoError(t, SetupScheme(s))
+
+	instance := composite.New(composite.WithGroupVersionKind(redisGVK))
+	instance.SetName("instance")
+	instance.SetCompositionReference(&corev1.ObjectReference{Name: "small"})
+	instance.SetLabels(map[string]string{
+		InstanceIDLabel: "instance",
+		ServiceIDLabel:  "redis-k8s",
+		PlanNameLabel:   "small",
+		SLALabel:        SLAStandard,
+		"foreign":       "label",
+	})
+
+	c := &conflictingClient{
+		Client:    fake.NewFakeClientWithScheme(s, newPlan("small", SLAStandard), newPlan("small-premium", SLAPremium), instance),
+		conflicts: 2,
+	}
+	cp := &Crossplane{Client: c, logger: lager.NewLogger("test")}
+	cp.SetServiceIDs([]string{"redis-k8s"})
+
+	require.NoError(t, cp.UpdateInstanceSLA(context.Background(), "instance", "redis-k8s", "small-premium"))
+	assert.Equal(t, 0, c.conflicts)
+
+	updated := composite.New(composite.WithGroupVersionKind(redisGVK))
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "instance"}, updated))
+	assert.Equal(t, "small-premium", updated.GetCompositionReference().Name)
+	assert.Equal(t, "small-premium", updated.GetLabels()[PlanNameLabel])
+	assert.Equal(t, SLAPremium, updated.GetLabels()[SLALabel])
+	assert.Equal(t, "label", updated.GetLabels()["foreign"])
+	assert.Equal(t, "true", updated.GetAnnotations()["reconciled"], "changes of other writers must be kept")
+
+	assert.Equal(t, ErrSLAChangeNotPermitted, cp.UpdateInstanceSLA(context.Background(), "instance", "redis-k8s", "small-premium"))
+}
+
+func TestUpdateInstanceSLA_Cluster(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, scheme.AddToScheme(s))
+	require.NoError(t, SetupScheme(s))
+
+	newClusterPlan := func(name, sla, cluster string) *v1beta1.Composition {
+		plan := newPlan(name, sla)
+		if cluster != "" {
+			plan.Labels[ClusterLabel] = cluster
+		}
+		return plan
+	}
+	instance := composite.New(composite.WithGroupVersionKind(redisGVK))
+	instance.SetName("instance")
+	instance.SetResourceVersion("1")
+	instance.SetCompositionReference(&corev1.ObjectReference{Name: "small"})
+	instance.SetLabels(map[string]string{
+		InstanceIDLabel: "instance",
+		ServiceIDLabel:  "redis-k8s",
+		PlanNameLabel:   "small",
+		SLALabel:        SLAStandard,
+		ClusterLabel:    "service-1",
+	})
+	cp := &Crossplane{
+		Client: fake.NewFakeClientWithScheme(s,
+			newClusterPlan("small", SLAStandard, "service-1"),
+			newClusterPlan("small-premium", SLAPremium, "service-2"),
+			instance,
+		),
+		logger: lager.NewLogger("test"),
+	}
+	cp.SetServiceIDs([]string{"redis-k8s"})
+
+	err := cp.UpdateInstanceSLA(context.Background(), "instance", "redis-k8s", "small-premium")
+	assert.True(t, errors.Is(err, ErrClusterChangeNotPermitted), err)
+
+	// Plans without a cluster keep the instance on its cluster.
+	premium := &v1beta1.Composition{}
+	require.NoError(t, cp.Client.Get(context.Background(), types.NamespacedName{Name: "small-premium"}, premium))
+	delete(premium.Labels, ClusterLabel)
+	require.NoError(t, cp.Client.Update(context.Background(), premium))
+	require.NoError(t, cp.UpdateInstanceSLA(context.Background(), "instance", "redis-k8s", "small-premium"))
+
+	updated := composite.New(composite.WithGroupVersionKind(redisGVK))
+	require.NoError(t, cp.Client.Get(context.Background(), types.NamespacedName{Name: "instance"}, updated))
+	assert.Equal(t, "small-premium", updated.GetCompositionReference().Name)
+	assert.Equal(t, "service-1", updated.GetLabels()[ClusterLabel])
+	providerConfig, err := fieldpath.Pave(updated.Object).GetString(InstanceSpecProviderConfigPath)
+	require.NoError(t, err)
+	assert.Equal(t, "service-1", providerConfig)
+}
@@ -0,0 +1,131 @@
+package crossplane
+
+import (
+	"context"
+	"sort"
+
+	runtimev1alpha1 "github.com/crossplane/crossplane-runtime/apis/core/v1alpha1"
+	"github.com/crossplane/crossplane-runtime/pkg/resource/unstructured/composite"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// InstanceFilter selects instances in ListInstances. Empty fields match all instances.
+type InstanceFilter struct {
+	ServiceID string
+	PlanID    string
+	SLA       string
+	Cluster   string
+	ParentID  string
+	Tenant    string
+	// Ready matches instances by their ready condition if set.
+	Ready *bool
+
+	// Platform, OrganizationGUID, SpaceGUID, Namespace and InstanceName match the context of the instances.
+	Platform         string
+	OrganizationGUID string
+	SpaceGUID        string
+	Namespace        string
+	InstanceName     string
+}
+
+// labels returns the labels an instance must have to match the filter.
+func (f InstanceFilter) labels() client.MatchingLabels {
+	l := client.MatchingLabels{}
+	for k, v := range map[string]string{
+		ServiceIDLabel: f.ServiceID,
+		SLALabel:       f.SLA,
+		ClusterLabel:   f.Cluster,
+		ParentIDLabel:  f.ParentID,
+		TenantLabel:    f.Tenant,
+
+		PlatformLabel:          f.Platform,
+		OrganizationLabel:      f.OrganizationGUID,
+		SpaceLabel:             f.SpaceGUID,
+		PlatformNamespaceLabel: f.Namespace,
+	} {
+		if v != "" {
+			l[k] = v
+		}
+	}
+	return l
+}
+
+// matches checks the parts of the filter which can't be expressed as label selector.
+func (f InstanceFilter) matches(instance *composite.Unstructured) bool {
+	if f.PlanID != "" {
+		ref := instance.GetCompositionReference()
+		if ref == nil || ref.Name != f.PlanID {
+			return false
+		}
+	}
+	if f.InstanceName != "" && instance.GetAnnotations()[InstanceNameAnnotation] != f.InstanceName {
+		return false
+	}
+	if f.Ready != nil {
+		ready := instance.GetCondition(runtimev1alpha1.TypeReady).Status == corev1.ConditionTrue
+		if ready != *f.Ready {
+			return false
+		}
+	}
+	return true
+}
+
+// ListInstances returns all instances of the services offered by the broker matching the filter.
+// The instances are sorted by creation time and name.
+func (cp *Crossplane) ListInstances(ctx context.Context, filter InstanceFilter) ([]*composite.Unstructured, error) {
+	serviceIDs := cp.ServiceIDs()
+	if filter.ServiceID != "" {
+		if !contains(serviceIDs, filter.ServiceID) {
+			return []*composite.Unstructured{}, nil
+		}
+		serviceIDs = []string{filter.ServiceID}
+	}
+	plans, err := cp.getPlansForService(ctx, serviceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	gvks := map[schema.GroupVersionKind]bool{}
+	for _, plan := range plans {
+		gvk, err := gvkFromPlan(&plan)
+		if err != nil {
+			return nil, err
+		}
+		gvks[gvk] = true
+	}
+
+	instances := make([]*composite.Unstructured, 0)
+	for gvk := range gvks {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		if err := cp.Client.List(ctx, list, filter.labels()); err != nil {
+			return nil, err
+		}
+		for _, item := range list.Items {
+			instance := &composite.Unstructured{Unstructured: item}
+			if filter.matches(instance) {
+				instances = append(instances, instance)
+			}
+		}
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		ti, tj := instances[i].GetCreationTimestamp(), instances[j].GetCreationTimestamp()
+		if !ti.Equal(&tj) {
+			return ti.Before(&tj)
+		}
+		return instances[i].GetName() < instances[j].GetName()
+	})
+	return instances, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
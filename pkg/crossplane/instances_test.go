@@ -0,0 +1,115 @@
+package crossplane
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	runtimev1alpha1 "github.com/crossplane/crossplane-runtime/apis/core/v1alpha1"
+	"github.com/crossplane/crossplane-runtime/pkg/resource/unstructured/composite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestListInstances(t *testing.T) {
+	created := time.Date(2020, 11, 1, 0, 0, 0, 0, time.UTC)
+	newListedInstance := func(name, plan string, age time.Duration, ready bool, labels map[string]string) *composite.Unstructured {
+		instance := newInstance(name, labels)
+		instance.SetCompositionReference(&corev1.ObjectReference{Name: plan})
+		instance.SetCreationTimestamp(metav1.NewTime(created.Add(-age)))
+		if ready {
+			instance.SetConditions(runtimev1alpha1.Available())
+		}
+		return instance
+	}
+
+	named := newListedInstance("named", "small", 3*time.Hour, true, map[string]string{TenantLabel: "tenant-b", PlatformLabel: "kubernetes", PlatformNamespaceLabel: "app"})
+	named.SetAnnotations(map[string]string{InstanceNameAnnotation: "my cache"})
+
+	cp := newParentTestCrossplane(t,
+		named,
+		newListedInstance("newest", "small", 0, false, map[string]string{TenantLabel: "tenant-a", SLALabel: SLAStandard}),
+		newListedInstance("oldest", "small", 2*time.Hour, true, map[string]string{TenantLabel: "tenant-a", SLALabel: SLAStandard, ClusterLabel: "c1"}),
+		newListedInstance("premium", "small-premium", time.Hour, true, map[string]string{TenantLabel: "tenant-b", SLALabel: SLAPremium, ClusterLabel: "c1", PlatformLabel: "cloudfoundry", SpaceLabel: "space"}),
+		newListedInstance("child", "small", time.Hour, true, map[string]string{TenantLabel: "tenant-a", SLALabel: SLAStandard, ParentIDLabel: "oldest"}),
+	)
+
+	ready, notReady := true, false
+	tests := map[string]struct {
+		filter InstanceFilter
+		want   []string
+	}{
+		"all": {
+			want: []string{"named", "oldest", "child", "premium", "newest"},
+		},
+		"service": {
+			filter: InstanceFilter{ServiceID: "redis-k8s"},
+			want:   []string{"named", "oldest", "child", "premium", "newest"},
+		},
+		"unknown service": {
+			filter: InstanceFilter{ServiceID: "mongodb"},
+			want:   []string{},
+		},
+		"plan": {
+			filter: InstanceFilter{PlanID: "small-premium"},
+			want:   []string{"premium"},
+		},
+		"sla": {
+			filter: InstanceFilter{SLA: SLAStandard},
+			want:   []string{"oldest", "child", "newest"},
+		},
+		"cluster": {
+			filter: InstanceFilter{Cluster: "c1"},
+			want:   []string{"oldest", "premium"},
+		},
+		"parent": {
+			filter: InstanceFilter{ParentID: "oldest"},
+			want:   []string{"child"},
+		},
+		"tenant": {
+			filter: InstanceFilter{Tenant: "tenant-b"},
+			want:   []string{"named", "premium"},
+		},
+		"ready": {
+			filter: InstanceFilter{Ready: &ready},
+			want:   []string{"named", "oldest", "child", "premium"},
+		},
+		"platform": {
+			filter: InstanceFilter{Platform: "cloudfoundry"},
+			want:   []string{"premium"},
+		},
+		"space": {
+			filter: InstanceFilter{SpaceGUID: "space"},
+			want:   []string{"premium"},
+		},
+		"namespace": {
+			filter: InstanceFilter{Namespace: "app"},
+			want:   []string{"named"},
+		},
+		"instance name": {
+			filter: InstanceFilter{InstanceName: "my cache"},
+			want:   []string{"named"},
+		},
+		"not ready": {
+			filter: InstanceFilter{Ready: &notReady},
+			want:   []string{"newest"},
+		},
+		"combined": {
+			filter: InstanceFilter{Tenant: "tenant-a", Cluster: "c1", Ready: &ready},
+			want:   []string{"oldest"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			instances, err := cp.ListInstances(context.Background(), tt.filter)
+			require.NoError(t, err)
+			names := make([]string, 0, len(instances))
+			for _, instance := range instances {
+				names = append(names, instance.GetName())
+			}
+			assert.Equal(t, tt.want, names)
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package crossplane
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	helmv1alpha1 "github.com/crossplane-contrib/provider-helm/apis/release/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// redacted replaces secrets in log lines.
+	redacted = "[REDACTED]"
+	// minSecretLength is the minimum length of secret values to be redacted, shorter values would redact arbitrary words.
+	minSecretLength = 6
+	// maxLogLineLength is the maximum length of a log line, longer lines are truncated.
+	maxLogLineLength = 64 * 1024
+)
+
+// ErrLogsNotAvailable is returned for instances without pods of their own, e.g. MariaDB databases.
+var ErrLogsNotAvailable = errors.New("instance has no logs of its own")
+
+// secretAssignment matches assignments of secrets like `password=...` or Redis' `requirepass ...`, the value is redacted.
+var secretAssignment = regexp.MustCompile(`(?i)((?:password|passwd|pwd|secret|token)["']?\s*[:=]\s*["']?|(?:requirepass|masterauth)\s+["']?)[^\s"',;]+`)
+
+// LogOptions select the logs of an instance.
+type LogOptions struct {
+	// Since only returns lines newer than this. Zero returns all lines.
+	Since time.Duration
+	// Tail limits the number of lines of each container. Negative returns all lines.
+	Tail int64
+	// Follow streams new lines until the context is done.
+	Follow bool
+}
+
+// LogLine is a line logged by a container of an instance.
+type LogLine struct {
+	Pod       string    `json:"pod"`
+	Container string    `json:"container"`
+	Time      time.Time `json:"time"`
+	Message   string    `json:"message"`
+}
+
+// logSource is a container of an instance.
+type logSource struct {
+	providerConfig string
+	namespace      string
+	pod            string
+	container      string
+}
+
+// StreamLogs passes the lines logged by the containers of an instance to send, with secrets redacted.
+// Without following, the lines are sent container by container, otherwise they are interleaved as they arrive.
+// send is never called concurrently, an error returned by it stops streaming.
+func (cp *Crossplane) StreamLogs(ctx context.Context, instanceID string, opts LogOptions, send func(LogLine) error) error {
+	instance, err := cp.GetInstance(ctx, instanceID)
+	if err != nil {
+		return err
+	}
+	releases, err := cp.namespaceReleases(ctx, instance.GetResourceReferences())
+	if err != nil {
+		return err
+	}
+	if len(releases) == 0 {
+		return ErrLogsNotAvailable
+	}
+
+	sources := []logSource{}
+	r := &redactor{}
+	for _, release := range releases {
+		s, err := cp.logSources(ctx, release, r)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, s...)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	var sendErr error
+	lockedSend := func(l LogLine) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if sendErr != nil {
+			return sendErr
+		}
+		if sendErr = send(l); sendErr != nil {
+			cancel()
+		}
+		return sendErr
+	}
+
+	if !opts.Follow {
+		for _, s := range sources {
+			if err := cp.streamContainerLogs(ctx, s, opts, r, lockedSend); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var wg sync.WaitGroup
+	for _, s := range sources {
+		wg.Add(1)
+		go func(s logSource) {
+			defer wg.Done()
+			// Failing containers are logged, the others keep streaming.
+			_ = cp.streamContainerLogs(ctx, s, opts, r, lockedSend)
+		}(s)
+	}
+	wg.Wait()
+	return sendErr
+}
+
+// logSources returns the containers in the namespace of a release and adds the secrets of the namespace to the redactor.
+func (cp *Crossplane) logSources(ctx context.Context, release *helmv1alpha1.Release, r *redactor) ([]logSource, error) {
+	klient, err := cp.GetDownstreamClientForHelmRelease(ctx, release)
+	if err != nil {
+		return nil, err
+	}
+	namespace := release.Spec.ForProvider.Namespace
+	secrets := &corev1.SecretList{}
+	if err := klient.List(ctx, secrets, client.InNamespace(namespace)); err != nil {
+		cp.Downstream.Failed(providerConfigName(release), err)
+		return nil, fmt.Errorf("list secrets(%q): %w", namespace, err)
+	}
+	for _, s := range secrets.Items {
+		for _, v := range s.Data {
+			r.add(string(v))
+		}
+	}
+
+	pods := &corev1.PodList{}
+	if err := klient.List(ctx, pods, client.InNamespace(namespace)); err != nil {
+		cp.Downstream.Failed(providerConfigName(release), err)
+		return nil, fmt.Errorf("list pods(%q): %w", namespace, err)
+	}
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return pods.Items[i].Name < pods.Items[j].Name
+	})
+	sources := []logSource{}
+	for _, p := range pods.Items {
+		for _, c := range p.Spec.Containers {
+			sources = append(sources, logSource{
+				providerConfig: providerConfigName(release),
+				namespace:      namespace,
+				pod:            p.Name,
+				container:      c.Name,
+			})
+		}
+	}
+	return sources, nil
+}
+
+// streamContainerLogs sends the lines of a container. Containers without logs, e.g. because they haven't started yet, are skipped.
+func (cp *Crossplane) streamContainerLogs(ctx context.Context, s logSource, opts LogOptions, r *redactor, send func(LogLine) error) error {
+	logger := cp.logger.WithData(lager.Data{"namespace": s.namespace, "pod": s.pod, "container": s.container})
+	podOpts := &corev1.PodLogOptions{
+		Container:  s.container,
+		Follow:     opts.Follow,
+		Timestamps: true,
+	}
+	if opts.Since > 0 {
+		seconds := int64(opts.Since.Seconds())
+		if seconds < 1 {
+			seconds = 1
+		}
+		podOpts.SinceSeconds = &seconds
+	}
+	if opts.Tail >= 0 {
+		tail := opts.Tail
+		podOpts.TailLines = &tail
+	}
+	stream, err := cp.Downstream.PodLogs(ctx, s.providerConfig, s.namespace, s.pod, podOpts)
+	if err != nil {
+		logger.Info("skip-container-logs", lager.Data{"error": err.Error()})
+		return nil
+	}
+	defer stream.Close()
+
+	reader := bufio.NewReaderSize(stream, maxLogLineLength)
+	for {
+		b, isPrefix, err := reader.ReadLine()
+		// The buffer is reused by the next read.
+		line := string(b)
+		// Discard the rest of lines which are too long.
+		for err == nil && isPrefix {
+			_, isPrefix, err = reader.ReadLine()
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				return nil
+			}
+			logger.Error("read-container-logs", err)
+			return err
+		}
+
+		l := LogLine{Pod: s.pod, Container: s.container, Message: line}
+		// Lines are prefixed with their timestamp.
+		if i := strings.IndexByte(l.Message, ' '); i > 0 {
+			if t, err := time.Parse(time.RFC3339Nano, l.Message[:i]); err == nil {
+				l.Time, l.Message = t, l.Message[i+1:]
+			}
+		}
+		l.Message = r.redact(l.Message)
+		if err := send(l); err != nil {
+			return err
+		}
+	}
+}
+
+// redactor removes secrets from log lines. Secrets must be added before redacting concurrently.
+type redactor struct {
+	secrets []string
+}
+
+// add adds a secret value, e.g. a password of the instance, to be redacted.
+func (r *redactor) add(secret string) {
+	secret = strings.TrimSpace(secret)
+	if len(secret) < minSecretLength || strings.ContainsAny(secret, "\n") {
+		return
+	}
+	r.secrets = append(r.secrets, secret)
+	// Longer secrets first, so secrets containing others are redacted completely.
+	sort.Slice(r.secrets, func(i, j int) bool {
+		return len(r.secrets[i]) > len(r.secrets[j])
+	})
+}
+
+// redact replaces the known secret values and the values of secret assignments in a line.
+func (r *redactor) redact(line string) string {
+	for _, s := range r.secrets {
+		line = strings.ReplaceAll(line, s, redacted)
+	}
+	return secretAssignment.ReplaceAllString(line, "${1}"+redacted)
+}
@@ -0,0 +1,138 @@
+package crossplane
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	helmv1alpha1 "github.com/crossplane-contrib/provider-helm/apis/release/v1alpha1"
+	"github.com/crossplane-contrib/provider-helm/apis/v1alpha1"
+	runtimev1alpha1 "github.com/crossplane/crossplane-runtime/apis/core/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestStreamLogs(t *testing.T) {
+	instance := newInstance("instance", nil)
+	instance.SetResourceReferences([]corev1.ObjectReference{
+		{Kind: "Release", Name: "instance-redis"},
+		{Kind: "Release", Name: "instance-haproxy"},
+	})
+	newRelease := func(name string) *helmv1alpha1.Release {
+		return &helmv1alpha1.Release{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: helmv1alpha1.ReleaseSpec{
+				ResourceSpec: runtimev1alpha1.ResourceSpec{
+					ProviderConfigReference: &runtimev1alpha1.Reference{Name: "eu-1"},
+				},
+				ForProvider: helmv1alpha1.ReleaseParameters{Namespace: "instance"},
+			},
+		}
+	}
+	pc := &v1alpha1.ProviderConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "eu-1"},
+		Spec: v1alpha1.ProviderConfigSpec{
+			ProviderConfigSpec: runtimev1alpha1.ProviderConfigSpec{
+				Credentials: runtimev1alpha1.ProviderCredentials{
+					Source: runtimev1alpha1.CredentialsSourceSecret,
+					SecretRef: &runtimev1alpha1.SecretKeySelector{
+						SecretReference: runtimev1alpha1.SecretReference{Namespace: "crossplane", Name: "eu-1"},
+						Key:             runtimev1alpha1.ResourceCredentialsSecretKubeconfigKey,
+					},
+				},
+			},
+		},
+	}
+	kubeconfig := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "crossplane", Name: "eu-1"},
+		Data:       map[string][]byte{runtimev1alpha1.ResourceCredentialsSecretKubeconfigKey: []byte("eu-1")},
+	}
+	database := newInstance("database", map[string]string{ParentIDLabel: "instance"})
+
+	cp := newParentTestCrossplane(t, instance, database, newRelease("instance-redis"), newRelease("instance-haproxy"), pc, kubeconfig)
+
+	newPod := func(name string, containers ...string) *corev1.Pod {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "instance", Name: name}}
+		for _, c := range containers {
+			pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: c})
+		}
+		return pod
+	}
+	s := runtime.NewScheme()
+	require.NoError(t, scheme.AddToScheme(s))
+	downstream := fake.NewFakeClientWithScheme(s,
+		newPod("redis-node-1", "redis", "sentinel"),
+		newPod("redis-node-0", "redis"),
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "instance", Name: "redis"},
+			Data:       map[string][]byte{"redis-password": []byte("s3cr3t-pw")},
+		},
+	)
+	logs := map[string]string{
+		"redis-node-0/redis":    "2020-11-01T12:00:00.5Z Ready to accept connections\n2020-11-01T12:00:01Z AUTH s3cr3t-pw failed\n",
+		"redis-node-1/redis":    "2020-11-01T12:00:02Z config requirepass other-pw\n" + strings.Repeat("x", maxLogLineLength+10) + "\n",
+		"redis-node-1/sentinel": "no timestamp password=hunter22\n",
+	}
+	var mu sync.Mutex
+	var opts []*corev1.PodLogOptions
+	cp.Downstream = NewDownstreamClients(cp.Client, nil, lager.NewLogger("test"))
+	cp.Downstream.newClient = func(kubeconfig []byte) (k8sclient.Client, *rest.Config, error) {
+		return downstream, &rest.Config{Host: string(kubeconfig)}, nil
+	}
+	cp.Downstream.streamLogs = func(ctx context.Context, config *rest.Config, namespace, pod string, o *corev1.PodLogOptions) (io.ReadCloser, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		opts = append(opts, o)
+		l, ok := logs[pod+"/"+o.Container]
+		if !ok {
+			return nil, errors.New("container is waiting to start")
+		}
+		return ioutil.NopCloser(strings.NewReader(l)), nil
+	}
+
+	lines := []LogLine{}
+	err := cp.StreamLogs(context.Background(), "instance", LogOptions{Since: 10 * time.Minute, Tail: 100}, func(l LogLine) error {
+		lines = append(lines, l)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, opts, 3, "the releases share a namespace, its logs must only be streamed once")
+	assert.Equal(t, int64(600), *opts[0].SinceSeconds)
+	assert.Equal(t, int64(100), *opts[0].TailLines)
+	assert.True(t, opts[0].Timestamps)
+	assert.Equal(t, []LogLine{
+		{Pod: "redis-node-0", Container: "redis", Time: time.Date(2020, 11, 1, 12, 0, 0, 5e8, time.UTC), Message: "Ready to accept connections"},
+		{Pod: "redis-node-0", Container: "redis", Time: time.Date(2020, 11, 1, 12, 0, 1, 0, time.UTC), Message: "AUTH [REDACTED] failed"},
+		{Pod: "redis-node-1", Container: "redis", Time: time.Date(2020, 11, 1, 12, 0, 2, 0, time.UTC), Message: "config requirepass [REDACTED]"},
+		{Pod: "redis-node-1", Container: "redis", Message: strings.Repeat("x", maxLogLineLength)},
+		{Pod: "redis-node-1", Container: "sentinel", Message: "no timestamp password=[REDACTED]"},
+	}, lines)
+
+	stop := errors.New("client gone")
+	sent := 0
+	err = cp.StreamLogs(context.Background(), "instance", LogOptions{Tail: -1, Follow: true}, func(l LogLine) error {
+		sent++
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, sent, "streaming must stop once sending fails")
+	assert.Nil(t, opts[len(opts)-1].TailLines)
+
+	err = cp.StreamLogs(context.Background(), "database", LogOptions{}, func(LogLine) error { return nil })
+	assert.Equal(t, ErrLogsNotAvailable, err)
+	err = cp.StreamLogs(context.Background(), "unknown", LogOptions{}, func(LogLine) error { return nil })
+	assert.Equal(t, ErrInstanceNotFound, err)
+}
@@ -41,7 +41,7 @@ func (cp *Crossplane) createBinding(ctx context.Context, bindingID, instanceID,
 	secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      fmt.Sprintf(secretName, bindingID),
-			Namespace: spksNamespace,
+			Namespace: cp.Namespace,
 			Labels:    labels,
 		},
 		Data: map[string][]byte{
@@ -87,7 +87,7 @@ func (cp *Crossplane) deleteBinding(ctx context.Context, bindingID string) error
 	secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      fmt.Sprintf(secretName, bindingID),
-			Namespace: spksNamespace,
+			Namespace: cp.Namespace,
 		},
 	}
 	return cp.Client.Delete(ctx, secret)
@@ -24,6 +24,24 @@ const (
 	DeletionTimestampAnnotation = SynToolsBase + "/deletionTimestamp"
 	// TagsAnnotation of the instance
 	TagsAnnotation = SynToolsBase + "/tags"
+	// MaxDatabasesAnnotation of a MariaDB plan limits the number of databases of a cluster
+	MaxDatabasesAnnotation = SynToolsBase + "/max-databases"
+	// MemoryAnnotation of a plan is the memory an instance of the plan uses, counted against quotas
+	MemoryAnnotation = SynToolsBase + "/memory"
+	// StorageAnnotation of a plan is the storage an instance of the plan uses, counted against quotas
+	StorageAnnotation = SynToolsBase + "/storage"
+	// ChartVersionsAnnotation of a plan is a JSON object of the chart versions its instances are upgraded to, keyed by chart name
+	ChartVersionsAnnotation = SynToolsBase + "/chart-versions"
+	// MaintenanceWindowAnnotation of an instance is the window in which it is upgraded, see maintenance.Parse
+	MaintenanceWindowAnnotation = SynToolsBase + "/maintenance-window"
+	// LastUpgradeAnnotation of an instance is the JSON record of its last upgrade
+	LastUpgradeAnnotation = SynToolsBase + "/last-upgrade"
+	// InstanceNameAnnotation name of the instance on the platform
+	InstanceNameAnnotation = SynToolsBase + "/instance-name"
+	// OrganizationNameAnnotation name of the platform organization of the instance
+	OrganizationNameAnnotation = SynToolsBase + "/organization-name"
+	// SpaceNameAnnotation name of the platform space of the instance
+	SpaceNameAnnotation = SynToolsBase + "/space-name"
 )
 
 const (
@@ -35,6 +53,10 @@ const (
 	PlanNameLabel = SynToolsBase + "/plan"
 	// InstanceIDLabel of the instance
 	InstanceIDLabel = SynToolsBase + "/instance"
+	// BindingIDLabel of a binding record
+	BindingIDLabel = SynToolsBase + "/binding"
+	// ActionIDLabel of an action record
+	ActionIDLabel = SynToolsBase + "/action"
 	// ParentIDLabel of the instance
 	ParentIDLabel = SynToolsBase + "/parent"
 	// BindableLabel of the instance
@@ -47,6 +69,16 @@ const (
 	ClusterLabel = SynToolsBase + "/cluster"
 	// SLALabel SLA level for this instance
 	SLALabel = SynToolsBase + "/sla"
+	// TenantLabel name of the tenant owning this instance
+	TenantLabel = SynToolsBase + "/tenant"
+	// OrganizationLabel GUID of the platform organization the instance has been provisioned in
+	OrganizationLabel = SynToolsBase + "/organization"
+	// SpaceLabel GUID of the platform space the instance has been provisioned in
+	SpaceLabel = SynToolsBase + "/space"
+	// PlatformLabel name of the platform the instance has been provisioned from, e.g. cloudfoundry or kubernetes
+	PlatformLabel = SynToolsBase + "/platform"
+	// PlatformNamespaceLabel Kubernetes namespace the instance has been provisioned from
+	PlatformNamespaceLabel = SynToolsBase + "/platform-namespace"
 )
 
 const (
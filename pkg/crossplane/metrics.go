@@ -0,0 +1,341 @@
+package crossplane
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	helmv1alpha1 "github.com/crossplane-contrib/provider-helm/apis/release/v1alpha1"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// metricsPortName is the name of the container port of the Redis and MySQL exporters.
+	metricsPortName = "metrics"
+	// metricsPath is the path exporters serve their metrics on.
+	metricsPath = "/metrics"
+	// metricsScrapeTimeout limits the duration of reading the metrics of a single node.
+	metricsScrapeTimeout = 10 * time.Second
+	// maxMetricsSize is the maximum size of the metrics of a single node.
+	maxMetricsSize = 8 << 20
+	// nodeLabel is added to the metrics of all nodes in the Prometheus text format.
+	nodeLabel = "node"
+	// exportedNodeLabel is the name of a node label of an exporter, it is renamed like Prometheus does on conflicts.
+	exportedNodeLabel = "exported_node"
+)
+
+// ErrMetricsNotAvailable is returned for instances without metrics exporters, e.g. MariaDB databases or instances of plans without metrics.
+var ErrMetricsNotAvailable = errors.New("metrics not available")
+
+// errExporterUnreachable is returned if the metrics of a node can't be read.
+// Its cause might contain addresses of the downstream cluster and is only logged.
+var errExporterUnreachable = errors.New("exporter unreachable")
+
+// aggregation combines the values of the series of a metric.
+type aggregation int
+
+const (
+	aggregateSum aggregation = iota
+	aggregateMax
+)
+
+// normalizedMetrics maps the metrics of the Redis and MySQL exporters to the names returned by the broker.
+// Metrics with several series, e.g. the keys of each Redis database, are aggregated.
+var normalizedMetrics = []struct {
+	name        string
+	metric      string
+	aggregation aggregation
+}{
+	// redis_exporter
+	{"up", "redis_up", aggregateMax},
+	{"memory_used_bytes", "redis_memory_used_bytes", aggregateSum},
+	{"memory_max_bytes", "redis_memory_max_bytes", aggregateSum},
+	{"connections", "redis_connected_clients", aggregateSum},
+	{"ops_per_second", "redis_instantaneous_ops_per_sec", aggregateSum},
+	{"keys", "redis_db_keys", aggregateSum},
+	{"connected_replicas", "redis_connected_slaves", aggregateSum},
+	{"replication_lag_seconds", "redis_connected_slave_lag_seconds", aggregateMax},
+	// mysqld_exporter
+	{"up", "mysql_up", aggregateMax},
+	{"connections", "mysql_global_status_threads_connected", aggregateSum},
+	{"max_connections", "mysql_global_variables_max_connections", aggregateSum},
+	{"queries_total", "mysql_global_status_queries", aggregateSum},
+	{"replication_lag_seconds", "mysql_slave_status_seconds_behind_master", aggregateMax},
+	{"galera_cluster_size", "mysql_global_status_wsrep_cluster_size", aggregateMax},
+	{"galera_ready", "mysql_global_status_wsrep_ready", aggregateMax},
+	{"galera_local_state", "mysql_global_status_wsrep_local_state", aggregateMax},
+}
+
+// InstanceMetrics are the operational metrics of the nodes of an instance.
+type InstanceMetrics struct {
+	CollectedAt time.Time     `json:"collected_at"`
+	Nodes       []NodeMetrics `json:"nodes"`
+}
+
+// NodeMetrics are the normalized metrics of a node, read from the exporter running next to it.
+type NodeMetrics struct {
+	Node string `json:"node"`
+	// Role of Redis nodes, either `master` or `replica`.
+	Role    string             `json:"role,omitempty"`
+	Metrics map[string]float64 `json:"metrics"`
+	// Error describes why the metrics of the node couldn't be read.
+	Error string `json:"error,omitempty"`
+
+	families map[string]*dto.MetricFamily
+}
+
+// metricsSource is an exporter of an instance.
+type metricsSource struct {
+	providerConfig string
+	namespace      string
+	pod            string
+	port           string
+}
+
+// GetMetrics reads the metrics of the exporters in the namespaces of an instance through the API server of their cluster.
+// Nodes whose metrics can't be read are returned with an error, the metrics of the other nodes are still returned.
+func (cp *Crossplane) GetMetrics(ctx context.Context, instanceID string) (*InstanceMetrics, error) {
+	instance, err := cp.GetInstance(ctx, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	releases, err := cp.namespaceReleases(ctx, instance.GetResourceReferences())
+	if err != nil {
+		return nil, err
+	}
+	if len(releases) == 0 {
+		return nil, fmt.Errorf("%w: instance has no nodes of its own", ErrMetricsNotAvailable)
+	}
+	sources := []metricsSource{}
+	for _, release := range releases {
+		s, err := cp.metricsSources(ctx, release)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, s...)
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("%w: instance has no metrics exporter", ErrMetricsNotAvailable)
+	}
+
+	m := &InstanceMetrics{
+		CollectedAt: time.Now().UTC(),
+		Nodes:       make([]NodeMetrics, len(sources)),
+	}
+	var wg sync.WaitGroup
+	for i, s := range sources {
+		wg.Add(1)
+		go func(i int, s metricsSource) {
+			defer wg.Done()
+			m.Nodes[i] = cp.scrapeMetrics(ctx, s)
+		}(i, s)
+	}
+	wg.Wait()
+	return m, nil
+}
+
+// metricsSources returns the running pods in the namespace of a release which have an exporter.
+func (cp *Crossplane) metricsSources(ctx context.Context, release *helmv1alpha1.Release) ([]metricsSource, error) {
+	klient, err := cp.GetDownstreamClientForHelmRelease(ctx, release)
+	if err != nil {
+		return nil, err
+	}
+	namespace := release.Spec.ForProvider.Namespace
+	pods := &corev1.PodList{}
+	if err := klient.List(ctx, pods, client.InNamespace(namespace)); err != nil {
+		cp.Downstream.Failed(providerConfigName(release), err)
+		return nil, fmt.Errorf("list pods(%q): %w", namespace, err)
+	}
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return pods.Items[i].Name < pods.Items[j].Name
+	})
+	sources := []metricsSource{}
+	for _, p := range pods.Items {
+		if p.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		if port := metricsPort(&p); port != 0 {
+			sources = append(sources, metricsSource{
+				providerConfig: providerConfigName(release),
+				namespace:      namespace,
+				pod:            p.Name,
+				port:           strconv.Itoa(int(port)),
+			})
+		}
+	}
+	return sources, nil
+}
+
+// metricsPort returns the port of the exporter of a pod, zero if it has none.
+func metricsPort(pod *corev1.Pod) int32 {
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			if p.Name == metricsPortName {
+				return p.ContainerPort
+			}
+		}
+	}
+	return 0
+}
+
+// scrapeMetrics reads and normalizes the metrics of a node.
+func (cp *Crossplane) scrapeMetrics(ctx context.Context, s metricsSource) NodeMetrics {
+	n := NodeMetrics{Node: s.pod, Metrics: map[string]float64{}}
+	families, err := cp.readMetrics(ctx, s)
+	if err != nil {
+		cp.logger.Info("scrape-metrics-failed", lager.Data{"namespace": s.namespace, "pod": s.pod, "error": err.Error()})
+		n.Error = err.Error()
+		if errors.Is(err, errExporterUnreachable) {
+			n.Error = errExporterUnreachable.Error()
+		}
+		return n
+	}
+	n.families = families
+
+	for _, nm := range normalizedMetrics {
+		if v, ok := aggregate(families[nm.metric], nm.aggregation); ok {
+			n.Metrics[nm.name] = v
+		}
+	}
+	if info, ok := families["redis_instance_info"]; ok {
+		for _, m := range info.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() != "role" {
+					continue
+				}
+				n.Role = l.GetValue()
+				if n.Role == "slave" {
+					n.Role = "replica"
+				}
+			}
+		}
+	}
+	return n
+}
+
+func (cp *Crossplane) readMetrics(ctx context.Context, s metricsSource) (map[string]*dto.MetricFamily, error) {
+	ctx, cancel := context.WithTimeout(ctx, metricsScrapeTimeout)
+	defer cancel()
+	stream, err := cp.Downstream.PodProxy(ctx, s.providerConfig, s.namespace, s.pod, s.port, metricsPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errExporterUnreachable, err)
+	}
+	defer stream.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(stream, maxMetricsSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errExporterUnreachable, err)
+	}
+	if len(b) > maxMetricsSize {
+		return nil, fmt.Errorf("metrics exceed %d bytes", maxMetricsSize)
+	}
+	parser := expfmt.TextParser{}
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("invalid metrics: %w", err)
+	}
+	return families, nil
+}
+
+// aggregate combines the values of the series of a metric. It returns false if the metric has no series.
+func aggregate(family *dto.MetricFamily, a aggregation) (float64, bool) {
+	if family == nil || len(family.GetMetric()) == 0 {
+		return 0, false
+	}
+	result := 0.0
+	if a == aggregateMax {
+		result = math.Inf(-1)
+	}
+	for _, m := range family.GetMetric() {
+		v := metricValue(m)
+		switch a {
+		case aggregateSum:
+			result += v
+		case aggregateMax:
+			result = math.Max(result, v)
+		}
+	}
+	return result, true
+}
+
+func metricValue(m *dto.Metric) float64 {
+	switch {
+	case m.Gauge != nil:
+		return m.GetGauge().GetValue()
+	case m.Counter != nil:
+		return m.GetCounter().GetValue()
+	default:
+		return m.GetUntyped().GetValue()
+	}
+}
+
+// WriteText writes the metrics of all nodes in the Prometheus text format, the series are labeled with their node.
+// Nodes whose metrics couldn't be read are listed in comments.
+func (m *InstanceMetrics) WriteText(w io.Writer) error {
+	merged := map[string]*dto.MetricFamily{}
+	for _, n := range m.Nodes {
+		if n.Error != "" {
+			if _, err := fmt.Fprintf(w, "# node %s: %s\n", n.Node, n.Error); err != nil {
+				return err
+			}
+			continue
+		}
+		for name, family := range n.families {
+			target, ok := merged[name]
+			if !ok {
+				target = &dto.MetricFamily{Name: family.Name, Help: family.Help, Type: family.Type}
+				merged[name] = target
+			}
+			for _, metric := range family.GetMetric() {
+				target.Metric = append(target.Metric, &dto.Metric{
+					Label:       nodeLabels(n.Node, metric.GetLabel()),
+					Gauge:       metric.Gauge,
+					Counter:     metric.Counter,
+					Summary:     metric.Summary,
+					Untyped:     metric.Untyped,
+					Histogram:   metric.Histogram,
+					TimestampMs: metric.TimestampMs,
+				})
+			}
+		}
+	}
+
+	names := make([]string, 0, len(merged))
+	for name := range merged {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	enc := expfmt.NewEncoder(w, expfmt.FmtText)
+	for _, name := range names {
+		if err := enc.Encode(merged[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nodeLabels prepends the node label to the labels of a series, a node label of the exporter is renamed to exported_node.
+func nodeLabels(node string, labels []*dto.LabelPair) []*dto.LabelPair {
+	labelName, labelValue := nodeLabel, node
+	result := make([]*dto.LabelPair, 0, len(labels)+1)
+	result = append(result, &dto.LabelPair{Name: &labelName, Value: &labelValue})
+	for _, l := range labels {
+		if l.GetName() == nodeLabel {
+			name, value := exportedNodeLabel, l.GetValue()
+			l = &dto.LabelPair{Name: &name, Value: &value}
+		}
+		result = append(result, l)
+	}
+	return result
+}
@@ -0,0 +1,112 @@
+package crossplane
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+const redisMasterMetrics = `# HELP redis_up Information about the Redis instance
+# TYPE redis_up gauge
+redis_up 1
+# TYPE redis_instance_info gauge
+redis_instance_info{redis_mode="standalone",redis_version="6.0.9",role="master"} 1
+# TYPE redis_memory_used_bytes gauge
+redis_memory_used_bytes 1.048576e+06
+# TYPE redis_connected_clients gauge
+redis_connected_clients 7
+# TYPE redis_instantaneous_ops_per_sec gauge
+redis_instantaneous_ops_per_sec 42
+# TYPE redis_db_keys gauge
+redis_db_keys{db="db0"} 10
+redis_db_keys{db="db1"} 5
+# TYPE redis_connected_slaves gauge
+redis_connected_slaves 2
+# TYPE redis_connected_slave_lag_seconds gauge
+redis_connected_slave_lag_seconds{slave_ip="10.0.0.2",slave_port="6379",slave_state="online"} 1
+redis_connected_slave_lag_seconds{slave_ip="10.0.0.3",slave_port="6379",slave_state="online"} 3
+# TYPE redis_key_size gauge
+redis_key_size{db="db0",key="queue",node="10.0.0.1:6379"} 3
+`
+
+func TestGetMetrics(t *testing.T) {
+	ctx := context.Background()
+	newPod := func(name string, port int32, phase corev1.PodPhase) *corev1.Pod {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "instance", Name: name}}
+		pod.Status.Phase = phase
+		pod.Spec.Containers = []corev1.Container{{Name: "redis"}}
+		if port != 0 {
+			pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+				Name:  "metrics",
+				Ports: []corev1.ContainerPort{{Name: "metrics", ContainerPort: port}},
+			})
+		}
+		return pod
+	}
+	cp, _ := newDownstreamTestCrossplane(t,
+		newPod("redis-node-0", 9121, corev1.PodRunning),
+		newPod("redis-node-1", 9121, corev1.PodRunning),
+		newPod("redis-node-2", 9121, corev1.PodPending),
+		newPod("haproxy", 0, corev1.PodRunning),
+	)
+	var mu sync.Mutex
+	var proxied []string
+	cp.Downstream.proxyPod = func(ctx context.Context, config *rest.Config, namespace, pod, port, path string) (io.ReadCloser, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		proxied = append(proxied, namespace+"/"+pod+":"+port+path)
+		if pod == "redis-node-1" {
+			return nil, errors.New("dial tcp 10.0.0.1:6443: connection refused")
+		}
+		return ioutil.NopCloser(strings.NewReader(redisMasterMetrics)), nil
+	}
+
+	m, err := cp.GetMetrics(ctx, "instance")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"instance/redis-node-0:9121/metrics", "instance/redis-node-1:9121/metrics"}, proxied)
+	require.Len(t, m.Nodes, 2)
+	assert.Equal(t, "redis-node-0", m.Nodes[0].Node)
+	assert.Equal(t, "master", m.Nodes[0].Role)
+	assert.Equal(t, map[string]float64{
+		"up":                      1,
+		"memory_used_bytes":       1048576,
+		"connections":             7,
+		"ops_per_second":          42,
+		"keys":                    15,
+		"connected_replicas":      2,
+		"replication_lag_seconds": 3,
+	}, m.Nodes[0].Metrics)
+	assert.Equal(t, "redis-node-1", m.Nodes[1].Node)
+	assert.Equal(t, "exporter unreachable", m.Nodes[1].Error, "addresses of the downstream cluster must not be returned")
+	assert.Empty(t, m.Nodes[1].Metrics)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, m.WriteText(buf))
+	text := buf.String()
+	assert.Contains(t, text, "# node redis-node-1: exporter unreachable\n")
+	assert.Contains(t, text, "# TYPE redis_db_keys gauge\nredis_db_keys{node=\"redis-node-0\",db=\"db0\"} 10\n")
+	assert.Contains(t, text, "redis_up{node=\"redis-node-0\"} 1\n")
+	assert.Contains(t, text, "redis_key_size{node=\"redis-node-0\",db=\"db0\",key=\"queue\",exported_node=\"10.0.0.1:6379\"} 3\n")
+
+	_, err = cp.GetMetrics(ctx, "database")
+	assert.True(t, errors.Is(err, ErrMetricsNotAvailable), err)
+	_, err = cp.GetMetrics(ctx, "unknown")
+	assert.Equal(t, ErrInstanceNotFound, err)
+}
+
+func TestGetMetrics_NoExporter(t *testing.T) {
+	cp, _ := newDownstreamTestCrossplane(t, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "instance", Name: "redis-node-0"}})
+	_, err := cp.GetMetrics(context.Background(), "instance")
+	assert.EqualError(t, err, "metrics not available: instance has no metrics exporter")
+}
@@ -0,0 +1,623 @@
+package crossplane
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	helmv1alpha1 "github.com/crossplane-contrib/provider-helm/apis/release/v1alpha1"
+	runtimev1alpha1 "github.com/crossplane/crossplane-runtime/apis/core/v1alpha1"
+	"github.com/crossplane/crossplane-runtime/pkg/fieldpath"
+	"github.com/crossplane/crossplane-runtime/pkg/meta"
+	"github.com/crossplane/crossplane-runtime/pkg/resource/unstructured/composite"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// MigrationState is the step a migration is in.
+type MigrationState string
+
+const (
+	// MigrationProvisioning waits for the releases of the instance to be deployed to the target cluster.
+	MigrationProvisioning MigrationState = "provisioning"
+	// MigrationCopyingData copies the data of the instance from the source to the target cluster.
+	MigrationCopyingData MigrationState = "copying_data"
+	// MigrationUpdatingBindings records the credentials of the bindings to the endpoints on the target cluster.
+	MigrationUpdatingBindings MigrationState = "updating_bindings"
+	// MigrationCleaningUp uninstalls the releases of the instance from the source cluster.
+	MigrationCleaningUp MigrationState = "cleaning_up"
+	// MigrationSucceeded is the final state of a successful migration.
+	MigrationSucceeded MigrationState = "succeeded"
+	// MigrationFailed is the final state of a migration which can't be completed.
+	MigrationFailed MigrationState = "failed"
+)
+
+const (
+	migrationRecordSourceKey       = "source_cluster"
+	migrationRecordTargetKey       = "target_cluster"
+	migrationRecordStateKey        = "state"
+	migrationRecordMessageKey      = "message"
+	migrationRecordSkipDataCopyKey = "skip_data_copy"
+	migrationRecordCopyPositionKey = "copy_position"
+	migrationRecordCopiedKeysKey   = "copied_keys"
+	migrationRecordStartedAtKey    = "started_at"
+	migrationRecordUpdatedAtKey    = "updated_at"
+)
+
+const (
+	// MigrationSourceLabel marks the releases keeping the instance on the source cluster while it's being migrated.
+	MigrationSourceLabel = SynToolsBase + "/migration-source"
+	// migrationSourceSuffix is appended to the names of the releases on the source cluster.
+	migrationSourceSuffix = "-source"
+	// migrationCopyBudget limits the time spent copying data per call of AdvanceMigration.
+	migrationCopyBudget = 20 * time.Second
+)
+
+var (
+	// ErrMigrationNotPermitted is returned if an instance can't be migrated to the requested cluster.
+	ErrMigrationNotPermitted = errors.New("migration not permitted")
+	// ErrMigrationInProgress is returned if an instance is already being migrated.
+	ErrMigrationInProgress = errors.New("migration in progress")
+	// ErrMigrationNotFound is returned if an instance has never been migrated.
+	ErrMigrationNotFound = errors.New("migration not found")
+)
+
+// Migration is the operation moving an instance from one downstream cluster to another.
+type Migration struct {
+	InstanceID    string
+	SourceCluster string
+	TargetCluster string
+	State         MigrationState
+	// Message describes the current step or why the migration failed.
+	Message      string
+	SkipDataCopy bool
+	// CopiedKeys is the number of keys copied to the target cluster.
+	CopiedKeys int
+	StartedAt  time.Time
+	UpdatedAt  time.Time
+
+	// copyPosition is where copying the data continues.
+	copyPosition string
+	// resourceVersion of the record, empty if the migration hasn't been recorded yet.
+	resourceVersion string
+}
+
+// Done returns true if the migration succeeded or failed.
+func (m *Migration) Done() bool {
+	return m.State == MigrationSucceeded || m.State == MigrationFailed
+}
+
+// StartMigration starts moving an instance to another cluster of the placement pool.
+//
+// The composite is kept, as its name is the ID of the instance the platform knows, and pointed to the ProviderConfig
+// of the target cluster. Crossplane then deploys the releases of the instance to the target cluster. Copies of the
+// releases keep the instance running on the source cluster until its data has been copied. The migration is driven
+// by AdvanceMigration. Only instances placed from the pool can be migrated, the cluster of all other instances is
+// fixed by their plan.
+//
+// Starting migrations of the same instance must be serialized by the caller.
+func (cp *Crossplane) StartMigration(ctx context.Context, instanceID, targetCluster string, skipDataCopy bool) (*Migration, error) {
+	resourceVersion := ""
+	if m, err := cp.GetMigration(ctx, instanceID); err == nil && !m.Done() {
+		return nil, fmt.Errorf("%w: instance %q is being migrated to %q", ErrMigrationInProgress, instanceID, m.TargetCluster)
+	} else if err == nil {
+		resourceVersion = m.resourceVersion
+	} else if !errors.Is(err, ErrMigrationNotFound) {
+		return nil, err
+	}
+
+	instance, err := cp.GetInstance(ctx, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	sourceCluster := instance.GetLabels()[ClusterLabel]
+	if err := cp.validateMigration(ctx, instance, targetCluster); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	m := &Migration{
+		InstanceID:      instanceID,
+		SourceCluster:   sourceCluster,
+		TargetCluster:   targetCluster,
+		State:           MigrationProvisioning,
+		Message:         fmt.Sprintf("deploying instance to cluster %q", targetCluster),
+		SkipDataCopy:    skipDataCopy,
+		StartedAt:       now,
+		UpdatedAt:       now,
+		resourceVersion: resourceVersion,
+	}
+	if err := cp.saveMigration(ctx, m); err != nil {
+		if k8serrors.IsConflict(err) {
+			return nil, fmt.Errorf("%w: instance %q is being migrated", ErrMigrationInProgress, instanceID)
+		}
+		return nil, err
+	}
+
+	cp.logger.Info("start-migration", lager.Data{"instance": instanceID, "source": sourceCluster, "target": targetCluster})
+	if err := cp.createSourceReleases(ctx, instance); err != nil {
+		return nil, cp.abortMigration(ctx, m, err)
+	}
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		instance, err := cp.GetInstance(ctx, instanceID)
+		if err != nil {
+			return err
+		}
+		return cp.patchInstance(ctx, instance, func() {
+			labels := instance.GetLabels()
+			labels[ClusterLabel] = targetCluster
+			instance.SetLabels(labels)
+			_ = fieldpath.Pave(instance.Object).SetValue(InstanceSpecProviderConfigPath, targetCluster)
+		})
+	})
+	if err != nil {
+		return nil, cp.abortMigration(ctx, m, err)
+	}
+	return m, nil
+}
+
+// abortMigration removes the releases on the source cluster, keeping them installed, and records that the migration
+// failed because of the error. The error is returned, unless recording the failure fails.
+func (cp *Crossplane) abortMigration(ctx context.Context, m *Migration, err error) error {
+	if delErr := cp.deleteSourceReleases(ctx, m.InstanceID, runtimev1alpha1.DeletionOrphan); delErr != nil {
+		cp.logger.Error("delete-source-releases-failed", delErr, lager.Data{"instance": m.InstanceID})
+	}
+	if saveErr := cp.failMigration(ctx, m, err); saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+// validateMigration checks whether the instance can be moved to the target cluster.
+func (cp *Crossplane) validateMigration(ctx context.Context, instance *composite.Unstructured, targetCluster string) error {
+	ref := instance.GetCompositionReference()
+	if ref == nil {
+		return fmt.Errorf("%w: instance has no plan", ErrMigrationNotPermitted)
+	}
+	plan, err := cp.GetPlan(ctx, ref.Name)
+	if err != nil {
+		return err
+	}
+	if cluster := plan.Labels[ClusterLabel]; cluster != "" {
+		return fmt.Errorf("%w: plan %q deploys all instances to cluster %q", ErrMigrationNotPermitted, plan.Name, cluster)
+	}
+	if instance.GetLabels()[ClusterLabel] == targetCluster {
+		return fmt.Errorf("%w: instance is already on cluster %q", ErrMigrationNotPermitted, targetCluster)
+	}
+	inPool := false
+	for _, c := range cp.Placement.Clusters {
+		inPool = inPool || c.Name == targetCluster
+	}
+	if !inPool {
+		return fmt.Errorf("%w: cluster %q is not in the placement pool", ErrMigrationNotPermitted, targetCluster)
+	}
+	if instance.GetCondition(runtimev1alpha1.TypeReady).Status != corev1.ConditionTrue {
+		return fmt.Errorf("%w: instance is not ready", ErrMigrationNotPermitted)
+	}
+	if parentID := instance.GetLabels()[ParentIDLabel]; parentID != "" && parentID != instance.GetName() {
+		return fmt.Errorf("%w: instance shares the cluster of its parent %q", ErrMigrationNotPermitted, parentID)
+	}
+	children, err := cp.ChildInstances(ctx, instance.GetName())
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if child != instance.GetName() {
+			return fmt.Errorf("%w: instance is the parent of other instances", ErrMigrationNotPermitted)
+		}
+	}
+	sources, err := cp.sourceReleases(ctx, instance.GetName())
+	if err != nil {
+		return err
+	}
+	if len(sources) > 0 {
+		return fmt.Errorf("%w: releases of an earlier migration are still on the source cluster", ErrMigrationNotPermitted)
+	}
+	return nil
+}
+
+// AdvanceMigration runs the next steps of the migration of an instance, as far as possible without waiting, and returns its state.
+// Steps failing with transient errors are retried on the next call.
+func (cp *Crossplane) AdvanceMigration(ctx context.Context, instanceID string) (*Migration, error) {
+	m, err := cp.GetMigration(ctx, instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	for !m.Done() {
+		instance, err := cp.GetInstance(ctx, instanceID)
+		if errors.Is(err, ErrInstanceNotFound) {
+			// The instance has been deprovisioned on the target cluster, it must not stay on the source cluster.
+			if err := cp.cleanUpSource(ctx, m); err != nil {
+				return m, err
+			}
+			return m, cp.failMigration(ctx, m, errors.New("instance has been deleted"))
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		wait := false
+		switch m.State {
+		case MigrationProvisioning:
+			deployed, err := cp.releasesDeployedTo(ctx, instance, m.TargetCluster)
+			if err != nil || !deployed {
+				return m, err
+			}
+			m.State, m.Message = MigrationCopyingData, fmt.Sprintf("copying data from cluster %q", m.SourceCluster)
+		case MigrationCopyingData:
+			done, err := cp.copyData(ctx, instance, m)
+			if err != nil {
+				return m, err
+			}
+			if done {
+				m.State, m.Message = MigrationUpdatingBindings, "updating bindings to the endpoints on the target cluster"
+			} else {
+				m.Message, wait = fmt.Sprintf("copying data from cluster %q, %d keys copied", m.SourceCluster, m.CopiedKeys), true
+			}
+		case MigrationUpdatingBindings:
+			if err := cp.updateBindingRecords(ctx, instance); err != nil {
+				return m, err
+			}
+			m.State, m.Message = MigrationCleaningUp, fmt.Sprintf("removing instance from cluster %q", m.SourceCluster)
+		case MigrationCleaningUp:
+			if err := cp.cleanUpSource(ctx, m); err != nil {
+				return m, err
+			}
+			m.State, m.Message = MigrationSucceeded, fmt.Sprintf("instance has been migrated to cluster %q", m.TargetCluster)
+		default:
+			return m, cp.failMigration(ctx, m, fmt.Errorf("unknown state %q", m.State))
+		}
+		m.UpdatedAt = time.Now().UTC()
+		cp.logger.Info("advance-migration", lager.Data{"instance": instanceID, "state": m.State})
+		if err := cp.saveMigration(ctx, m); err != nil {
+			if k8serrors.IsConflict(err) {
+				// Another call advanced the migration in the meantime.
+				return cp.GetMigration(ctx, instanceID)
+			}
+			return nil, err
+		}
+		if wait {
+			return m, nil
+		}
+	}
+	return m, nil
+}
+
+// releasesDeployedTo checks whether all releases of the instance are ready on the target cluster.
+func (cp *Crossplane) releasesDeployedTo(ctx context.Context, instance *composite.Unstructured, targetCluster string) (bool, error) {
+	refs := findResourceRefs(instance.GetResourceReferences(), "Release")
+	if len(refs) == 0 {
+		return false, nil
+	}
+	for _, ref := range refs {
+		release, err := cp.getRelease(ctx, ref.Name)
+		if err != nil {
+			return false, err
+		}
+		if providerConfigName(release) != targetCluster || release.GetCondition(runtimev1alpha1.TypeReady).Status != corev1.ConditionTrue {
+			return false, nil
+		}
+	}
+	return instance.GetCondition(runtimev1alpha1.TypeReady).Status == corev1.ConditionTrue, nil
+}
+
+// updateBindingRecords records the credentials of all bindings of the instance again.
+// The credential versions of the bindings change, as the endpoints on the target cluster differ.
+// Applications get the new credentials by fetching their bindings again or by being rebound.
+func (cp *Crossplane) updateBindingRecords(ctx context.Context, instance *composite.Unstructured) error {
+	bindings, err := cp.ListBindings(ctx, instance.GetName())
+	if err != nil || len(bindings) == 0 {
+		return err
+	}
+	sb, err := ServiceBinderFactory(cp, instance, cp.logger)
+	if err != nil {
+		return err
+	}
+	for _, b := range bindings {
+		creds, err := sb.GetBinding(ctx, b.ID)
+		if err != nil {
+			return err
+		}
+		if err := cp.RecordBinding(ctx, b.InstanceID, b.ID, b.AppGUID, creds); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyData copies a batch of the data of the instance from the source cluster and returns true once all data has been copied.
+// Only Redis instances hold data to copy, MariaDB clusters store their data in databases, which are child instances
+// and prevent the cluster from being migrated.
+func (cp *Crossplane) copyData(ctx context.Context, instance *composite.Unstructured, m *Migration) (bool, error) {
+	if m.SkipDataCopy || instance.GetLabels()[ServiceNameLabel] != serviceRedis {
+		return true, nil
+	}
+	from, err := parseRedisCopyPosition(m.copyPosition)
+	if err != nil {
+		return false, err
+	}
+
+	secrets := findResourceRefs(instance.GetResourceReferences(), "Secret")
+	if len(secrets) != 1 {
+		return false, errors.New("resourceRef contains more than one secret")
+	}
+	sc, err := NewSecretResource(cp.Namespace, secrets[0], cp).GetCredentials(ctx)
+	if err != nil {
+		return false, err
+	}
+	password := sc.(*SecretCredentials).Password
+
+	sources, err := cp.sourceReleases(ctx, m.InstanceID)
+	if err != nil {
+		return false, err
+	}
+	var sourceHaProxy *helmv1alpha1.Release
+	for i := range sources {
+		if sources[i].Spec.ForProvider.Chart.Name == cp.HaProxyRelease {
+			sourceHaProxy = &sources[i]
+		}
+	}
+	if sourceHaProxy == nil {
+		return false, fmt.Errorf("release %q not found on cluster %q", cp.HaProxyRelease, m.SourceCluster)
+	}
+	targetHaProxy, err := findRelease(ctx, cp, findResourceRefs(instance.GetResourceReferences(), "Release"), cp.HaProxyRelease)
+	if err != nil {
+		return false, err
+	}
+
+	source, err := cp.dialRedisRelease(ctx, sourceHaProxy, password)
+	if err != nil {
+		return false, fmt.Errorf("connect to source: %w", err)
+	}
+	defer source.Close()
+	target, err := cp.dialRedisRelease(ctx, targetHaProxy, password)
+	if err != nil {
+		return false, fmt.Errorf("connect to target: %w", err)
+	}
+	defer target.Close()
+
+	batchCtx, cancel := context.WithTimeout(ctx, migrationCopyBudget)
+	defer cancel()
+	pos, copied, done, err := copyRedisKeys(batchCtx, source, target, from)
+	if err != nil {
+		return false, err
+	}
+	m.copyPosition, m.CopiedKeys = pos.String(), m.CopiedKeys+copied
+	return done, nil
+}
+
+// dialRedisRelease connects to the Redis master exposed by the HAProxy release.
+func (cp *Crossplane) dialRedisRelease(ctx context.Context, haproxy *helmv1alpha1.Release, password string) (*redisConn, error) {
+	hc, err := NewHaProxyResource(haproxy, cp).GetCredentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	endpoints, err := mapRedisEndpoints(hc.(*HaProxyCredentials))
+	if err != nil {
+		return nil, err
+	}
+	master := endpoints["master"]
+	return dialRedis(ctx, net.JoinHostPort(master.Host, strconv.Itoa(int(master.Port))), password)
+}
+
+// createSourceReleases creates copies of the releases of the instance, which keep managing the helm releases on the
+// source cluster once the instance is pointed to the target cluster. The copies orphan the helm releases if deleted,
+// until the migration removes the instance from the source cluster.
+func (cp *Crossplane) createSourceReleases(ctx context.Context, instance *composite.Unstructured) error {
+	for _, ref := range findResourceRefs(instance.GetResourceReferences(), "Release") {
+		release, err := cp.getRelease(ctx, ref.Name)
+		if err != nil {
+			return err
+		}
+		externalName := meta.GetExternalName(release)
+		if externalName == "" {
+			externalName = release.Name
+		}
+		source := &helmv1alpha1.Release{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: release.Name + migrationSourceSuffix,
+				Labels: map[string]string{
+					InstanceIDLabel:      instance.GetName(),
+					MigrationSourceLabel: "true",
+				},
+			},
+			Spec: helmv1alpha1.ReleaseSpec{
+				ResourceSpec: runtimev1alpha1.ResourceSpec{
+					ProviderConfigReference: release.Spec.ProviderConfigReference.DeepCopy(),
+					DeletionPolicy:          runtimev1alpha1.DeletionOrphan,
+				},
+				RollbackRetriesLimit: release.Spec.RollbackRetriesLimit,
+			},
+		}
+		release.Spec.ForProvider.DeepCopyInto(&source.Spec.ForProvider)
+		meta.SetExternalName(source, externalName)
+		if err := cp.Client.Create(ctx, source); err != nil {
+			return fmt.Errorf("create release(%q): %w", source.Name, err)
+		}
+	}
+	return nil
+}
+
+// sourceReleases returns the releases keeping the instance on the source cluster of a migration.
+func (cp *Crossplane) sourceReleases(ctx context.Context, instanceID string) ([]helmv1alpha1.Release, error) {
+	list := &helmv1alpha1.ReleaseList{}
+	err := cp.Client.List(ctx, list, client.MatchingLabels{InstanceIDLabel: instanceID, MigrationSourceLabel: "true"})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// deleteSourceReleases deletes the releases on the source cluster. Crossplane uninstalls the helm releases from the
+// source cluster with the deletion policy Delete and keeps them installed with Orphan.
+func (cp *Crossplane) deleteSourceReleases(ctx context.Context, instanceID string, policy runtimev1alpha1.DeletionPolicy) error {
+	sources, err := cp.sourceReleases(ctx, instanceID)
+	if err != nil {
+		return err
+	}
+	for i := range sources {
+		release := &sources[i]
+		if release.Spec.DeletionPolicy != policy {
+			patch := client.MergeFrom(release.DeepCopy())
+			release.Spec.DeletionPolicy = policy
+			if err := cp.Client.Patch(ctx, release, patch, client.FieldOwner(FieldManager)); err != nil {
+				if k8serrors.IsNotFound(err) {
+					continue
+				}
+				return fmt.Errorf("patch release(%q): %w", release.Name, err)
+			}
+		}
+		if err := cp.Client.Delete(ctx, release); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("delete release(%q): %w", release.Name, err)
+		}
+	}
+	return nil
+}
+
+// cleanUpSource uninstalls the releases of the instance from the source cluster and marks its namespace there as deleted.
+func (cp *Crossplane) cleanUpSource(ctx context.Context, m *Migration) error {
+	if err := cp.deleteSourceReleases(ctx, m.InstanceID, runtimev1alpha1.DeletionDelete); err != nil {
+		return err
+	}
+	return cp.markSourceNamespaceDeleted(ctx, m.InstanceID, m.SourceCluster)
+}
+
+// markSourceNamespaceDeleted marks the namespace of the instance on the source cluster as deleted, like deprovisioning does.
+func (cp *Crossplane) markSourceNamespaceDeleted(ctx context.Context, instanceID, sourceCluster string) error {
+	if sourceCluster == "" {
+		return nil
+	}
+	klient, err := cp.Downstream.Get(ctx, sourceCluster)
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			// The cluster has been decommissioned already.
+			cp.logger.Info("source-cluster-gone", lager.Data{"instance": instanceID, "cluster": sourceCluster, "error": err.Error()})
+			return nil
+		}
+		return err
+	}
+	ns := corev1.Namespace{}
+	if err := klient.Get(ctx, types.NamespacedName{Name: instanceID}, &ns); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		cp.Downstream.Failed(sourceCluster, err)
+		return fmt.Errorf("get namespace(%q): %w", instanceID, err)
+	}
+	patch := client.MergeFrom(ns.DeepCopy())
+	markDeleted(&ns)
+	if err := klient.Patch(ctx, &ns, patch); err != nil {
+		cp.Downstream.Failed(sourceCluster, err)
+		return fmt.Errorf("patch namespace(%q): %w", instanceID, err)
+	}
+	return nil
+}
+
+// failMigration records that the migration failed because of the error.
+// Only errors recording the failure are returned.
+func (cp *Crossplane) failMigration(ctx context.Context, m *Migration, err error) error {
+	m.State, m.Message, m.UpdatedAt = MigrationFailed, err.Error(), time.Now().UTC()
+	cp.logger.Error("migration-failed", err, lager.Data{"instance": m.InstanceID})
+	return cp.saveMigration(ctx, m)
+}
+
+// IsMigrating returns true if the instance is being migrated.
+func (cp *Crossplane) IsMigrating(ctx context.Context, instanceID string) (bool, error) {
+	m, err := cp.GetMigration(ctx, instanceID)
+	if errors.Is(err, ErrMigrationNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !m.Done(), nil
+}
+
+// GetMigration returns the last migration of an instance.
+func (cp *Crossplane) GetMigration(ctx context.Context, instanceID string) (*Migration, error) {
+	cm := &corev1.ConfigMap{}
+	err := cp.Client.Get(ctx, types.NamespacedName{Name: migrationRecordName(instanceID), Namespace: cp.Namespace}, cm)
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, ErrMigrationNotFound
+		}
+		return nil, err
+	}
+	m := &Migration{
+		InstanceID:    instanceID,
+		SourceCluster: cm.Data[migrationRecordSourceKey],
+		TargetCluster: cm.Data[migrationRecordTargetKey],
+		State:         MigrationState(cm.Data[migrationRecordStateKey]),
+		Message:       cm.Data[migrationRecordMessageKey],
+	}
+	m.SkipDataCopy, _ = strconv.ParseBool(cm.Data[migrationRecordSkipDataCopyKey])
+	m.CopiedKeys, _ = strconv.Atoi(cm.Data[migrationRecordCopiedKeysKey])
+	m.copyPosition = cm.Data[migrationRecordCopyPositionKey]
+	m.resourceVersion = cm.ResourceVersion
+	m.StartedAt, _ = time.Parse(time.RFC3339, cm.Data[migrationRecordStartedAtKey])
+	m.UpdatedAt, _ = time.Parse(time.RFC3339, cm.Data[migrationRecordUpdatedAtKey])
+	return m, nil
+}
+
+// saveMigration records the migration. The record is created for new migrations and otherwise updated only if it
+// hasn't changed since the migration has been read, failing with a conflict otherwise.
+func (cp *Crossplane) saveMigration(ctx context.Context, m *Migration) error {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            migrationRecordName(m.InstanceID),
+			Namespace:       cp.Namespace,
+			ResourceVersion: m.resourceVersion,
+			Labels: map[string]string{
+				InstanceIDLabel: m.InstanceID,
+			},
+		},
+		Data: map[string]string{
+			migrationRecordSourceKey:       m.SourceCluster,
+			migrationRecordTargetKey:       m.TargetCluster,
+			migrationRecordStateKey:        string(m.State),
+			migrationRecordMessageKey:      m.Message,
+			migrationRecordSkipDataCopyKey: strconv.FormatBool(m.SkipDataCopy),
+			migrationRecordCopyPositionKey: m.copyPosition,
+			migrationRecordCopiedKeysKey:   strconv.Itoa(m.CopiedKeys),
+			migrationRecordStartedAtKey:    m.StartedAt.Format(time.RFC3339),
+			migrationRecordUpdatedAtKey:    m.UpdatedAt.Format(time.RFC3339),
+		},
+	}
+	if m.resourceVersion == "" {
+		if err := cp.Client.Create(ctx, cm); err != nil {
+			if k8serrors.IsAlreadyExists(err) {
+				return fmt.Errorf("%w: instance %q is being migrated", ErrMigrationInProgress, m.InstanceID)
+			}
+			return err
+		}
+	} else if err := cp.Client.Update(ctx, cm, client.FieldOwner(FieldManager)); err != nil {
+		return err
+	}
+	m.resourceVersion = cm.ResourceVersion
+	return nil
+}
+
+// DeleteMigrationRecord deletes the record of the migration of an instance. Instances without record are ignored.
+func (cp *Crossplane) DeleteMigrationRecord(ctx context.Context, instanceID string) error {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      migrationRecordName(instanceID),
+			Namespace: cp.Namespace,
+		},
+	}
+	return client.IgnoreNotFound(cp.Client.Delete(ctx, cm))
+}
+
+// migrationRecordName is the name of the ConfigMap recording the migration of an instance.
+func migrationRecordName(instanceID string) string {
+	return "migration-" + instanceID
+}
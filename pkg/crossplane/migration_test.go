@@ -0,0 +1,315 @@
+package crossplane
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"broker/pkg/config"
+
+	"code.cloudfoundry.org/lager"
+	helmv1alpha1 "github.com/crossplane-contrib/provider-helm/apis/release/v1alpha1"
+	runtimev1alpha1 "github.com/crossplane/crossplane-runtime/apis/core/v1alpha1"
+	"github.com/crossplane/crossplane-runtime/pkg/fieldpath"
+	"github.com/crossplane/crossplane-runtime/pkg/meta"
+	"github.com/crossplane/crossplane-runtime/pkg/resource/unstructured/composite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var migrationPool = config.PlacementConfig{Clusters: []config.ClusterConfig{{Name: "eu-1"}, {Name: "eu-2"}}}
+
+func newMigratableInstance(name string, labels map[string]string) *composite.Unstructured {
+	l := map[string]string{ClusterLabel: "eu-1"}
+	for k, v := range labels {
+		l[k] = v
+	}
+	instance := newInstance(name, l)
+	instance.SetCompositionReference(&corev1.ObjectReference{Name: "small"})
+	instance.SetConditions(runtimev1alpha1.Available())
+	// The fake client doesn't set a resource version on the objects it's created with.
+	instance.SetResourceVersion("1")
+	return instance
+}
+
+func TestStartMigration(t *testing.T) {
+	fixed := newPlan("fixed", SLAStandard)
+	fixed.Labels[ClusterLabel] = "eu-1"
+	fixedInstance := newMigratableInstance("fixed", nil)
+	fixedInstance.SetCompositionReference(&corev1.ObjectReference{Name: "fixed"})
+	notReady := newMigratableInstance("not-ready", nil)
+	notReady.SetConditions(runtimev1alpha1.Creating())
+	leftover := &helmv1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "leftover-redis-source",
+			Labels: map[string]string{InstanceIDLabel: "leftover", MigrationSourceLabel: "true"},
+		},
+	}
+
+	tests := map[string]struct {
+		instance     string
+		target       string
+		skipDataCopy bool
+		err          error
+	}{
+		"migrate": {
+			instance:     "instance",
+			target:       "eu-2",
+			skipDataCopy: true,
+		},
+		"data copy": {
+			instance: "instance",
+			target:   "eu-2",
+		},
+		"same cluster": {
+			instance:     "instance",
+			target:       "eu-1",
+			skipDataCopy: true,
+			err:          ErrMigrationNotPermitted,
+		},
+		"not in pool": {
+			instance:     "instance",
+			target:       "us-1",
+			skipDataCopy: true,
+			err:          ErrMigrationNotPermitted,
+		},
+		"plan with cluster": {
+			instance:     "fixed",
+			target:       "eu-2",
+			skipDataCopy: true,
+			err:          ErrMigrationNotPermitted,
+		},
+		"not ready": {
+			instance:     "not-ready",
+			target:       "eu-2",
+			skipDataCopy: true,
+			err:          ErrMigrationNotPermitted,
+		},
+		"parent": {
+			instance:     "parent",
+			target:       "eu-2",
+			skipDataCopy: true,
+			err:          ErrMigrationNotPermitted,
+		},
+		"child": {
+			instance:     "child",
+			target:       "eu-2",
+			skipDataCopy: true,
+			err:          ErrMigrationNotPermitted,
+		},
+		"releases of earlier migration": {
+			instance:     "leftover",
+			target:       "eu-2",
+			skipDataCopy: true,
+			err:          ErrMigrationNotPermitted,
+		},
+		"unknown instance": {
+			instance:     "unknown",
+			target:       "eu-2",
+			skipDataCopy: true,
+			err:          ErrInstanceNotFound,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			cp := newParentTestCrossplane(t,
+				fixed.DeepCopy(),
+				fixedInstance.DeepCopy(),
+				notReady.DeepCopy(),
+				newMigratableInstance("instance", nil),
+				newMigratableInstance("parent", nil),
+				newMigratableInstance("child", map[string]string{ParentIDLabel: "parent"}),
+				newMigratableInstance("leftover", nil),
+				leftover.DeepCopy(),
+			)
+			cp.Placement = migrationPool
+
+			m, err := cp.StartMigration(context.Background(), tt.instance, tt.target, tt.skipDataCopy)
+			if tt.err != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, tt.err), err.Error())
+				_, err := cp.GetMigration(context.Background(), tt.instance)
+				assert.Equal(t, ErrMigrationNotFound, err, "rejected migrations must not be recorded")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, MigrationProvisioning, m.State)
+			assert.Equal(t, "eu-1", m.SourceCluster)
+
+			instance, err := cp.GetInstance(context.Background(), tt.instance)
+			require.NoError(t, err)
+			assert.Equal(t, tt.target, instance.GetLabels()[ClusterLabel])
+			providerConfig, err := fieldpath.Pave(instance.Object).GetString(InstanceSpecProviderConfigPath)
+			require.NoError(t, err)
+			assert.Equal(t, tt.target, providerConfig)
+
+			_, err = cp.StartMigration(context.Background(), tt.instance, "eu-1", true)
+			assert.True(t, errors.Is(err, ErrMigrationInProgress))
+			migrating, err := cp.IsMigrating(context.Background(), tt.instance)
+			require.NoError(t, err)
+			assert.True(t, migrating)
+		})
+	}
+}
+
+func TestAdvanceMigration(t *testing.T) {
+	instance := newMigratableInstance("instance", nil)
+	instance.SetResourceReferences([]corev1.ObjectReference{{Kind: "Release", Name: "instance-redis"}})
+	release := &helmv1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Name: "instance-redis"},
+		Spec: helmv1alpha1.ReleaseSpec{
+			ResourceSpec: runtimev1alpha1.ResourceSpec{
+				ProviderConfigReference: &runtimev1alpha1.Reference{Name: "eu-1"},
+			},
+		},
+	}
+	release.SetConditions(runtimev1alpha1.Available())
+	pc, secret := newClusterProviderConfig("eu-1")
+
+	cp := newParentTestCrossplane(t, instance, release, pc, secret)
+	cp.Placement = migrationPool
+
+	s := runtime.NewScheme()
+	require.NoError(t, scheme.AddToScheme(s))
+	sourceClient := fake.NewFakeClientWithScheme(s, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "instance"}})
+	cp.Downstream = NewDownstreamClients(cp.Client, nil, lager.NewLogger("test"))
+	cp.Downstream.newClient = func(kubeconfig []byte) (k8sclient.Client, *rest.Config, error) {
+		return sourceClient, &rest.Config{Host: string(kubeconfig)}, nil
+	}
+	ctx := context.Background()
+
+	_, err := cp.AdvanceMigration(ctx, "instance")
+	assert.Equal(t, ErrMigrationNotFound, err)
+
+	_, err = cp.StartMigration(ctx, "instance", "eu-2", true)
+	require.NoError(t, err)
+
+	source := &helmv1alpha1.Release{}
+	require.NoError(t, cp.Client.Get(ctx, types.NamespacedName{Name: "instance-redis-source"}, source))
+	assert.Equal(t, "instance-redis", meta.GetExternalName(source), "the copy must manage the helm release on the source cluster")
+	assert.Equal(t, "eu-1", providerConfigName(source))
+	assert.Equal(t, runtimev1alpha1.DeletionOrphan, source.Spec.DeletionPolicy)
+	assert.Equal(t, "instance", source.Labels[InstanceIDLabel])
+
+	stale, err := cp.GetMigration(ctx, "instance")
+	require.NoError(t, err)
+	m, err := cp.AdvanceMigration(ctx, "instance")
+	require.NoError(t, err)
+	assert.Equal(t, MigrationProvisioning, m.State, "the release hasn't been moved yet")
+
+	release.Spec.ProviderConfigReference.Name = "eu-2"
+	require.NoError(t, cp.Client.Update(ctx, release))
+	m, err = cp.AdvanceMigration(ctx, "instance")
+	require.NoError(t, err)
+	assert.Equal(t, MigrationSucceeded, m.State)
+	assert.Equal(t, `instance has been migrated to cluster "eu-2"`, m.Message)
+
+	ns := &corev1.Namespace{}
+	require.NoError(t, sourceClient.Get(ctx, types.NamespacedName{Name: "instance"}, ns))
+	assert.Equal(t, "true", ns.Labels[DeletedLabel], "the namespace on the source cluster must be cleaned up")
+	err = cp.Client.Get(ctx, types.NamespacedName{Name: "instance-redis-source"}, source)
+	assert.True(t, k8serrors.IsNotFound(err), "the releases on the source cluster must be uninstalled")
+
+	stale.Message = "stale"
+	err = cp.saveMigration(ctx, stale)
+	assert.True(t, k8serrors.IsConflict(err), "migrations must only be saved if they haven't changed")
+
+	migrating, err := cp.IsMigrating(ctx, "instance")
+	require.NoError(t, err)
+	assert.False(t, migrating)
+
+	m, err = cp.StartMigration(ctx, "instance", "eu-1", true)
+	require.NoError(t, err, "finished migrations must not block new ones")
+	assert.Equal(t, "eu-2", m.SourceCluster)
+}
+
+func TestAdvanceMigration_CopyData(t *testing.T) {
+	instance := newMigratableInstance("instance", map[string]string{ServiceNameLabel: serviceRedis})
+	instance.SetResourceReferences([]corev1.ObjectReference{
+		{Kind: "Release", Name: "instance-haproxy"},
+		{Kind: "Secret", Name: "instance-password"},
+	})
+	release := &helmv1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Name: "instance-haproxy"},
+		Spec: helmv1alpha1.ReleaseSpec{
+			ResourceSpec: runtimev1alpha1.ResourceSpec{
+				ProviderConfigReference: &runtimev1alpha1.Reference{Name: "eu-1"},
+			},
+			ForProvider: helmv1alpha1.ReleaseParameters{
+				Chart:     helmv1alpha1.ChartSpec{Name: "haproxy"},
+				Namespace: "instance",
+			},
+		},
+	}
+	release.SetConditions(runtimev1alpha1.Available())
+	password := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "crossplane", Name: "instance-password"},
+		Data: map[string][]byte{
+			runtimev1alpha1.ResourceCredentialsSecretPasswordKey: []byte("secret"),
+			runtimev1alpha1.ResourceCredentialsSecretPortKey:     []byte("6379"),
+		},
+	}
+	pc1, secret1 := newClusterProviderConfig("eu-1")
+	pc2, secret2 := newClusterProviderConfig("eu-2")
+
+	cp := newParentTestCrossplane(t, instance, release, password, pc1, secret1, pc2, secret2)
+	cp.Placement = migrationPool
+	cp.Namespace = "crossplane"
+	cp.HaProxyRelease = "haproxy"
+
+	sourceRedis := newFakeRedis(t, "secret", map[int]map[string]fakeRedisKey{
+		0: {"a": {value: "dump-a", ttl: -1}},
+		2: {"b": {value: "dump-b", ttl: 60000}},
+	})
+	targetRedis := newFakeRedis(t, "secret", nil)
+	s := runtime.NewScheme()
+	require.NoError(t, scheme.AddToScheme(s))
+	haproxy := func(r *fakeRedis) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "instance", Name: "haproxy"},
+			Spec: corev1.ServiceSpec{
+				Ports: []corev1.ServicePort{{Name: "redis", Port: r.port()}, {Name: "sentinel", Port: 26379}},
+			},
+			Status: corev1.ServiceStatus{
+				LoadBalancer: corev1.LoadBalancerStatus{Ingress: []corev1.LoadBalancerIngress{{IP: "127.0.0.1"}}},
+			},
+		}
+	}
+	clusters := map[string]k8sclient.Client{
+		"eu-1": fake.NewFakeClientWithScheme(s, haproxy(sourceRedis)),
+		"eu-2": fake.NewFakeClientWithScheme(s, haproxy(targetRedis)),
+	}
+	cp.Downstream = NewDownstreamClients(cp.Client, nil, lager.NewLogger("test"))
+	cp.Downstream.newClient = func(kubeconfig []byte) (k8sclient.Client, *rest.Config, error) {
+		return clusters[string(kubeconfig)], &rest.Config{Host: string(kubeconfig)}, nil
+	}
+	ctx := context.Background()
+
+	_, err := cp.StartMigration(ctx, "instance", "eu-2", false)
+	require.NoError(t, err)
+	release.Spec.ProviderConfigReference.Name = "eu-2"
+	require.NoError(t, cp.Client.Update(ctx, release))
+
+	m, err := cp.AdvanceMigration(ctx, "instance")
+	require.NoError(t, err)
+	assert.Equal(t, MigrationSucceeded, m.State)
+	assert.Equal(t, 2, m.CopiedKeys)
+	assert.Equal(t, map[string]fakeRedisKey{"a": {value: "dump-a", ttl: -1}}, targetRedis.keys(0))
+	assert.Equal(t, map[string]fakeRedisKey{"b": {value: "dump-b", ttl: 60000}}, targetRedis.keys(2))
+
+	m, err = cp.GetMigration(ctx, "instance")
+	require.NoError(t, err)
+	assert.Equal(t, 2, m.CopiedKeys, "the copied keys must be recorded")
+	sources, err := cp.sourceReleases(ctx, "instance")
+	require.NoError(t, err)
+	assert.Empty(t, sources)
+}
@@ -0,0 +1,99 @@
+package crossplane
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/crossplane/crossplane-runtime/pkg/fieldpath"
+	"github.com/crossplane/crossplane-runtime/pkg/resource/unstructured/composite"
+	"github.com/crossplane/crossplane/apis/apiextensions/v1beta1"
+)
+
+// ErrInvalidParent is returned if an instance references a parent it may not share.
+var ErrInvalidParent = errors.New("invalid parent reference")
+
+// ParentReference returns the ID of the parent instance referenced in the provisioning parameters.
+// It returns an empty string if there is no parent reference.
+func ParentReference(parameters json.RawMessage) (string, error) {
+	if len(parameters) == 0 {
+		return "", nil
+	}
+	params := map[string]interface{}{}
+	if err := json.Unmarshal(parameters, &params); err != nil {
+		return "", fmt.Errorf("%w: parameters must be an object: %s", ErrInvalidParent, err)
+	}
+	if _, ok := params[instanceParamsParentReferenceName]; !ok {
+		return "", nil
+	}
+	parentReference, err := fieldpath.Pave(params).GetString(instanceParamsParentReferenceName)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidParent, err)
+	}
+	return parentReference, nil
+}
+
+// ValidateParentReference validates the parent reference in the provisioning parameters of an instance of the given plan.
+// Services requiring a parent, like MariaDB databases, are checked to reference a suitable parent.
+func (cp *Crossplane) ValidateParentReference(ctx context.Context, plan *v1beta1.Composition, parameters json.RawMessage, childLabels map[string]string) error {
+	parentID, err := ParentReference(parameters)
+	if err != nil {
+		return err
+	}
+
+	serviceName := plan.Labels[ServiceNameLabel]
+	if parentID == "" {
+		if serviceName == serviceMariadbDatabase {
+			return fmt.Errorf("%w: %s instances require a %s instance as parent_reference", ErrInvalidParent, serviceMariadbDatabase, serviceMariadb)
+		}
+		return nil
+	}
+
+	parent, err := cp.ValidateParent(ctx, parentID, childLabels)
+	if err != nil {
+		return err
+	}
+	if serviceName == serviceMariadbDatabase {
+		return cp.validateMariadbDatabaseParent(ctx, parent)
+	}
+	return nil
+}
+
+// ValidateParent checks that a child instance with the given labels may reference the parent instance.
+// Both instances must belong to the same tenant and organization.
+// Parents created before organizations were recorded don't have an organization label and are shared within their tenant.
+func (cp *Crossplane) ValidateParent(ctx context.Context, parentID string, childLabels map[string]string) (*composite.Unstructured, error) {
+	parent, err := cp.GetInstance(ctx, parentID)
+	if err != nil {
+		if errors.Is(err, ErrInstanceNotFound) {
+			return nil, fmt.Errorf("%w: instance %q does not exist", ErrInvalidParent, parentID)
+		}
+		return nil, err
+	}
+
+	parentLabels := parent.GetLabels()
+	if parentLabels[TenantLabel] != childLabels[TenantLabel] {
+		return nil, fmt.Errorf("%w: instance %q belongs to another tenant", ErrInvalidParent, parentID)
+	}
+	if org, ok := parentLabels[OrganizationLabel]; ok && org != childLabels[OrganizationLabel] {
+		return nil, fmt.Errorf("%w: instance %q belongs to another organization", ErrInvalidParent, parentID)
+	}
+	return parent, nil
+}
+
+// ChildInstances returns the names of all instances referencing the given instance as their parent.
+// Instances of all services offered by the broker are considered.
+func (cp *Crossplane) ChildInstances(ctx context.Context, parentID string) ([]string, error) {
+	instances, err := cp.ListInstances(ctx, InstanceFilter{ParentID: parentID})
+	if err != nil {
+		return nil, err
+	}
+	children := make([]string, 0, len(instances))
+	for _, instance := range instances {
+		children = append(children, instance.GetName())
+	}
+	sort.Strings(children)
+	return children, nil
+}
@@ -0,0 +1,297 @@
+package crossplane
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"code.cloudfoundry.org/lager"
+	runtimev1alpha1 "github.com/crossplane/crossplane-runtime/apis/core/v1alpha1"
+	"github.com/crossplane/crossplane-runtime/pkg/resource/unstructured/composite"
+	"github.com/crossplane/crossplane/apis/apiextensions/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newInstance(name string, labels map[string]string) *composite.Unstructured {
+	instance := composite.New(composite.WithGroupVersionKind(redisGVK))
+	instance.SetName(name)
+	l := map[string]string{
+		InstanceIDLabel: name,
+		ServiceIDLabel:  "redis-k8s",
+		PlanNameLabel:   "small",
+	}
+	for k, v := range labels {
+		l[k] = v
+	}
+	instance.SetLabels(l)
+	return instance
+}
+
+func newParentTestCrossplane(t *testing.T, objs ...runtime.Object) *Crossplane {
+	s := runtime.NewScheme()
+	require.NoError(t, scheme.AddToScheme(s))
+	require.NoError(t, SetupScheme(s))
+	// The fake client needs the list type to list composites.
+	s.AddKnownTypeWithName(redisGVK.GroupVersion().WithKind(redisGVK.Kind+"List"), &unstructured.UnstructuredList{})
+
+	objs = append(objs, newPlan("small", SLAStandard))
+	cp := &Crossplane{Client: fake.NewFakeClientWithScheme(s, objs...), logger: lager.NewLogger("test")}
+	cp.SetServiceIDs([]string{"redis-k8s"})
+	return cp
+}
+
+func TestParentReference(t *testing.T) {
+	tests := map[string]struct {
+		params json.RawMessage
+		want   string
+		err    string
+	}{
+		"no parameters": {},
+		"no parent": {
+			params: json.RawMessage(`{"foo": "bar"}`),
+		},
+		"parent": {
+			params: json.RawMessage(`{"parent_reference": "parent"}`),
+			want:   "parent",
+		},
+		"not a string": {
+			params: json.RawMessage(`{"parent_reference": 1}`),
+			err:    "invalid parent reference: parent_reference: not a string",
+		},
+		"not an object": {
+			params: json.RawMessage(`["parent"]`),
+			err:    "invalid parent reference: parameters must be an object: json: cannot unmarshal array into Go value of type map[string]interface {}",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := ParentReference(tt.params)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				assert.True(t, errors.Is(err, ErrInvalidParent))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestValidateParent(t *testing.T) {
+	cp := newParentTestCrossplane(t,
+		newInstance("parent", map[string]string{TenantLabel: "tenant-a", OrganizationLabel: "org-a"}),
+		newInstance("legacy", map[string]string{TenantLabel: "tenant-a"}),
+	)
+
+	tests := map[string]struct {
+		parent string
+		labels map[string]string
+		err    string
+	}{
+		"same tenant and organization": {
+			parent: "parent",
+			labels: map[string]string{TenantLabel: "tenant-a", OrganizationLabel: "org-a"},
+		},
+		"other tenant": {
+			parent: "parent",
+			labels: map[string]string{TenantLabel: "tenant-b", OrganizationLabel: "org-a"},
+			err:    `invalid parent reference: instance "parent" belongs to another tenant`,
+		},
+		"no tenant": {
+			parent: "parent",
+			labels: map[string]string{OrganizationLabel: "org-a"},
+			err:    `invalid parent reference: instance "parent" belongs to another tenant`,
+		},
+		"other organization": {
+			parent: "parent",
+			labels: map[string]string{TenantLabel: "tenant-a", OrganizationLabel: "org-b"},
+			err:    `invalid parent reference: instance "parent" belongs to another organization`,
+		},
+		"parent without organization": {
+			parent: "legacy",
+			labels: map[string]string{TenantLabel: "tenant-a", OrganizationLabel: "org-b"},
+		},
+		"not found": {
+			parent: "unknown",
+			labels: map[string]string{TenantLabel: "tenant-a", OrganizationLabel: "org-a"},
+			err:    `invalid parent reference: instance "unknown" does not exist`,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			parent, err := cp.ValidateParent(context.Background(), tt.parent, tt.labels)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				assert.True(t, errors.Is(err, ErrInvalidParent))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.parent, parent.GetName())
+		})
+	}
+}
+
+func TestChildInstances(t *testing.T) {
+	cp := newParentTestCrossplane(t,
+		newInstance("parent", nil),
+		newInstance("child-2", map[string]string{ParentIDLabel: "parent"}),
+		newInstance("child-1", map[string]string{ParentIDLabel: "parent"}),
+		newInstance("other", map[string]string{ParentIDLabel: "other-parent"}),
+	)
+
+	children, err := cp.ChildInstances(context.Background(), "parent")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"child-1", "child-2"}, children)
+
+	children, err = cp.ChildInstances(context.Background(), "child-1")
+	require.NoError(t, err)
+	assert.Empty(t, children)
+}
+
+func TestValidateParentReference(t *testing.T) {
+	mariadbGVK := schema.GroupVersionKind{Group: "syn.tools", Version: "v1alpha1", Kind: "CompositeMariaDBInstance"}
+	databaseGVK := schema.GroupVersionKind{Group: "syn.tools", Version: "v1alpha1", Kind: "CompositeMariaDBDatabaseInstance"}
+
+	newServicePlan := func(name, service string, gvk schema.GroupVersionKind, annotations map[string]string) *v1beta1.Composition {
+		return &v1beta1.Composition{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Labels:      map[string]string{ServiceIDLabel: service, ServiceNameLabel: service, PlanNameLabel: name},
+				Annotations: annotations,
+			},
+			Spec: v1beta1.CompositionSpec{
+				CompositeTypeRef: v1beta1.TypeReference{APIVersion: gvk.GroupVersion().String(), Kind: gvk.Kind},
+			},
+		}
+	}
+	newServiceInstance := func(name string, plan *v1beta1.Composition, ready bool, labels map[string]string) *composite.Unstructured {
+		gvk := schema.FromAPIVersionAndKind(plan.Spec.CompositeTypeRef.APIVersion, plan.Spec.CompositeTypeRef.Kind)
+		instance := composite.New(composite.WithGroupVersionKind(gvk))
+		instance.SetName(name)
+		instance.SetCompositionReference(&corev1.ObjectReference{Name: plan.Name})
+		l := map[string]string{}
+		for _, k := range []string{ServiceIDLabel, ServiceNameLabel, PlanNameLabel} {
+			l[k] = plan.Labels[k]
+		}
+		for k, v := range labels {
+			l[k] = v
+		}
+		instance.SetLabels(l)
+		if ready {
+			instance.SetConditions(runtimev1alpha1.Available())
+		}
+		return instance
+	}
+
+	small := newServicePlan("mariadb-small", serviceMariadb, mariadbGVK, map[string]string{MaxDatabasesAnnotation: "2"})
+	large := newServicePlan("mariadb-large", serviceMariadb, mariadbGVK, nil)
+	database := newServicePlan("mariadb-database", serviceMariadbDatabase, databaseGVK, nil)
+	redis := newPlan("small", SLAStandard)
+
+	s := runtime.NewScheme()
+	require.NoError(t, scheme.AddToScheme(s))
+	require.NoError(t, SetupScheme(s))
+	for _, gvk := range []schema.GroupVersionKind{redisGVK, mariadbGVK, databaseGVK} {
+		s.AddKnownTypeWithName(gvk.GroupVersion().WithKind(gvk.Kind+"List"), &unstructured.UnstructuredList{})
+	}
+	cp := &Crossplane{
+		Client: fake.NewFakeClientWithScheme(s,
+			small, large, database, redis,
+			newServiceInstance("cluster", small, true, nil),
+			newServiceInstance("full-cluster", small, true, nil),
+			newServiceInstance("db-1", database, true, map[string]string{ParentIDLabel: "full-cluster"}),
+			newServiceInstance("db-2", database, true, map[string]string{ParentIDLabel: "full-cluster"}),
+			newServiceInstance("large-cluster", large, true, nil),
+			newServiceInstance("db-3", database, true, map[string]string{ParentIDLabel: "large-cluster"}),
+			newServiceInstance("new-cluster", small, false, nil),
+			newServiceInstance("redis", redis, true, map[string]string{ServiceNameLabel: "redis-k8s"}),
+		),
+		logger:              lager.NewLogger("test"),
+		MariadbMaxDatabases: 2,
+	}
+	cp.SetServiceIDs([]string{serviceMariadb, serviceMariadbDatabase, "redis-k8s"})
+
+	tests := map[string]struct {
+		plan   *v1beta1.Composition
+		params string
+		max    int
+		err    string
+	}{
+		"database": {
+			plan:   database,
+			params: `{"parent_reference": "cluster"}`,
+		},
+		"database without parent": {
+			plan:   database,
+			params: `{}`,
+			err:    "invalid parent reference: mariadb-k8s-database instances require a mariadb-k8s instance as parent_reference",
+		},
+		"unknown parent": {
+			plan:   database,
+			params: `{"parent_reference": "clustr"}`,
+			err:    `invalid parent reference: instance "clustr" does not exist`,
+		},
+		"parent of another service": {
+			plan:   database,
+			params: `{"parent_reference": "redis"}`,
+			err:    `invalid parent reference: instance "redis" is a redis-k8s instance, not mariadb-k8s`,
+		},
+		"parent not ready": {
+			plan:   database,
+			params: `{"parent_reference": "new-cluster"}`,
+			err:    `invalid parent reference: instance "new-cluster" is not ready`,
+		},
+		"capacity of plan exceeded": {
+			plan:   database,
+			params: `{"parent_reference": "full-cluster"}`,
+			err:    `invalid parent reference: instance "full-cluster" has no capacity for another database, it already has 2 of 2 databases`,
+		},
+		"default capacity": {
+			plan:   database,
+			params: `{"parent_reference": "large-cluster"}`,
+			max:    2,
+		},
+		"default capacity exceeded": {
+			plan:   database,
+			params: `{"parent_reference": "large-cluster"}`,
+			max:    1,
+			err:    `invalid parent reference: instance "large-cluster" has no capacity for another database, it already has 1 of 1 databases`,
+		},
+		"unlimited capacity": {
+			plan:   database,
+			params: `{"parent_reference": "large-cluster"}`,
+		},
+		"other service without parent": {
+			plan: redis,
+		},
+		"other service with parent": {
+			plan:   redis,
+			params: `{"parent_reference": "cluster"}`,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			cp.MariadbMaxDatabases = tt.max
+			var params json.RawMessage
+			if tt.params != "" {
+				params = json.RawMessage(tt.params)
+			}
+			err := cp.ValidateParentReference(context.Background(), tt.plan, params, nil)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				assert.True(t, errors.Is(err, ErrInvalidParent))
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
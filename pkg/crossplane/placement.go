@@ -0,0 +1,112 @@
+package crossplane
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"broker/pkg/config"
+
+	"github.com/crossplane/crossplane/apis/apiextensions/v1beta1"
+)
+
+const (
+	// InstanceSpecProviderConfigPath is the path to the name of the ProviderConfig an instance has been placed on.
+	// Compositions of plans without a cluster patch it into their resources.
+	InstanceSpecProviderConfigPath = "spec.providerConfigRef.name"
+
+	// instanceParamsRegionName is the name of the parameter requesting a region
+	instanceParamsRegionName = "region"
+	// instanceParamsZoneName is the name of the parameter requesting a preferred zone
+	instanceParamsZoneName = "zone"
+)
+
+var (
+	// ErrInvalidPlacement is returned if the placement parameters of an instance are invalid.
+	ErrInvalidPlacement = errors.New("invalid placement")
+	// ErrNoCapacity is returned if no cluster of the placement pool can take another instance.
+	ErrNoCapacity = errors.New("no cluster with capacity left")
+)
+
+// placementParameters are the parameters of an instance influencing its placement.
+type placementParameters struct {
+	Region string `json:"region"`
+	Zone   string `json:"zone"`
+}
+
+// PlaceInstance chooses the cluster of the placement pool a new instance of the plan is deployed to and returns its name.
+// Plans with a cluster label and children sharing the cluster of their parent aren't placed, an empty string is returned for them.
+//
+// Clusters outside of the region requested in the parameters and clusters without capacity left are skipped.
+// Of the remaining clusters, the ones in the requested zone are preferred, then the ones with the lowest utilization.
+// Callers must serialize placing and creating instances, otherwise concurrent instances can exceed the capacity of a cluster.
+func (cp *Crossplane) PlaceInstance(ctx context.Context, plan *v1beta1.Composition, parameters json.RawMessage) (string, error) {
+	if plan.Labels[ClusterLabel] != "" || len(cp.Placement.Clusters) == 0 {
+		return "", nil
+	}
+	parentID, err := ParentReference(parameters)
+	if err != nil || parentID != "" {
+		return "", err
+	}
+
+	params := placementParameters{}
+	if len(parameters) > 0 {
+		if err := json.Unmarshal(parameters, &params); err != nil {
+			return "", fmt.Errorf("%w: %s and %s must be strings: %s", ErrInvalidPlacement, instanceParamsRegionName, instanceParamsZoneName, err)
+		}
+	}
+
+	instances, err := cp.ListInstances(ctx, InstanceFilter{})
+	if err != nil {
+		return "", err
+	}
+	used := map[string]int{}
+	for _, instance := range instances {
+		used[instance.GetLabels()[ClusterLabel]]++
+	}
+
+	inRegion := false
+	candidates := make([]config.ClusterConfig, 0, len(cp.Placement.Clusters))
+	for _, c := range cp.Placement.Clusters {
+		if params.Region != "" && c.Region != params.Region {
+			continue
+		}
+		inRegion = true
+		if c.Capacity > 0 && used[c.Name] >= c.Capacity {
+			continue
+		}
+		candidates = append(candidates, c)
+	}
+	if !inRegion {
+		return "", fmt.Errorf("%w: there are no clusters in region %q", ErrInvalidPlacement, params.Region)
+	}
+	if len(candidates) == 0 {
+		if params.Region != "" {
+			return "", fmt.Errorf("%w in region %q", ErrNoCapacity, params.Region)
+		}
+		return "", ErrNoCapacity
+	}
+
+	utilization := func(c config.ClusterConfig) float64 {
+		if c.Capacity == 0 {
+			return 0
+		}
+		return float64(used[c.Name]) / float64(c.Capacity)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ci, cj := candidates[i], candidates[j]
+		if zi, zj := ci.Zone == params.Zone, cj.Zone == params.Zone; params.Zone != "" && zi != zj {
+			return zi
+		}
+		if ui, uj := utilization(ci), utilization(cj); ui != uj {
+			return ui < uj
+		}
+		if used[ci.Name] != used[cj.Name] {
+			return used[ci.Name] < used[cj.Name]
+		}
+		return ci.Name < cj.Name
+	})
+	return candidates[0].Name, nil
+}
@@ -0,0 +1,167 @@
+package crossplane
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"broker/pkg/config"
+
+	"github.com/crossplane/crossplane-runtime/pkg/fieldpath"
+	"github.com/crossplane/crossplane-runtime/pkg/resource/unstructured/composite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestPlaceInstance(t *testing.T) {
+	pool := config.PlacementConfig{Clusters: []config.ClusterConfig{
+		{Name: "eu-1", Region: "eu", Zone: "a", Capacity: 2},
+		{Name: "eu-2", Region: "eu", Zone: "b", Capacity: 4},
+		{Name: "us-1", Region: "us", Zone: "a"},
+	}}
+	pooled := newPlan("pooled", SLAStandard)
+	fixed := newPlan("fixed", SLAStandard)
+	fixed.Labels[ClusterLabel] = "service-1"
+
+	objs := []*composite.Unstructured{
+		newInstance("i-1", map[string]string{ClusterLabel: "eu-1"}),
+		newInstance("i-2", map[string]string{ClusterLabel: "eu-2"}),
+		newInstance("i-3", map[string]string{ClusterLabel: "us-1"}),
+		newInstance("i-4", map[string]string{ClusterLabel: "eu-1"}),
+	}
+
+	tests := map[string]struct {
+		pool       config.PlacementConfig
+		fixed      bool
+		parameters string
+		existing   int
+		want       string
+		err        error
+	}{
+		"no pool": {},
+		"plan with cluster": {
+			pool:  pool,
+			fixed: true,
+		},
+		"child": {
+			pool:       pool,
+			parameters: `{"parent_reference": "parent"}`,
+		},
+		"lowest utilization": {
+			pool:     pool,
+			existing: 2,
+			want:     "us-1",
+		},
+		"region": {
+			pool:       pool,
+			parameters: `{"region": "eu"}`,
+			existing:   3,
+			want:       "eu-2",
+		},
+		"zone preferred": {
+			pool:       pool,
+			parameters: `{"region": "eu", "zone": "a"}`,
+			existing:   2,
+			want:       "eu-1",
+		},
+		"zone full": {
+			pool:       pool,
+			parameters: `{"region": "eu", "zone": "a"}`,
+			existing:   4,
+			want:       "eu-2",
+		},
+		"unknown region": {
+			pool:       pool,
+			parameters: `{"region": "ap"}`,
+			err:        ErrInvalidPlacement,
+		},
+		"invalid region": {
+			pool:       pool,
+			parameters: `{"region": 1}`,
+			err:        ErrInvalidPlacement,
+		},
+		"region full": {
+			pool: config.PlacementConfig{Clusters: []config.ClusterConfig{
+				{Name: "eu-1", Region: "eu", Capacity: 1},
+				{Name: "us-1", Region: "us"},
+			}},
+			parameters: `{"region": "eu"}`,
+			existing:   1,
+			err:        ErrNoCapacity,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			cp := newParentTestCrossplane(t)
+			for _, instance := range objs[:tt.existing] {
+				require.NoError(t, cp.Client.Create(context.Background(), instance.DeepCopy()))
+			}
+			cp.Placement = tt.pool
+			plan := pooled
+			if tt.fixed {
+				plan = fixed
+			}
+			var parameters json.RawMessage
+			if tt.parameters != "" {
+				parameters = json.RawMessage(tt.parameters)
+			}
+
+			cluster, err := cp.PlaceInstance(context.Background(), plan, parameters)
+			if tt.err != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, tt.err), err.Error())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, cluster)
+		})
+	}
+}
+
+func TestCreateInstance_Placed(t *testing.T) {
+	cp := newParentTestCrossplane(t)
+	plan := newPlan("pooled", SLAStandard)
+	require.NoError(t, cp.CreateInstance(context.Background(), "placed", nil, plan, map[string]string{ClusterLabel: "eu-1"}, nil))
+
+	plan.Labels[ClusterLabel] = "service-1"
+	require.NoError(t, cp.CreateInstance(context.Background(), "fixed", nil, plan, map[string]string{ClusterLabel: "eu-1"}, nil))
+
+	for name, cluster := range map[string]string{"placed": "eu-1", "fixed": "service-1"} {
+		instance := composite.New(composite.WithGroupVersionKind(redisGVK))
+		require.NoError(t, cp.Client.Get(context.Background(), types.NamespacedName{Name: name}, instance))
+		assert.Equal(t, cluster, instance.GetLabels()[ClusterLabel], name)
+
+		providerConfig, err := fieldpath.Pave(instance.Object).GetString(InstanceSpecProviderConfigPath)
+		if name == "fixed" {
+			assert.Error(t, err, "instances of plans with a cluster must not be placed")
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, "eu-1", providerConfig)
+	}
+}
+
+func TestCreateInstance_ChildOnParentCluster(t *testing.T) {
+	placed := newInstance("placed", map[string]string{ClusterLabel: "eu-2"})
+	require.NoError(t, fieldpath.Pave(placed.Object).SetValue(InstanceSpecProviderConfigPath, "eu-2"))
+	cp := newParentTestCrossplane(t, placed, newInstance("fixed", map[string]string{ClusterLabel: "service-1"}))
+	plan := newPlan("pooled", SLAStandard)
+
+	for parent, cluster := range map[string]string{"placed": "eu-2", "fixed": "service-1"} {
+		child := "child-of-" + parent
+		params := json.RawMessage(`{"parent_reference":"` + parent + `"}`)
+		require.NoError(t, cp.CreateInstance(context.Background(), child, params, plan, map[string]string{ClusterLabel: "eu-1"}, nil))
+
+		instance := composite.New(composite.WithGroupVersionKind(redisGVK))
+		require.NoError(t, cp.Client.Get(context.Background(), types.NamespacedName{Name: child}, instance))
+		assert.Equal(t, cluster, instance.GetLabels()[ClusterLabel], child)
+		providerConfig, err := fieldpath.Pave(instance.Object).GetString(InstanceSpecProviderConfigPath)
+		require.NoError(t, err)
+		assert.Equal(t, cluster, providerConfig, child)
+	}
+
+	err := cp.CreateInstance(context.Background(), "orphan", json.RawMessage(`{"parent_reference":"unknown"}`), plan, nil, nil)
+	assert.True(t, errors.Is(err, ErrInvalidParent), err)
+}
@@ -0,0 +1,141 @@
+package crossplane
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"broker/pkg/config"
+
+	"github.com/crossplane/crossplane/apis/apiextensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// ErrQuotaExceeded is returned if provisioning or updating an instance would exceed a quota.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// usage sums up the instances of an organization or space.
+type usage struct {
+	instances int
+	memory    resource.Quantity
+	storage   resource.Quantity
+}
+
+func (u *usage) add(memory, storage resource.Quantity) {
+	u.instances++
+	u.memory.Add(memory)
+	u.storage.Add(storage)
+}
+
+// CheckQuota checks whether an instance of the plan fits into the quotas of the organization and space.
+// The instance with the given ID is not counted, so updates to another plan are checked as if the instance already had the new plan.
+// Instances without organization aren't limited.
+func (cp *Crossplane) CheckQuota(ctx context.Context, instanceID string, plan *v1beta1.Composition, organization, space string) error {
+	if organization == "" || !cp.Quota.Enabled() {
+		return nil
+	}
+
+	plans, err := cp.getPlansForService(ctx, cp.ServiceIDs())
+	if err != nil {
+		return err
+	}
+	plansByName := make(map[string]*v1beta1.Composition, len(plans))
+	for i := range plans {
+		plansByName[plans[i].Name] = &plans[i]
+	}
+
+	instances, err := cp.ListInstances(ctx, InstanceFilter{OrganizationGUID: organization})
+	if err != nil {
+		return err
+	}
+
+	var orgUsage, spaceUsage usage
+	planInstances := 0
+	for _, instance := range instances {
+		if instance.GetName() == instanceID || instance.GetDeletionTimestamp() != nil {
+			continue
+		}
+		planName := ""
+		if ref := instance.GetCompositionReference(); ref != nil {
+			planName = ref.Name
+		}
+		var memory, storage resource.Quantity
+		if p, ok := plansByName[planName]; ok {
+			if memory, storage, err = planResources(p); err != nil {
+				return err
+			}
+		}
+		orgUsage.add(memory, storage)
+		if space != "" && instance.GetLabels()[SpaceLabel] == space {
+			spaceUsage.add(memory, storage)
+		}
+		if planName == plan.Name {
+			planInstances++
+		}
+	}
+
+	memory, storage, err := planResources(plan)
+	if err != nil {
+		return err
+	}
+
+	orgLimits := cp.Quota.Organization
+	if l, ok := cp.Quota.Organizations[organization]; ok {
+		orgLimits = l
+	}
+	if err := checkLimits(fmt.Sprintf("organization %q", organization), orgLimits, orgUsage, plan.Name, memory, storage); err != nil {
+		return err
+	}
+	if space != "" {
+		if err := checkLimits(fmt.Sprintf("space %q", space), cp.Quota.Space, spaceUsage, plan.Name, memory, storage); err != nil {
+			return err
+		}
+	}
+	if max, ok := cp.Quota.Plans[plan.Name]; ok && max > 0 && planInstances >= max {
+		return fmt.Errorf("%w: organization %q has %d of %d instances of plan %q, 0 remaining", ErrQuotaExceeded, organization, planInstances, max, plan.Name)
+	}
+	return nil
+}
+
+// checkLimits checks whether another instance of the plan requiring the given memory and storage fits into the limits.
+func checkLimits(name string, limits config.QuotaLimits, used usage, plan string, memory, storage resource.Quantity) error {
+	if limits.Instances > 0 && used.instances >= limits.Instances {
+		return fmt.Errorf("%w: %s has %d of %d instances, 0 remaining", ErrQuotaExceeded, name, used.instances, limits.Instances)
+	}
+	for _, r := range []struct {
+		name            string
+		limit, used, rq resource.Quantity
+	}{
+		{"memory", limits.Memory, used.memory, memory},
+		{"storage", limits.Storage, used.storage, storage},
+	} {
+		if r.limit.IsZero() {
+			continue
+		}
+		remaining := r.limit.DeepCopy()
+		remaining.Sub(r.used)
+		if remaining.Cmp(r.rq) < 0 {
+			if remaining.Sign() < 0 {
+				remaining = resource.Quantity{}
+			}
+			return fmt.Errorf("%w: plan %q requires %s %s, %s has %s of %s remaining",
+				ErrQuotaExceeded, plan, r.rq.String(), r.name, name, remaining.String(), r.limit.String())
+		}
+	}
+	return nil
+}
+
+// planResources returns the memory and storage of an instance of the plan as set by its annotations.
+func planResources(plan *v1beta1.Composition) (memory, storage resource.Quantity, err error) {
+	if v, ok := plan.Annotations[MemoryAnnotation]; ok {
+		if memory, err = resource.ParseQuantity(v); err != nil {
+			return memory, storage, fmt.Errorf("invalid %s annotation of plan %q: %w", MemoryAnnotation, plan.Name, err)
+		}
+	}
+	if v, ok := plan.Annotations[StorageAnnotation]; ok {
+		if storage, err = resource.ParseQuantity(v); err != nil {
+			return memory, storage, fmt.Errorf("invalid %s annotation of plan %q: %w", StorageAnnotation, plan.Name, err)
+		}
+	}
+	return memory, storage, nil
+}
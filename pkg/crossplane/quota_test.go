@@ -0,0 +1,138 @@
+package crossplane
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"broker/pkg/config"
+
+	"github.com/crossplane/crossplane-runtime/pkg/resource/unstructured/composite"
+	"github.com/crossplane/crossplane/apis/apiextensions/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCheckQuota(t *testing.T) {
+	newSizedPlan := func(name, memory, storage string) *v1beta1.Composition {
+		plan := newPlan(name, SLAStandard)
+		plan.SetAnnotations(map[string]string{MemoryAnnotation: memory, StorageAnnotation: storage})
+		return plan
+	}
+	newQuotaInstance := func(name, plan, org, space string) *composite.Unstructured {
+		instance := newInstance(name, map[string]string{OrganizationLabel: org, SpaceLabel: space})
+		instance.SetCompositionReference(&corev1.ObjectReference{Name: plan})
+		return instance
+	}
+	medium := newSizedPlan("medium", "1Gi", "8Gi")
+	large := newSizedPlan("large", "4Gi", "16Gi")
+
+	deleting := newQuotaInstance("deleting", "large", "org-a", "space-a")
+	now := metav1.Now()
+	deleting.SetDeletionTimestamp(&now)
+
+	objs := []*composite.Unstructured{
+		newQuotaInstance("a-1", "medium", "org-a", "space-a"),
+		newQuotaInstance("a-2", "medium", "org-a", "space-b"),
+		newQuotaInstance("a-3", "small", "org-a", "space-a"),
+		newQuotaInstance("b-1", "large", "org-b", "space-c"),
+		deleting,
+	}
+
+	tests := map[string]struct {
+		quota      config.QuotaConfig
+		instanceID string
+		plan       *v1beta1.Composition
+		org, space string
+		err        string
+	}{
+		"disabled": {
+			plan: large,
+			org:  "org-a",
+		},
+		"no organization": {
+			quota: config.QuotaConfig{Organization: config.QuotaLimits{Instances: 1}},
+			plan:  large,
+		},
+		"organization instances": {
+			quota: config.QuotaConfig{Organization: config.QuotaLimits{Instances: 3}},
+			plan:  medium,
+			org:   "org-a",
+			err:   `quota exceeded: organization "org-a" has 3 of 3 instances, 0 remaining`,
+		},
+		"organization instances on update": {
+			quota:      config.QuotaConfig{Organization: config.QuotaLimits{Instances: 3}},
+			instanceID: "a-3",
+			plan:       medium,
+			org:        "org-a",
+		},
+		"organization override": {
+			quota: config.QuotaConfig{
+				Organization:  config.QuotaLimits{Instances: 3},
+				Organizations: map[string]config.QuotaLimits{"org-a": {Instances: 4}},
+			},
+			plan: medium,
+			org:  "org-a",
+		},
+		"organization memory": {
+			quota: config.QuotaConfig{Organization: config.QuotaLimits{Memory: resource.MustParse("5Gi")}},
+			plan:  large,
+			org:   "org-a",
+			err:   `quota exceeded: plan "large" requires 4Gi memory, organization "org-a" has 3Gi of 5Gi remaining`,
+		},
+		"organization memory fits": {
+			quota: config.QuotaConfig{Organization: config.QuotaLimits{Memory: resource.MustParse("6Gi")}},
+			plan:  large,
+			org:   "org-a",
+		},
+		"organization storage": {
+			quota: config.QuotaConfig{Organization: config.QuotaLimits{Storage: resource.MustParse("20Gi")}},
+			plan:  medium,
+			org:   "org-a",
+			err:   `quota exceeded: plan "medium" requires 8Gi storage, organization "org-a" has 4Gi of 20Gi remaining`,
+		},
+		"space instances": {
+			quota: config.QuotaConfig{Space: config.QuotaLimits{Instances: 2}},
+			plan:  medium,
+			org:   "org-a",
+			space: "space-a",
+			err:   `quota exceeded: space "space-a" has 2 of 2 instances, 0 remaining`,
+		},
+		"other space": {
+			quota: config.QuotaConfig{Space: config.QuotaLimits{Instances: 2}},
+			plan:  medium,
+			org:   "org-a",
+			space: "space-b",
+		},
+		"plan": {
+			quota: config.QuotaConfig{Plans: map[string]int{"medium": 2}},
+			plan:  medium,
+			org:   "org-a",
+			err:   `quota exceeded: organization "org-a" has 2 of 2 instances of plan "medium", 0 remaining`,
+		},
+		"other plan": {
+			quota: config.QuotaConfig{Plans: map[string]int{"medium": 2}},
+			plan:  large,
+			org:   "org-a",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			cp := newParentTestCrossplane(t, medium.DeepCopy(), large.DeepCopy(),
+				objs[0].DeepCopy(), objs[1].DeepCopy(), objs[2].DeepCopy(), objs[3].DeepCopy(), objs[4].DeepCopy())
+			cp.Quota = tt.quota
+
+			err := cp.CheckQuota(context.Background(), tt.instanceID, tt.plan, tt.org, tt.space)
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.EqualError(t, err, tt.err)
+				assert.True(t, errors.Is(err, ErrQuotaExceeded))
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package crossplane
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// redisCopyBatchSize is the number of keys requested from the source per SCAN.
+	redisCopyBatchSize = 100
+	// redisDialTimeout limits connecting to the Redis of an instance.
+	redisDialTimeout = 10 * time.Second
+	// redisCommandTimeout limits the duration of a single command.
+	redisCommandTimeout = 30 * time.Second
+)
+
+// errRedisNil is the nil reply of Redis, e.g. for keys which don't exist.
+var errRedisNil = errors.New("redis: nil")
+
+// redisError is an error reply of Redis.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// redisConn is a minimal client of the Redis protocol, sufficient to copy keys with DUMP and RESTORE.
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dialRedis connects to a Redis and authenticates with the password, if any.
+func dialRedis(ctx context.Context, addr, password string) (*redisConn, error) {
+	d := net.Dialer{Timeout: redisDialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+	if password != "" {
+		if _, err := c.do("AUTH", password); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("authenticate: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// Close closes the connection.
+func (c *redisConn) Close() error {
+	return c.conn.Close()
+}
+
+// do sends a command and returns its reply. Replies are strings, integers, nested slices or nil.
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(redisCommandTimeout)); err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return c.reply()
+}
+
+func (c *redisConn) reply() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			values[i], err = c.reply()
+			if err != nil && !errors.Is(err, errRedisNil) {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("redis: invalid reply %q", line)
+}
+
+// redisDatabases returns the numbers of the databases containing keys, in ascending order.
+func redisDatabases(c *redisConn) ([]int, error) {
+	reply, err := c.do("INFO", "keyspace")
+	if err != nil {
+		return nil, err
+	}
+	info, ok := reply.(string)
+	if !ok {
+		return nil, fmt.Errorf("redis: unexpected INFO reply %T", reply)
+	}
+	dbs := []int{}
+	for _, line := range strings.Split(info, "\n") {
+		name := strings.SplitN(strings.TrimSpace(line), ":", 2)[0]
+		if !strings.HasPrefix(name, "db") {
+			continue
+		}
+		if db, err := strconv.Atoi(strings.TrimPrefix(name, "db")); err == nil {
+			dbs = append(dbs, db)
+		}
+	}
+	sort.Ints(dbs)
+	return dbs, nil
+}
+
+// redisCopyPosition is the progress of copying the keys of a Redis, the database and the SCAN cursor within it.
+// The zero value starts at the first database.
+type redisCopyPosition struct {
+	database int
+	cursor   string
+}
+
+// scanCursor returns the cursor to continue scanning the database at, 0 starts a new scan.
+func (p redisCopyPosition) scanCursor() string {
+	if p.cursor == "" {
+		return "0"
+	}
+	return p.cursor
+}
+
+func (p redisCopyPosition) String() string {
+	return strconv.Itoa(p.database) + "/" + p.scanCursor()
+}
+
+func parseRedisCopyPosition(s string) (redisCopyPosition, error) {
+	if s == "" {
+		return redisCopyPosition{}, nil
+	}
+	parts := strings.SplitN(s, "/", 2)
+	db, err := strconv.Atoi(parts[0])
+	if err != nil || len(parts) != 2 {
+		return redisCopyPosition{}, fmt.Errorf("invalid copy position %q", s)
+	}
+	return redisCopyPosition{database: db, cursor: parts[1]}, nil
+}
+
+// copyRedisKeys copies the keys of all databases from the source to the target, starting at the given position.
+// Keys are copied with their TTL and replace existing keys on the target, so copying can be repeated.
+// Copying stops at the deadline of the context. The position to continue at, the number of copied keys
+// and whether all keys have been copied are returned.
+func copyRedisKeys(ctx context.Context, source, target *redisConn, from redisCopyPosition) (redisCopyPosition, int, bool, error) {
+	dbs, err := redisDatabases(source)
+	if err != nil {
+		return from, 0, false, err
+	}
+	pos, copied := from, 0
+	for _, db := range dbs {
+		if db < pos.database {
+			continue
+		}
+		if db > pos.database {
+			pos = redisCopyPosition{database: db}
+		}
+		for _, c := range []*redisConn{source, target} {
+			if _, err := c.do("SELECT", strconv.Itoa(db)); err != nil {
+				return pos, copied, false, err
+			}
+		}
+		for {
+			if ctx.Err() != nil {
+				return pos, copied, false, nil
+			}
+			next, keys, err := scanRedis(source, pos.scanCursor())
+			if err != nil {
+				return pos, copied, false, err
+			}
+			for _, key := range keys {
+				ok, err := copyRedisKey(source, target, key)
+				if err != nil {
+					return pos, copied, false, fmt.Errorf("copy key %q of database %d: %w", key, db, err)
+				}
+				if ok {
+					copied++
+				}
+			}
+			if next == "0" {
+				break
+			}
+			pos.cursor = next
+		}
+		pos = redisCopyPosition{database: db + 1}
+	}
+	return pos, copied, true, nil
+}
+
+func scanRedis(c *redisConn, cursor string) (string, []string, error) {
+	reply, err := c.do("SCAN", cursor, "COUNT", strconv.Itoa(redisCopyBatchSize))
+	if err != nil {
+		return "", nil, err
+	}
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return "", nil, fmt.Errorf("redis: unexpected SCAN reply %v", reply)
+	}
+	next, ok := values[0].(string)
+	if !ok {
+		return "", nil, fmt.Errorf("redis: unexpected SCAN cursor %v", values[0])
+	}
+	rawKeys, _ := values[1].([]interface{})
+	keys := make([]string, 0, len(rawKeys))
+	for _, k := range rawKeys {
+		if key, ok := k.(string); ok {
+			keys = append(keys, key)
+		}
+	}
+	return next, keys, nil
+}
+
+// copyRedisKey copies a key with its TTL. It returns false if the key expired or has been deleted in the meantime.
+func copyRedisKey(source, target *redisConn, key string) (bool, error) {
+	reply, err := source.do("PTTL", key)
+	if err != nil {
+		return false, err
+	}
+	ttl, _ := reply.(int64)
+	switch {
+	case ttl == -2:
+		return false, nil
+	case ttl < 0:
+		ttl = 0
+	}
+	reply, err = source.do("DUMP", key)
+	if errors.Is(err, errRedisNil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	value, _ := reply.(string)
+	if _, err := target.do("RESTORE", key, strconv.FormatInt(ttl, 10), value, "REPLACE"); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,240 @@
+package crossplane
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedisKey is a key of the fake Redis, its DUMP payload and TTL in milliseconds, -1 without TTL.
+type fakeRedisKey struct {
+	value string
+	ttl   int64
+}
+
+// fakeRedis serves the commands used to copy keys. SCAN returns one key per call.
+type fakeRedis struct {
+	password string
+	listener net.Listener
+
+	mu  sync.Mutex
+	dbs map[int]map[string]fakeRedisKey
+}
+
+func newFakeRedis(t *testing.T, password string, dbs map[int]map[string]fakeRedisKey) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	if dbs == nil {
+		dbs = map[int]map[string]fakeRedisKey{}
+	}
+	r := &fakeRedis{password: password, listener: l, dbs: dbs}
+	t.Cleanup(func() { l.Close() })
+	go r.serve()
+	return r
+}
+
+func (r *fakeRedis) addr() string {
+	return r.listener.Addr().String()
+}
+
+func (r *fakeRedis) port() int32 {
+	return int32(r.listener.Addr().(*net.TCPAddr).Port)
+}
+
+func (r *fakeRedis) keys(db int) map[string]fakeRedisKey {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := map[string]fakeRedisKey{}
+	for k, v := range r.dbs[db] {
+		keys[k] = v
+	}
+	return keys
+}
+
+func (r *fakeRedis) serve() {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		go r.handle(conn)
+	}
+}
+
+func (r *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	authenticated, db := r.password == "", 0
+	for {
+		args, err := readFakeRedisCommand(br)
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(args[0])
+		if cmd == "AUTH" {
+			if len(args) != 2 || args[1] != r.password {
+				fmt.Fprint(conn, "-WRONGPASS invalid password\r\n")
+				continue
+			}
+			authenticated = true
+			fmt.Fprint(conn, "+OK\r\n")
+			continue
+		}
+		if !authenticated {
+			fmt.Fprint(conn, "-NOAUTH Authentication required.\r\n")
+			continue
+		}
+		r.mu.Lock()
+		switch cmd {
+		case "INFO":
+			var b strings.Builder
+			b.WriteString("# Keyspace\r\n")
+			for n, keys := range r.dbs {
+				if len(keys) > 0 {
+					fmt.Fprintf(&b, "db%d:keys=%d,expires=0,avg_ttl=0\r\n", n, len(keys))
+				}
+			}
+			writeFakeRedisBulk(conn, b.String())
+		case "SELECT":
+			db, _ = strconv.Atoi(args[1])
+			fmt.Fprint(conn, "+OK\r\n")
+		case "SCAN":
+			names := []string{}
+			for k := range r.dbs[db] {
+				names = append(names, k)
+			}
+			sort.Strings(names)
+			i, _ := strconv.Atoi(args[1])
+			next, keys := "0", []string{}
+			if i < len(names) {
+				keys = append(keys, names[i])
+				if i+1 < len(names) {
+					next = strconv.Itoa(i + 1)
+				}
+			}
+			fmt.Fprint(conn, "*2\r\n")
+			writeFakeRedisBulk(conn, next)
+			fmt.Fprintf(conn, "*%d\r\n", len(keys))
+			for _, k := range keys {
+				writeFakeRedisBulk(conn, k)
+			}
+		case "PTTL":
+			ttl := int64(-2)
+			if key, ok := r.dbs[db][args[1]]; ok {
+				ttl = key.ttl
+			}
+			fmt.Fprintf(conn, ":%d\r\n", ttl)
+		case "DUMP":
+			key, ok := r.dbs[db][args[1]]
+			if !ok {
+				fmt.Fprint(conn, "$-1\r\n")
+				break
+			}
+			writeFakeRedisBulk(conn, key.value)
+		case "RESTORE":
+			ttl, _ := strconv.ParseInt(args[2], 10, 64)
+			if ttl == 0 {
+				ttl = -1
+			}
+			if r.dbs[db] == nil {
+				r.dbs[db] = map[string]fakeRedisKey{}
+			}
+			r.dbs[db][args[1]] = fakeRedisKey{value: args[3], ttl: ttl}
+			fmt.Fprint(conn, "+OK\r\n")
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+		r.mu.Unlock()
+	}
+}
+
+func readFakeRedisCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(line[1:], "\r\n"))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSuffix(line[1:], "\r\n"))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func writeFakeRedisBulk(w io.Writer, s string) {
+	fmt.Fprintf(w, "$%d\r\n%s\r\n", len(s), s)
+}
+
+func TestCopyRedisKeys(t *testing.T) {
+	source := newFakeRedis(t, "secret", map[int]map[string]fakeRedisKey{
+		0: {
+			"a": {value: "dump-a", ttl: -1},
+			"b": {value: "dump-b", ttl: 60000},
+			"c": {value: "dump-c\r\nbinary", ttl: -1},
+		},
+		3: {
+			"d": {value: "dump-d", ttl: -1},
+		},
+	})
+	target := newFakeRedis(t, "secret", nil)
+	ctx := context.Background()
+
+	_, err := dialRedis(ctx, source.addr(), "wrong")
+	require.Error(t, err)
+
+	sc, err := dialRedis(ctx, source.addr(), "secret")
+	require.NoError(t, err)
+	defer sc.Close()
+	tc, err := dialRedis(ctx, target.addr(), "secret")
+	require.NoError(t, err)
+	defer tc.Close()
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	pos, copied, done, err := copyRedisKeys(cancelled, sc, tc, redisCopyPosition{})
+	require.NoError(t, err)
+	assert.False(t, done, "copying must stop at the deadline")
+	assert.Equal(t, 0, copied)
+	assert.Equal(t, "0/0", pos.String())
+
+	from, err := parseRedisCopyPosition("0/1")
+	require.NoError(t, err)
+	pos, copied, done, err = copyRedisKeys(ctx, sc, tc, from)
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, 3, copied)
+	assert.Equal(t, map[string]fakeRedisKey{
+		"b": {value: "dump-b", ttl: 60000},
+		"c": {value: "dump-c\r\nbinary", ttl: -1},
+	}, target.keys(0), "copying must continue at the position")
+	assert.Equal(t, map[string]fakeRedisKey{"d": {value: "dump-d", ttl: -1}}, target.keys(3))
+	assert.Equal(t, "4/0", pos.String())
+
+	_, err = parseRedisCopyPosition("invalid")
+	assert.Error(t, err)
+}
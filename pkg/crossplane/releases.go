@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	helmv1alpha1 "github.com/crossplane-contrib/provider-helm/apis/release/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 )
 
@@ -16,3 +17,23 @@ func (cp *Crossplane) getRelease(ctx context.Context, releaseName string) (*helm
 	}
 	return release, nil
 }
+
+// namespaceReleases returns one release of each downstream namespace of an instance.
+// The releases of an instance usually share the namespace of the instance on its cluster.
+func (cp *Crossplane) namespaceReleases(ctx context.Context, refs []corev1.ObjectReference) ([]*helmv1alpha1.Release, error) {
+	releases := []*helmv1alpha1.Release{}
+	seen := map[string]bool{}
+	for _, ref := range findResourceRefs(refs, "Release") {
+		release, err := cp.getRelease(ctx, ref.Name)
+		if err != nil {
+			return nil, err
+		}
+		key := providerConfigName(release) + "/" + release.Spec.ForProvider.Namespace
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		releases = append(releases, release)
+	}
+	return releases, nil
+}
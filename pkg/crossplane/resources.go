@@ -50,9 +50,10 @@ func (hpr *HaProxyResource) GetCredentials(ctx context.Context) (interface{}, er
 
 	svc := &corev1.Service{}
 	if err := klient.Get(ctx, types.NamespacedName{
-		Name:      helmHaProxyRelease,
+		Name:      hpr.cp.HaProxyRelease,
 		Namespace: hpr.release.Spec.ForProvider.Namespace,
 	}, svc); err != nil {
+		hpr.cp.Downstream.Failed(providerConfigName(hpr.release), err)
 		return nil, err
 	}
 
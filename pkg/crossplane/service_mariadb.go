@@ -4,15 +4,12 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"strings"
 
 	"code.cloudfoundry.org/lager"
 	runtimev1alpha1 "github.com/crossplane/crossplane-runtime/apis/core/v1alpha1"
 	"github.com/crossplane/crossplane-runtime/pkg/resource/unstructured/composite"
 	"github.com/pivotal-cf/brokerapi/v7/domain/apiresponses"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 const (
@@ -43,7 +40,7 @@ func NewMariadbServiceBinder(c *Crossplane, instance *composite.Unstructured, lo
 func (msb MariadbServiceBinder) FinishProvision(ctx context.Context) error {
 	// FIXME(mw): check if we can merge code with RedisServiceBinder
 	releases := findResourceRefs(msb.resources, "Release")
-	haproxy, err := findRelease(ctx, msb.cp, releases, helmHaProxyRelease)
+	haproxy, err := findRelease(ctx, msb.cp, releases, msb.cp.HaProxyRelease)
 	if err != nil {
 		return err
 	}
@@ -54,7 +51,7 @@ func (msb MariadbServiceBinder) FinishProvision(ctx context.Context) error {
 	}
 	hcreds := hc.(*HaProxyCredentials)
 
-	s, err := msb.cp.getSecret(ctx, spksNamespace, msb.instanceID)
+	s, err := msb.cp.getSecret(ctx, msb.cp.Namespace, msb.instanceID)
 	if err != nil {
 		return err
 	}
@@ -64,7 +61,7 @@ func (msb MariadbServiceBinder) FinishProvision(ctx context.Context) error {
 	}
 
 	if string(s.Data[runtimev1alpha1.ResourceCredentialsSecretEndpointKey]) != hcreds.Host {
-		msb.logger.Info("update-secret", lager.Data{"endpoint": hcreds.Host, "namespace": spksNamespace})
+		msb.logger.Info("update-secret", lager.Data{"endpoint": hcreds.Host, "namespace": msb.cp.Namespace})
 		s.Data[runtimev1alpha1.ResourceCredentialsSecretEndpointKey] = []byte(hcreds.Host)
 		if err := msb.cp.Client.Update(ctx, s); err != nil {
 			return err
@@ -100,26 +97,7 @@ func (msb MariadbServiceBinder) Endpoints(ctx context.Context, instanceID string
 	return []Endpoint{}, nil
 }
 
-// Deprovision removes the downstream namespace and checks if no DBs exist for this instance anymore.
+// Deprovision removes the downstream namespace.
 func (msb MariadbServiceBinder) Deprovision(ctx context.Context) error {
-	instanceList := &unstructured.UnstructuredList{}
-	instanceList.SetGroupVersionKind(groupVersionKind)
-	instanceList.SetKind("CompositeMariaDBDatabaseInstanceList")
-	if err := msb.cp.Client.List(ctx, instanceList, client.MatchingLabels{
-		ParentIDLabel: msb.instanceID,
-	}); err != nil {
-		return err
-	}
-	if len(instanceList.Items) > 0 {
-		var instances []string
-		for _, instance := range instanceList.Items {
-			instances = append(instances, instance.GetName())
-		}
-		return apiresponses.NewFailureResponseBuilder(
-			fmt.Errorf("instance is still in use by %q", strings.Join(instances, ", ")),
-			http.StatusUnprocessableEntity,
-			"deprovision-instance-in-use",
-		).WithErrorKey("InUseError").Build()
-	}
 	return markNamespaceDeleted(ctx, msb.cp, msb.instanceID, msb.resources)
 }
@@ -59,7 +59,7 @@ func (msb MariadbDatabaseServiceBinder) Bind(ctx context.Context, bindingID stri
 	}
 
 	// In order to directly return the credentials we need to get the IP/port for this instance.
-	secret, err := msb.cp.getSecret(ctx, spksNamespace, parentRef)
+	secret, err := msb.cp.getSecret(ctx, msb.cp.Namespace, parentRef)
 	if err != nil {
 		if k8serrors.IsNotFound(err) {
 			err = ErrInstanceNotReady
@@ -79,7 +79,7 @@ func (msb MariadbDatabaseServiceBinder) Bind(ctx context.Context, bindingID stri
 
 // GetBinding returns credentials for MariaDB
 func (msb MariadbDatabaseServiceBinder) GetBinding(ctx context.Context, bindingID string) (Credentials, error) {
-	us, err := msb.cp.getSecret(ctx, spksNamespace, bindingID)
+	us, err := msb.cp.getSecret(ctx, msb.cp.Namespace, bindingID)
 	if err != nil {
 		if k8serrors.IsNotFound(err) {
 			err = apiresponses.ErrBindingNotFound
@@ -117,7 +117,7 @@ func (msb MariadbDatabaseServiceBinder) Endpoints(ctx context.Context, instanceI
 		return nil, err
 	}
 
-	secret, err := msb.cp.getSecret(ctx, spksNamespace, parentRef)
+	secret, err := msb.cp.getSecret(ctx, msb.cp.Namespace, parentRef)
 	if err != nil {
 		if k8serrors.IsNotFound(err) {
 			err = ErrInstanceNotReady
@@ -147,6 +147,42 @@ func (msb MariadbDatabaseServiceBinder) parseDBInstance() (string, error) {
 	return parentReference, nil
 }
 
+// validateMariadbDatabaseParent checks that the parent of a database is a ready MariaDB cluster with capacity for another database.
+func (cp *Crossplane) validateMariadbDatabaseParent(ctx context.Context, parent *composite.Unstructured) error {
+	if service := parent.GetLabels()[ServiceNameLabel]; service != serviceMariadb {
+		return fmt.Errorf("%w: instance %q is a %s instance, not %s", ErrInvalidParent, parent.GetName(), service, serviceMariadb)
+	}
+	if parent.GetCondition(runtimev1alpha1.TypeReady).Status != corev1.ConditionTrue {
+		return fmt.Errorf("%w: instance %q is not ready", ErrInvalidParent, parent.GetName())
+	}
+
+	max := cp.MariadbMaxDatabases
+	if ref := parent.GetCompositionReference(); ref != nil {
+		plan, err := cp.GetPlan(ctx, ref.Name)
+		if err != nil {
+			return err
+		}
+		if v, ok := plan.Annotations[MaxDatabasesAnnotation]; ok {
+			max, err = strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("invalid annotation %s of plan %q: %w", MaxDatabasesAnnotation, plan.Name, err)
+			}
+		}
+	}
+	if max <= 0 {
+		return nil
+	}
+
+	databases, err := cp.ChildInstances(ctx, parent.GetName())
+	if err != nil {
+		return err
+	}
+	if len(databases) >= max {
+		return fmt.Errorf("%w: instance %q has no capacity for another database, it already has %d of %d databases", ErrInvalidParent, parent.GetName(), len(databases), max)
+	}
+	return nil
+}
+
 func mapMariadbEndpoint(data map[string][]byte) (*Endpoint, error) {
 	hostBytes, ok := data[runtimev1alpha1.ResourceCredentialsSecretEndpointKey]
 	if !ok {
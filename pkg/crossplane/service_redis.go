@@ -51,7 +51,7 @@ func (rsb RedisServiceBinder) GetBinding(ctx context.Context, _ string) (Credent
 	if len(secrets) != 1 {
 		return nil, errors.New("resourceRef contains more than one secret")
 	}
-	sr := NewSecretResource(spksNamespace, secrets[0], rsb.cp)
+	sr := NewSecretResource(rsb.cp.Namespace, secrets[0], rsb.cp)
 	sc, err := sr.GetCredentials(ctx)
 	if err != nil {
 		return nil, err
@@ -59,7 +59,7 @@ func (rsb RedisServiceBinder) GetBinding(ctx context.Context, _ string) (Credent
 	creds[runtimev1alpha1.ResourceCredentialsSecretPasswordKey] = sc.(*SecretCredentials).Password
 
 	releases := findResourceRefs(rsb.resources, "Release")
-	haproxy, err := findRelease(ctx, rsb.cp, releases, helmHaProxyRelease)
+	haproxy, err := findRelease(ctx, rsb.cp, releases, rsb.cp.HaProxyRelease)
 	if err != nil {
 		return nil, err
 	}
@@ -88,7 +88,7 @@ func (rsb RedisServiceBinder) Unbind(ctx context.Context, bindingID string) erro
 // Endpoints retrieves host/port/protocol for the redis instance.
 func (rsb RedisServiceBinder) Endpoints(ctx context.Context, instanceID string) ([]Endpoint, error) {
 	releases := findResourceRefs(rsb.resources, "Release")
-	haproxy, err := findRelease(ctx, rsb.cp, releases, helmHaProxyRelease)
+	haproxy, err := findRelease(ctx, rsb.cp, releases, rsb.cp.HaProxyRelease)
 	if err != nil {
 		return nil, err
 	}
@@ -19,11 +19,6 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-const (
-	helmHaProxyRelease = "haproxy"
-	spksNamespace      = "spks-crossplane"
-)
-
 var (
 	// ErrNotImplemented is the error returned for not implmemented functions
 	ErrNotImplemented = apiresponses.
@@ -41,7 +36,7 @@ var ErrInstanceNotReady = errors.New("instance not ready")
 func (cp *Crossplane) getServices(ctx context.Context) ([]v1beta1.CompositeResourceDefinition, error) {
 	xrds := &v1beta1.CompositeResourceDefinitionList{}
 
-	req, err := labels.NewRequirement(ServiceIDLabel, selection.In, cp.ServiceIDs)
+	req, err := labels.NewRequirement(ServiceIDLabel, selection.In, cp.ServiceIDs())
 	if err != nil {
 		return nil, err
 	}
@@ -126,6 +121,31 @@ func findPort(ports []Port, name string) (int32, error) {
 func markNamespaceDeleted(ctx context.Context, c *Crossplane, instanceID string, refs []corev1.ObjectReference) error {
 	c.logger.Debug("mark namespace deleted", lager.Data{"instance-id": instanceID})
 
+	err := patchNamespace(ctx, c, instanceID, refs, markDeleted)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Debug("success marking namespace deleted", lager.Data{"instance-id": instanceID})
+
+	return nil
+}
+
+// markDeleted labels a namespace to be cleaned up.
+func markDeleted(ns *corev1.Namespace) {
+	if ns.Labels == nil {
+		ns.Labels = map[string]string{}
+	}
+	if ns.Annotations == nil {
+		ns.Annotations = map[string]string{}
+	}
+	ns.Labels[DeletedLabel] = "true"
+	ns.Annotations[DeletionTimestampAnnotation] = metav1.NowMicro().UTC().Format(metav1.RFC3339Micro)
+}
+
+// patchNamespace applies the changes done by mutate to the namespace of an instance on its downstream cluster.
+// The downstream cluster is the one the first helm release of the instance is deployed to.
+func patchNamespace(ctx context.Context, c *Crossplane, instanceID string, refs []corev1.ObjectReference, mutate func(ns *corev1.Namespace)) error {
 	releases := findResourceRefs(refs, "Release")
 	if len(releases) <= 0 {
 		return fmt.Errorf("no releases found for instance %q", instanceID)
@@ -146,6 +166,7 @@ func markNamespaceDeleted(ctx context.Context, c *Crossplane, instanceID string,
 	}
 	ns := corev1.Namespace{}
 	if err := klient.Get(ctx, types.NamespacedName{Name: instanceID}, &ns); err != nil {
+		c.Downstream.Failed(providerConfigName(release), err)
 		return fmt.Errorf("get namespace(%q): %w", instanceID, err)
 	}
 
@@ -155,15 +176,11 @@ func markNamespaceDeleted(ctx context.Context, c *Crossplane, instanceID string,
 	if ns.Annotations == nil {
 		ns.Annotations = map[string]string{}
 	}
-
-	ns.Labels[DeletedLabel] = "true"
-	ns.Annotations[DeletionTimestampAnnotation] = metav1.NowMicro().UTC().Format(metav1.RFC3339Micro)
+	mutate(&ns)
 
 	if err := klient.Patch(ctx, &ns, client.Merge); err != nil {
+		c.Downstream.Failed(providerConfigName(release), err)
 		return fmt.Errorf("patch namespace(%q): %w", instanceID, err)
 	}
-
-	c.logger.Debug("success marking namespace deleted", lager.Data{"instance-id": instanceID})
-
 	return nil
 }
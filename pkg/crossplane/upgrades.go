@@ -0,0 +1,314 @@
+package crossplane
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"broker/pkg/maintenance"
+
+	"code.cloudfoundry.org/lager"
+	helmv1alpha1 "github.com/crossplane-contrib/provider-helm/apis/release/v1alpha1"
+	runtimev1alpha1 "github.com/crossplane/crossplane-runtime/apis/core/v1alpha1"
+	"github.com/crossplane/crossplane-runtime/pkg/fieldpath"
+	"github.com/crossplane/crossplane-runtime/pkg/resource/unstructured/composite"
+	"github.com/crossplane/crossplane/apis/apiextensions/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// InstanceSpecChartVersionsPath is the path to the chart versions of an instance, keyed by chart name.
+	// Crossplane renders the releases of an instance from its composition again and again,
+	// compositions patch the versions into their releases so upgrades aren't reverted.
+	InstanceSpecChartVersionsPath = "spec.chartVersions"
+
+	// instanceParamsMaintenanceWindowName is the name of the parameter setting the maintenance window of an instance
+	instanceParamsMaintenanceWindowName = "maintenance_window"
+)
+
+// ErrInvalidMaintenanceWindow is returned if the maintenance window in the parameters of an instance can't be parsed.
+var ErrInvalidMaintenanceWindow = errors.New("invalid maintenance window")
+
+// ChartUpgrade is the upgrade of the chart of one release of an instance.
+type ChartUpgrade struct {
+	Chart       string `json:"chart"`
+	FromVersion string `json:"from_version"`
+	ToVersion   string `json:"to_version"`
+}
+
+// Upgrade is an upgrade of the charts of an instance.
+type Upgrade struct {
+	Charts []ChartUpgrade `json:"charts"`
+	// Time the upgrade has been done at or, for pending upgrades, the start of the next maintenance window.
+	// Pending upgrades of instances without maintenance window aren't scheduled and have a zero time.
+	Time time.Time `json:"time"`
+}
+
+// UpgradeStatus is the pending and the last upgrade of an instance.
+type UpgradeStatus struct {
+	MaintenanceWindow string
+	// Pending is nil if the instance runs the chart versions of its plan.
+	Pending *Upgrade
+	// Last is nil if the instance has never been upgraded.
+	Last *Upgrade
+}
+
+// pendingRelease is a release of an instance which isn't at the chart version of its plan.
+type pendingRelease struct {
+	release *helmv1alpha1.Release
+	upgrade ChartUpgrade
+}
+
+// MaintenanceWindowParameter returns the maintenance window set in the parameters of an instance in its normalized form.
+// It returns an empty string if the parameters don't set a window.
+func MaintenanceWindowParameter(parameters json.RawMessage) (string, error) {
+	if len(parameters) == 0 {
+		return "", nil
+	}
+	params := map[string]interface{}{}
+	if err := json.Unmarshal(parameters, &params); err != nil {
+		return "", fmt.Errorf("%w: parameters must be an object: %s", ErrInvalidMaintenanceWindow, err)
+	}
+	if _, ok := params[instanceParamsMaintenanceWindowName]; !ok {
+		return "", nil
+	}
+	s, err := fieldpath.Pave(params).GetString(instanceParamsMaintenanceWindowName)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidMaintenanceWindow, err)
+	}
+	w, err := maintenance.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidMaintenanceWindow, err)
+	}
+	return w.String(), nil
+}
+
+// SetMaintenanceWindow stores the maintenance window on an instance.
+func (cp *Crossplane) SetMaintenanceWindow(ctx context.Context, instanceID, window string) error {
+	annotations := map[string]string{MaintenanceWindowAnnotation: window}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		instance, err := cp.GetInstance(ctx, instanceID)
+		if err != nil {
+			return err
+		}
+		if hasMetadata(instance, nil, annotations) {
+			return nil
+		}
+		return cp.patchInstance(ctx, instance, func() {
+			mergeMetadata(instance, nil, annotations)
+		})
+	})
+}
+
+// maintenanceWindow returns the maintenance window of an instance, or the default one if it has none.
+// The window is empty if there is neither.
+func (cp *Crossplane) maintenanceWindow(instance *composite.Unstructured) (string, *maintenance.Window, error) {
+	s := instance.GetAnnotations()[MaintenanceWindowAnnotation]
+	if s == "" {
+		s = cp.MaintenanceWindow
+	}
+	if s == "" {
+		return "", nil, nil
+	}
+	w, err := maintenance.Parse(s)
+	if err != nil {
+		return s, nil, err
+	}
+	return s, &w, nil
+}
+
+// GetUpgradeStatus returns the pending and the last upgrade of an instance.
+func (cp *Crossplane) GetUpgradeStatus(ctx context.Context, instanceID string, now time.Time) (*UpgradeStatus, error) {
+	instance, err := cp.GetInstance(ctx, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	status := &UpgradeStatus{}
+	s, window, err := cp.maintenanceWindow(instance)
+	if err != nil {
+		return nil, err
+	}
+	status.MaintenanceWindow = s
+
+	pending, err := cp.pendingReleases(ctx, instance)
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) > 0 {
+		status.Pending = &Upgrade{Charts: make([]ChartUpgrade, 0, len(pending))}
+		for _, p := range pending {
+			status.Pending.Charts = append(status.Pending.Charts, p.upgrade)
+		}
+		if window != nil {
+			status.Pending.Time = window.Next(now)
+		}
+	}
+
+	if last, ok := instance.GetAnnotations()[LastUpgradeAnnotation]; ok {
+		status.Last = &Upgrade{}
+		if err := json.Unmarshal([]byte(last), status.Last); err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: %w", LastUpgradeAnnotation, err)
+		}
+	}
+	return status, nil
+}
+
+// pendingReleases returns the releases of an instance which aren't at the chart version of its plan, sorted by chart.
+func (cp *Crossplane) pendingReleases(ctx context.Context, instance *composite.Unstructured) ([]pendingRelease, error) {
+	ref := instance.GetCompositionReference()
+	if ref == nil {
+		return nil, nil
+	}
+	plan, err := cp.GetPlan(ctx, ref.Name)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := planChartVersions(plan)
+	if err != nil || len(versions) == 0 {
+		return nil, err
+	}
+
+	pending := []pendingRelease{}
+	for _, ref := range findResourceRefs(instance.GetResourceReferences(), "Release") {
+		release, err := cp.getRelease(ctx, ref.Name)
+		if err != nil {
+			return nil, err
+		}
+		chart := release.Spec.ForProvider.Chart
+		if v, ok := versions[chart.Name]; ok && v != chart.Version {
+			pending = append(pending, pendingRelease{
+				release: release,
+				upgrade: ChartUpgrade{Chart: chart.Name, FromVersion: chart.Version, ToVersion: v},
+			})
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].upgrade.Chart < pending[j].upgrade.Chart
+	})
+	return pending, nil
+}
+
+// planChartVersions returns the chart versions set by the annotation of a plan.
+func planChartVersions(plan *v1beta1.Composition) (map[string]string, error) {
+	versions := map[string]string{}
+	v, ok := plan.Annotations[ChartVersionsAnnotation]
+	if !ok {
+		return versions, nil
+	}
+	if err := json.Unmarshal([]byte(v), &versions); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation of plan %q: %w", ChartVersionsAnnotation, plan.Name, err)
+	}
+	return versions, nil
+}
+
+// RunUpgrader upgrades the instances in their maintenance window in the given interval until the context is done.
+func (cp *Crossplane) RunUpgrader(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if _, err := cp.UpgradeInstances(ctx, time.Now()); err != nil {
+				cp.logger.Error("upgrade-instances", err)
+			}
+		}
+	}
+}
+
+// UpgradeInstances upgrades the charts of all ready instances within their maintenance window to the versions of their plans.
+// It returns the IDs of the upgraded instances. Instances failing to upgrade are logged and retried in the next run.
+func (cp *Crossplane) UpgradeInstances(ctx context.Context, now time.Time) ([]string, error) {
+	instances, err := cp.ListInstances(ctx, InstanceFilter{})
+	if err != nil {
+		return nil, err
+	}
+	upgraded := []string{}
+	for _, instance := range instances {
+		logger := cp.logger.WithData(lager.Data{"instance": instance.GetName()})
+		if instance.GetDeletionTimestamp() != nil || instance.GetCondition(runtimev1alpha1.TypeReady).Status != corev1.ConditionTrue {
+			continue
+		}
+		_, window, err := cp.maintenanceWindow(instance)
+		if err != nil {
+			logger.Error("invalid-maintenance-window", err)
+			continue
+		}
+		if window == nil || !window.Contains(now) {
+			continue
+		}
+		if migrating, err := cp.IsMigrating(ctx, instance.GetName()); err != nil || migrating {
+			continue
+		}
+		pending, err := cp.pendingReleases(ctx, instance)
+		if err != nil {
+			logger.Error("pending-upgrades", err)
+			continue
+		}
+		if len(pending) == 0 {
+			continue
+		}
+		if err := cp.upgradeInstance(ctx, instance.GetName(), pending, now); err != nil {
+			logger.Error("upgrade-instance", err)
+			continue
+		}
+		upgraded = append(upgraded, instance.GetName())
+	}
+	return upgraded, nil
+}
+
+// upgradeInstance sets the new chart versions on the composite and its releases and records the upgrade.
+// The composite is changed first, so Crossplane doesn't revert the releases to the old versions.
+func (cp *Crossplane) upgradeInstance(ctx context.Context, instanceID string, pending []pendingRelease, now time.Time) error {
+	last := Upgrade{Charts: make([]ChartUpgrade, 0, len(pending)), Time: now.UTC()}
+	for _, p := range pending {
+		last.Charts = append(last.Charts, p.upgrade)
+	}
+	lastJSON, err := json.Marshal(last)
+	if err != nil {
+		return err
+	}
+
+	cp.logger.Info("upgrade-instance", lager.Data{"instance": instanceID, "charts": last.Charts})
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		instance, err := cp.GetInstance(ctx, instanceID)
+		if err != nil {
+			return err
+		}
+		var setErr error
+		err = cp.patchInstance(ctx, instance, func() {
+			p := fieldpath.Pave(instance.Object)
+			for _, u := range last.Charts {
+				if err := p.SetString(InstanceSpecChartVersionsPath+"["+u.Chart+"]", u.ToVersion); err != nil {
+					setErr = err
+				}
+			}
+			mergeMetadata(instance, nil, map[string]string{LastUpgradeAnnotation: string(lastJSON)})
+		})
+		if setErr != nil {
+			return setErr
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, p := range pending {
+		patch := client.MergeFrom(p.release.DeepCopy())
+		p.release.Spec.ForProvider.Chart.Version = p.upgrade.ToVersion
+		if err := cp.Client.Patch(ctx, p.release, patch, client.FieldOwner(FieldManager)); err != nil {
+			return fmt.Errorf("upgrade release %q: %w", p.release.Name, err)
+		}
+	}
+	return nil
+}
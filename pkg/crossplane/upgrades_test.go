@@ -0,0 +1,151 @@
+package crossplane
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	helmv1alpha1 "github.com/crossplane-contrib/provider-helm/apis/release/v1alpha1"
+	runtimev1alpha1 "github.com/crossplane/crossplane-runtime/apis/core/v1alpha1"
+	"github.com/crossplane/crossplane-runtime/pkg/fieldpath"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestMaintenanceWindowParameter(t *testing.T) {
+	tests := map[string]struct {
+		parameters string
+		want       string
+		err        bool
+	}{
+		"no parameters":    {},
+		"no window":        {parameters: `{"region": "eu"}`},
+		"window":           {parameters: `{"maintenance_window": "Sun 02:00-04:00"}`, want: "sun 02:00-04:00"},
+		"invalid window":   {parameters: `{"maintenance_window": "sunday"}`, err: true},
+		"not a string":     {parameters: `{"maintenance_window": 2}`, err: true},
+		"not an object":    {parameters: `[]`, err: true},
+		"daily window":     {parameters: `{"maintenance_window": "23:00-01:00"}`, want: "23:00-01:00"},
+		"other parameters": {parameters: `{"parent_reference": "parent", "maintenance_window": "mon 01:00-02:00"}`, want: "mon 01:00-02:00"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var parameters json.RawMessage
+			if tt.parameters != "" {
+				parameters = json.RawMessage(tt.parameters)
+			}
+			window, err := MaintenanceWindowParameter(parameters)
+			if tt.err {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, ErrInvalidMaintenanceWindow))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, window)
+		})
+	}
+}
+
+func TestUpgradeInstances(t *testing.T) {
+	plan := newPlan("upgradable", SLAStandard)
+	plan.SetAnnotations(map[string]string{ChartVersionsAnnotation: `{"redis": "12.2.0", "haproxy": "1.1.2"}`})
+
+	newRelease := func(name, chart, version string) *helmv1alpha1.Release {
+		return &helmv1alpha1.Release{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: helmv1alpha1.ReleaseSpec{
+				ForProvider: helmv1alpha1.ReleaseParameters{
+					Chart: helmv1alpha1.ChartSpec{Name: chart, Version: version},
+				},
+			},
+		}
+	}
+	instance := newInstance("instance", map[string]string{PlanNameLabel: "upgradable"})
+	instance.SetCompositionReference(&corev1.ObjectReference{Name: "upgradable"})
+	instance.SetResourceReferences([]corev1.ObjectReference{
+		{Kind: "Release", Name: "instance-redis"},
+		{Kind: "Release", Name: "instance-haproxy"},
+	})
+	instance.SetAnnotations(map[string]string{MaintenanceWindowAnnotation: "sun 02:00-04:00"})
+	instance.SetConditions(runtimev1alpha1.Available())
+	instance.SetResourceVersion("1")
+
+	// The default window doesn't apply to instances with their own.
+	withoutWindow := newInstance("without-window", map[string]string{PlanNameLabel: "upgradable"})
+	withoutWindow.SetCompositionReference(&corev1.ObjectReference{Name: "upgradable"})
+	withoutWindow.SetResourceReferences([]corev1.ObjectReference{{Kind: "Release", Name: "without-window-redis"}})
+	withoutWindow.SetConditions(runtimev1alpha1.Available())
+	withoutWindow.SetResourceVersion("1")
+
+	cp := newParentTestCrossplane(t, plan, instance, withoutWindow,
+		newRelease("instance-redis", "redis", "12.1.1"),
+		newRelease("instance-haproxy", "haproxy", "1.1.2"),
+		newRelease("without-window-redis", "redis", "12.1.1"),
+	)
+	cp.MaintenanceWindow = "mon 02:00-04:00"
+	ctx := context.Background()
+	// 2020-11-01 is a sunday.
+	sunday := time.Date(2020, 11, 1, 3, 0, 0, 0, time.UTC)
+
+	status, err := cp.GetUpgradeStatus(ctx, "instance", sunday.Add(-2*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, &UpgradeStatus{
+		MaintenanceWindow: "sun 02:00-04:00",
+		Pending: &Upgrade{
+			Charts: []ChartUpgrade{{Chart: "redis", FromVersion: "12.1.1", ToVersion: "12.2.0"}},
+			Time:   sunday.Add(-time.Hour),
+		},
+	}, status)
+
+	upgraded, err := cp.UpgradeInstances(ctx, sunday.Add(-2*time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, upgraded, "instances must only be upgraded in their window")
+
+	upgraded, err = cp.UpgradeInstances(ctx, sunday)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"instance"}, upgraded)
+
+	release := &helmv1alpha1.Release{}
+	require.NoError(t, cp.Client.Get(ctx, types.NamespacedName{Name: "instance-redis"}, release))
+	assert.Equal(t, "12.2.0", release.Spec.ForProvider.Chart.Version)
+	require.NoError(t, cp.Client.Get(ctx, types.NamespacedName{Name: "without-window-redis"}, release))
+	assert.Equal(t, "12.1.1", release.Spec.ForProvider.Chart.Version)
+
+	updated, err := cp.GetInstance(ctx, "instance")
+	require.NoError(t, err)
+	version, err := fieldpath.Pave(updated.Object).GetString(InstanceSpecChartVersionsPath + "[redis]")
+	require.NoError(t, err)
+	assert.Equal(t, "12.2.0", version, "the version must be set on the composite, otherwise Crossplane reverts it")
+
+	status, err = cp.GetUpgradeStatus(ctx, "instance", sunday)
+	require.NoError(t, err)
+	assert.Nil(t, status.Pending)
+	assert.Equal(t, &Upgrade{
+		Charts: []ChartUpgrade{{Chart: "redis", FromVersion: "12.1.1", ToVersion: "12.2.0"}},
+		Time:   sunday,
+	}, status.Last)
+
+	status, err = cp.GetUpgradeStatus(ctx, "without-window", sunday)
+	require.NoError(t, err)
+	assert.Equal(t, "mon 02:00-04:00", status.MaintenanceWindow)
+	require.NotNil(t, status.Pending)
+	assert.Equal(t, sunday.Add(23*time.Hour), status.Pending.Time)
+	assert.Nil(t, status.Last)
+}
+
+func TestSetMaintenanceWindow(t *testing.T) {
+	instance := newInstance("instance", nil)
+	instance.SetResourceVersion("1")
+	cp := newParentTestCrossplane(t, instance)
+
+	require.NoError(t, cp.SetMaintenanceWindow(context.Background(), "instance", "sun 02:00-04:00"))
+	updated, err := cp.GetInstance(context.Background(), "instance")
+	require.NoError(t, err)
+	assert.Equal(t, "sun 02:00-04:00", updated.GetAnnotations()[MaintenanceWindowAnnotation])
+
+	assert.Equal(t, ErrInstanceNotFound, cp.SetMaintenanceWindow(context.Background(), "unknown", "sun 02:00-04:00"))
+}
@@ -0,0 +1,51 @@
+//go:build integration
+// +build integration
+
+package crossplanebroker_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"broker/pkg/conformance"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConformance(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go env.reconciler.run(ctx, 100*time.Millisecond)
+
+	srv := httptest.NewServer(brokerapi.New(env.broker, lager.NewLogger("conformance"), brokerapi.BrokerCredentials{
+		Username: "test",
+		Password: "test",
+	}))
+	defer srv.Close()
+
+	report, err := conformance.NewRunner(conformance.Config{
+		URL:          srv.URL,
+		Username:     "test",
+		Password:     "test",
+		APIVersion:   "2.14",
+		ServiceID:    "redis-k8s",
+		PlanID:       "redis-small",
+		UpdatePlanID: "redis-small-premium",
+		PollInterval: 100 * time.Millisecond,
+		Timeout:      30 * time.Second,
+	}, srv.Client()).Run(ctx)
+	require.NoError(t, err)
+
+	for _, res := range report.Results {
+		t.Logf("%s: %s %s", res.Name, res.Status, res.Error)
+		assert.Equal(t, conformance.StatusPassed, res.Status, "%s: %s", res.Name, res.Error)
+	}
+	assert.True(t, report.Succeeded(), "%d scenarios failed", report.Failed)
+	assert.Zero(t, report.Skipped, "all scenarios must run")
+	assert.Empty(t, report.Cleanup)
+}
@@ -3,13 +3,20 @@ package crossplanebroker
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 
+	"broker/pkg/auth"
+	"broker/pkg/config"
 	"broker/pkg/crossplane"
+	"broker/pkg/dashboard"
 
 	"code.cloudfoundry.org/lager"
 	"github.com/crossplane/crossplane-runtime/apis/core/v1alpha1"
 	"github.com/crossplane/crossplane-runtime/pkg/fieldpath"
+	"github.com/crossplane/crossplane-runtime/pkg/resource/unstructured/composite"
+	"github.com/crossplane/crossplane/apis/apiextensions/v1beta1"
 	"github.com/pivotal-cf/brokerapi/v7/domain"
 	"github.com/pivotal-cf/brokerapi/v7/domain/apiresponses"
 	"github.com/pivotal-cf/brokerapi/v7/middlewares"
@@ -19,13 +26,17 @@ import (
 // CrossplaneBroker implements the Crossplane service broker
 type CrossplaneBroker struct {
 	c      *crossplane.Crossplane
+	locks  *instanceLocks
 	logger lager.Logger
+	// Dashboard builds the dashboard URLs of instances. Nil disables dashboards.
+	Dashboard *dashboard.Dashboard
 }
 
 // New is the constructor for Crossplane
 func New(c *crossplane.Crossplane, logger lager.Logger) (*CrossplaneBroker, error) {
 	return &CrossplaneBroker{
 		c:      c,
+		locks:  newInstanceLocks(),
 		logger: logger,
 	}, nil
 }
@@ -47,6 +58,12 @@ func (b *CrossplaneBroker) Provision(ctx context.Context, instanceID string, det
 		return spec, apiresponses.ErrAsyncRequired
 	}
 
+	unlock, ok := b.locks.tryLock(instanceID)
+	if !ok {
+		return spec, apiresponses.ErrConcurrentInstanceAccess
+	}
+	defer unlock()
+
 	plan, err := b.c.GetPlan(ctx, details.PlanID)
 	if err != nil {
 		return spec, crossplane.ConvertError(ctx, err)
@@ -67,13 +84,82 @@ func (b *CrossplaneBroker) Provision(ctx context.Context, instanceID string, det
 		return spec, apiresponses.ErrInstanceAlreadyExists
 	}
 
-	err = b.c.CreateInstance(ctx, instanceID, details.RawParameters, plan)
+	labels := map[string]string{}
+	if p, ok := auth.PrincipalFromContext(ctx); ok && p.Tenant != "" {
+		labels[crossplane.TenantLabel] = p.Tenant
+	}
+	instanceContext, err := crossplane.ParseInstanceContext(details.RawContext)
+	if err != nil {
+		return spec, crossplane.ConvertError(ctx, invalidContextError(err))
+	}
+	if instanceContext.OrganizationGUID == "" {
+		instanceContext.OrganizationGUID = details.OrganizationGUID
+	}
+	if instanceContext.SpaceGUID == "" {
+		instanceContext.SpaceGUID = details.SpaceGUID
+	}
+	contextLabels, err := instanceContext.Labels()
+	if err != nil {
+		return spec, crossplane.ConvertError(ctx, invalidContextError(err))
+	}
+	for k, v := range contextLabels {
+		labels[k] = v
+	}
+
+	parentReference, err := crossplane.ParentReference(details.RawParameters)
+	if err != nil {
+		return spec, crossplane.ConvertError(ctx, invalidParentError(err))
+	}
+	if parentReference != "" && parentReference != instanceID {
+		// Children are checked against the capacity of their parent, provision them one after another.
+		unlockParent, ok := b.locks.tryLock(parentReference)
+		if !ok {
+			return spec, apiresponses.ErrConcurrentInstanceAccess
+		}
+		defer unlockParent()
+	}
+	if err := b.c.ValidateParentReference(ctx, plan, details.RawParameters, labels); err != nil {
+		return spec, crossplane.ConvertError(ctx, invalidParentError(err))
+	}
+	unlockQuota, err := b.lockQuota(ctx, instanceContext.OrganizationGUID)
+	if err != nil {
+		return spec, crossplane.ConvertError(ctx, err)
+	}
+	defer unlockQuota()
+	if err := b.c.CheckQuota(ctx, instanceID, plan, instanceContext.OrganizationGUID, instanceContext.SpaceGUID); err != nil {
+		return spec, crossplane.ConvertError(ctx, quotaError(err))
+	}
+	unlockPlacement, err := b.lockPlacement(ctx, plan, parentReference)
+	if err != nil {
+		return spec, crossplane.ConvertError(ctx, err)
+	}
+	defer unlockPlacement()
+	cluster, err := b.c.PlaceInstance(ctx, plan, details.RawParameters)
+	if err != nil {
+		return spec, crossplane.ConvertError(ctx, placementError(err))
+	}
+	if cluster != "" {
+		labels[crossplane.ClusterLabel] = cluster
+		logger.Info("place-instance", lager.Data{"cluster": cluster})
+	}
+
+	annotations := instanceContext.Annotations()
+	window, err := crossplane.MaintenanceWindowParameter(details.RawParameters)
+	if err != nil {
+		return spec, crossplane.ConvertError(ctx, invalidMaintenanceWindowError(err))
+	}
+	if window != "" {
+		annotations[crossplane.MaintenanceWindowAnnotation] = window
+	}
+
+	err = b.c.CreateInstance(ctx, instanceID, details.RawParameters, plan, labels, annotations)
 	if err != nil {
 		return spec, crossplane.ConvertError(ctx, err)
 	}
 
 	return domain.ProvisionedServiceSpec{
-		IsAsync: true,
+		IsAsync:      true,
+		DashboardURL: b.Dashboard.URL(instanceID),
 	}, nil
 }
 
@@ -84,6 +170,15 @@ func (b *CrossplaneBroker) Deprovision(ctx context.Context, instanceID string, d
 
 	spec := domain.DeprovisionServiceSpec{}
 
+	unlock, ok := b.locks.tryLock(instanceID)
+	if !ok {
+		return spec, apiresponses.ErrConcurrentInstanceAccess
+	}
+	defer unlock()
+	if err := b.checkNotMigrating(ctx, instanceID); err != nil {
+		return spec, err
+	}
+
 	plan, err := b.c.GetPlan(ctx, details.PlanID)
 	if err != nil {
 		return spec, crossplane.ConvertError(ctx, err)
@@ -97,14 +192,62 @@ func (b *CrossplaneBroker) Deprovision(ctx context.Context, instanceID string, d
 		return spec, apiresponses.ErrInstanceDoesNotExist
 	}
 
+	children, err := b.c.ChildInstances(ctx, instanceID)
+	if err != nil {
+		return spec, crossplane.ConvertError(ctx, err)
+	}
+	if len(children) > 0 {
+		return spec, crossplane.ConvertError(ctx, apiresponses.NewFailureResponseBuilder(
+			fmt.Errorf("instance is still in use by %q", strings.Join(children, ", ")),
+			http.StatusUnprocessableEntity,
+			"deprovision-instance-in-use",
+		).WithErrorKey("InUseError").Build())
+	}
+
 	sb, err := crossplane.ServiceBinderFactory(b.c, instance, logger)
 	if err != nil {
 		return spec, crossplane.ConvertError(ctx, err)
 	}
+
+	bindings, err := b.c.ListBindings(ctx, instanceID)
+	if err != nil {
+		return spec, crossplane.ConvertError(ctx, err)
+	}
+	if len(bindings) > 0 {
+		if b.c.DeprovisionBindings != config.DeprovisionBindingsCascade {
+			ids := make([]string, 0, len(bindings))
+			for _, binding := range bindings {
+				ids = append(ids, binding.ID)
+			}
+			return spec, crossplane.ConvertError(ctx, apiresponses.NewFailureResponseBuilder(
+				fmt.Errorf("instance still has bindings %q", strings.Join(ids, ", ")),
+				http.StatusUnprocessableEntity,
+				"deprovision-instance-bound",
+			).WithErrorKey("InUseError").Build())
+		}
+		for _, binding := range bindings {
+			logger.Info("deprovision-cascade-unbind", lager.Data{"binding-id": binding.ID})
+			if err := sb.Unbind(ctx, binding.ID); err != nil {
+				return spec, crossplane.ConvertError(ctx, err)
+			}
+			if err := b.c.DeleteBindingRecord(ctx, binding.ID); err != nil {
+				return spec, crossplane.ConvertError(ctx, err)
+			}
+		}
+	}
+
 	if err := sb.Deprovision(ctx); err != nil {
 		return spec, crossplane.ConvertError(ctx, err)
 	}
 
+	// The records are deleted before the instance, so deprovisioning is retried if deleting them fails.
+	if err := b.c.DeleteActionRecords(ctx, instanceID); err != nil {
+		return spec, crossplane.ConvertError(ctx, err)
+	}
+	if err := b.c.DeleteMigrationRecord(ctx, instanceID); err != nil {
+		return spec, crossplane.ConvertError(ctx, err)
+	}
+
 	if err := b.c.DeleteInstance(ctx, instance.GetName(), plan); err != nil {
 		return spec, crossplane.ConvertError(ctx, err)
 	}
@@ -123,6 +266,12 @@ func (b *CrossplaneBroker) Bind(ctx context.Context, instanceID, bindingID strin
 		IsAsync: false,
 	}
 
+	unlock, ok := b.locks.tryLock(instanceID)
+	if !ok {
+		return spec, apiresponses.ErrConcurrentInstanceAccess
+	}
+	defer unlock()
+
 	plan, err := b.c.GetPlan(ctx, details.PlanID)
 	if err != nil {
 		return spec, crossplane.ConvertError(ctx, err)
@@ -148,12 +297,33 @@ func (b *CrossplaneBroker) Bind(ctx context.Context, instanceID, bindingID strin
 		return spec, crossplane.ConvertError(ctx, err)
 	}
 
+	recorded, err := b.c.BindingRecorded(ctx, bindingID)
+	if err != nil {
+		return spec, crossplane.ConvertError(ctx, err)
+	}
+
 	creds, err := sb.Bind(ctx, bindingID)
 	if err != nil {
 		return spec, crossplane.ConvertError(ctx, err)
 	}
 
+	appGUID := details.AppGUID
+	if details.BindResource != nil && details.BindResource.AppGuid != "" {
+		appGUID = details.BindResource.AppGuid
+	}
+	if err := b.c.RecordBinding(ctx, instanceID, bindingID, appGUID, creds); err != nil {
+		// The platform considers the binding failed, credentials of a new binding would be left behind.
+		if !recorded {
+			if uerr := sb.Unbind(ctx, bindingID); uerr != nil {
+				logger.Error("bind-cleanup-failed", uerr)
+			}
+		}
+		return spec, crossplane.ConvertError(ctx, err)
+	}
+
 	spec.Credentials = creds
+	// Binding again returns the credentials of the existing binding with 200.
+	spec.AlreadyExists = recorded
 
 	return spec, nil
 }
@@ -167,6 +337,12 @@ func (b *CrossplaneBroker) Unbind(ctx context.Context, instanceID, bindingID str
 		IsAsync: false,
 	}
 
+	unlock, ok := b.locks.tryLock(instanceID)
+	if !ok {
+		return spec, apiresponses.ErrConcurrentInstanceAccess
+	}
+	defer unlock()
+
 	plan, err := b.c.GetPlan(ctx, details.PlanID)
 	if err != nil {
 		return spec, crossplane.ConvertError(ctx, err)
@@ -184,7 +360,31 @@ func (b *CrossplaneBroker) Unbind(ctx context.Context, instanceID, bindingID str
 		return spec, crossplane.ConvertError(ctx, err)
 	}
 
-	return spec, sb.Unbind(ctx, bindingID)
+	bindings, err := b.c.ListBindings(ctx, instanceID)
+	if err != nil {
+		return spec, crossplane.ConvertError(ctx, err)
+	}
+	if !hasBinding(bindings, bindingID) {
+		return spec, apiresponses.ErrBindingDoesNotExist
+	}
+
+	if err := sb.Unbind(ctx, bindingID); err != nil {
+		return spec, crossplane.ConvertError(ctx, err)
+	}
+	if err := b.c.DeleteBindingRecord(ctx, bindingID); err != nil {
+		return spec, crossplane.ConvertError(ctx, err)
+	}
+	return spec, nil
+}
+
+// hasBinding returns whether a binding is in the given bindings.
+func hasBinding(bindings []crossplane.Binding, bindingID string) bool {
+	for _, binding := range bindings {
+		if binding.ID == bindingID {
+			return true
+		}
+	}
+	return false
 }
 
 // LastOperation returns the status of the last async operation
@@ -219,6 +419,10 @@ func (b *CrossplaneBroker) LastOperation(ctx context.Context, instanceID string,
 		if err := sb.FinishProvision(ctx); err != nil {
 			return domain.LastOperation{}, crossplane.ConvertError(ctx, err)
 		}
+		// The instance is provisioned even if its namespace couldn't be labeled, it is retried on later polls and updates.
+		if err := b.c.SyncNamespaceContext(ctx, instance); err != nil {
+			logger.Error("sync-namespace-context", err)
+		}
 		logger.WithData(lager.Data{"reason": condition.Reason, "message": condition.Message}).Info("provision-succeeded")
 	case v1alpha1.ReasonCreating:
 		op.State = domain.InProgress
@@ -237,19 +441,137 @@ func (b *CrossplaneBroker) Update(ctx context.Context, instanceID string, detail
 
 	spec := domain.UpdateServiceSpec{}
 
-	if err := b.c.UpdateInstanceSLA(ctx, instanceID, details.ServiceID, details.PlanID); err != nil {
-		switch err {
-		case crossplane.ErrSLAChangeNotPermitted, crossplane.ErrClusterChangeNotPermitted, crossplane.ErrServiceUpdateNotPermitted:
-			err = apiresponses.NewFailureResponse(err, http.StatusUnprocessableEntity, "update-instance-failed")
-		case crossplane.ErrInstanceNotFound:
+	unlock, ok := b.locks.tryLock(instanceID)
+	if !ok {
+		return spec, apiresponses.ErrConcurrentInstanceAccess
+	}
+	defer unlock()
+	if err := b.checkNotMigrating(ctx, instanceID); err != nil {
+		return spec, err
+	}
+
+	instanceContext, err := crossplane.ParseInstanceContext(details.RawContext)
+	if err != nil {
+		return spec, crossplane.ConvertError(ctx, invalidContextError(err))
+	}
+	window, err := crossplane.MaintenanceWindowParameter(details.RawParameters)
+	if err != nil {
+		return spec, crossplane.ConvertError(ctx, invalidMaintenanceWindowError(err))
+	}
+
+	// Platforms send context updates, e.g. renames, without a plan or with the current plan.
+	if details.PlanID != "" && details.PlanID != details.PreviousValues.PlanID {
+		unlockQuota, err := b.checkUpdateQuota(ctx, instanceID, details.PlanID)
+		if err != nil {
+			return spec, crossplane.ConvertError(ctx, err)
+		}
+		defer unlockQuota()
+		if err := b.c.UpdateInstanceSLA(ctx, instanceID, details.ServiceID, details.PlanID); err != nil {
+			switch {
+			case errors.Is(err, crossplane.ErrSLAChangeNotPermitted),
+				errors.Is(err, crossplane.ErrClusterChangeNotPermitted),
+				errors.Is(err, crossplane.ErrServiceUpdateNotPermitted):
+				err = apiresponses.NewFailureResponse(err, http.StatusUnprocessableEntity, "update-instance-failed")
+			case errors.Is(err, crossplane.ErrInstanceNotFound):
+				err = apiresponses.ErrInstanceDoesNotExist
+			}
+			return spec, crossplane.ConvertError(ctx, err)
+		}
+	}
+
+	if err := b.c.UpdateInstanceContext(ctx, instanceID, instanceContext); err != nil {
+		if errors.Is(err, crossplane.ErrInstanceNotFound) {
+			err = apiresponses.ErrInstanceDoesNotExist
+		}
+		return spec, crossplane.ConvertError(ctx, invalidContextError(err))
+	}
+	if window != "" {
+		if err := b.c.SetMaintenanceWindow(ctx, instanceID, window); err != nil {
+			if errors.Is(err, crossplane.ErrInstanceNotFound) {
+				err = apiresponses.ErrInstanceDoesNotExist
+			}
+			return spec, crossplane.ConvertError(ctx, err)
+		}
+	}
+
+	instance, err := b.c.GetInstance(ctx, instanceID)
+	if err != nil {
+		if errors.Is(err, crossplane.ErrInstanceNotFound) {
 			err = apiresponses.ErrInstanceDoesNotExist
 		}
 		return spec, crossplane.ConvertError(ctx, err)
 	}
+	// The namespace of instances still being provisioned is updated once provisioning succeeded.
+	// The update is applied even if the namespace couldn't be labeled, it is retried on later updates.
+	if instance.GetCondition(v1alpha1.TypeReady).Status == corev1.ConditionTrue {
+		if err := b.c.SyncNamespaceContext(ctx, instance); err != nil {
+			logger.Error("sync-namespace-context", err)
+		}
+	}
 
+	spec.DashboardURL = b.Dashboard.URL(instanceID)
 	return spec, nil
 }
 
+// checkNotMigrating rejects changes to instances being migrated to another cluster.
+func (b *CrossplaneBroker) checkNotMigrating(ctx context.Context, instanceID string) error {
+	migrating, err := b.c.IsMigrating(ctx, instanceID)
+	if err != nil {
+		return crossplane.ConvertError(ctx, err)
+	}
+	if migrating {
+		return apiresponses.ErrConcurrentInstanceAccess
+	}
+	return nil
+}
+
+// checkUpdateQuota checks whether the instance still fits into the quotas of its organization and space with the new plan.
+// The quotas stay locked until the returned function is called once the instance has been updated.
+func (b *CrossplaneBroker) checkUpdateQuota(ctx context.Context, instanceID, planID string) (func(), error) {
+	if !b.c.Quota.Enabled() {
+		return func() {}, nil
+	}
+	instance, err := b.c.GetInstance(ctx, instanceID)
+	if err != nil {
+		if errors.Is(err, crossplane.ErrInstanceNotFound) {
+			return nil, apiresponses.ErrInstanceDoesNotExist
+		}
+		return nil, err
+	}
+	plan, err := b.c.GetPlan(ctx, planID)
+	if err != nil {
+		return nil, err
+	}
+	labels := instance.GetLabels()
+	unlock, err := b.lockQuota(ctx, labels[crossplane.OrganizationLabel])
+	if err != nil {
+		return nil, err
+	}
+	if err := b.c.CheckQuota(ctx, instanceID, plan, labels[crossplane.OrganizationLabel], labels[crossplane.SpaceLabel]); err != nil {
+		unlock()
+		return nil, quotaError(err)
+	}
+	return unlock, nil
+}
+
+// lockPlacement serializes placing instances and creating them, otherwise concurrent requests could exceed the capacity
+// of a cluster. Instances which aren't placed, see crossplane.PlaceInstance, aren't serialized.
+func (b *CrossplaneBroker) lockPlacement(ctx context.Context, plan *v1beta1.Composition, parentReference string) (func(), error) {
+	if plan.Labels[crossplane.ClusterLabel] != "" || len(b.c.Placement.Clusters) == 0 || parentReference != "" {
+		return func() {}, nil
+	}
+	return b.locks.lock(ctx, "placement")
+}
+
+// lockQuota serializes checking the quotas of an organization and creating or updating its instances,
+// otherwise concurrent requests could exceed the quotas. Spaces are checked within their organization.
+func (b *CrossplaneBroker) lockQuota(ctx context.Context, organization string) (func(), error) {
+	if organization == "" || !b.c.Quota.Enabled() {
+		return func() {}, nil
+	}
+	return b.locks.lock(ctx, "organization/"+organization)
+}
+
 // GetBinding returns a previously created binding
 func (b *CrossplaneBroker) GetBinding(ctx context.Context, instanceID, bindingID string) (domain.GetBindingSpec, error) {
 	logger := requestScopedLogger(ctx, b.logger).WithData(lager.Data{"instance-id": instanceID, "binding-id": bindingID})
@@ -289,25 +611,31 @@ func (b *CrossplaneBroker) GetInstance(ctx context.Context, instanceID string) (
 	logger := requestScopedLogger(ctx, b.logger).WithData(lager.Data{"instance-id": instanceID})
 	logger.Info("get-instance")
 
+	spec, _, err := b.getInstance(ctx, instanceID)
+	return spec, err
+}
+
+func (b *CrossplaneBroker) getInstance(ctx context.Context, instanceID string) (domain.GetInstanceDetailsSpec, *composite.Unstructured, error) {
 	instance, err := b.c.GetInstance(ctx, instanceID)
 	if err != nil {
 		if errors.Is(err, crossplane.ErrInstanceNotFound) {
 			err = apiresponses.ErrInstanceDoesNotExist
 		}
-		return domain.GetInstanceDetailsSpec{}, crossplane.ConvertError(ctx, err)
+		return domain.GetInstanceDetailsSpec{}, nil, crossplane.ConvertError(ctx, err)
 	}
 
 	params, err := fieldpath.Pave(instance.Object).GetValue(crossplane.InstanceSpecParamsPath)
 	if err != nil {
-		return domain.GetInstanceDetailsSpec{}, err
+		return domain.GetInstanceDetailsSpec{}, nil, err
 	}
 
 	spec := domain.GetInstanceDetailsSpec{
-		PlanID:     instance.GetCompositionReference().Name,
-		ServiceID:  instance.GetLabels()[crossplane.ServiceIDLabel],
-		Parameters: params,
+		PlanID:       instance.GetCompositionReference().Name,
+		ServiceID:    instance.GetLabels()[crossplane.ServiceIDLabel],
+		DashboardURL: b.Dashboard.URL(instanceID),
+		Parameters:   params,
 	}
-	return spec, nil
+	return spec, instance, nil
 }
 
 // LastBindingOperation is not implemented since async bindings are not supported
@@ -318,6 +646,63 @@ func (b *CrossplaneBroker) LastBindingOperation(ctx context.Context, instanceID,
 	return domain.LastOperation{}, crossplane.ErrNotImplemented
 }
 
+// invalidParentError converts errors of parent references in the provisioning parameters to bad requests.
+func invalidParentError(err error) error {
+	if !errors.Is(err, crossplane.ErrInvalidParent) {
+		return err
+	}
+	return apiresponses.NewFailureResponseBuilder(
+		err,
+		http.StatusBadRequest,
+		"invalid-parent-reference",
+	).Build()
+}
+
+func invalidContextError(err error) error {
+	if !errors.Is(err, crossplane.ErrInvalidContext) {
+		return err
+	}
+	return apiresponses.NewFailureResponseBuilder(
+		err,
+		http.StatusBadRequest,
+		"invalid-context",
+	).Build()
+}
+
+func invalidMaintenanceWindowError(err error) error {
+	if !errors.Is(err, crossplane.ErrInvalidMaintenanceWindow) {
+		return err
+	}
+	return apiresponses.NewFailureResponseBuilder(
+		err,
+		http.StatusBadRequest,
+		"invalid-maintenance-window",
+	).Build()
+}
+
+// quotaError converts exceeded quotas to unprocessable requests. The message tells the remaining capacity.
+func quotaError(err error) error {
+	if !errors.Is(err, crossplane.ErrQuotaExceeded) {
+		return err
+	}
+	return apiresponses.NewFailureResponseBuilder(
+		err,
+		http.StatusUnprocessableEntity,
+		"quota-exceeded",
+	).WithErrorKey("QuotaExceeded").Build()
+}
+
+// placementError converts invalid placement parameters to bad requests and a full pool to unprocessable requests.
+func placementError(err error) error {
+	switch {
+	case errors.Is(err, crossplane.ErrInvalidPlacement):
+		return apiresponses.NewFailureResponseBuilder(err, http.StatusBadRequest, "invalid-placement").Build()
+	case errors.Is(err, crossplane.ErrNoCapacity):
+		return apiresponses.NewFailureResponseBuilder(err, http.StatusUnprocessableEntity, "no-capacity").Build()
+	}
+	return err
+}
+
 func requestScopedLogger(ctx context.Context, logger lager.Logger) lager.Logger {
 	id, ok := ctx.Value(middlewares.CorrelationIDKey).(string)
 	if !ok {
@@ -0,0 +1,418 @@
+package crossplanebroker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"broker/pkg/config"
+	"broker/pkg/crossplane"
+
+	"code.cloudfoundry.org/lager"
+	runtimev1alpha1 "github.com/crossplane/crossplane-runtime/apis/core/v1alpha1"
+	"github.com/crossplane/crossplane-runtime/pkg/resource/unstructured/composite"
+	"github.com/crossplane/crossplane/apis/apiextensions/v1beta1"
+	"github.com/gorilla/mux"
+	"github.com/pivotal-cf/brokerapi/v7/domain"
+	"github.com/pivotal-cf/brokerapi/v7/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var (
+	redisGVK = schema.GroupVersionKind{Group: "syn.tools", Version: "v1alpha1", Kind: "CompositeRedisInstance"}
+	userGVK  = schema.GroupVersionKind{Group: "syn.tools", Version: "v1alpha1", Kind: "CompositeMariaDBUserInstance"}
+)
+
+func newTestPlan(name string, labels map[string]string) *v1beta1.Composition {
+	l := map[string]string{
+		crossplane.ServiceIDLabel:   "redis-k8s",
+		crossplane.ServiceNameLabel: "redis-k8s",
+		crossplane.PlanNameLabel:    name,
+		crossplane.SLALabel:         crossplane.SLAStandard,
+	}
+	for k, v := range labels {
+		l[k] = v
+	}
+	return &v1beta1.Composition{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: l},
+		Spec: v1beta1.CompositionSpec{
+			CompositeTypeRef: v1beta1.TypeReference{
+				APIVersion: redisGVK.GroupVersion().String(),
+				Kind:       redisGVK.Kind,
+			},
+		},
+	}
+}
+
+func newTestInstance(name, plan string, labels map[string]string) *composite.Unstructured {
+	instance := composite.New(composite.WithGroupVersionKind(redisGVK))
+	instance.SetName(name)
+	instance.SetResourceVersion("1")
+	instance.SetCompositionReference(&corev1.ObjectReference{Name: plan})
+	l := map[string]string{
+		crossplane.InstanceIDLabel:  name,
+		crossplane.ServiceIDLabel:   "redis-k8s",
+		crossplane.ServiceNameLabel: "redis-k8s",
+		crossplane.PlanNameLabel:    plan,
+		crossplane.SLALabel:         crossplane.SLAStandard,
+	}
+	for k, v := range labels {
+		l[k] = v
+	}
+	instance.SetLabels(l)
+	_ = unstructured.SetNestedField(instance.Object, map[string]interface{}{}, "spec", "parameters")
+	return instance
+}
+
+// newTestBroker returns a broker backed by a fake client with the plan "small" and the given objects.
+func newTestBroker(t *testing.T, objs ...runtime.Object) (*CrossplaneBroker, k8sclient.Client) {
+	s := runtime.NewScheme()
+	require.NoError(t, scheme.AddToScheme(s))
+	require.NoError(t, crossplane.SetupScheme(s))
+	// The fake client needs the list type to list composites.
+	s.AddKnownTypeWithName(redisGVK.GroupVersion().WithKind(redisGVK.Kind+"List"), &unstructured.UnstructuredList{})
+
+	objs = append(objs, newTestPlan("small", nil))
+	k := fake.NewFakeClientWithScheme(s, objs...)
+	logger := lager.NewLogger("test")
+	b, err := New(crossplane.NewWithClient(k, []string{"redis-k8s"}, config.Default().Crossplane, logger), logger)
+	require.NoError(t, err)
+	return b, k
+}
+
+func TestAttachGetInstanceRoute(t *testing.T) {
+	b, _ := newTestBroker(t, newTestInstance("instance", "small", map[string]string{crossplane.ClusterLabel: "eu-1"}))
+	router := mux.NewRouter()
+	AttachGetInstanceRoute(router, b, lager.NewLogger("test"))
+
+	tests := map[string]struct {
+		instance string
+		version  string
+		code     int
+		body     string
+	}{
+		"instance": {
+			instance: "instance",
+			version:  "2.16",
+			code:     http.StatusOK,
+			body:     `"metadata":{"labels":{"cluster":"eu-1","sla":"standard"}`,
+		},
+		"unknown instance": {
+			instance: "unknown",
+			version:  "2.16",
+			code:     http.StatusGone,
+		},
+		"before 2.14": {
+			instance: "instance",
+			version:  "2.13",
+			code:     http.StatusPreconditionFailed,
+			body:     "only supported starting with OSB version 2.14",
+		},
+		"other major version": {
+			instance: "instance",
+			version:  "1.14",
+			code:     http.StatusPreconditionFailed,
+		},
+		"invalid version": {
+			instance: "instance",
+			version:  "latest",
+			code:     http.StatusPreconditionFailed,
+			body:     `invalid X-Broker-API-Version header \"latest\"`,
+		},
+		"missing version": {
+			instance: "instance",
+			code:     http.StatusPreconditionFailed,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v2/service_instances/"+tt.instance, nil)
+			if tt.version != "" {
+				req.Header.Set("X-Broker-API-Version", tt.version)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			assert.Equal(t, tt.code, rec.Code, rec.Body.String())
+			assert.Contains(t, rec.Body.String(), tt.body)
+		})
+	}
+}
+
+func TestLastOperation_NamespaceNotSynced(t *testing.T) {
+	instance := newTestInstance("instance", "small", map[string]string{crossplane.OrganizationLabel: "org"})
+	instance.SetConditions(runtimev1alpha1.Available())
+	// The release is gone, the namespace on the downstream cluster can't be labeled.
+	instance.SetResourceReferences([]corev1.ObjectReference{{Kind: "Release", Name: "instance-redis"}})
+	b, _ := newTestBroker(t, instance)
+
+	op, err := b.LastOperation(context.Background(), "instance", domain.PollDetails{})
+	require.NoError(t, err)
+	assert.Equal(t, domain.Succeeded, op.State)
+}
+
+func TestProvision_QuotaExceeded(t *testing.T) {
+	ctx := context.Background()
+	b, _ := newTestBroker(t, newTestInstance("existing", "small", map[string]string{crossplane.OrganizationLabel: "org"}))
+	b.c.Quota.Organization.Instances = 2
+	details := domain.ProvisionDetails{ServiceID: "redis-k8s", PlanID: "small", OrganizationGUID: "org", SpaceGUID: "space"}
+
+	// Concurrent provisions must not exceed the quota.
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = b.Provision(ctx, "instance-"+string(rune('a'+i)), details, true)
+		}(i)
+	}
+	wg.Wait()
+
+	provisioned := 0
+	for _, err := range errs {
+		if err == nil {
+			provisioned++
+			continue
+		}
+		var fr *apiresponses.FailureResponse
+		require.True(t, errors.As(err, &fr), err)
+		assert.Equal(t, http.StatusUnprocessableEntity, fr.ValidatedStatusCode(nil))
+		assert.Equal(t, "QuotaExceeded", fr.ErrorResponse().(apiresponses.ErrorResponse).Error)
+		assert.Contains(t, err.Error(), `organization "org" has 2 of 2 instances, 0 remaining`)
+	}
+	assert.Equal(t, 1, provisioned)
+
+	_, err := b.Provision(ctx, "other-org", domain.ProvisionDetails{ServiceID: "redis-k8s", PlanID: "small", OrganizationGUID: "other"}, true)
+	assert.NoError(t, err, "other organizations must not be affected")
+}
+
+func TestProvision_NoCapacity(t *testing.T) {
+	ctx := context.Background()
+	b, k := newTestBroker(t)
+	b.c.Placement.Clusters = []config.ClusterConfig{{Name: "eu-1", Capacity: 1}, {Name: "eu-2", Capacity: 1}}
+	details := domain.ProvisionDetails{ServiceID: "redis-k8s", PlanID: "small"}
+
+	// Concurrent provisions must not exceed the capacity of the clusters.
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = b.Provision(ctx, "instance-"+string(rune('a'+i)), details, true)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		var fr *apiresponses.FailureResponse
+		require.True(t, errors.As(err, &fr), err)
+		assert.Equal(t, http.StatusUnprocessableEntity, fr.ValidatedStatusCode(nil))
+	}
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(redisGVK.GroupVersion().WithKind(redisGVK.Kind + "List"))
+	require.NoError(t, k.List(ctx, list))
+	clusters := []string{}
+	for _, instance := range list.Items {
+		clusters = append(clusters, instance.GetLabels()[crossplane.ClusterLabel])
+	}
+	assert.ElementsMatch(t, []string{"eu-1", "eu-2"}, clusters)
+}
+
+// newTestDatabase returns a ready MariaDB database on the given parent, bindings need the plan "mariadb-database".
+func newTestDatabase(name, parent string) (*composite.Unstructured, *v1beta1.Composition) {
+	labels := map[string]string{
+		crossplane.ServiceIDLabel:   "mariadb-k8s-database",
+		crossplane.ServiceNameLabel: "mariadb-k8s-database",
+		crossplane.ParentIDLabel:    parent,
+	}
+	database := newTestInstance(name, "mariadb-database", labels)
+	database.SetConditions(runtimev1alpha1.Available())
+	_ = unstructured.SetNestedField(database.Object, parent, "spec", "parameters", "parent_reference")
+	delete(labels, crossplane.ParentIDLabel)
+	return database, newTestPlan("mariadb-database", labels)
+}
+
+// newTestEndpoint returns the connection secret of a MariaDB cluster.
+func newTestEndpoint(parent string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: parent, Namespace: config.Default().Crossplane.Namespace},
+		Data: map[string][]byte{
+			runtimev1alpha1.ResourceCredentialsSecretEndpointKey: []byte("10.0.0.1"),
+			runtimev1alpha1.ResourceCredentialsSecretPortKey:     []byte("3306"),
+		},
+	}
+}
+
+func TestDeprovision_Bindings(t *testing.T) {
+	ctx := context.Background()
+	namespace := config.Default().Crossplane.Namespace
+	database, plan := newTestDatabase("database", "cluster")
+	// A user binding created before bindings were recorded.
+	labels := map[string]string{crossplane.InstanceIDLabel: "database", crossplane.ParentIDLabel: "cluster"}
+	password := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "user-1-password", Namespace: namespace, Labels: labels}}
+	user := composite.New(composite.WithGroupVersionKind(userGVK))
+	user.SetName("user-1")
+	user.SetLabels(labels)
+	// Records of a finished action and migration.
+	action := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "action-database-1", Namespace: namespace, Labels: map[string]string{
+		crossplane.InstanceIDLabel: "database",
+		crossplane.ActionIDLabel:   "1",
+	}}, Data: map[string]string{"state": string(crossplane.ActionSucceeded)}}
+	migration := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "migration-database", Namespace: namespace, Labels: map[string]string{
+		crossplane.InstanceIDLabel: "database",
+	}}, Data: map[string]string{"state": string(crossplane.MigrationSucceeded)}}
+	b, k := newTestBroker(t, database, plan, password, user, action, migration)
+	details := domain.DeprovisionDetails{ServiceID: "mariadb-k8s-database", PlanID: "mariadb-database"}
+
+	_, err := b.Deprovision(ctx, "database", details, false)
+	var fr *apiresponses.FailureResponse
+	require.True(t, errors.As(err, &fr), err)
+	assert.Equal(t, http.StatusUnprocessableEntity, fr.ValidatedStatusCode(nil))
+	assert.Contains(t, err.Error(), `instance still has bindings "user-1"`)
+
+	b.c.DeprovisionBindings = config.DeprovisionBindingsCascade
+	require.NoError(t, b.c.RecordBinding(ctx, "database", "user-1", "app", crossplane.Credentials{"password": "secret"}))
+	_, err = b.Deprovision(ctx, "database", details, false)
+	require.NoError(t, err)
+	bindings, err := b.c.ListBindings(ctx, "database")
+	require.NoError(t, err)
+	assert.Empty(t, bindings, "the bindings must be deleted with the instance")
+	err = k.Get(ctx, types.NamespacedName{Name: "user-1"}, user)
+	assert.True(t, k8serrors.IsNotFound(err), err)
+	_, err = b.c.GetInstance(ctx, "database")
+	assert.Equal(t, crossplane.ErrInstanceNotFound, err)
+	for _, record := range []*corev1.ConfigMap{action, migration} {
+		err = k.Get(ctx, types.NamespacedName{Name: record.Name, Namespace: namespace}, &corev1.ConfigMap{})
+		assert.True(t, k8serrors.IsNotFound(err), "the record %q must be deleted with the instance", record.Name)
+	}
+}
+
+// failingRecordClient fails creating binding records.
+type failingRecordClient struct {
+	k8sclient.Client
+}
+
+func (c *failingRecordClient) Create(ctx context.Context, obj runtime.Object, opts ...k8sclient.CreateOption) error {
+	if _, ok := obj.(*corev1.ConfigMap); ok {
+		return errors.New("etcd unavailable")
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func TestBind_RecordFailed(t *testing.T) {
+	ctx := context.Background()
+	namespace := config.Default().Crossplane.Namespace
+	database, plan := newTestDatabase("database", "cluster")
+	b, k := newTestBroker(t, database, plan, newTestEndpoint("cluster"))
+	b.c.Client = &failingRecordClient{Client: k}
+
+	_, err := b.Bind(ctx, "database", "binding", domain.BindDetails{ServiceID: "mariadb-k8s-database", PlanID: "mariadb-database"}, false)
+	assert.Error(t, err)
+	err = k.Get(ctx, types.NamespacedName{Name: "binding-password", Namespace: namespace}, &corev1.Secret{})
+	assert.True(t, k8serrors.IsNotFound(err), "the credentials of the failed binding must be deleted: %v", err)
+	err = k.Get(ctx, types.NamespacedName{Name: "binding"}, composite.New(composite.WithGroupVersionKind(userGVK)))
+	assert.True(t, k8serrors.IsNotFound(err), "the user of the failed binding must be deleted: %v", err)
+}
+
+func TestBind_Idempotent(t *testing.T) {
+	ctx := context.Background()
+	database, plan := newTestDatabase("database", "cluster")
+	b, _ := newTestBroker(t, database, plan, newTestEndpoint("cluster"))
+	details := domain.BindDetails{ServiceID: "mariadb-k8s-database", PlanID: "mariadb-database"}
+
+	binding, err := b.Bind(ctx, "database", "binding", details, false)
+	require.NoError(t, err)
+	assert.False(t, binding.AlreadyExists)
+
+	again, err := b.Bind(ctx, "database", "binding", details, false)
+	require.NoError(t, err)
+	assert.True(t, again.AlreadyExists, "binding again must return 200")
+	assert.Equal(t, binding.Credentials, again.Credentials)
+
+	_, err = b.Unbind(ctx, "database", "unknown", domain.UnbindDetails{ServiceID: "mariadb-k8s-database", PlanID: "mariadb-database"}, false)
+	assert.Equal(t, apiresponses.ErrBindingDoesNotExist, err)
+}
+
+// forbiddenDeleteClient isn't permitted to delete composites.
+type forbiddenDeleteClient struct {
+	k8sclient.Client
+}
+
+func (c *forbiddenDeleteClient) Delete(ctx context.Context, obj runtime.Object, opts ...k8sclient.DeleteOption) error {
+	if cmp, ok := obj.(*composite.Unstructured); ok {
+		return k8serrors.NewForbidden(schema.GroupResource{Group: userGVK.Group, Resource: "compositemariadbuserinstances"}, cmp.GetName(), errors.New("RBAC"))
+	}
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func TestUnbind_Failed(t *testing.T) {
+	ctx := context.Background()
+	database, plan := newTestDatabase("database", "cluster")
+	b, k := newTestBroker(t, database, plan, newTestEndpoint("cluster"))
+	_, err := b.Bind(ctx, "database", "binding", domain.BindDetails{ServiceID: "mariadb-k8s-database", PlanID: "mariadb-database"}, false)
+	require.NoError(t, err)
+	b.c.Client = &forbiddenDeleteClient{Client: k}
+
+	_, err = b.Unbind(ctx, "database", "binding", domain.UnbindDetails{ServiceID: "mariadb-k8s-database", PlanID: "mariadb-database"}, false)
+	var fr *apiresponses.FailureResponse
+	require.True(t, errors.As(err, &fr), err)
+	assert.Equal(t, http.StatusForbidden, fr.ValidatedStatusCode(nil))
+	assert.Contains(t, err.Error(), "correlation-id")
+}
+
+func TestUpdate_NamespaceContextFailure(t *testing.T) {
+	instance := newTestInstance("instance", "small", nil)
+	instance.SetConditions(runtimev1alpha1.Available())
+	// The release doesn't exist, so the namespace on the downstream cluster can't be labeled.
+	instance.SetResourceReferences([]corev1.ObjectReference{{APIVersion: "helm.crossplane.io/v1alpha1", Kind: "Release", Name: "instance"}})
+	b, c := newTestBroker(t, instance)
+
+	_, err := b.Update(context.Background(), "instance", domain.UpdateDetails{
+		ServiceID:      "redis-k8s",
+		PlanID:         "small",
+		PreviousValues: domain.PreviousValues{PlanID: "small"},
+		RawContext:     json.RawMessage(`{"platform": "cloudfoundry", "organization_guid": "org-a", "instance_name": "renamed"}`),
+	}, true)
+	require.NoError(t, err, "the namespace is labeled on a best-effort basis")
+
+	updated := composite.New(composite.WithGroupVersionKind(redisGVK))
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "instance"}, updated))
+	assert.Equal(t, "cloudfoundry", updated.GetLabels()[crossplane.PlatformLabel])
+}
+
+func TestUpdate_ClusterChange(t *testing.T) {
+	b, _ := newTestBroker(t,
+		newTestInstance("instance", "small", map[string]string{crossplane.ClusterLabel: "eu-1"}),
+		// The SLA change itself is permitted, only the cluster differs.
+		newTestPlan("small-premium", map[string]string{crossplane.ClusterLabel: "eu-2", crossplane.SLALabel: crossplane.SLAPremium}),
+	)
+
+	_, err := b.Update(context.Background(), "instance", domain.UpdateDetails{
+		ServiceID:      "redis-k8s",
+		PlanID:         "small-premium",
+		PreviousValues: domain.PreviousValues{PlanID: "small"},
+	}, true)
+	var fr *apiresponses.FailureResponse
+	require.True(t, errors.As(err, &fr), err)
+	assert.Equal(t, http.StatusUnprocessableEntity, fr.ValidatedStatusCode(nil))
+	assert.Contains(t, err.Error(), `plan "small-premium" is deployed to cluster "eu-2"`)
+}
@@ -0,0 +1,89 @@
+package crossplanebroker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"broker/pkg/crossplane"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/gorilla/mux"
+	"github.com/pivotal-cf/brokerapi/v7/domain/apiresponses"
+)
+
+// GetInstanceResponse is the OSB response to fetching an instance, including the instance metadata of OSB 2.16.
+type GetInstanceResponse struct {
+	apiresponses.GetInstanceResponse
+	Metadata crossplane.InstanceMetadata `json:"metadata"`
+}
+
+// GetInstanceWithMetadata returns a service instance and its metadata.
+func (b *CrossplaneBroker) GetInstanceWithMetadata(ctx context.Context, instanceID string) (GetInstanceResponse, error) {
+	logger := requestScopedLogger(ctx, b.logger).WithData(lager.Data{"instance-id": instanceID})
+	logger.Info("get-instance")
+
+	spec, instance, err := b.getInstance(ctx, instanceID)
+	if err != nil {
+		return GetInstanceResponse{}, err
+	}
+	md, err := b.c.GetInstanceMetadata(ctx, instance)
+	if err != nil {
+		return GetInstanceResponse{}, crossplane.ConvertError(ctx, err)
+	}
+	return GetInstanceResponse{
+		GetInstanceResponse: apiresponses.GetInstanceResponse{
+			ServiceID:    spec.ServiceID,
+			PlanID:       spec.PlanID,
+			DashboardURL: spec.DashboardURL,
+			Parameters:   spec.Parameters,
+		},
+		Metadata: md,
+	}, nil
+}
+
+// AttachGetInstanceRoute serves fetching instances including their metadata, which brokerapi v7 drops from the response.
+// It must be attached before the brokerapi routes to take precedence over them.
+func AttachGetInstanceRoute(router *mux.Router, b *CrossplaneBroker, logger lager.Logger) {
+	router.HandleFunc("/v2/service_instances/{instance_id}", func(w http.ResponseWriter, req *http.Request) {
+		header := req.Header.Get("X-Broker-API-Version")
+		var version struct{ major, minor int }
+		if _, err := fmt.Sscanf(header, "%d.%d", &version.major, &version.minor); err != nil {
+			respond(w, logger, http.StatusPreconditionFailed, apiresponses.ErrorResponse{
+				Description: fmt.Sprintf("invalid X-Broker-API-Version header %q", header),
+			})
+			return
+		}
+		if version.major != 2 || version.minor < 14 {
+			respond(w, logger, http.StatusPreconditionFailed, apiresponses.ErrorResponse{
+				Description: "get instance endpoint only supported starting with OSB version 2.14",
+			})
+			return
+		}
+
+		res, err := b.GetInstanceWithMetadata(req.Context(), mux.Vars(req)["instance_id"])
+		if err != nil {
+			var fr *apiresponses.FailureResponse
+			if errors.As(err, &fr) {
+				respond(w, logger, fr.ValidatedStatusCode(logger), fr.ErrorResponse())
+				return
+			}
+			logger.Error("get-instance", err)
+			respond(w, logger, http.StatusInternalServerError, apiresponses.ErrorResponse{
+				Description: err.Error(),
+			})
+			return
+		}
+		respond(w, logger, http.StatusOK, res)
+	}).Methods(http.MethodGet)
+}
+
+func respond(w http.ResponseWriter, logger lager.Logger, status int, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("encode-response", err, lager.Data{"status": status})
+	}
+}
@@ -0,0 +1,423 @@
+//go:build integration
+// +build integration
+
+package crossplanebroker_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"broker/pkg/config"
+	"broker/pkg/crossplane"
+	"broker/pkg/crossplanebroker"
+	"broker/pkg/custom"
+
+	"code.cloudfoundry.org/lager"
+	helmv1alpha1 "github.com/crossplane-contrib/provider-helm/apis/v1alpha1"
+	runtimev1alpha1 "github.com/crossplane/crossplane-runtime/apis/core/v1alpha1"
+	"github.com/crossplane/crossplane-runtime/pkg/resource/unstructured/composite"
+	"github.com/crossplane/crossplane/apis/apiextensions/v1beta1"
+	"github.com/pivotal-cf/brokerapi/v7/domain"
+	"github.com/pivotal-cf/brokerapi/v7/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	extv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdv1 "k8s.io/client-go/tools/clientcmd/api/v1"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	namespace      = "spks-crossplane"
+	providerConfig = "envtest"
+)
+
+var (
+	redisGVK    = schema.GroupVersionKind{Group: "syn.tools", Version: "v1alpha1", Kind: "CompositeRedisInstance"}
+	mariadbGVK  = schema.GroupVersionKind{Group: "syn.tools", Version: "v1alpha1", Kind: "CompositeMariaDBInstance"}
+	databaseGVK = schema.GroupVersionKind{Group: "syn.tools", Version: "v1alpha1", Kind: "CompositeMariaDBDatabaseInstance"}
+	userGVK     = schema.GroupVersionKind{Group: "syn.tools", Version: "v1alpha1", Kind: "CompositeMariaDBUserInstance"}
+)
+
+// env is the environment shared by all tests of the suite.
+var env struct {
+	client     k8sclient.Client
+	cp         *crossplane.Crossplane
+	broker     *crossplanebroker.CrossplaneBroker
+	reconciler *fakeReconciler
+}
+
+// TestMain starts a control plane with the Crossplane and provider-helm CRDs.
+// The etcd and kube-apiserver binaries are looked up in KUBEBUILDER_ASSETS.
+func TestMain(m *testing.M) {
+	os.Exit(runSuite(m))
+}
+
+func runSuite(m *testing.M) int {
+	// The downstream cluster is the envtest cluster itself and configured in a ProviderConfig,
+	// don't fall back to the local kubeconfig.
+	os.Unsetenv(clientcmd.RecommendedConfigPathEnvVar)
+
+	testEnv := &envtest.Environment{
+		CRDDirectoryPaths:     []string{filepath.Join("testdata", "crds")},
+		ErrorIfCRDPathMissing: true,
+	}
+	cfg, err := testEnv.Start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to start envtest: %s\n", err)
+		return 1
+	}
+	defer testEnv.Stop()
+
+	if err := setup(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to set up suite: %s\n", err)
+		return 1
+	}
+	return m.Run()
+}
+
+func setup(cfg *rest.Config) error {
+	s := runtime.NewScheme()
+	if err := scheme.AddToScheme(s); err != nil {
+		return err
+	}
+	if err := crossplane.SetupScheme(s); err != nil {
+		return err
+	}
+	c, err := k8sclient.New(cfg, k8sclient.Options{Scheme: s})
+	if err != nil {
+		return err
+	}
+
+	logger := lager.NewLogger("integration")
+	logger.RegisterSink(lager.NewPrettySink(os.Stdout, lager.INFO))
+
+	cp := crossplane.NewWithClient(c, []string{"redis-k8s", "mariadb-k8s", "mariadb-k8s-database"}, config.Crossplane{
+		Namespace:      namespace,
+		HaProxyRelease: "haproxy",
+	}, logger)
+	b, err := crossplanebroker.New(cp, logger)
+	if err != nil {
+		return err
+	}
+
+	kubeconfig, err := kubeconfigFor(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	objs := []runtime.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: providerConfig, Namespace: namespace},
+			Data:       map[string][]byte{runtimev1alpha1.ResourceCredentialsSecretKubeconfigKey: kubeconfig},
+		},
+		&helmv1alpha1.ProviderConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: providerConfig},
+			Spec: helmv1alpha1.ProviderConfigSpec{
+				ProviderConfigSpec: runtimev1alpha1.ProviderConfigSpec{
+					Credentials: runtimev1alpha1.ProviderCredentials{
+						Source: runtimev1alpha1.CredentialsSourceSecret,
+						SecretRef: &runtimev1alpha1.SecretKeySelector{
+							SecretReference: runtimev1alpha1.SecretReference{Name: providerConfig, Namespace: namespace},
+							Key:             runtimev1alpha1.ResourceCredentialsSecretKubeconfigKey,
+						},
+					},
+				},
+			},
+		},
+		newService("redis-k8s", redisGVK),
+		newService("mariadb-k8s", mariadbGVK),
+		newService("mariadb-k8s-database", databaseGVK),
+		newPlan("redis-small", "redis-k8s", "small", crossplane.SLAStandard, redisGVK),
+		newPlan("redis-small-premium", "redis-k8s", "small-premium", crossplane.SLAPremium, redisGVK),
+		newPlan("mariadb-small", "mariadb-k8s", "small", crossplane.SLAStandard, mariadbGVK),
+		newPlan("mariadb-small-premium", "mariadb-k8s", "small-premium", crossplane.SLAPremium, mariadbGVK),
+		newPlan("mariadb-database-default", "mariadb-k8s-database", "default", crossplane.SLAStandard, databaseGVK),
+	}
+	for _, obj := range objs {
+		if err := c.Create(ctx, obj); err != nil {
+			return err
+		}
+	}
+
+	env.client = c
+	env.cp = cp
+	env.broker = b
+	env.reconciler = &fakeReconciler{client: c, namespace: namespace, haProxyRelease: "haproxy"}
+	return nil
+}
+
+// kubeconfigFor returns a kubeconfig to connect to the envtest cluster.
+func kubeconfigFor(cfg *rest.Config) ([]byte, error) {
+	server := cfg.Host
+	if !strings.Contains(server, "://") {
+		server = "http://" + server
+	}
+	return yaml.Marshal(clientcmdv1.Config{
+		Clusters:       []clientcmdv1.NamedCluster{{Name: "envtest", Cluster: clientcmdv1.Cluster{Server: server}}},
+		AuthInfos:      []clientcmdv1.NamedAuthInfo{{Name: "envtest"}},
+		Contexts:       []clientcmdv1.NamedContext{{Name: "envtest", Context: clientcmdv1.Context{Cluster: "envtest", AuthInfo: "envtest"}}},
+		CurrentContext: "envtest",
+	})
+}
+
+func newService(serviceID string, gvk schema.GroupVersionKind) *v1beta1.CompositeResourceDefinition {
+	plural := strings.ToLower(gvk.Kind) + "s"
+	return &v1beta1.CompositeResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: plural + "." + gvk.Group,
+			Labels: map[string]string{
+				crossplane.ServiceIDLabel:   serviceID,
+				crossplane.ServiceNameLabel: serviceID,
+				crossplane.UpdatableLabel:   "true",
+			},
+		},
+		Spec: v1beta1.CompositeResourceDefinitionSpec{
+			Group: gvk.Group,
+			Names: extv1.CustomResourceDefinitionNames{Kind: gvk.Kind, Plural: plural},
+			Versions: []v1beta1.CompositeResourceDefinitionVersion{
+				{Name: gvk.Version, Served: true, Referenceable: true},
+			},
+		},
+	}
+}
+
+func newPlan(name, serviceID, planName, sla string, gvk schema.GroupVersionKind) *v1beta1.Composition {
+	return &v1beta1.Composition{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				crossplane.ServiceIDLabel:   serviceID,
+				crossplane.ServiceNameLabel: serviceID,
+				crossplane.PlanNameLabel:    planName,
+				crossplane.SLALabel:         sla,
+			},
+		},
+		Spec: v1beta1.CompositionSpec{
+			CompositeTypeRef: v1beta1.TypeReference{
+				APIVersion: gvk.GroupVersion().String(),
+				Kind:       gvk.Kind,
+			},
+			Resources: []v1beta1.ComposedTemplate{},
+		},
+	}
+}
+
+// assertStatus asserts that err is an API error with the given status code.
+func assertStatus(t *testing.T, status int, err error) {
+	t.Helper()
+	var apiErr *apiresponses.FailureResponse
+	require.True(t, errors.As(err, &apiErr), "expected API error, got %v", err)
+	assert.Equal(t, status, apiErr.ValidatedStatusCode(nil), err.Error())
+}
+
+func getComposite(t *testing.T, gvk schema.GroupVersionKind, name string) *composite.Unstructured {
+	t.Helper()
+	cmp := composite.New(composite.WithGroupVersionKind(gvk))
+	require.NoError(t, env.client.Get(context.Background(), types.NamespacedName{Name: name}, cmp))
+	return cmp
+}
+
+func TestRedis(t *testing.T) {
+	ctx := context.Background()
+	b := env.broker
+	const instanceID = "redis-1"
+
+	spec, err := b.Provision(ctx, instanceID, domain.ProvisionDetails{
+		ServiceID:  "redis-k8s",
+		PlanID:     "redis-small",
+		RawContext: json.RawMessage(`{"platform": "cloudfoundry", "organization_guid": "org-a", "space_guid": "space-a", "instance_name": "cache"}`),
+	}, true)
+	require.NoError(t, err)
+	assert.True(t, spec.IsAsync)
+	instanceContext := crossplane.InstanceContextOf(getComposite(t, redisGVK, instanceID))
+	assert.Equal(t, crossplane.InstanceContext{Platform: "cloudfoundry", OrganizationGUID: "org-a", SpaceGUID: "space-a", InstanceName: "cache"}, instanceContext)
+
+	_, err = b.Provision(ctx, instanceID, domain.ProvisionDetails{ServiceID: "redis-k8s", PlanID: "redis-small"}, false)
+	assert.Equal(t, apiresponses.ErrAsyncRequired, err)
+
+	op, err := b.LastOperation(ctx, instanceID, domain.PollDetails{})
+	require.NoError(t, err)
+	assert.Equal(t, domain.InProgress, op.State)
+
+	_, err = b.Bind(ctx, instanceID, "binding-1", domain.BindDetails{ServiceID: "redis-k8s", PlanID: "redis-small"}, false)
+	assert.Equal(t, apiresponses.ErrConcurrentInstanceAccess, err, "binding must wait until the instance is ready")
+
+	env.reconciler.reconcile(ctx, t)
+
+	op, err = b.LastOperation(ctx, instanceID, domain.PollDetails{})
+	require.NoError(t, err)
+	assert.Equal(t, domain.Succeeded, op.State)
+
+	ns := &corev1.Namespace{}
+	require.NoError(t, env.client.Get(ctx, types.NamespacedName{Name: instanceID}, ns))
+	assert.Equal(t, "space-a", ns.Labels[crossplane.SpaceLabel], "context must be copied to the downstream namespace")
+	assert.Equal(t, "cache", ns.Annotations[crossplane.InstanceNameAnnotation])
+
+	binding, err := b.Bind(ctx, instanceID, "binding-1", domain.BindDetails{
+		ServiceID:    "redis-k8s",
+		PlanID:       "redis-small",
+		BindResource: &domain.BindResource{AppGuid: "app-1"},
+	}, false)
+	require.NoError(t, err)
+	creds := binding.Credentials.(crossplane.Credentials)
+	assert.Equal(t, "10.0.0.1", creds["host"])
+	assert.Equal(t, int32(6379), creds["port"])
+	assert.Equal(t, "secret", creds["password"])
+
+	getBinding, err := b.GetBinding(ctx, instanceID, "binding-1")
+	require.NoError(t, err)
+	assert.Equal(t, binding.Credentials, getBinding.Credentials)
+
+	endpoints, err := custom.NewAPIHandler(env.cp, lager.NewLogger("custom")).Endpoints(ctx, instanceID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []custom.Endpoint{
+		{Destination: "10.0.0.1", Ports: "6379", Protocol: "tcp"},
+		{Destination: "10.0.0.1", Ports: "26379", Protocol: "tcp"},
+	}, endpoints)
+
+	_, err = b.Update(ctx, instanceID, domain.UpdateDetails{ServiceID: "redis-k8s", PlanID: "redis-small-premium"}, true)
+	require.NoError(t, err)
+	instance := getComposite(t, redisGVK, instanceID)
+	assert.Equal(t, "redis-small-premium", instance.GetCompositionReference().Name)
+	assert.Equal(t, crossplane.SLAPremium, instance.GetLabels()[crossplane.SLALabel])
+
+	_, err = b.Update(ctx, instanceID, domain.UpdateDetails{
+		ServiceID:  "redis-k8s",
+		RawContext: json.RawMessage(`{"platform": "cloudfoundry", "organization_guid": "org-a", "space_guid": "space-a", "instance_name": "renamed"}`),
+	}, true)
+	require.NoError(t, err)
+	assert.Equal(t, "renamed", crossplane.InstanceContextOf(getComposite(t, redisGVK, instanceID)).InstanceName)
+	require.NoError(t, env.client.Get(ctx, types.NamespacedName{Name: instanceID}, ns))
+	assert.Equal(t, "renamed", ns.Annotations[crossplane.InstanceNameAnnotation], "renames must be applied to the downstream namespace")
+
+	details, err := b.GetInstance(ctx, instanceID)
+	require.NoError(t, err)
+	assert.Equal(t, "redis-small-premium", details.PlanID)
+	assert.Equal(t, "redis-k8s", details.ServiceID)
+	withMetadata, err := b.GetInstanceWithMetadata(ctx, instanceID)
+	require.NoError(t, err)
+	assert.Equal(t, "redis-small-premium", withMetadata.PlanID)
+	assert.Equal(t, crossplane.SLAPremium, withMetadata.Metadata.Labels["sla"])
+	assert.Contains(t, withMetadata.Metadata.Attributes, "upgrade_available")
+
+	bindings, err := custom.NewAPIHandler(env.cp, lager.NewLogger("custom")).ListBindings(ctx, instanceID)
+	require.NoError(t, err)
+	require.Len(t, bindings, 1)
+	assert.Equal(t, "binding-1", bindings[0].ID)
+	assert.Equal(t, "app-1", bindings[0].AppGUID)
+
+	_, err = b.Deprovision(ctx, instanceID, domain.DeprovisionDetails{ServiceID: "redis-k8s", PlanID: "redis-small-premium"}, true)
+	assertStatus(t, http.StatusUnprocessableEntity, err)
+
+	_, err = b.Unbind(ctx, instanceID, "binding-1", domain.UnbindDetails{ServiceID: "redis-k8s", PlanID: "redis-small-premium"}, false)
+	require.NoError(t, err)
+
+	bindings, err = custom.NewAPIHandler(env.cp, lager.NewLogger("custom")).ListBindings(ctx, instanceID)
+	require.NoError(t, err)
+	assert.Empty(t, bindings)
+
+	_, err = b.Deprovision(ctx, instanceID, domain.DeprovisionDetails{ServiceID: "redis-k8s", PlanID: "redis-small-premium"}, true)
+	require.NoError(t, err)
+
+	require.NoError(t, env.client.Get(ctx, types.NamespacedName{Name: instanceID}, ns))
+	assert.Equal(t, "true", ns.Labels[crossplane.DeletedLabel], "downstream namespace must be marked as deleted")
+
+	_, err = b.LastOperation(ctx, instanceID, domain.PollDetails{})
+	assert.Error(t, err, "instance must be deleted")
+}
+
+func TestMariaDB(t *testing.T) {
+	ctx := context.Background()
+	b := env.broker
+	const (
+		instanceID = "mariadb-1"
+		databaseID = "mariadb-database-1"
+		bindingID  = "mariadb-binding-1"
+	)
+
+	_, err := b.Provision(ctx, instanceID, domain.ProvisionDetails{ServiceID: "mariadb-k8s", PlanID: "mariadb-small", OrganizationGUID: "org-a"}, true)
+	require.NoError(t, err)
+	env.reconciler.reconcile(ctx, t)
+
+	op, err := b.LastOperation(ctx, instanceID, domain.PollDetails{})
+	require.NoError(t, err)
+	assert.Equal(t, domain.Succeeded, op.State)
+
+	secret := &corev1.Secret{}
+	require.NoError(t, env.client.Get(ctx, types.NamespacedName{Name: instanceID, Namespace: namespace}, secret))
+	assert.Equal(t, "10.0.0.1", string(secret.Data[runtimev1alpha1.ResourceCredentialsSecretEndpointKey]), "finishing the provisioning must set the endpoint")
+
+	_, err = b.Bind(ctx, instanceID, bindingID, domain.BindDetails{ServiceID: "mariadb-k8s", PlanID: "mariadb-small"}, false)
+	assertStatus(t, http.StatusUnprocessableEntity, err)
+
+	_, err = b.Update(ctx, instanceID, domain.UpdateDetails{ServiceID: "mariadb-k8s", PlanID: "mariadb-small-premium"}, true)
+	require.NoError(t, err)
+
+	params, err := json.Marshal(map[string]string{"parent_reference": instanceID})
+	require.NoError(t, err)
+	_, err = b.Provision(ctx, databaseID, domain.ProvisionDetails{ServiceID: "mariadb-k8s-database", PlanID: "mariadb-database-default", OrganizationGUID: "org-b", RawParameters: params}, true)
+	assertStatus(t, http.StatusBadRequest, err)
+
+	unknownParams, err := json.Marshal(map[string]string{"parent_reference": "unknown"})
+	require.NoError(t, err)
+	_, err = b.Provision(ctx, databaseID, domain.ProvisionDetails{ServiceID: "mariadb-k8s-database", PlanID: "mariadb-database-default", OrganizationGUID: "org-a", RawParameters: unknownParams}, true)
+	assertStatus(t, http.StatusBadRequest, err)
+
+	_, err = b.Provision(ctx, databaseID, domain.ProvisionDetails{ServiceID: "mariadb-k8s-database", PlanID: "mariadb-database-default", OrganizationGUID: "org-a", RawParameters: params}, true)
+	require.NoError(t, err)
+	assert.Equal(t, instanceID, getComposite(t, databaseGVK, databaseID).GetLabels()[crossplane.ParentIDLabel])
+	env.reconciler.reconcile(ctx, t)
+
+	op, err = b.LastOperation(ctx, databaseID, domain.PollDetails{})
+	require.NoError(t, err)
+	assert.Equal(t, domain.Succeeded, op.State)
+
+	_, err = b.Update(ctx, databaseID, domain.UpdateDetails{ServiceID: "mariadb-k8s-database", PlanID: "mariadb-database-default"}, true)
+	assertStatus(t, http.StatusUnprocessableEntity, err)
+
+	binding, err := b.Bind(ctx, databaseID, bindingID, domain.BindDetails{ServiceID: "mariadb-k8s-database", PlanID: "mariadb-database-default"}, false)
+	require.NoError(t, err)
+	creds := binding.Credentials.(crossplane.Credentials)
+	assert.Equal(t, "10.0.0.1", creds["host"])
+	assert.Equal(t, int32(3306), creds["port"])
+	assert.Equal(t, bindingID, creds[runtimev1alpha1.ResourceCredentialsSecretUserKey])
+	assert.Equal(t, databaseID, creds["database"])
+	assert.NotEmpty(t, creds["password"])
+
+	env.reconciler.reconcile(ctx, t)
+
+	getBinding, err := b.GetBinding(ctx, databaseID, bindingID)
+	require.NoError(t, err)
+	assert.Equal(t, binding.Credentials, getBinding.Credentials)
+
+	_, err = b.Deprovision(ctx, instanceID, domain.DeprovisionDetails{ServiceID: "mariadb-k8s", PlanID: "mariadb-small-premium"}, true)
+	assertStatus(t, http.StatusUnprocessableEntity, err)
+
+	_, err = b.Unbind(ctx, databaseID, bindingID, domain.UnbindDetails{ServiceID: "mariadb-k8s-database", PlanID: "mariadb-database-default"}, false)
+	require.NoError(t, err)
+
+	_, err = b.Deprovision(ctx, databaseID, domain.DeprovisionDetails{ServiceID: "mariadb-k8s-database", PlanID: "mariadb-database-default"}, true)
+	require.NoError(t, err)
+
+	_, err = b.Deprovision(ctx, instanceID, domain.DeprovisionDetails{ServiceID: "mariadb-k8s", PlanID: "mariadb-small-premium"}, true)
+	require.NoError(t, err)
+}
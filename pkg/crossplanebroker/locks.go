@@ -0,0 +1,68 @@
+package crossplanebroker
+
+import (
+	"context"
+	"sync"
+)
+
+// instanceLocks serializes mutating operations per instance ID.
+// Other keys, like the organizations of quotas, can be locked as well.
+type instanceLocks struct {
+	mu sync.Mutex
+	// locked contains a channel per locked key, it is closed on unlock.
+	locked map[string]chan struct{}
+}
+
+func newInstanceLocks() *instanceLocks {
+	return &instanceLocks{locked: map[string]chan struct{}{}}
+}
+
+// tryLock locks the instance and returns a function to unlock it.
+// It returns false without blocking if another operation holds the lock.
+func (l *instanceLocks) tryLock(instanceID string) (func(), bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.locked[instanceID]; ok {
+		return nil, false
+	}
+	return l.acquire(instanceID), true
+}
+
+// lock locks the key and returns a function to unlock it.
+// It waits for other operations holding the lock until the context is done.
+func (l *instanceLocks) lock(ctx context.Context, key string) (func(), error) {
+	for {
+		l.mu.Lock()
+		released, ok := l.locked[key]
+		if !ok {
+			unlock := l.acquire(key)
+			l.mu.Unlock()
+			return unlock, nil
+		}
+		l.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// acquire locks the key, l.mu must be held.
+func (l *instanceLocks) acquire(key string) func() {
+	released := make(chan struct{})
+	l.locked[key] = released
+	return func() {
+		l.mu.Lock()
+		delete(l.locked, key)
+		l.mu.Unlock()
+		close(released)
+	}
+}
+
+// TryLock locks the instance for operations outside of the OSB API, e.g. actions and migrations of the custom API.
+// It returns false without blocking if another operation holds the lock.
+func (b *CrossplaneBroker) TryLock(instanceID string) (func(), bool) {
+	return b.locks.tryLock(instanceID)
+}
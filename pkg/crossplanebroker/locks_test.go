@@ -0,0 +1,59 @@
+package crossplanebroker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstanceLocks(t *testing.T) {
+	l := newInstanceLocks()
+
+	unlock, ok := l.tryLock("1")
+	require.True(t, ok)
+
+	_, ok = l.tryLock("1")
+	assert.False(t, ok, "second lock of the same instance must fail")
+
+	unlock2, ok := l.tryLock("2")
+	require.True(t, ok, "other instances must not be affected")
+	unlock2()
+
+	unlock()
+	unlock, ok = l.tryLock("1")
+	assert.True(t, ok, "instance must be lockable after unlock")
+	unlock()
+}
+
+func TestInstanceLocks_Lock(t *testing.T) {
+	l := newInstanceLocks()
+	unlock, err := l.lock(context.Background(), "organization/org")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = l.lock(ctx, "organization/org")
+	assert.Equal(t, context.DeadlineExceeded, err, "lock must wait until the context is done")
+
+	locked := make(chan struct{})
+	go func() {
+		unlock, err := l.lock(context.Background(), "organization/org")
+		assert.NoError(t, err)
+		close(locked)
+		unlock()
+	}()
+	select {
+	case <-locked:
+		t.Fatal("the key must still be locked")
+	case <-time.After(10 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("waiting operations must get the lock once it is released")
+	}
+}
@@ -0,0 +1,223 @@
+//go:build integration
+// +build integration
+
+package crossplanebroker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"broker/pkg/crossplane"
+
+	helmv1alpha1 "github.com/crossplane-contrib/provider-helm/apis/release/v1alpha1"
+	runtimev1alpha1 "github.com/crossplane/crossplane-runtime/apis/core/v1alpha1"
+	"github.com/crossplane/crossplane-runtime/pkg/resource/unstructured/composite"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const loadBalancerIP = "10.0.0.1"
+
+// fakeReconciler plays the part of Crossplane and provider-helm.
+// There are no controllers running in envtest, so tests call reconcile explicitly
+// after each step which would be picked up by a controller.
+//
+// The envtest cluster is both the control plane and the downstream cluster of all instances.
+type fakeReconciler struct {
+	client         k8sclient.Client
+	namespace      string
+	haProxyRelease string
+}
+
+// reconcile makes all pending composites available.
+func (r *fakeReconciler) reconcile(ctx context.Context, t *testing.T) {
+	t.Helper()
+	require.NoError(t, r.reconcileAll(ctx))
+}
+
+// run reconciles in the given interval until the context is done.
+// It is used for clients polling the broker, e.g. the conformance checks.
+func (r *fakeReconciler) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Errors are retried in the next interval, e.g. conflicts with concurrent updates by the broker.
+			_ = r.reconcileAll(ctx)
+		}
+	}
+}
+
+func (r *fakeReconciler) reconcileAll(ctx context.Context) error {
+	for _, gvk := range []schema.GroupVersionKind{redisGVK, mariadbGVK} {
+		pending, err := r.pending(ctx, gvk)
+		if err != nil {
+			return err
+		}
+		for _, cmp := range pending {
+			port := "6379"
+			if gvk == mariadbGVK {
+				port = "3306"
+			}
+			if err := r.reconcileService(ctx, cmp, port); err != nil {
+				return err
+			}
+		}
+	}
+
+	pending, err := r.pending(ctx, databaseGVK)
+	if err != nil {
+		return err
+	}
+	for _, cmp := range pending {
+		if err := r.setAvailable(ctx, cmp); err != nil {
+			return err
+		}
+	}
+
+	pending, err = r.pending(ctx, userGVK)
+	if err != nil {
+		return err
+	}
+	for _, cmp := range pending {
+		if err := r.reconcileUser(ctx, cmp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pending lists all composites of a kind which aren't available yet.
+func (r *fakeReconciler) pending(ctx context.Context, gvk schema.GroupVersionKind) ([]*composite.Unstructured, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+	if err := r.client.List(ctx, list); err != nil {
+		return nil, err
+	}
+
+	pending := make([]*composite.Unstructured, 0)
+	for _, item := range list.Items {
+		cmp := &composite.Unstructured{Unstructured: item}
+		if cmp.GetCondition(runtimev1alpha1.TypeReady).Reason != runtimev1alpha1.ReasonAvailable {
+			pending = append(pending, cmp)
+		}
+	}
+	return pending, nil
+}
+
+// reconcileService deploys the downstream namespace, the haproxy service and release and the connection secret of an instance.
+func (r *fakeReconciler) reconcileService(ctx context.Context, cmp *composite.Unstructured, port string) error {
+	instanceID := cmp.GetName()
+
+	if err := r.create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: instanceID}}); err != nil {
+		return err
+	}
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: r.haProxyRelease, Namespace: instanceID},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeLoadBalancer,
+			Ports: []corev1.ServicePort{
+				{Name: "redis", Port: 6379},
+				{Name: "sentinel", Port: 26379},
+			},
+		},
+	}
+	if err := r.create(ctx, svc); err != nil {
+		return err
+	}
+	if err := r.client.Get(ctx, types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}, svc); err != nil {
+		return err
+	}
+	svc.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: loadBalancerIP}}
+	if err := r.client.Status().Update(ctx, svc); err != nil {
+		return err
+	}
+
+	release := &helmv1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Name: instanceID + "-haproxy"},
+		Spec: helmv1alpha1.ReleaseSpec{
+			ResourceSpec: runtimev1alpha1.ResourceSpec{
+				ProviderConfigReference: &runtimev1alpha1.Reference{Name: providerConfig},
+			},
+			ForProvider: helmv1alpha1.ReleaseParameters{
+				Chart: helmv1alpha1.ChartSpec{
+					Repository: "https://charts.example.com",
+					Name:       r.haProxyRelease,
+					Version:    "1.0.0",
+				},
+				Namespace: instanceID,
+			},
+		},
+	}
+	if err := r.create(ctx, release); err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: instanceID, Namespace: r.namespace},
+		Data: map[string][]byte{
+			runtimev1alpha1.ResourceCredentialsSecretEndpointKey: []byte(instanceID + "." + instanceID + ".svc"),
+			runtimev1alpha1.ResourceCredentialsSecretPortKey:     []byte(port),
+			runtimev1alpha1.ResourceCredentialsSecretPasswordKey: []byte("secret"),
+		},
+	}
+	if err := r.create(ctx, secret); err != nil {
+		return err
+	}
+
+	cmp.SetResourceReferences([]corev1.ObjectReference{
+		{APIVersion: helmv1alpha1.SchemeGroupVersion.String(), Kind: "Release", Name: release.Name},
+		{APIVersion: "v1", Kind: "Secret", Name: secret.Name, Namespace: secret.Namespace},
+	})
+	return r.setAvailable(ctx, cmp)
+}
+
+// reconcileUser creates the connection secret of a MariaDB user from the secret of the cluster and the user's password.
+func (r *fakeReconciler) reconcileUser(ctx context.Context, cmp *composite.Unstructured) error {
+	parent := &corev1.Secret{}
+	if err := r.client.Get(ctx, types.NamespacedName{Name: cmp.GetLabels()[crossplane.ParentIDLabel], Namespace: r.namespace}, parent); err != nil {
+		return err
+	}
+	password := &corev1.Secret{}
+	if err := r.client.Get(ctx, types.NamespacedName{Name: cmp.GetName() + "-password", Namespace: r.namespace}, password); err != nil {
+		return err
+	}
+
+	err := r.create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: cmp.GetName(), Namespace: r.namespace},
+		Data: map[string][]byte{
+			runtimev1alpha1.ResourceCredentialsSecretEndpointKey: parent.Data[runtimev1alpha1.ResourceCredentialsSecretEndpointKey],
+			runtimev1alpha1.ResourceCredentialsSecretPortKey:     parent.Data[runtimev1alpha1.ResourceCredentialsSecretPortKey],
+			runtimev1alpha1.ResourceCredentialsSecretPasswordKey: password.Data[runtimev1alpha1.ResourceCredentialsSecretPasswordKey],
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return r.setAvailable(ctx, cmp)
+}
+
+func (r *fakeReconciler) setAvailable(ctx context.Context, cmp *composite.Unstructured) error {
+	cmp.SetConditions(runtimev1alpha1.Available())
+	return r.client.Update(ctx, cmp)
+}
+
+// create creates obj, existing objects are left untouched.
+func (r *fakeReconciler) create(ctx context.Context, obj runtime.Object) error {
+	if err := r.client.Create(ctx, obj); err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package custom
+
+import (
+	"broker/pkg/auth"
+	"broker/pkg/crossplane"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/pivotal-cf/brokerapi/v7/domain/apiresponses"
+)
+
+func (h APIHandler) StartAction(ctx context.Context, instanceID string, action crossplane.ActionType, r *ActionRequest) (*Action, error) {
+	// The caller is recorded to audit actions.
+	requestedBy := ""
+	if p, ok := auth.PrincipalFromContext(ctx); ok {
+		requestedBy = p.Name
+	}
+	// Checking for actions in progress and recording the new one must not interleave.
+	unlock, ok := h.tryLock(instanceID)
+	if !ok {
+		return nil, actionError(fmt.Errorf("%w: another operation on the instance is in progress", crossplane.ErrActionInProgress))
+	}
+	defer unlock()
+	a, err := h.c.StartAction(ctx, instanceID, action, r.Node, requestedBy)
+	if err != nil {
+		return nil, actionError(err)
+	}
+	return newAction(a), nil
+}
+
+func (h APIHandler) ListActions(ctx context.Context, instanceID string) ([]Action, error) {
+	if _, err := h.c.GetInstance(ctx, instanceID); err != nil {
+		return nil, actionError(err)
+	}
+	actions, err := h.c.ListActions(ctx, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	l := make([]Action, 0, len(actions))
+	for i := range actions {
+		l = append(l, *newAction(&actions[i]))
+	}
+	return l, nil
+}
+
+func (h APIHandler) Action(ctx context.Context, instanceID, actionID string) (*Action, error) {
+	a, err := h.c.AdvanceAction(ctx, instanceID, actionID)
+	if err != nil {
+		return nil, actionError(err)
+	}
+	return newAction(a), nil
+}
+
+func newAction(a *crossplane.Action) *Action {
+	return &Action{
+		ID:                a.ID,
+		ServiceInstanceID: a.InstanceID,
+		Action:            a.Type,
+		Node:              a.Node,
+		State:             a.State,
+		Description:       a.Message,
+		RequestedBy:       a.RequestedBy,
+		StartedAt:         a.StartedAt,
+		UpdatedAt:         a.UpdatedAt,
+	}
+}
+
+// actionError converts the errors of actions to API errors.
+func actionError(err error) error {
+	code := 0
+	switch {
+	case errors.Is(err, crossplane.ErrInstanceNotFound):
+		return notFoundError("instance not found", err)
+	case errors.Is(err, crossplane.ErrActionNotFound):
+		return notFoundError("instance has no such action", err)
+	case errors.Is(err, crossplane.ErrActionInProgress):
+		code = http.StatusConflict
+	case errors.Is(err, crossplane.ErrActionRateLimited):
+		code = http.StatusTooManyRequests
+	case errors.Is(err, crossplane.ErrActionNotPermitted):
+		code = http.StatusUnprocessableEntity
+	default:
+		return err
+	}
+	return APIError{
+		code: code,
+		err: apiresponses.ErrorResponse{
+			Error:       "InvalidAction",
+			Description: err.Error(),
+		},
+	}
+}
@@ -1,20 +1,26 @@
 package custom
 
 import (
+	"broker/pkg/auth"
 	"broker/pkg/crossplane"
+	"broker/pkg/dashboard"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
 
 	"code.cloudfoundry.org/lager"
 	"github.com/gorilla/mux"
 	"github.com/pivotal-cf/brokerapi/v7/domain/apiresponses"
+	"github.com/prometheus/common/expfmt"
 )
 
 type API struct {
 	handler CustomAPI
+	c       *crossplane.Crossplane
 	logger  lager.Logger
 }
 
@@ -30,20 +36,45 @@ func (ae APIError) Error() string {
 func NewAPI(router *mux.Router, handler *APIHandler, logger lager.Logger) *API {
 	api := API{
 		handler: handler,
+		c:       handler.c,
 		logger:  logger,
 	}
 
-	router.HandleFunc("/custom/service_instances/{service_instance_id}/endpoint", api.Endpoints).Methods("GET")
-	router.HandleFunc("/custom/service_instances/{service_instance_id}/usage", api.ServiceUsage).Methods("GET")
-	router.HandleFunc("/custom/admin/service-definition", api.CreateUpdateServiceDefinition).Methods("POST")
-	router.HandleFunc("/custom/admin/service-definition/{id}", api.DeleteServiceDefinition).Methods("DELETE")
-	router.HandleFunc("/custom/service_instances/{service_instance_id}/backups", api.CreateBackup).Methods("POST")
-	router.HandleFunc("/custom/service_instances/{service_instance_id}/backups/{backup_id}", api.DeleteBackup).Methods("DELETE")
-	router.HandleFunc("/custom/service_instances/{service_instance_id}/backups/{backup_id}", api.Backup).Methods("GET")
-	router.HandleFunc("/custom/service_instances/{service_instance_id}/backups", api.ListBackups).Methods("GET")
-	router.HandleFunc("/custom/service_instances/{service_instance_id}/backups/{backup_id}/restores", api.RestoreBackup).Methods("POST")
-	router.HandleFunc("/custom/service_instances/{service_instance_id}/backups/{backup_id}/restores/{restore_id}", api.Endpoints).Methods("GET")
-	router.HandleFunc("/custom/service_instances/{service_instance_id}/api-docs", api.APIDocs).Methods("GET")
+	adminRouter := router.PathPrefix("/custom/admin").Subrouter()
+	adminRouter.Use(auth.RequireRole(auth.RoleAdmin))
+	adminRouter.HandleFunc("/service-definition", api.CreateUpdateServiceDefinition).Methods("POST")
+	adminRouter.HandleFunc("/service-definition/{id}", api.DeleteServiceDefinition).Methods("DELETE")
+	adminRouter.HandleFunc("/clusters", api.Clusters).Methods("GET")
+	adminRouter.HandleFunc("/service_instances", api.ListInstances).Methods("GET")
+	adminRouter.HandleFunc("/service_instances/{service_instance_id}/migration", api.StartMigration).Methods("POST")
+	adminRouter.HandleFunc("/service_instances/{service_instance_id}/migration", api.Migration).Methods("GET")
+
+	instanceRouter := router.PathPrefix("/custom/service_instances/{service_instance_id}").Subrouter()
+	instanceRouter.Use(auth.RequireRole(auth.RolePlatform, auth.RoleInstanceOwner))
+	instanceRouter.Use(api.requireInstanceOwner)
+	instanceRouter.HandleFunc("/endpoint", api.Endpoints).Methods("GET")
+	instanceRouter.HandleFunc("/usage", api.ServiceUsage).Methods("GET")
+	instanceRouter.HandleFunc("/service_bindings", api.ListBindings).Methods("GET")
+	instanceRouter.HandleFunc("/upgrades", api.Upgrades).Methods("GET")
+	instanceRouter.HandleFunc("/dashboard", api.DashboardLink).Methods("POST")
+	instanceRouter.HandleFunc("/logs", api.Logs).Methods("GET")
+	instanceRouter.HandleFunc("/metrics", api.Metrics).Methods("GET")
+	instanceRouter.HandleFunc("/actions", api.ListActions).Methods("GET")
+	instanceRouter.HandleFunc("/actions/{action}", api.StartAction).Methods("POST")
+	instanceRouter.HandleFunc("/actions/{action_id}", api.Action).Methods("GET")
+	instanceRouter.HandleFunc("/backups", api.CreateBackup).Methods("POST")
+	instanceRouter.HandleFunc("/backups/{backup_id}", api.DeleteBackup).Methods("DELETE")
+	instanceRouter.HandleFunc("/backups/{backup_id}", api.Backup).Methods("GET")
+	instanceRouter.HandleFunc("/backups", api.ListBackups).Methods("GET")
+	instanceRouter.HandleFunc("/backups/{backup_id}/restores", api.RestoreBackup).Methods("POST")
+	instanceRouter.HandleFunc("/backups/{backup_id}/restores/{restore_id}", api.Endpoints).Methods("GET")
+	instanceRouter.HandleFunc("/api-docs", api.APIDocs).Methods("GET")
+
+	// The dashboard URL returned to the platform, browsers are redirected to a signed dashboard link.
+	launchRouter := router.NewRoute().Subrouter()
+	launchRouter.Use(auth.RequireRole(auth.RolePlatform, auth.RoleInstanceOwner))
+	launchRouter.Use(api.requireInstanceOwner)
+	launchRouter.HandleFunc(dashboard.PathPrefix+"{service_instance_id}"+dashboard.LaunchPath, api.LaunchDashboard).Methods("GET")
 
 	return &api
 }
@@ -96,6 +127,183 @@ func (a API) ServiceUsage(w http.ResponseWriter, req *http.Request) {
 	a.respond(w, http.StatusOK, r)
 }
 
+func (a API) ListBindings(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	instanceID := vars["service_instance_id"]
+
+	r, err := a.handler.ListBindings(req.Context(), instanceID)
+	if err != nil {
+		a.handleAPIError(req.Context(), w, err)
+		return
+	}
+	a.respond(w, http.StatusOK, r)
+}
+
+func (a API) Upgrades(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	instanceID := vars["service_instance_id"]
+
+	r, err := a.handler.Upgrades(req.Context(), instanceID)
+	if err != nil {
+		a.handleAPIError(req.Context(), w, err)
+		return
+	}
+	a.respond(w, http.StatusOK, r)
+}
+
+func (a API) DashboardLink(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	instanceID := vars["service_instance_id"]
+
+	r, err := a.handler.DashboardLink(req.Context(), instanceID)
+	if err != nil {
+		a.handleAPIError(req.Context(), w, err)
+		return
+	}
+	a.respond(w, http.StatusOK, r)
+}
+
+// Logs streams the log lines as JSON, one line per log line, or as server-sent events if the client accepts them.
+// Errors occurring after the first line has been sent can't change the status anymore, they end the stream.
+func (a API) Logs(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	instanceID := vars["service_instance_id"]
+
+	opts, err := parseLogQuery(req.URL.Query())
+	if err != nil {
+		a.handleAPIError(req.Context(), w, err)
+		return
+	}
+
+	sse := strings.Contains(req.Header.Get("Accept"), "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	started := false
+	start := func() {
+		started = true
+		if sse {
+			w.Header().Set("Content-Type", "text/event-stream")
+		} else {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+	}
+	err = a.handler.Logs(req.Context(), instanceID, opts, func(l crossplane.LogLine) error {
+		if !started {
+			start()
+		}
+		if sse {
+			if _, err := io.WriteString(w, "event: log\ndata: "); err != nil {
+				return err
+			}
+		}
+		// Encode terminates the line, server-sent events are terminated by an empty line.
+		if err := encoder.Encode(l); err != nil {
+			return err
+		}
+		if sse {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	switch {
+	case err != nil && !started:
+		a.handleAPIError(req.Context(), w, err)
+	case err != nil:
+		a.logger.Error("stream-logs", err, lager.Data{"instance-id": instanceID})
+		if sse {
+			io.WriteString(w, "event: error\ndata: {\"description\": \"streaming logs failed\"}\n\n")
+		}
+	case !started:
+		start()
+	}
+}
+
+// Metrics responds with the normalized metrics as JSON or, with `format=prometheus`, with the metrics in the Prometheus text format.
+func (a API) Metrics(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	instanceID := vars["service_instance_id"]
+
+	format := req.URL.Query().Get("format")
+	if format != "" && format != metricsFormatJSON && format != metricsFormatPrometheus {
+		a.handleAPIError(req.Context(), w, badRequestError(fmt.Errorf("invalid format %q: must be %s or %s", format, metricsFormatJSON, metricsFormatPrometheus)))
+		return
+	}
+
+	r, err := a.handler.Metrics(req.Context(), instanceID)
+	if err != nil {
+		a.handleAPIError(req.Context(), w, err)
+		return
+	}
+	if format != metricsFormatPrometheus {
+		a.respond(w, http.StatusOK, r)
+		return
+	}
+	w.Header().Set("Content-Type", string(expfmt.FmtText))
+	w.WriteHeader(http.StatusOK)
+	if err := r.WriteText(w); err != nil {
+		a.logger.Error("encoding metrics", err, lager.Data{"instance-id": instanceID})
+	}
+}
+
+func (a API) StartAction(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	instanceID := vars["service_instance_id"]
+	action := crossplane.ActionType(vars["action"])
+
+	// The request body is optional, restarts of all nodes don't need one.
+	var ar ActionRequest
+	err := json.NewDecoder(req.Body).Decode(&ar)
+	if err != nil && !errors.Is(err, io.EOF) {
+		a.handleAPIError(req.Context(), w, APIError{
+			code: http.StatusBadRequest,
+			err: apiresponses.ErrorResponse{
+				Error: err.Error(),
+			},
+		})
+		return
+	}
+	defer req.Body.Close()
+
+	r, err := a.handler.StartAction(req.Context(), instanceID, action, &ar)
+	if err != nil {
+		a.handleAPIError(req.Context(), w, err)
+		return
+	}
+	a.respond(w, http.StatusAccepted, r)
+}
+
+func (a API) ListActions(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	instanceID := vars["service_instance_id"]
+
+	r, err := a.handler.ListActions(req.Context(), instanceID)
+	if err != nil {
+		a.handleAPIError(req.Context(), w, err)
+		return
+	}
+	a.respond(w, http.StatusOK, r)
+}
+
+func (a API) Action(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	instanceID := vars["service_instance_id"]
+	actionID := vars["action_id"]
+
+	r, err := a.handler.Action(req.Context(), instanceID, actionID)
+	if err != nil {
+		a.handleAPIError(req.Context(), w, err)
+		return
+	}
+	a.respond(w, http.StatusOK, r)
+}
+
 func (a API) CreateUpdateServiceDefinition(w http.ResponseWriter, req *http.Request) {
 	var sd ServiceDefinitionRequest
 	err := json.NewDecoder(req.Body).Decode(&sd)
@@ -129,6 +337,67 @@ func (a API) DeleteServiceDefinition(w http.ResponseWriter, req *http.Request) {
 	a.respond(w, http.StatusNoContent, nil)
 }
 
+func (a API) Clusters(w http.ResponseWriter, req *http.Request) {
+	r, err := a.handler.Clusters(req.Context())
+	if err != nil {
+		a.handleAPIError(req.Context(), w, err)
+		return
+	}
+	a.respond(w, http.StatusOK, r)
+}
+
+func (a API) ListInstances(w http.ResponseWriter, req *http.Request) {
+	q, err := parseInstanceQuery(req.URL.Query())
+	if err != nil {
+		a.handleAPIError(req.Context(), w, err)
+		return
+	}
+
+	r, err := a.handler.ListInstances(req.Context(), q)
+	if err != nil {
+		a.handleAPIError(req.Context(), w, err)
+		return
+	}
+	a.respond(w, http.StatusOK, r)
+}
+
+func (a API) StartMigration(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	instanceID := vars["service_instance_id"]
+
+	var mr MigrationRequest
+	err := json.NewDecoder(req.Body).Decode(&mr)
+	if err != nil {
+		a.handleAPIError(req.Context(), w, APIError{
+			code: http.StatusBadRequest,
+			err: apiresponses.ErrorResponse{
+				Error: err.Error(),
+			},
+		})
+		return
+	}
+	defer req.Body.Close()
+
+	r, err := a.handler.StartMigration(req.Context(), instanceID, &mr)
+	if err != nil {
+		a.handleAPIError(req.Context(), w, err)
+		return
+	}
+	a.respond(w, http.StatusAccepted, r)
+}
+
+func (a API) Migration(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	instanceID := vars["service_instance_id"]
+
+	r, err := a.handler.Migration(req.Context(), instanceID)
+	if err != nil {
+		a.handleAPIError(req.Context(), w, err)
+		return
+	}
+	a.respond(w, http.StatusOK, r)
+}
+
 func (a API) CreateBackup(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	instanceID := vars["service_instance_id"]
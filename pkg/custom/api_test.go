@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"broker/pkg/auth"
 	"broker/pkg/crossplane"
 	"broker/pkg/custom"
 
@@ -14,9 +15,16 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func newTestAPI() (func(), string) {
+var admin = &auth.Principal{Name: "admin", Roles: []auth.Role{auth.RoleAdmin}}
+
+func newTestAPI(p *auth.Principal) (func(), string) {
 	logger := lager.NewLogger("testing")
 	router := mux.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			next.ServeHTTP(w, req.WithContext(auth.WithPrincipal(req.Context(), p)))
+		})
+	})
 	cp := &crossplane.Crossplane{}
 	handler := custom.NewAPIHandler(cp, logger)
 
@@ -27,7 +35,7 @@ func newTestAPI() (func(), string) {
 }
 
 // func TestAPI_Endpoints(t *testing.T) {
-// 	close, url := newTestAPI()
+// 	close, url := newTestAPI(admin)
 // 	defer close()
 
 // 	res, err := http.Get(url + "/custom/service_instances/test/endpoint")
@@ -43,7 +51,7 @@ func newTestAPI() (func(), string) {
 // 	assert.Len(t, endpoints, 0)
 // }
 func TestAPI_NotImplementedEndpoint(t *testing.T) {
-	close, url := newTestAPI()
+	close, url := newTestAPI(admin)
 	defer close()
 
 	res, err := http.Get(url + "/custom/service_instances/test/usage")
@@ -62,3 +70,33 @@ func TestAPI_NotImplementedEndpoint(t *testing.T) {
 	assert.Equal(t, notImplemented.Error, "API not implemented")
 	assert.Equal(t, notImplemented.Description, "API not implemented")
 }
+
+func TestAPI_AdminRequiresAdminRole(t *testing.T) {
+	close, url := newTestAPI(&auth.Principal{Name: "platform", Roles: []auth.Role{auth.RolePlatform}})
+	defer close()
+
+	res, err := http.Post(url+"/custom/admin/service-definition", "application/json", nil)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+}
+
+func TestAPI_InstanceOwnerRequiresTenant(t *testing.T) {
+	close, url := newTestAPI(&auth.Principal{Name: "user", Roles: []auth.Role{auth.RoleInstanceOwner}})
+	defer close()
+
+	res, err := http.Get(url + "/custom/service_instances/test/endpoint")
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusForbidden, res.StatusCode, "instance owners without a tenant must not access untenanted instances")
+}
+
+func TestAPI_MetricsInvalidFormat(t *testing.T) {
+	close, url := newTestAPI(admin)
+	defer close()
+
+	res, err := http.Get(url + "/custom/service_instances/test/metrics?format=xml")
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
@@ -0,0 +1,58 @@
+package custom
+
+import (
+	"errors"
+	"net/http"
+
+	"broker/pkg/auth"
+	"broker/pkg/crossplane"
+
+	"github.com/gorilla/mux"
+	"github.com/pivotal-cf/brokerapi/v7/domain/apiresponses"
+)
+
+// requireInstanceOwner rejects requests for instances not belonging to the tenant of the caller.
+// Admins may access all instances, instance owners without a tenant none.
+func (a API) requireInstanceOwner(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		p, ok := auth.PrincipalFromContext(req.Context())
+		if !ok {
+			a.handleAPIError(req.Context(), w, forbiddenError("not authenticated"))
+			return
+		}
+		if p.HasRole(auth.RoleAdmin) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		// Instances provisioned by principals without a tenant aren't labeled with one, they belong to the platform.
+		if p.Tenant == "" && !p.HasRole(auth.RolePlatform) {
+			a.handleAPIError(req.Context(), w, forbiddenError("instance owners must belong to a tenant"))
+			return
+		}
+
+		instanceID := mux.Vars(req)["service_instance_id"]
+		instance, err := a.c.GetInstance(req.Context(), instanceID)
+		if err != nil {
+			if errors.Is(err, crossplane.ErrInstanceNotFound) {
+				err = notFoundError("instance not found", err)
+			}
+			a.handleAPIError(req.Context(), w, err)
+			return
+		}
+		if instance.GetLabels()[crossplane.TenantLabel] != p.Tenant {
+			a.handleAPIError(req.Context(), w, forbiddenError("instance is owned by another tenant"))
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func forbiddenError(description string) error {
+	return APIError{
+		code: http.StatusForbidden,
+		err: apiresponses.ErrorResponse{
+			Error:       "Forbidden",
+			Description: description,
+		},
+	}
+}
@@ -0,0 +1,32 @@
+package custom
+
+import (
+	"broker/pkg/crossplane"
+	"context"
+	"errors"
+)
+
+func (h APIHandler) ListBindings(ctx context.Context, instanceID string) ([]ServiceBinding, error) {
+	if _, err := h.c.GetInstance(ctx, instanceID); err != nil {
+		if errors.Is(err, crossplane.ErrInstanceNotFound) {
+			return nil, notFoundError("instance not found", err)
+		}
+		return nil, err
+	}
+
+	bindings, err := h.c.ListBindings(ctx, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]ServiceBinding, 0, len(bindings))
+	for _, b := range bindings {
+		res = append(res, ServiceBinding{
+			ID:                b.ID,
+			ServiceInstanceID: b.InstanceID,
+			AppGUID:           b.AppGUID,
+			CreatedAt:         b.CreatedAt,
+			CredentialVersion: b.CredentialVersion,
+		})
+	}
+	return res, nil
+}
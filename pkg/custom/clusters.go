@@ -0,0 +1,10 @@
+package custom
+
+import (
+	"broker/pkg/crossplane"
+	"context"
+)
+
+func (h APIHandler) Clusters(ctx context.Context) ([]crossplane.ClusterHealth, error) {
+	return h.c.Downstream.Health(), nil
+}
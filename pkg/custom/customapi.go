@@ -1,6 +1,7 @@
 package custom
 
 import (
+	"broker/pkg/crossplane"
 	"context"
 	"time"
 )
@@ -19,6 +20,42 @@ type CustomAPI interface {
 	// DeleteServiceDefinition
 	// DELETE /custom/admin/service-definition/{id}
 	DeleteServiceDefinition(ctx context.Context, id string) error
+	// Clusters lists the health of the downstream clusters
+	// GET /custom/admin/clusters
+	Clusters(ctx context.Context) ([]crossplane.ClusterHealth, error)
+	// ListInstances lists and searches service instances
+	// GET /custom/admin/service_instances
+	ListInstances(ctx context.Context, q InstanceQuery) (*InstanceList, error)
+	// StartMigration starts moving an instance to another cluster of the placement pool
+	// POST /custom/admin/service_instances/{service_instance_id}/migration
+	StartMigration(ctx context.Context, instanceID string, r *MigrationRequest) (*Migration, error)
+	// Migration returns the progress of the last migration of an instance and advances it
+	// GET /custom/admin/service_instances/{service_instance_id}/migration
+	Migration(ctx context.Context, instanceID string) (*Migration, error)
+	// ListBindings lists the bindings of an instance
+	// GET /custom/service_instances/{service_instance_id}/service_bindings
+	ListBindings(ctx context.Context, instanceID string) ([]ServiceBinding, error)
+	// Upgrades returns the maintenance window and the pending and last upgrade of an instance
+	// GET /custom/service_instances/{service_instance_id}/upgrades
+	Upgrades(ctx context.Context, instanceID string) (*Upgrades, error)
+	// DashboardLink returns a signed, short-lived link to the dashboard of an instance
+	// POST /custom/service_instances/{service_instance_id}/dashboard
+	DashboardLink(ctx context.Context, instanceID string) (*DashboardLink, error)
+	// Logs streams the logs of the pods of an instance to send
+	// GET /custom/service_instances/{service_instance_id}/logs
+	Logs(ctx context.Context, instanceID string, opts crossplane.LogOptions, send func(crossplane.LogLine) error) error
+	// Metrics returns the operational metrics of the nodes of an instance, read from their exporters
+	// GET /custom/service_instances/{service_instance_id}/metrics
+	Metrics(ctx context.Context, instanceID string) (*crossplane.InstanceMetrics, error)
+	// StartAction restarts a node of an instance or fails over its Redis master
+	// POST /custom/service_instances/{service_instance_id}/actions/{action}
+	StartAction(ctx context.Context, instanceID string, action crossplane.ActionType, r *ActionRequest) (*Action, error)
+	// ListActions lists the recorded actions of an instance, the latest first
+	// GET /custom/service_instances/{service_instance_id}/actions
+	ListActions(ctx context.Context, instanceID string) ([]Action, error)
+	// Action returns the progress of an action and advances it
+	// GET /custom/service_instances/{service_instance_id}/actions/{action_id}
+	Action(ctx context.Context, instanceID, actionID string) (*Action, error)
 	// CreateBackup
 	// POST /custom/service_instances/{service_instance_id}/backups
 	CreateBackup(ctx context.Context, instanceID string, b *BackupRequest) (*Backup, error)
@@ -49,6 +86,122 @@ type Endpoint struct {
 	Protocol    string `json:"protocol"`
 }
 
+// InstanceQuery filters and paginates service instances.
+type InstanceQuery struct {
+	crossplane.InstanceFilter
+	Offset int
+	Limit  int
+}
+
+// InstanceList is a page of service instances.
+type InstanceList struct {
+	Total            int               `json:"total"`
+	Offset           int               `json:"offset"`
+	Limit            int               `json:"limit"`
+	ServiceInstances []ServiceInstance `json:"service_instances"`
+}
+
+// ServiceInstance summarizes a service instance.
+type ServiceInstance struct {
+	ID          string         `json:"id"`
+	ServiceID   string         `json:"service_id"`
+	ServiceName string         `json:"service_name"`
+	PlanID      string         `json:"plan_id"`
+	PlanName    string         `json:"plan_name"`
+	SLA         string         `json:"sla"`
+	Cluster     string         `json:"cluster"`
+	ParentID    string         `json:"parent_id,omitempty"`
+	Tenant      string         `json:"tenant,omitempty"`
+	Status      InstanceStatus `json:"status"`
+	CreatedAt   time.Time      `json:"created_at"`
+	// Context is the platform context the instance has been provisioned or last updated with.
+	Context crossplane.InstanceContext `json:"context"`
+}
+
+// InstanceStatus is the ready condition of a service instance.
+type InstanceStatus struct {
+	Ready   bool   `json:"ready"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// ServiceBinding is a binding of a service instance.
+type ServiceBinding struct {
+	ID                string    `json:"id"`
+	ServiceInstanceID string    `json:"service_instance_id"`
+	AppGUID           string    `json:"app_guid,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+	CredentialVersion string    `json:"credential_version"`
+}
+
+// MigrationRequest requests moving an instance to another cluster.
+type MigrationRequest struct {
+	TargetCluster string `json:"target_cluster"`
+	// SkipDataCopy starts the instance out empty on the target cluster instead of copying its data.
+	SkipDataCopy bool `json:"skip_data_copy"`
+}
+
+// Migration is the operation moving an instance to another cluster.
+type Migration struct {
+	ServiceInstanceID string                    `json:"service_instance_id"`
+	SourceCluster     string                    `json:"source_cluster"`
+	TargetCluster     string                    `json:"target_cluster"`
+	State             crossplane.MigrationState `json:"state"`
+	Description       string                    `json:"description,omitempty"`
+	SkipDataCopy      bool                      `json:"skip_data_copy"`
+	CopiedKeys        int                       `json:"copied_keys"`
+	StartedAt         time.Time                 `json:"started_at"`
+	UpdatedAt         time.Time                 `json:"updated_at"`
+}
+
+// Upgrades are the maintenance window and the pending and last upgrade of an instance.
+type Upgrades struct {
+	MaintenanceWindow string `json:"maintenance_window,omitempty"`
+	// Pending is missing if the instance runs the chart versions of its plan.
+	Pending *PendingUpgrade `json:"pending,omitempty"`
+	// Last is missing if the instance has never been upgraded.
+	Last *LastUpgrade `json:"last,omitempty"`
+}
+
+// PendingUpgrade is an upgrade to be done in the next maintenance window.
+type PendingUpgrade struct {
+	Charts []crossplane.ChartUpgrade `json:"charts"`
+	// ScheduledAt is the start of the next maintenance window, missing if the instance has no window.
+	ScheduledAt *time.Time `json:"scheduled_at,omitempty"`
+}
+
+// LastUpgrade is the last upgrade done.
+type LastUpgrade struct {
+	Charts     []crossplane.ChartUpgrade `json:"charts"`
+	UpgradedAt time.Time                 `json:"upgraded_at"`
+}
+
+// DashboardLink grants access to the dashboard of an instance until it expires.
+type DashboardLink struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ActionRequest requests an action on an instance.
+type ActionRequest struct {
+	// Node is the pod to act on. Restarts without a node restart all nodes one after another,
+	// failovers require the node of the current Redis master.
+	Node string `json:"node,omitempty"`
+}
+
+// Action is an operation on the nodes of an instance, recorded with the caller requesting it.
+type Action struct {
+	ID                string                 `json:"id"`
+	ServiceInstanceID string                 `json:"service_instance_id"`
+	Action            crossplane.ActionType  `json:"action"`
+	Node              string                 `json:"node,omitempty"`
+	State             crossplane.ActionState `json:"state"`
+	Description       string                 `json:"description,omitempty"`
+	RequestedBy       string                 `json:"requested_by"`
+	StartedAt         time.Time              `json:"started_at"`
+	UpdatedAt         time.Time              `json:"updated_at"`
+}
+
 type UsageUnit string
 type UsageType string
 
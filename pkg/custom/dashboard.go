@@ -0,0 +1,191 @@
+package custom
+
+import (
+	"broker/pkg/crossplane"
+	"broker/pkg/dashboard"
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/gorilla/mux"
+	"github.com/pivotal-cf/brokerapi/v7/domain/apiresponses"
+)
+
+var dashboardsDisabled = APIError{
+	code: http.StatusNotImplemented,
+	err: apiresponses.ErrorResponse{
+		Error:       "DashboardsDisabled",
+		Description: "dashboards are not enabled",
+	},
+}
+
+// LaunchDashboard redirects the dashboard URL returned to the platform to a dashboard URL signed with a fresh token.
+// It's served by the API, requests are authenticated and authorized like those of the other instance APIs.
+func (a API) LaunchDashboard(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	link, err := a.handler.DashboardLink(req.Context(), mux.Vars(req)["service_instance_id"])
+	if err != nil {
+		a.handleAPIError(req.Context(), w, err)
+		return
+	}
+	http.Redirect(w, req, link.URL, http.StatusSeeOther)
+}
+
+func (h APIHandler) DashboardLink(ctx context.Context, instanceID string) (*DashboardLink, error) {
+	if h.Dashboard == nil {
+		return nil, dashboardsDisabled
+	}
+	if _, err := h.c.GetInstance(ctx, instanceID); err != nil {
+		if errors.Is(err, crossplane.ErrInstanceNotFound) {
+			return nil, notFoundError("instance not found", err)
+		}
+		return nil, err
+	}
+	url, expiresAt, err := h.Dashboard.SignedURL(instanceID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &DashboardLink{URL: url, ExpiresAt: expiresAt}, nil
+}
+
+// DashboardPage serves the dashboards of instances.
+// Dashboards aren't protected by the broker's authentication but by the token of a signed dashboard link.
+// The dashboard URL returned to the platform is served by the API, see API.LaunchDashboard.
+type DashboardPage struct {
+	handler *APIHandler
+	logger  lager.Logger
+}
+
+// NewDashboardPage attaches the dashboards to a router which doesn't authenticate requests.
+func NewDashboardPage(router *mux.Router, handler *APIHandler, logger lager.Logger) *DashboardPage {
+	p := &DashboardPage{handler: handler, logger: logger}
+	router.HandleFunc(dashboard.PathPrefix+"{service_instance_id}", p.ServeHTTP).Methods("GET")
+	return p
+}
+
+// dashboardData is rendered by the dashboard template.
+// Sections which can't be loaded have an error message instead of their data.
+type dashboardData struct {
+	InstanceID     string
+	PlanID         string
+	Metadata       crossplane.InstanceMetadata
+	Endpoints      []Endpoint
+	EndpointsError string
+	Backups        []Backup
+	BackupsError   string
+	Usage          *ServiceUsage
+	UsageError     string
+}
+
+func (p *DashboardPage) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// The token is part of the URL, it must neither be cached nor leak to other sites.
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+
+	ctx := req.Context()
+	instanceID := mux.Vars(req)["service_instance_id"]
+	logger := p.logger.WithData(lager.Data{"instance-id": instanceID})
+	if p.handler.Dashboard == nil {
+		http.NotFound(w, req)
+		return
+	}
+	if err := p.handler.Dashboard.Verify(req.URL.Query().Get(dashboard.TokenParam), instanceID, time.Now()); err != nil {
+		logger.Info("dashboard-token-rejected", lager.Data{"error": err.Error()})
+		http.Error(w, "The dashboard link is invalid or has expired, request a new one with POST /custom/service_instances/"+instanceID+"/dashboard.", http.StatusUnauthorized)
+		return
+	}
+
+	instance, err := p.handler.c.GetInstance(ctx, instanceID)
+	if err != nil {
+		if errors.Is(err, crossplane.ErrInstanceNotFound) {
+			http.NotFound(w, req)
+			return
+		}
+		logger.Error("dashboard-get-instance", err)
+		http.Error(w, "Unable to load the instance.", http.StatusInternalServerError)
+		return
+	}
+	data := dashboardData{InstanceID: instanceID}
+	if ref := instance.GetCompositionReference(); ref != nil {
+		data.PlanID = ref.Name
+	}
+	if data.Metadata, err = p.handler.c.GetInstanceMetadata(ctx, instance); err != nil {
+		logger.Error("dashboard-get-metadata", err)
+	}
+	data.Endpoints, err = p.handler.Endpoints(ctx, instanceID)
+	data.EndpointsError = p.sectionError(logger, "endpoints", err)
+	data.Backups, err = p.handler.ListBackups(ctx, instanceID)
+	data.BackupsError = p.sectionError(logger, "backups", err)
+	data.Usage, err = p.handler.ServiceUsage(ctx, instanceID)
+	data.UsageError = p.sectionError(logger, "usage", err)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, data); err != nil {
+		logger.Error("dashboard-render", err)
+	}
+}
+
+// sectionError returns the message shown instead of a section of the dashboard which couldn't be loaded.
+// Internal errors are logged but not shown.
+func (p *DashboardPage) sectionError(logger lager.Logger, section string, err error) string {
+	if err == nil {
+		return ""
+	}
+	var ae APIError
+	if errors.As(err, &ae) && ae.code == http.StatusNotImplemented {
+		return "Not available for this instance."
+	}
+	logger.Error("dashboard-"+section, err)
+	return "Unable to load the " + section + "."
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Instance {{.InstanceID}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { text-align: left; padding: 0.2em 1em 0.2em 0; }
+</style>
+</head>
+<body>
+<h1>Instance {{.InstanceID}}</h1>
+
+<h2>Status</h2>
+<table>
+<tr><th>Plan</th><td>{{.PlanID}}</td></tr>
+{{with .Metadata.Labels.sla}}<tr><th>SLA</th><td>{{.}}</td></tr>{{end}}
+{{with .Metadata.Labels.cluster}}<tr><th>Cluster</th><td>{{.}}</td></tr>{{end}}
+<tr><th>Ready</th><td>{{.Metadata.Attributes.ready}}{{with .Metadata.Attributes.ready_reason}} ({{.}}){{end}}</td></tr>
+{{range $chart, $version := .Metadata.Attributes.charts}}<tr><th>Chart {{$chart}}</th><td>{{$version}}</td></tr>{{end}}
+{{if .Metadata.Attributes.upgrade_available}}<tr><th>Upgrade</th><td>available in the next maintenance window</td></tr>{{end}}
+</table>
+
+<h2>Endpoints</h2>
+{{if .EndpointsError}}<p>{{.EndpointsError}}</p>{{else}}
+<table>
+<tr><th>Destination</th><th>Ports</th><th>Protocol</th></tr>
+{{range .Endpoints}}<tr><td>{{.Destination}}</td><td>{{.Ports}}</td><td>{{.Protocol}}</td></tr>{{end}}
+</table>{{end}}
+
+<h2>Backups</h2>
+{{if .BackupsError}}<p>{{.BackupsError}}</p>{{else if not .Backups}}<p>No backups.</p>{{else}}
+<table>
+<tr><th>ID</th><th>Created</th><th>Status</th></tr>
+{{range .Backups}}<tr><td>{{.ID}}</td><td>{{.CreatedAt.Format "2006-01-02 15:04 MST"}}</td><td>{{.Status}}</td></tr>{{end}}
+</table>{{end}}
+
+<h2>Usage</h2>
+{{if .UsageError}}<p>{{.UsageError}}</p>{{else}}{{with .Usage}}<p>{{.Value}} {{.Unit}} ({{.Type}}) until {{.EndDate.Format "2006-01-02"}}</p>{{end}}{{end}}
+</body>
+</html>
+`))
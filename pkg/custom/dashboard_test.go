@@ -0,0 +1,159 @@
+package custom
+
+import (
+	"broker/pkg/auth"
+	"broker/pkg/config"
+	"broker/pkg/crossplane"
+	"broker/pkg/dashboard"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newTestDashboard(t *testing.T) *dashboard.Dashboard {
+	dir, err := ioutil.TempDir("", "dashboard")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	keyFile := filepath.Join(dir, "key")
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte(strings.Repeat("k", 32)), 0600))
+
+	d, err := dashboard.New(config.DashboardConfig{
+		URL:          "https://broker.example.com",
+		TokenKeyFile: keyFile,
+		TokenTTL:     metav1.Duration{Duration: time.Minute},
+	})
+	require.NoError(t, err)
+	return d
+}
+
+func TestAPIHandler_DashboardLink(t *testing.T) {
+	ctx := context.Background()
+	apiHandler := createAPIHandler([]runtime.Object{newTestInstance("instance", time.Now(), true, nil)})
+
+	_, err := apiHandler.DashboardLink(ctx, "instance")
+	var apiErr APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotImplemented, apiErr.code, "dashboards are disabled")
+
+	apiHandler.Dashboard = newTestDashboard(t)
+	link, err := apiHandler.DashboardLink(ctx, "instance")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(link.URL, "https://broker.example.com/dashboard/service_instances/instance?token="), link.URL)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), link.ExpiresAt, 2*time.Second)
+
+	_, err = apiHandler.DashboardLink(ctx, "unknown")
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.code)
+}
+
+func TestDashboardPage(t *testing.T) {
+	apiHandler := createAPIHandler([]runtime.Object{newTestInstance("instance", time.Now(), true, nil)})
+	apiHandler.Dashboard = newTestDashboard(t)
+	router := mux.NewRouter()
+	NewDashboardPage(router, apiHandler, lager.NewLogger("dashboard"))
+
+	link, err := apiHandler.DashboardLink(context.Background(), "instance")
+	require.NoError(t, err)
+	signed, err := url.Parse(link.URL)
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		url      string
+		code     int
+		contains string
+	}{
+		"signed link": {
+			url:      signed.RequestURI(),
+			code:     http.StatusOK,
+			contains: "<h1>Instance instance</h1>",
+		},
+		"without token": {
+			url:  "/dashboard/service_instances/instance",
+			code: http.StatusUnauthorized,
+		},
+		"token of another instance": {
+			url:  "/dashboard/service_instances/other?" + signed.RawQuery,
+			code: http.StatusUnauthorized,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			assert.Equal(t, tt.code, rec.Code)
+			assert.Contains(t, rec.Body.String(), tt.contains)
+			assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+		})
+	}
+}
+
+func TestAPI_LaunchDashboard(t *testing.T) {
+	apiHandler := createAPIHandler([]runtime.Object{
+		newTestInstance("instance", time.Now(), true, map[string]string{crossplane.TenantLabel: "tenant-1"}),
+	})
+	apiHandler.Dashboard = newTestDashboard(t)
+	launch, err := url.Parse(apiHandler.Dashboard.URL("instance"))
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		principal *auth.Principal
+		code      int
+	}{
+		"owner":                {principal: &auth.Principal{Name: "owner", Tenant: "tenant-1", Roles: []auth.Role{auth.RoleInstanceOwner}}, code: http.StatusSeeOther},
+		"admin":                {principal: &auth.Principal{Name: "admin", Roles: []auth.Role{auth.RoleAdmin}}, code: http.StatusSeeOther},
+		"other tenant":         {principal: &auth.Principal{Name: "other", Tenant: "tenant-2", Roles: []auth.Role{auth.RoleInstanceOwner}}, code: http.StatusForbidden},
+		"owner without tenant": {principal: &auth.Principal{Name: "owner", Roles: []auth.Role{auth.RoleInstanceOwner}}, code: http.StatusForbidden},
+		"unauthenticated":      {code: http.StatusForbidden},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			logger := lager.NewLogger("dashboard")
+			router := mux.NewRouter()
+			NewDashboardPage(router, apiHandler, logger)
+			apiRouter := router.NewRoute().Subrouter()
+			apiRouter.Use(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+					if tt.principal != nil {
+						req = req.WithContext(auth.WithPrincipal(req.Context(), tt.principal))
+					}
+					next.ServeHTTP(w, req)
+				})
+			})
+			NewAPI(apiRouter, apiHandler, logger)
+
+			// The URL returned to the platform leads to the dashboard on each visit.
+			for i := 0; i < 2; i++ {
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, launch.RequestURI(), nil))
+				require.Equal(t, tt.code, rec.Code, rec.Body.String())
+				if tt.code != http.StatusSeeOther {
+					return
+				}
+				assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+
+				location, err := url.Parse(rec.Header().Get("Location"))
+				require.NoError(t, err)
+				assert.Equal(t, "/dashboard/service_instances/instance", location.Path)
+				rec = httptest.NewRecorder()
+				router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, location.RequestURI(), nil))
+				assert.Equal(t, http.StatusOK, rec.Code)
+				assert.Contains(t, rec.Body.String(), "<h1>Instance instance</h1>")
+			}
+		})
+	}
+}
@@ -4,18 +4,38 @@ import (
 	"net/http"
 
 	"broker/pkg/crossplane"
+	"broker/pkg/dashboard"
 
 	"code.cloudfoundry.org/lager"
 	"github.com/pivotal-cf/brokerapi/v7/domain/apiresponses"
 )
 
+// InstanceLocker serializes the operations on an instance, it is implemented by the broker.
+type InstanceLocker interface {
+	// TryLock locks the instance and returns a function to unlock it.
+	// It returns false without blocking if another operation holds the lock.
+	TryLock(instanceID string) (func(), bool)
+}
+
 type APIHandler struct {
 	c      *crossplane.Crossplane
 	logger lager.Logger
+	// Dashboard signs the links to the dashboards of instances. Nil disables dashboards.
+	Dashboard *dashboard.Dashboard
+	// Locks serializes actions and migrations with the operations of the broker. Nil doesn't lock.
+	Locks InstanceLocker
 }
 
 func NewAPIHandler(c *crossplane.Crossplane, logger lager.Logger) *APIHandler {
-	return &APIHandler{c, logger}
+	return &APIHandler{c: c, logger: logger}
+}
+
+// tryLock locks the instance with the locks of the broker.
+func (h APIHandler) tryLock(instanceID string) (func(), bool) {
+	if h.Locks == nil {
+		return func() {}, true
+	}
+	return h.Locks.TryLock(instanceID)
 }
 
 func notFoundError(description string, err error) error {
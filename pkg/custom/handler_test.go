@@ -1,15 +1,26 @@
 package custom
 
 import (
+	"broker/pkg/config"
 	"broker/pkg/crossplane"
 	"context"
+	"errors"
+	"net/http"
+	"net/url"
 	"testing"
+	"time"
 
 	"code.cloudfoundry.org/lager"
+	runtimev1alpha1 "github.com/crossplane/crossplane-runtime/apis/core/v1alpha1"
+	"github.com/crossplane/crossplane-runtime/pkg/resource/unstructured/composite"
 	"github.com/crossplane/crossplane/apis/apiextensions/v1beta1"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/kubernetes/scheme"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
@@ -19,12 +30,20 @@ const (
 	serviceName = "redis-k8s"
 )
 
+var instanceGVK = schema.GroupVersionKind{
+	Group:   "syn.tools",
+	Kind:    "CompositeRedisInstance",
+	Version: "v1alpha1",
+}
+
 func createAPIHandler(objs []runtime.Object) *APIHandler {
 	logger := lager.NewLogger("apihandler")
 	s := scheme.Scheme
 	if err := crossplane.SetupScheme(s); err != nil {
 		panic(err)
 	}
+	// The fake client needs the list type to list instances.
+	s.AddKnownTypeWithName(instanceGVK.GroupVersion().WithKind(instanceGVK.Kind+"List"), &unstructured.UnstructuredList{})
 
 	plan := &v1beta1.Composition{
 		ObjectMeta: metav1.ObjectMeta{
@@ -37,17 +56,14 @@ func createAPIHandler(objs []runtime.Object) *APIHandler {
 		},
 		Spec: v1beta1.CompositionSpec{
 			CompositeTypeRef: v1beta1.TypeReference{
-				APIVersion: "syn.tools/v1alpha1",
-				Kind:       "CompositeRedisInstance",
+				APIVersion: instanceGVK.GroupVersion().String(),
+				Kind:       instanceGVK.Kind,
 			},
 		},
 	}
 
 	objs = append(objs, plan)
-	cp := &crossplane.Crossplane{
-		Client:     fake.NewFakeClientWithScheme(s, objs...),
-		ServiceIDs: []string{serviceName},
-	}
+	cp := crossplane.NewWithClient(fake.NewFakeClientWithScheme(s, objs...), []string{serviceName}, config.Default().Crossplane, logger)
 	return NewAPIHandler(cp, logger)
 }
 
@@ -76,3 +92,369 @@ func TestAPIHandler_CreateUpdateServiceDefinition(t *testing.T) {
 	assert.Error(t, err)
 	assert.EqualError(t, err, "API not implemented (http code 501)")
 }
+
+func newTestInstance(name string, created time.Time, ready bool, labels map[string]string) *composite.Unstructured {
+	instance := composite.New(composite.WithGroupVersionKind(instanceGVK))
+	instance.SetName(name)
+	instance.SetCreationTimestamp(metav1.NewTime(created))
+	instance.SetCompositionReference(&corev1.ObjectReference{
+		Name: planName,
+	})
+	l := map[string]string{
+		crossplane.ServiceIDLabel:   serviceName,
+		crossplane.ServiceNameLabel: serviceName,
+		crossplane.PlanNameLabel:    planName,
+		crossplane.SLALabel:         crossplane.SLAStandard,
+		crossplane.ClusterLabel:     "cluster-1",
+	}
+	for k, v := range labels {
+		l[k] = v
+	}
+	instance.SetLabels(l)
+	if ready {
+		instance.SetConditions(runtimev1alpha1.Available())
+	} else {
+		instance.SetConditions(runtimev1alpha1.Creating())
+	}
+	return instance
+}
+
+func TestAPIHandler_ListInstances(t *testing.T) {
+	created := time.Date(2020, 11, 1, 12, 0, 0, 0, time.UTC)
+	apiHandler := createAPIHandler([]runtime.Object{
+		newTestInstance("first", created, true, map[string]string{crossplane.TenantLabel: "tenant"}),
+		newTestInstance("second", created.Add(time.Minute), false, map[string]string{crossplane.ParentIDLabel: "first"}),
+		newTestInstance("third", created.Add(2*time.Minute), true, nil),
+	})
+
+	l, err := apiHandler.ListInstances(context.Background(), InstanceQuery{Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 3, l.Total)
+	assert.Equal(t, 2, l.Limit)
+	require.Len(t, l.ServiceInstances, 2)
+	assert.Equal(t, ServiceInstance{
+		ID:          "first",
+		ServiceID:   serviceName,
+		ServiceName: serviceName,
+		PlanID:      planName,
+		PlanName:    planName,
+		SLA:         crossplane.SLAStandard,
+		Cluster:     "cluster-1",
+		Tenant:      "tenant",
+		Status:      InstanceStatus{Ready: true, Reason: "Available"},
+		CreatedAt:   created,
+	}, l.ServiceInstances[0])
+	assert.Equal(t, "second", l.ServiceInstances[1].ID)
+	assert.Equal(t, "first", l.ServiceInstances[1].ParentID)
+	assert.Equal(t, InstanceStatus{Ready: false, Reason: "Creating"}, l.ServiceInstances[1].Status)
+
+	l, err = apiHandler.ListInstances(context.Background(), InstanceQuery{Offset: 2, Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 3, l.Total)
+	require.Len(t, l.ServiceInstances, 1)
+	assert.Equal(t, "third", l.ServiceInstances[0].ID)
+
+	l, err = apiHandler.ListInstances(context.Background(), InstanceQuery{Offset: 5, Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 3, l.Total)
+	assert.Empty(t, l.ServiceInstances)
+}
+
+func TestParseInstanceQuery(t *testing.T) {
+	ready := false
+	tests := map[string]struct {
+		query string
+		want  InstanceQuery
+		err   string
+	}{
+		"defaults": {
+			want: InstanceQuery{Limit: defaultInstanceLimit},
+		},
+		"filters": {
+			query: "service_id=redis-k8s&plan_id=small&sla=premium&cluster=c1&parent=p&tenant=t&ready=false&offset=10&limit=20" +
+				"&platform=cloudfoundry&organization_guid=o&space_guid=s&namespace=n&instance_name=my+cache",
+			want: InstanceQuery{
+				InstanceFilter: crossplane.InstanceFilter{
+					ServiceID: "redis-k8s",
+					PlanID:    "small",
+					SLA:       "premium",
+					Cluster:   "c1",
+					ParentID:  "p",
+					Tenant:    "t",
+					Ready:     &ready,
+
+					Platform:         "cloudfoundry",
+					OrganizationGUID: "o",
+					SpaceGUID:        "s",
+					Namespace:        "n",
+					InstanceName:     "my cache",
+				},
+				Offset: 10,
+				Limit:  20,
+			},
+		},
+		"invalid ready": {
+			query: "ready=maybe",
+			err:   `invalid ready "maybe": must be true or false`,
+		},
+		"negative offset": {
+			query: "offset=-1",
+			err:   `invalid offset "-1": must be a non-negative integer`,
+		},
+		"limit too large": {
+			query: "limit=501",
+			err:   `invalid limit "501": must be between 1 and 500`,
+		},
+		"limit zero": {
+			query: "limit=0",
+			err:   `invalid limit "0": must be between 1 and 500`,
+		},
+		"selector in label value": {
+			query: "tenant=" + url.QueryEscape("t,syn.tools/sla!=premium"),
+			err:   `invalid tenant "t,syn.tools/sla!=premium": a valid label must be an empty string or consist of alphanumeric characters, '-', '_' or '.', and must start and end with an alphanumeric character (e.g. 'MyValue',  or 'my_value',  or '12345', regex used for validation is '(([A-Za-z0-9][-A-Za-z0-9_.]*)?[A-Za-z0-9])?')`,
+		},
+		"invalid label value": {
+			query: "cluster=-c1",
+			err:   `invalid cluster "-c1": a valid label must be an empty string or consist of alphanumeric characters, '-', '_' or '.', and must start and end with an alphanumeric character (e.g. 'MyValue',  or 'my_value',  or '12345', regex used for validation is '(([A-Za-z0-9][-A-Za-z0-9_.]*)?[A-Za-z0-9])?')`,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			require.NoError(t, err)
+			q, err := parseInstanceQuery(values)
+			if tt.err != "" {
+				var apiErr APIError
+				require.True(t, errors.As(err, &apiErr))
+				assert.Equal(t, http.StatusBadRequest, apiErr.code)
+				assert.Equal(t, tt.err, apiErr.err.Description)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, q)
+		})
+	}
+}
+
+func TestAPIHandler_ListBindings(t *testing.T) {
+	ctx := context.Background()
+	apiHandler := createAPIHandler([]runtime.Object{
+		newTestInstance("instance", time.Now(), true, nil),
+	})
+	require.NoError(t, apiHandler.c.RecordBinding(ctx, "instance", "binding", "app", crossplane.Credentials{"password": "secret"}))
+
+	l, err := apiHandler.ListBindings(ctx, "instance")
+	require.NoError(t, err)
+	require.Len(t, l, 1)
+	assert.Equal(t, "binding", l[0].ID)
+	assert.Equal(t, "instance", l[0].ServiceInstanceID)
+	assert.Equal(t, "app", l[0].AppGUID)
+	assert.NotEmpty(t, l[0].CredentialVersion)
+
+	_, err = apiHandler.ListBindings(ctx, "unknown")
+	var apiErr APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.code)
+}
+
+func TestAPIHandler_Migration(t *testing.T) {
+	ctx := context.Background()
+	apiHandler := createAPIHandler([]runtime.Object{
+		newTestInstance("instance", time.Now(), true, nil),
+	})
+
+	tests := map[string]struct {
+		instanceID string
+		request    MigrationRequest
+		code       int
+	}{
+		"missing target": {
+			instanceID: "instance",
+			code:       http.StatusBadRequest,
+		},
+		"unknown instance": {
+			instanceID: "unknown",
+			request:    MigrationRequest{TargetCluster: "cluster-2", SkipDataCopy: true},
+			code:       http.StatusNotFound,
+		},
+		"not permitted": {
+			instanceID: "instance",
+			request:    MigrationRequest{TargetCluster: "cluster-2", SkipDataCopy: true},
+			code:       http.StatusUnprocessableEntity,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := apiHandler.StartMigration(ctx, tt.instanceID, &tt.request)
+			var apiErr APIError
+			require.True(t, errors.As(err, &apiErr), "%v", err)
+			assert.Equal(t, tt.code, apiErr.code)
+		})
+	}
+
+	_, err := apiHandler.Migration(ctx, "instance")
+	var apiErr APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.code)
+
+	apiHandler.Locks = lockedInstances{"instance": true}
+	_, err = apiHandler.StartMigration(ctx, "instance", &MigrationRequest{TargetCluster: "cluster-2"})
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusConflict, apiErr.code, "operations of the broker on the instance must block migrations")
+}
+
+func TestAPIHandler_Upgrades(t *testing.T) {
+	ctx := context.Background()
+	instance := newTestInstance("instance", time.Now(), true, nil)
+	instance.SetAnnotations(map[string]string{crossplane.MaintenanceWindowAnnotation: "sun 02:00-04:00"})
+	apiHandler := createAPIHandler([]runtime.Object{instance})
+
+	upgrades, err := apiHandler.Upgrades(ctx, "instance")
+	require.NoError(t, err)
+	assert.Equal(t, &Upgrades{MaintenanceWindow: "sun 02:00-04:00"}, upgrades)
+
+	_, err = apiHandler.Upgrades(ctx, "unknown")
+	var apiErr APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.code)
+}
+
+func TestParseLogQuery(t *testing.T) {
+	tests := map[string]struct {
+		query string
+		want  crossplane.LogOptions
+		err   string
+	}{
+		"defaults": {
+			want: crossplane.LogOptions{Tail: defaultLogTail},
+		},
+		"options": {
+			query: "since=10m&tail=0&follow=true",
+			want:  crossplane.LogOptions{Since: 10 * time.Minute, Tail: 0, Follow: true},
+		},
+		"invalid since": {
+			query: "since=-1m",
+			err:   `invalid since "-1m": must be a positive duration, e.g. 10m`,
+		},
+		"tail too large": {
+			query: "tail=10001",
+			err:   `invalid tail "10001": must be between 0 and 10000`,
+		},
+		"invalid follow": {
+			query: "follow=maybe",
+			err:   `invalid follow "maybe": must be true or false`,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			require.NoError(t, err)
+			opts, err := parseLogQuery(values)
+			if tt.err != "" {
+				var apiErr APIError
+				require.True(t, errors.As(err, &apiErr))
+				assert.Equal(t, http.StatusBadRequest, apiErr.code)
+				assert.Equal(t, tt.err, apiErr.err.Description)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, opts)
+		})
+	}
+}
+
+func TestAPIHandler_Logs(t *testing.T) {
+	ctx := context.Background()
+	// Instances without releases, e.g. MariaDB databases, have no pods of their own.
+	apiHandler := createAPIHandler([]runtime.Object{newTestInstance("instance", time.Now(), true, nil)})
+	send := func(crossplane.LogLine) error { return nil }
+
+	err := apiHandler.Logs(ctx, "instance", crossplane.LogOptions{}, send)
+	var apiErr APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusUnprocessableEntity, apiErr.code)
+
+	err = apiHandler.Logs(ctx, "unknown", crossplane.LogOptions{}, send)
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.code)
+}
+
+func TestAPIHandler_Actions(t *testing.T) {
+	ctx := context.Background()
+	// Instances without releases, e.g. MariaDB databases, have no nodes of their own.
+	apiHandler := createAPIHandler([]runtime.Object{newTestInstance("instance", time.Now(), true, nil)})
+
+	tests := map[string]struct {
+		instanceID string
+		action     crossplane.ActionType
+		code       int
+	}{
+		"unknown instance": {
+			instanceID: "unknown",
+			action:     crossplane.ActionRestart,
+			code:       http.StatusNotFound,
+		},
+		"without nodes": {
+			instanceID: "instance",
+			action:     crossplane.ActionRestart,
+			code:       http.StatusUnprocessableEntity,
+		},
+		"unknown action": {
+			instanceID: "instance",
+			action:     "scale",
+			code:       http.StatusUnprocessableEntity,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := apiHandler.StartAction(ctx, tt.instanceID, tt.action, &ActionRequest{})
+			var apiErr APIError
+			require.True(t, errors.As(err, &apiErr), "%v", err)
+			assert.Equal(t, tt.code, apiErr.code)
+		})
+	}
+
+	actions, err := apiHandler.ListActions(ctx, "instance")
+	require.NoError(t, err)
+	assert.Empty(t, actions)
+
+	_, err = apiHandler.Action(ctx, "instance", "1")
+	var apiErr APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.code)
+
+	_, err = apiHandler.ListActions(ctx, "unknown")
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.code)
+
+	apiHandler.Locks = lockedInstances{"instance": true}
+	_, err = apiHandler.StartAction(ctx, "instance", crossplane.ActionRestart, &ActionRequest{})
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusConflict, apiErr.code, "operations of the broker on the instance must block actions")
+}
+
+// lockedInstances is an InstanceLocker whose instances are locked by other operations.
+type lockedInstances map[string]bool
+
+func (l lockedInstances) TryLock(instanceID string) (func(), bool) {
+	if l[instanceID] {
+		return nil, false
+	}
+	return func() {}, true
+}
+
+func TestAPIHandler_Metrics(t *testing.T) {
+	ctx := context.Background()
+	// Instances without releases, e.g. MariaDB databases, have no exporters of their own.
+	apiHandler := createAPIHandler([]runtime.Object{newTestInstance("instance", time.Now(), true, nil)})
+
+	_, err := apiHandler.Metrics(ctx, "instance")
+	var apiErr APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusUnprocessableEntity, apiErr.code)
+	assert.Equal(t, "MetricsNotAvailable", apiErr.err.Error)
+
+	_, err = apiHandler.Metrics(ctx, "unknown")
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.code)
+}
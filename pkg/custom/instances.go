@@ -0,0 +1,137 @@
+package custom
+
+import (
+	"broker/pkg/crossplane"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	runtimev1alpha1 "github.com/crossplane/crossplane-runtime/apis/core/v1alpha1"
+	"github.com/crossplane/crossplane-runtime/pkg/resource/unstructured/composite"
+	"github.com/pivotal-cf/brokerapi/v7/domain/apiresponses"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	defaultInstanceLimit = 50
+	maxInstanceLimit     = 500
+)
+
+func (h APIHandler) ListInstances(ctx context.Context, q InstanceQuery) (*InstanceList, error) {
+	instances, err := h.c.ListInstances(ctx, q.InstanceFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &InstanceList{
+		Total:            len(instances),
+		Offset:           q.Offset,
+		Limit:            q.Limit,
+		ServiceInstances: make([]ServiceInstance, 0),
+	}
+	if q.Offset >= len(instances) {
+		return list, nil
+	}
+	end := q.Offset + q.Limit
+	if end > len(instances) {
+		end = len(instances)
+	}
+	for _, instance := range instances[q.Offset:end] {
+		list.ServiceInstances = append(list.ServiceInstances, serviceInstance(instance))
+	}
+	return list, nil
+}
+
+func serviceInstance(instance *composite.Unstructured) ServiceInstance {
+	labels := instance.GetLabels()
+	condition := instance.GetCondition(runtimev1alpha1.TypeReady)
+	si := ServiceInstance{
+		ID:          instance.GetName(),
+		ServiceID:   labels[crossplane.ServiceIDLabel],
+		ServiceName: labels[crossplane.ServiceNameLabel],
+		PlanName:    labels[crossplane.PlanNameLabel],
+		SLA:         labels[crossplane.SLALabel],
+		Cluster:     labels[crossplane.ClusterLabel],
+		ParentID:    labels[crossplane.ParentIDLabel],
+		Tenant:      labels[crossplane.TenantLabel],
+		Status: InstanceStatus{
+			Ready:   condition.Status == corev1.ConditionTrue,
+			Reason:  string(condition.Reason),
+			Message: condition.Message,
+		},
+		CreatedAt: instance.GetCreationTimestamp().UTC(),
+		Context:   crossplane.InstanceContextOf(instance),
+	}
+	if ref := instance.GetCompositionReference(); ref != nil {
+		si.PlanID = ref.Name
+	}
+	return si
+}
+
+// parseInstanceQuery reads the filters and pagination of the instance listing from the query parameters.
+func parseInstanceQuery(values url.Values) (InstanceQuery, error) {
+	q := InstanceQuery{
+		InstanceFilter: crossplane.InstanceFilter{
+			ServiceID: values.Get("service_id"),
+			PlanID:    values.Get("plan_id"),
+			SLA:       values.Get("sla"),
+			Cluster:   values.Get("cluster"),
+			ParentID:  values.Get("parent"),
+			Tenant:    values.Get("tenant"),
+
+			Platform:         values.Get("platform"),
+			OrganizationGUID: values.Get("organization_guid"),
+			SpaceGUID:        values.Get("space_guid"),
+			Namespace:        values.Get("namespace"),
+			InstanceName:     values.Get("instance_name"),
+		},
+		Limit: defaultInstanceLimit,
+	}
+
+	// The filters matched as labels must be valid label values, they would alter or break the selector otherwise.
+	for _, param := range []string{"service_id", "sla", "cluster", "parent", "tenant", "platform", "organization_guid", "space_guid", "namespace"} {
+		v := values.Get(param)
+		if v == "" {
+			continue
+		}
+		if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+			return q, badRequestError(fmt.Errorf("invalid %s %q: %s", param, v, strings.Join(errs, ", ")))
+		}
+	}
+	if v := values.Get("ready"); v != "" {
+		ready, err := strconv.ParseBool(v)
+		if err != nil {
+			return q, badRequestError(fmt.Errorf("invalid ready %q: must be true or false", v))
+		}
+		q.Ready = &ready
+	}
+	if v := values.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return q, badRequestError(fmt.Errorf("invalid offset %q: must be a non-negative integer", v))
+		}
+		q.Offset = offset
+	}
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxInstanceLimit {
+			return q, badRequestError(fmt.Errorf("invalid limit %q: must be between 1 and %d", v, maxInstanceLimit))
+		}
+		q.Limit = limit
+	}
+	return q, nil
+}
+
+func badRequestError(err error) error {
+	return APIError{
+		code: http.StatusBadRequest,
+		err: apiresponses.ErrorResponse{
+			Error:       "InvalidQuery",
+			Description: err.Error(),
+		},
+	}
+}
@@ -0,0 +1,63 @@
+package custom
+
+import (
+	"broker/pkg/crossplane"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pivotal-cf/brokerapi/v7/domain/apiresponses"
+)
+
+const (
+	defaultLogTail = 100
+	maxLogTail     = 10000
+)
+
+func (h APIHandler) Logs(ctx context.Context, instanceID string, opts crossplane.LogOptions, send func(crossplane.LogLine) error) error {
+	err := h.c.StreamLogs(ctx, instanceID, opts, send)
+	switch {
+	case errors.Is(err, crossplane.ErrInstanceNotFound):
+		return notFoundError("instance not found", err)
+	case errors.Is(err, crossplane.ErrLogsNotAvailable):
+		return APIError{
+			code: http.StatusUnprocessableEntity,
+			err: apiresponses.ErrorResponse{
+				Error:       "LogsNotAvailable",
+				Description: err.Error(),
+			},
+		}
+	}
+	return err
+}
+
+// parseLogQuery reads the log options from the query parameters.
+func parseLogQuery(values url.Values) (crossplane.LogOptions, error) {
+	opts := crossplane.LogOptions{Tail: defaultLogTail}
+	if v := values.Get("since"); v != "" {
+		since, err := time.ParseDuration(v)
+		if err != nil || since <= 0 {
+			return opts, badRequestError(fmt.Errorf("invalid since %q: must be a positive duration, e.g. 10m", v))
+		}
+		opts.Since = since
+	}
+	if v := values.Get("tail"); v != "" {
+		tail, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || tail < 0 || tail > maxLogTail {
+			return opts, badRequestError(fmt.Errorf("invalid tail %q: must be between 0 and %d", v, maxLogTail))
+		}
+		opts.Tail = tail
+	}
+	if v := values.Get("follow"); v != "" {
+		follow, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, badRequestError(fmt.Errorf("invalid follow %q: must be true or false", v))
+		}
+		opts.Follow = follow
+	}
+	return opts, nil
+}
@@ -0,0 +1,34 @@
+package custom
+
+import (
+	"broker/pkg/crossplane"
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/pivotal-cf/brokerapi/v7/domain/apiresponses"
+)
+
+const (
+	// metricsFormatJSON returns the normalized metrics of the nodes.
+	metricsFormatJSON = "json"
+	// metricsFormatPrometheus returns all metrics of the exporters in the Prometheus text format.
+	metricsFormatPrometheus = "prometheus"
+)
+
+func (h APIHandler) Metrics(ctx context.Context, instanceID string) (*crossplane.InstanceMetrics, error) {
+	m, err := h.c.GetMetrics(ctx, instanceID)
+	switch {
+	case errors.Is(err, crossplane.ErrInstanceNotFound):
+		return nil, notFoundError("instance not found", err)
+	case errors.Is(err, crossplane.ErrMetricsNotAvailable):
+		return nil, APIError{
+			code: http.StatusUnprocessableEntity,
+			err: apiresponses.ErrorResponse{
+				Error:       "MetricsNotAvailable",
+				Description: err.Error(),
+			},
+		}
+	}
+	return m, err
+}
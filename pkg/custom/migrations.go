@@ -0,0 +1,80 @@
+package custom
+
+import (
+	"broker/pkg/crossplane"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/pivotal-cf/brokerapi/v7/domain/apiresponses"
+)
+
+func (h APIHandler) StartMigration(ctx context.Context, instanceID string, r *MigrationRequest) (*Migration, error) {
+	if r.TargetCluster == "" {
+		return nil, APIError{
+			code: http.StatusBadRequest,
+			err: apiresponses.ErrorResponse{
+				Error:       "InvalidMigration",
+				Description: "target_cluster is required",
+			},
+		}
+	}
+	// Migrations are started while holding the lock of the instance, like all other operations on it.
+	unlock, ok := h.tryLock(instanceID)
+	if !ok {
+		return nil, migrationError(fmt.Errorf("%w: another operation on the instance is in progress", crossplane.ErrMigrationInProgress))
+	}
+	defer unlock()
+	m, err := h.c.StartMigration(ctx, instanceID, r.TargetCluster, r.SkipDataCopy)
+	if err != nil {
+		return nil, migrationError(err)
+	}
+	return migration(m), nil
+}
+
+func (h APIHandler) Migration(ctx context.Context, instanceID string) (*Migration, error) {
+	m, err := h.c.AdvanceMigration(ctx, instanceID)
+	if err != nil {
+		return nil, migrationError(err)
+	}
+	return migration(m), nil
+}
+
+func migration(m *crossplane.Migration) *Migration {
+	return &Migration{
+		ServiceInstanceID: m.InstanceID,
+		SourceCluster:     m.SourceCluster,
+		TargetCluster:     m.TargetCluster,
+		State:             m.State,
+		Description:       m.Message,
+		SkipDataCopy:      m.SkipDataCopy,
+		CopiedKeys:        m.CopiedKeys,
+		StartedAt:         m.StartedAt,
+		UpdatedAt:         m.UpdatedAt,
+	}
+}
+
+// migrationError converts the errors of migrations to API errors.
+func migrationError(err error) error {
+	code := 0
+	switch {
+	case errors.Is(err, crossplane.ErrInstanceNotFound):
+		return notFoundError("instance not found", err)
+	case errors.Is(err, crossplane.ErrMigrationNotFound):
+		return notFoundError("instance has not been migrated", err)
+	case errors.Is(err, crossplane.ErrMigrationInProgress):
+		code = http.StatusConflict
+	case errors.Is(err, crossplane.ErrMigrationNotPermitted):
+		code = http.StatusUnprocessableEntity
+	default:
+		return err
+	}
+	return APIError{
+		code: code,
+		err: apiresponses.ErrorResponse{
+			Error:       "InvalidMigration",
+			Description: err.Error(),
+		},
+	}
+}
@@ -0,0 +1,31 @@
+package custom
+
+import (
+	"broker/pkg/crossplane"
+	"context"
+	"errors"
+	"time"
+)
+
+func (h APIHandler) Upgrades(ctx context.Context, instanceID string) (*Upgrades, error) {
+	status, err := h.c.GetUpgradeStatus(ctx, instanceID, time.Now())
+	if err != nil {
+		if errors.Is(err, crossplane.ErrInstanceNotFound) {
+			return nil, notFoundError("instance not found", err)
+		}
+		return nil, err
+	}
+
+	res := &Upgrades{MaintenanceWindow: status.MaintenanceWindow}
+	if p := status.Pending; p != nil {
+		res.Pending = &PendingUpgrade{Charts: p.Charts}
+		if !p.Time.IsZero() {
+			scheduledAt := p.Time
+			res.Pending.ScheduledAt = &scheduledAt
+		}
+	}
+	if l := status.Last; l != nil {
+		res.Last = &LastUpgrade{Charts: l.Charts, UpgradedAt: l.Time}
+	}
+	return res, nil
+}
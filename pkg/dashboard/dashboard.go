@@ -0,0 +1,134 @@
+// Package dashboard builds the URLs of instance dashboards and signs the short-lived tokens granting access to them.
+package dashboard
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"time"
+
+	"broker/pkg/config"
+
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	// PathPrefix is the path of the dashboards below the broker URL, followed by the instance ID.
+	PathPrefix = "/dashboard/service_instances/"
+	// LaunchPath follows the instance ID in the dashboard URL returned to the platform.
+	// It requires the broker's authentication and redirects to a signed dashboard URL on each visit.
+	LaunchPath = "/launch"
+	// TokenParam is the query parameter containing the token of a signed dashboard URL.
+	TokenParam = "token"
+
+	audience = "dashboard"
+	// minKeySize is the minimum size of the signing key, HS256 requires at least 256 bits.
+	minKeySize = 32
+	// leeway is the accepted clock skew when validating tokens.
+	leeway = 10 * time.Second
+)
+
+// ErrInvalidToken is returned if a dashboard token is invalid, expired or for another instance.
+var ErrInvalidToken = errors.New("invalid dashboard token")
+
+// Dashboard builds the dashboard URLs of instances. A nil Dashboard has dashboards disabled.
+type Dashboard struct {
+	url    url.URL
+	key    []byte
+	signer jose.Signer
+	ttl    time.Duration
+}
+
+// New reads the token key and creates a Dashboard. It returns nil if dashboards are disabled.
+func New(cfg config.DashboardConfig) (*Dashboard, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	key, err := ioutil.ReadFile(cfg.TokenKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read dashboard token key: %w", err)
+	}
+	return newDashboard(cfg.URL, bytes.TrimSpace(key), cfg.TokenTTL.Duration)
+}
+
+func newDashboard(rawURL string, key []byte, ttl time.Duration) (*Dashboard, error) {
+	if len(key) < minKeySize {
+		return nil, fmt.Errorf("dashboard token key must have at least %d bytes, got %d", minKeySize, len(key))
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dashboard URL %q: %w", rawURL, err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: key}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		return nil, err
+	}
+	return &Dashboard{url: *u, key: key, signer: signer, ttl: ttl}, nil
+}
+
+// URL returns the dashboard URL of an instance returned to the platform, or an empty string if dashboards are disabled.
+// The platform keeps the URL for the lifetime of the instance, so it doesn't contain a token. Visiting it requires
+// the broker's authentication and redirects to a dashboard URL signed with a fresh token, see SignedURL.
+func (d *Dashboard) URL(instanceID string) string {
+	if d == nil {
+		return ""
+	}
+	u := d.instanceURL(instanceID)
+	u.Path += LaunchPath
+	return u.String()
+}
+
+func (d *Dashboard) instanceURL(instanceID string) *url.URL {
+	u := d.url
+	u.Path = path.Join(u.Path, PathPrefix, instanceID)
+	u.RawQuery = ""
+	return &u
+}
+
+// SignedURL returns the dashboard URL of an instance including a token granting access until the returned expiry.
+func (d *Dashboard) SignedURL(instanceID string, now time.Time) (string, time.Time, error) {
+	expiry := now.Add(d.ttl).Truncate(time.Second)
+	token, err := jwt.Signed(d.signer).Claims(jwt.Claims{
+		Issuer:    d.url.String(),
+		Subject:   instanceID,
+		Audience:  jwt.Audience{audience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		Expiry:    jwt.NewNumericDate(expiry),
+	}).CompactSerialize()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	u := d.instanceURL(instanceID)
+	u.RawQuery = url.Values{TokenParam: []string{token}}.Encode()
+	return u.String(), expiry, nil
+}
+
+// Verify checks that a token has been signed for the dashboard of the instance and hasn't expired yet.
+func (d *Dashboard) Verify(token, instanceID string, now time.Time) error {
+	tok, err := jwt.ParseSigned(token)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+	claims := jwt.Claims{}
+	if err := tok.Claims(d.key, &claims); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+	if claims.Expiry == 0 {
+		return fmt.Errorf("%w: token doesn't expire", ErrInvalidToken)
+	}
+	err = claims.ValidateWithLeeway(jwt.Expected{
+		Issuer:   d.url.String(),
+		Subject:  instanceID,
+		Audience: jwt.Audience{audience},
+		Time:     now,
+	}, leeway)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package dashboard
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+var testKey = []byte(strings.Repeat("k", minKeySize))
+
+func TestDashboard_URL(t *testing.T) {
+	d, err := newDashboard("https://broker.example.com/osb/", testKey, time.Minute)
+	require.NoError(t, err)
+	launch, err := url.Parse(d.URL("instance-1"))
+	require.NoError(t, err)
+	assert.Equal(t, "broker.example.com", launch.Host)
+	assert.Equal(t, "/osb/dashboard/service_instances/instance-1/launch", launch.Path)
+	assert.Equal(t, launch.String(), d.URL("instance-1"), "the URL kept by the platform must be stable")
+	assert.Empty(t, launch.RawQuery, "the URL kept by the platform must not grant access")
+
+	var disabled *Dashboard
+	assert.Empty(t, disabled.URL("instance-1"))
+
+	_, err = newDashboard("https://broker.example.com", []byte("short"), time.Minute)
+	assert.EqualError(t, err, "dashboard token key must have at least 32 bytes, got 5")
+}
+
+func TestDashboard_SignedURL(t *testing.T) {
+	d, err := newDashboard("https://broker.example.com", testKey, 15*time.Minute)
+	require.NoError(t, err)
+	now := time.Date(2020, 11, 1, 12, 0, 0, 0, time.UTC)
+
+	signed, expiry, err := d.SignedURL("instance-1", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(15*time.Minute), expiry)
+	u, err := url.Parse(signed)
+	require.NoError(t, err)
+	assert.Equal(t, "/dashboard/service_instances/instance-1", u.Path)
+	token := u.Query().Get(TokenParam)
+	require.NotEmpty(t, token)
+
+	noExpiry, err := jwt.Signed(d.signer).Claims(jwt.Claims{
+		Issuer:   "https://broker.example.com",
+		Subject:  "instance-1",
+		Audience: jwt.Audience{audience},
+	}).CompactSerialize()
+	require.NoError(t, err)
+
+	other, err := newDashboard("https://broker.example.com", []byte(strings.Repeat("o", minKeySize)), time.Minute)
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		dashboard *Dashboard
+		token     string
+		instance  string
+		now       time.Time
+		valid     bool
+	}{
+		"valid":            {dashboard: d, token: token, instance: "instance-1", now: now.Add(time.Minute), valid: true},
+		"expired":          {dashboard: d, token: token, instance: "instance-1", now: now.Add(16 * time.Minute)},
+		"other instance":   {dashboard: d, token: token, instance: "instance-2", now: now},
+		"other key":        {dashboard: other, token: token, instance: "instance-1", now: now},
+		"not a token":      {dashboard: d, token: "token", instance: "instance-1", now: now},
+		"without expiry":   {dashboard: d, token: noExpiry, instance: "instance-1", now: now},
+		"issued in future": {dashboard: d, token: token, instance: "instance-1", now: now.Add(-time.Minute)},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tt.dashboard.Verify(tt.token, tt.instance, tt.now)
+			if tt.valid {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, ErrInvalidToken))
+		})
+	}
+}
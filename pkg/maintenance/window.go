@@ -0,0 +1,126 @@
+// Package maintenance parses the maintenance windows in which instances may be disrupted, e.g. by upgrades.
+package maintenance
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Window is a daily or weekly maintenance window in UTC.
+// Windows ending before they start end on the next day.
+type Window struct {
+	weekly  bool
+	weekday time.Weekday
+	start   time.Duration
+	length  time.Duration
+}
+
+// Parse parses windows like `02:00-04:00` (daily) or `sun 22:00-02:00` (weekly, ending on monday).
+// Times are in UTC.
+func Parse(s string) (Window, error) {
+	w := Window{}
+	fields := strings.Fields(strings.ToLower(s))
+	switch len(fields) {
+	case 1:
+	case 2:
+		wd, ok := weekdays[fields[0]]
+		if !ok {
+			return w, fmt.Errorf("invalid maintenance window %q: unknown weekday %q", s, fields[0])
+		}
+		w.weekly, w.weekday = true, wd
+		fields = fields[1:]
+	default:
+		return w, fmt.Errorf("invalid maintenance window %q: expected [weekday] HH:MM-HH:MM", s)
+	}
+
+	times := strings.Split(fields[0], "-")
+	if len(times) != 2 {
+		return w, fmt.Errorf("invalid maintenance window %q: expected [weekday] HH:MM-HH:MM", s)
+	}
+	start, err := parseTimeOfDay(times[0])
+	if err != nil {
+		return w, fmt.Errorf("invalid maintenance window %q: %w", s, err)
+	}
+	end, err := parseTimeOfDay(times[1])
+	if err != nil {
+		return w, fmt.Errorf("invalid maintenance window %q: %w", s, err)
+	}
+	if start == end {
+		return w, fmt.Errorf("invalid maintenance window %q: start and end must differ", s)
+	}
+	w.start, w.length = start, end-start
+	if end < start {
+		w.length += day
+	}
+	return w, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains returns true if the time is within the window.
+func (w Window) Contains(t time.Time) bool {
+	t = t.UTC()
+	midnight := t.Truncate(day)
+	// The window containing t can have started on one of the previous days.
+	for d := 0; d <= 7; d++ {
+		date := midnight.Add(-time.Duration(d) * day)
+		if w.weekly && date.Weekday() != w.weekday {
+			continue
+		}
+		start := date.Add(w.start)
+		if start.After(t) {
+			continue
+		}
+		return t.Before(start.Add(w.length))
+	}
+	return false
+}
+
+// Next returns the time itself if it's within the window, the start of the next window otherwise.
+func (w Window) Next(t time.Time) time.Time {
+	t = t.UTC()
+	if w.Contains(t) {
+		return t
+	}
+	midnight := t.Truncate(day)
+	for d := 0; d <= 7; d++ {
+		date := midnight.Add(time.Duration(d) * day)
+		if w.weekly && date.Weekday() != w.weekday {
+			continue
+		}
+		if start := date.Add(w.start); start.After(t) {
+			return start
+		}
+	}
+	// Unreachable, there is a window start in every week.
+	return t
+}
+
+// String returns the window in the format accepted by Parse.
+func (w Window) String() string {
+	end := (w.start + w.length) % day
+	s := fmt.Sprintf("%02d:%02d-%02d:%02d", int(w.start.Hours()), int(w.start.Minutes())%60, int(end.Hours()), int(end.Minutes())%60)
+	if w.weekly {
+		return strings.ToLower(w.weekday.String()[:3]) + " " + s
+	}
+	return s
+}
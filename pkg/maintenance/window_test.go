@@ -0,0 +1,104 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := map[string]struct {
+		window string
+		want   string
+		err    string
+	}{
+		"daily":               {window: "02:00-04:00", want: "02:00-04:00"},
+		"weekly":              {window: "Sun 22:30-02:00", want: "sun 22:30-02:00"},
+		"unknown weekday":     {window: "sunday 02:00-04:00", err: `unknown weekday "sunday"`},
+		"missing end":         {window: "02:00", err: "expected [weekday] HH:MM-HH:MM"},
+		"invalid time":        {window: "02:00-25:00", err: `invalid time "25:00"`},
+		"empty window":        {window: "02:00-02:00", err: "start and end must differ"},
+		"too many fields":     {window: "sun mon 02:00-04:00", err: "expected [weekday] HH:MM-HH:MM"},
+		"surrounding spacing": {window: "  mon   01:00-01:30 ", want: "mon 01:00-01:30"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w, err := Parse(tt.window)
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, w.String())
+		})
+	}
+}
+
+func TestWindow(t *testing.T) {
+	// 2020-11-01 is a sunday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2020, 11, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := map[string]struct {
+		window   string
+		t        time.Time
+		contains bool
+		next     time.Time
+	}{
+		"daily, before": {
+			window: "02:00-04:00",
+			t:      at(3, 1, 0),
+			next:   at(3, 2, 0),
+		},
+		"daily, within": {
+			window:   "02:00-04:00",
+			t:        at(3, 3, 59),
+			contains: true,
+			next:     at(3, 3, 59),
+		},
+		"daily, end is exclusive": {
+			window: "02:00-04:00",
+			t:      at(3, 4, 0),
+			next:   at(4, 2, 0),
+		},
+		"weekly, other day": {
+			window: "sun 02:00-04:00",
+			t:      at(3, 3, 0),
+			next:   at(8, 2, 0),
+		},
+		"weekly, within": {
+			window:   "sun 02:00-04:00",
+			t:        at(1, 2, 0),
+			contains: true,
+			next:     at(1, 2, 0),
+		},
+		"across midnight, after midnight": {
+			window:   "sun 23:00-01:00",
+			t:        at(2, 0, 30),
+			contains: true,
+			next:     at(2, 0, 30),
+		},
+		"across midnight, after end": {
+			window: "sun 23:00-01:00",
+			t:      at(2, 1, 30),
+			next:   at(8, 23, 0),
+		},
+		"other time zone": {
+			window:   "sun 02:00-04:00",
+			t:        at(1, 3, 0).In(time.FixedZone("UTC+2", 2*60*60)),
+			contains: true,
+			next:     at(1, 3, 0),
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w, err := Parse(tt.window)
+			require.NoError(t, err)
+			assert.Equal(t, tt.contains, w.Contains(tt.t))
+			assert.Equal(t, tt.next, w.Next(tt.t))
+		})
+	}
+}
@@ -0,0 +1,79 @@
+// Package ratelimit limits the request rate of API callers.
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"broker/pkg/auth"
+	"broker/pkg/config"
+
+	"code.cloudfoundry.org/lager"
+	"golang.org/x/time/rate"
+)
+
+// Limiter limits the requests of each principal with a token bucket.
+// Unauthenticated requests are limited by their remote address.
+type Limiter struct {
+	limit  rate.Limit
+	burst  int
+	logger lager.Logger
+
+	mu      sync.Mutex
+	buckets map[string]*rate.Limiter
+}
+
+// New creates a limiter using the given config.
+func New(cfg config.RateLimitConfig, logger lager.Logger) *Limiter {
+	return &Limiter{
+		limit:   rate.Limit(cfg.RequestsPerSecond),
+		burst:   cfg.Burst,
+		logger:  logger,
+		buckets: map[string]*rate.Limiter{},
+	}
+}
+
+// Allow takes a token from the bucket of the key. If the bucket is empty, it returns false and
+// the time until the next token is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = rate.NewLimiter(l.limit, l.burst)
+		l.buckets[key] = b
+	}
+	l.mu.Unlock()
+
+	r := b.Reserve()
+	if d := r.Delay(); d > 0 {
+		r.Cancel()
+		return false, d
+	}
+	return true, 0
+}
+
+// Middleware rejects requests exceeding the rate limit with 429.
+// It must be used after the authentication middleware.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key := req.RemoteAddr
+		if host, _, err := net.SplitHostPort(key); err == nil {
+			key = host
+		}
+		if p, ok := auth.PrincipalFromContext(req.Context()); ok {
+			key = p.Method + ":" + p.Name
+		}
+
+		if ok, wait := l.Allow(key); !ok {
+			l.logger.Info("rate-limit-exceeded", lager.Data{"key": key, "URI": req.RequestURI})
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,34 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"broker/pkg/auth"
+	"broker/pkg/config"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	l := New(config.RateLimitConfig{RequestsPerSecond: 0.001, Burst: 2}, lager.NewLogger("test"))
+	handler := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	request := func(name string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v2/catalog", nil)
+		req = req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{Name: name, Method: "basic"}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, request("cf").Code)
+	assert.Equal(t, http.StatusOK, request("cf").Code)
+	rec := request("cf")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusOK, request("other").Code, "principals must have separate buckets")
+}
@@ -0,0 +1,140 @@
+package tlsconfig
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"broker/pkg/config"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// Reloader serves the certificate and client CAs read from files and reloads them when the files change.
+type Reloader struct {
+	cfg    config.TLSConfig
+	logger lager.Logger
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	contents  []byte
+}
+
+// NewReloader reads the certificate, key and client CAs configured in cfg.
+func NewReloader(cfg config.TLSConfig, logger lager.Logger) (*Reloader, error) {
+	r := &Reloader{
+		cfg:    cfg,
+		logger: logger,
+	}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns a server TLS configuration using the current certificate and client CAs for each handshake.
+func (r *Reloader) TLSConfig() *tls.Config {
+	base := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"h2", "http/1.1"},
+		GetCertificate: r.getCertificate,
+	}
+	if r.cfg.ClientCAFile != "" {
+		base.ClientAuth = tls.VerifyClientCertIfGiven
+		if r.cfg.ClientAuth == config.ClientAuthRequired {
+			base.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c := base.Clone()
+			r.mu.RLock()
+			c.ClientCAs = r.clientCAs
+			r.mu.RUnlock()
+			return c, nil
+		}
+	}
+	return base
+}
+
+// Run checks the files for changes in the given interval until the context is cancelled.
+// Invalid files are logged and the previous certificate is kept.
+func (r *Reloader) Run(ctx context.Context, interval time.Duration) {
+	if interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, err := r.reload()
+		if err != nil {
+			r.logger.Error("reload-tls-certificate", err)
+			continue
+		}
+		if changed {
+			r.logger.Info("tls-certificate-reloaded", lager.Data{"cert-file": r.cfg.CertFile})
+		}
+	}
+}
+
+func (r *Reloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// reload reads all files and replaces the certificate and client CAs if their content changed.
+func (r *Reloader) reload() (bool, error) {
+	certPEM, err := ioutil.ReadFile(r.cfg.CertFile)
+	if err != nil {
+		return false, fmt.Errorf("unable to read TLS certificate: %w", err)
+	}
+	keyPEM, err := ioutil.ReadFile(r.cfg.KeyFile)
+	if err != nil {
+		return false, fmt.Errorf("unable to read TLS key: %w", err)
+	}
+	var caPEM []byte
+	if r.cfg.ClientCAFile != "" {
+		caPEM, err = ioutil.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return false, fmt.Errorf("unable to read TLS client CA: %w", err)
+		}
+	}
+
+	contents := bytes.Join([][]byte{certPEM, keyPEM, caPEM}, []byte{0})
+	r.mu.RLock()
+	unchanged := bytes.Equal(contents, r.contents)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, fmt.Errorf("unable to parse TLS key pair: %w", err)
+	}
+	var pool *x509.CertPool
+	if caPEM != nil {
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return false, errors.New("no certificates found in TLS client CA file")
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.clientCAs = pool
+	r.contents = contents
+	return true, nil
+}
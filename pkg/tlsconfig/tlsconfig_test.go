@@ -0,0 +1,79 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"broker/pkg/config"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeKeyPair(t *testing.T, dir, commonName string) config.TLSConfig {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	cfg := config.TLSConfig{
+		CertFile: filepath.Join(dir, "tls.crt"),
+		KeyFile:  filepath.Join(dir, "tls.key"),
+	}
+	require.NoError(t, ioutil.WriteFile(cfg.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(cfg.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return cfg
+}
+
+func servedCommonName(t *testing.T, r *Reloader) string {
+	cert, err := r.TLSConfig().GetCertificate(nil)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return leaf.Subject.CommonName
+}
+
+func TestReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsconfig")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	r, err := NewReloader(writeKeyPair(t, dir, "first"), lager.NewLogger("test"))
+	require.NoError(t, err)
+	assert.Equal(t, "first", servedCommonName(t, r))
+
+	changed, err := r.reload()
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	writeKeyPair(t, dir, "second")
+	changed, err = r.reload()
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "second", servedCommonName(t, r))
+
+	// A broken key keeps the previous certificate.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tls.key"), []byte("broken"), 0600))
+	_, err = r.reload()
+	assert.Error(t, err)
+	assert.Equal(t, "second", servedCommonName(t, r))
+}